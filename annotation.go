@@ -0,0 +1,422 @@
+package pdf
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gogpu/gg"
+	"github.com/gogpu/gg/recording"
+	"github.com/gogpu/gg/text"
+)
+
+// AnnotationKind is the type of a markup annotation.
+type AnnotationKind int
+
+const (
+	// AnnotationText is a note shown as an icon, with its contents in a
+	// pop-up window.
+	AnnotationText AnnotationKind = iota
+
+	// AnnotationHighlight marks the areas of Quads like a highlighter pen.
+	AnnotationHighlight
+
+	// AnnotationUnderline underlines the areas of Quads.
+	AnnotationUnderline
+
+	// AnnotationSquare draws a rectangle around Rect.
+	AnnotationSquare
+
+	// AnnotationCircle draws an ellipse inside Rect.
+	AnnotationCircle
+
+	// AnnotationFreeText shows its contents directly on the page, in a box.
+	AnnotationFreeText
+
+	// AnnotationStamp shows a rubber stamp, such as "Approved".
+	AnnotationStamp
+
+	// AnnotationFileAttachment embeds File, shown as an icon that readers
+	// open to save or view the file.
+	AnnotationFileAttachment
+)
+
+// subtypes are the /Subtype names of the annotation kinds.
+var subtypes = [...]pdfName{
+	AnnotationText:           "Text",
+	AnnotationHighlight:      "Highlight",
+	AnnotationUnderline:      "Underline",
+	AnnotationSquare:         "Square",
+	AnnotationCircle:         "Circle",
+	AnnotationFreeText:       "FreeText",
+	AnnotationStamp:          "Stamp",
+	AnnotationFileAttachment: "FileAttachment",
+}
+
+// Annotation is a markup annotation, such as a review comment, added to a
+// page with AddAnnotation. Viewers list annotations with their author and
+// contents, and show the contents of most kinds in a pop-up window.
+type Annotation struct {
+	Kind AnnotationKind
+
+	// Rect is the area of the annotation in gg coordinates, within the
+	// page. For highlights and underlines it may be left empty to cover
+	// Quads.
+	Rect recording.Rect
+
+	// Quads are the areas, typically lines of text, that a highlight or
+	// underline marks, in gg coordinates.
+	Quads []recording.Rect
+
+	// Author and Contents are the author and text of the comment.
+	Author, Contents string
+
+	// Color is the color of the annotation; the zero value uses yellow.
+	// Its alpha sets the opacity.
+	Color gg.RGBA
+
+	// Created is the creation date; the zero value omits it.
+	Created time.Time
+
+	// Open shows the pop-up window, or the note of a text annotation, open
+	// when the file is opened.
+	Open bool
+
+	// Icon is the icon of a text note, such as "Comment", "Help", or
+	// "Note", the name of a stamp, such as "Approved" or "Draft", and the
+	// icon of a file attachment, such as "Paperclip" or "Graph". Empty
+	// uses "Note", "Draft", and "PushPin".
+	Icon string
+
+	// File is the file that a file attachment annotation embeds.
+	File *EmbeddedFile
+
+	// Face selects the registered font and the size of the text of
+	// free-text annotations and stamps, as for DrawText.
+	Face text.Face
+
+	// Appearance, if set, is drawn scaled to fill Rect in place of the
+	// generated appearance.
+	Appearance *recording.Recording
+}
+
+// pageAnnotation is an annotation together with the page it is on.
+type pageAnnotation struct {
+	Annotation
+	page *Backend
+}
+
+// defaultAnnotationColor is the color of annotations without one.
+var defaultAnnotationColor = gg.RGBA{R: 1, G: 0.85, B: 0, A: 1}
+
+// AddAnnotation adds a markup annotation to the page:
+//
+//	_ = b.AddAnnotation(pdf.Annotation{
+//		Kind:     pdf.AnnotationText,
+//		Rect:     recording.NewRect(410, 95, 20, 20),
+//		Author:   "Reviewer",
+//		Contents: "Q3 revenue looks low; please check the source data.",
+//	})
+//
+// Appearances are generated unless Appearance is set, so annotations
+// display the same in every viewer.
+func (b *Backend) AddAnnotation(a Annotation) error {
+	if a.Kind < AnnotationText || a.Kind > AnnotationFileAttachment {
+		return fmt.Errorf("pdf: unknown annotation kind %d", a.Kind)
+	}
+	if a.Kind == AnnotationFileAttachment {
+		if a.File == nil {
+			return fmt.Errorf("pdf: %s annotation has no file", subtypes[a.Kind])
+		}
+		if err := a.File.validate(); err != nil {
+			return err
+		}
+		f := *a.File
+		f.Data = append([]byte(nil), f.Data...)
+		a.File = &f
+	}
+	if a.Kind == AnnotationHighlight || a.Kind == AnnotationUnderline {
+		if len(a.Quads) == 0 {
+			return fmt.Errorf("pdf: %s annotation has no quads", subtypes[a.Kind])
+		}
+		if a.Rect.IsEmpty() {
+			a.Rect = a.Quads[0]
+			for _, q := range a.Quads[1:] {
+				a.Rect = a.Rect.Union(q)
+			}
+		}
+	}
+	if a.Rect.IsEmpty() || !rectContains(recording.NewRect(0, 0, b.width, b.height), a.Rect) {
+		return fmt.Errorf("pdf: %s annotation rectangle %v is empty or outside the page", subtypes[a.Kind], a.Rect)
+	}
+	if (a.Kind == AnnotationFreeText || a.Kind == AnnotationStamp) && a.Appearance == nil &&
+		b.shared.fontFor(a.Face) == nil && (b.shared.conformance.requiresEmbeddedFonts() || b.shared.pdfua) {
+		return fmt.Errorf("%w (%s annotation)", errNoEmbeddedFont, subtypes[a.Kind])
+	}
+	if a.Color == (gg.RGBA{}) {
+		a.Color = defaultAnnotationColor
+	}
+	b.shared.annotations = append(b.shared.annotations, &pageAnnotation{Annotation: a, page: b})
+	return nil
+}
+
+// writeAnnotations adds the annotations to their pages. Embedded files
+// without a modification date get the one of meta.
+func writeAnnotations(out *outputFile, pages []*Backend, shared *sharedResources, meta *metadata) error {
+	targets := make(map[*Backend]*pdfIndirect, len(pages))
+	for i, b := range pages {
+		targets[b] = out.pages[i]
+	}
+	for _, a := range shared.annotations {
+		page, ok := targets[a.page]
+		if !ok {
+			continue
+		}
+		height := a.page.height
+		rect := pageRect(a.Rect, height)
+		col := a.Color
+		dict := pdfDict{
+			"Type":    pdfName("Annot"),
+			"Subtype": subtypes[a.Kind],
+			"Rect":    rect,
+			"P":       page,
+			"F":       4, // print
+			"C":       pdfArray{col.R, col.G, col.B},
+		}
+		if col.A < 1 {
+			dict["CA"] = col.A
+		}
+		if a.Author != "" {
+			dict["T"] = textString(a.Author)
+		}
+		if a.Contents != "" {
+			dict["Contents"] = textString(a.Contents)
+		}
+		if !a.Created.IsZero() {
+			date := pdfString(formatPDFDate(a.Created))
+			dict["CreationDate"], dict["M"] = date, date
+		}
+
+		switch a.Kind {
+		case AnnotationText:
+			dict["Name"] = pdfName(iconOr(a.Icon, "Note"))
+			dict["Open"] = a.Open
+			dict["F"] = 4 | 8 | 16 // print, no zoom, no rotate
+		case AnnotationHighlight, AnnotationUnderline:
+			var quads pdfArray
+			for _, q := range a.Quads {
+				// Upper left, upper right, lower left, lower right.
+				quads = append(quads, q.MinX, height-q.MinY, q.MaxX, height-q.MinY, q.MinX, height-q.MaxY, q.MaxX, height-q.MaxY)
+			}
+			dict["QuadPoints"] = quads
+		case AnnotationStamp:
+			dict["Name"] = pdfName(iconOr(a.Icon, "Draft"))
+		case AnnotationFileAttachment:
+			spec := a.File.filespec(meta.modified)
+			dict["Name"] = pdfName(iconOr(a.Icon, "PushPin"))
+			dict["FS"] = spec
+			dict["AF"] = pdfArray{spec}
+			dict["F"] = 4 | 8 | 16 // print, no zoom, no rotate
+		}
+
+		var ap *pdfStream
+		if a.Appearance != nil {
+			rec := a.Appearance
+			h := float64(rec.Height())
+			captured := a.page.captureContent(func() { a.page.playInto(rec) })
+			if a.page.err != nil {
+				return a.page.err
+			}
+			ap = flateStream(pdfDict{
+				"Type":      pdfName("XObject"),
+				"Subtype":   pdfName("Form"),
+				"BBox":      rectArray(0, 0, float64(rec.Width()), h),
+				"Matrix":    pdfArray{1.0, 0.0, 0.0, -1.0, 0.0, h},
+				"Resources": captured.res.dict(),
+			}, captured.buf.Bytes())
+		} else {
+			c := newContentStream(shared)
+			if a.Kind == AnnotationFreeText || a.Kind == AnnotationStamp {
+				dict["DA"] = a.drawText(c, height)
+			} else {
+				a.draw(c, height)
+			}
+			// The appearance is drawn in page coordinates, which its bounding
+			// box maps onto the annotation rectangle unchanged.
+			ap = flateStream(pdfDict{
+				"Type":      pdfName("XObject"),
+				"Subtype":   pdfName("Form"),
+				"BBox":      rect,
+				"Resources": c.res.dict(),
+			}, c.buf.Bytes())
+		}
+		dict["AP"] = pdfDict{"N": ap}
+
+		annot := newIndirect(dict)
+		addAnnotation(page, annot)
+		if a.Kind != AnnotationFreeText && a.Contents != "" {
+			popup := newIndirect(pdfDict{
+				"Type":    pdfName("Annot"),
+				"Subtype": pdfName("Popup"),
+				"Rect":    popupRect(a.Rect, a.page.width, height),
+				"Parent":  annot,
+				"Open":    a.Open,
+			})
+			dict["Popup"] = popup
+			addAnnotation(page, popup)
+		}
+	}
+	return nil
+}
+
+// iconOr returns icon, or def if icon is empty.
+func iconOr(icon, def string) string {
+	if icon == "" {
+		return def
+	}
+	return icon
+}
+
+// popupRect returns the rectangle of the pop-up window of an annotation at
+// r: beside it, and within the page as far as possible.
+func popupRect(r recording.Rect, width, height float64) pdfArray {
+	const w, h = 200.0, 120.0
+	x := math.Max(0, math.Min(r.MaxX+10, width-w))
+	y := math.Max(0, math.Min(r.MinY, height-h))
+	return pageRect(recording.NewRect(x, y, w, h), height)
+}
+
+// draw writes the generated appearance of a note, highlight, underline,
+// square, circle, or file attachment in page coordinates.
+func (a *pageAnnotation) draw(c *contentStream, height float64) {
+	r := a.Rect
+	llx, lly, urx, ury := r.MinX, height-r.MaxY, r.MaxX, height-r.MinY
+	col := a.Color
+	col.A = 1
+	switch a.Kind {
+	case AnnotationText:
+		// A sheet of paper with three lines of writing.
+		w, h := urx-llx, ury-lly
+		c.fillColor(col)
+		c.strokeColor(gg.Black)
+		c.op("w", 0.05*math.Min(w, h))
+		c.op("re", llx+0.1*w, lly+0.05*h, 0.8*w, 0.9*h)
+		c.op("B")
+		for i := 1; i <= 3; i++ {
+			y := ury - 0.05*h - 0.225*h*float64(i)
+			c.op("m", llx+0.25*w, y)
+			c.op("l", urx-0.25*w, y)
+		}
+		c.op("S")
+	case AnnotationHighlight:
+		c.blendMode = "Multiply"
+		c.opacity(1, 1)
+		c.fillColor(col)
+		for _, q := range a.Quads {
+			c.op("re", q.MinX, height-q.MaxY, q.Width(), q.Height())
+		}
+		c.op("f")
+	case AnnotationUnderline:
+		c.strokeColor(col)
+		for _, q := range a.Quads {
+			lw := q.Height() / 14
+			c.op("w", lw)
+			c.op("m", q.MinX, height-q.MaxY+lw)
+			c.op("l", q.MaxX, height-q.MaxY+lw)
+			c.op("S")
+		}
+	case AnnotationFileAttachment:
+		// A sheet of paper with a folded corner.
+		w, h := urx-llx, ury-lly
+		fold := 0.3 * math.Min(w, h)
+		left, right, bottom, top := llx+0.15*w, urx-0.15*w, lly+0.05*h, ury-0.05*h
+		c.fillColor(col)
+		c.strokeColor(gg.Black)
+		c.op("w", 0.05*math.Min(w, h))
+		c.op("m", left, bottom)
+		c.op("l", left, top)
+		c.op("l", right-fold, top)
+		c.op("l", right, top-fold)
+		c.op("l", right, bottom)
+		c.op("h")
+		c.op("B")
+		c.op("m", right-fold, top)
+		c.op("l", right-fold, top-fold)
+		c.op("l", right, top-fold)
+		c.op("S")
+	case AnnotationSquare:
+		c.strokeColor(col)
+		c.op("w", 1.0)
+		c.op("re", llx+0.5, lly+0.5, urx-llx-1, ury-lly-1)
+		c.op("S")
+	case AnnotationCircle:
+		c.strokeColor(col)
+		c.op("w", 1.0)
+		ellipse(c, (llx+urx)/2, (lly+ury)/2, (urx-llx)/2-0.5, (ury-lly)/2-0.5)
+		c.op("S")
+	}
+}
+
+// drawText writes the generated appearance of a free-text annotation or a
+// stamp in page coordinates, and returns its default appearance string.
+func (a *pageAnnotation) drawText(c *contentStream, height float64) pdfString {
+	r := a.Rect
+	llx, lly := r.MinX, height-r.MaxY
+	col := a.Color
+	col.A = 1
+	font := newFieldFont(c.res, a.page.shared, a.Face)
+
+	if a.Kind == AnnotationStamp {
+		// The stamp name in capitals, fitted to the height, in a frame.
+		font.size = 0.5 * r.Height()
+		lw := 0.06 * r.Height()
+		c.strokeColor(col)
+		c.op("w", lw)
+		c.op("re", llx+lw/2, lly+lw/2, r.Width()-lw, r.Height()-lw)
+		c.op("S")
+		font.show(c, llx+2*lw, lly+(r.Height()-font.size)/2+0.22*font.size, stampLabel(iconOr(a.Icon, "Draft")))
+		return font.defaultAppearance()
+	}
+
+	c.strokeColor(col)
+	c.op("w", 1.0)
+	c.op("re", llx+0.5, lly+0.5, r.Width()-1, r.Height()-1)
+	c.op("S")
+	c.op("q")
+	c.op("re", llx+1, lly+1, r.Width()-2, r.Height()-2)
+	c.op("W")
+	c.op("n")
+	top := height - r.MinY - 2
+	for i, line := range strings.Split(a.Contents, "\n") {
+		font.show(c, llx+3, top-font.size*(0.8+1.15*float64(i)), line)
+	}
+	c.op("Q")
+	return font.defaultAppearance()
+}
+
+// stampLabel returns the text of a stamp named name: "NotApproved" is
+// shown as "NOT APPROVED".
+func stampLabel(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if i > 0 && unicode.IsUpper(r) {
+			b.WriteByte(' ')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// ellipse writes the path of an ellipse with center cx, cy and radii rx, ry
+// as four Bézier curves.
+func ellipse(c *contentStream, cx, cy, rx, ry float64) {
+	const k = 0.5523 // control point distance approximating a quarter circle
+	kx, ky := k*rx, k*ry
+	c.op("m", cx+rx, cy)
+	c.op("c", cx+rx, cy+ky, cx+kx, cy+ry, cx, cy+ry)
+	c.op("c", cx-kx, cy+ry, cx-rx, cy+ky, cx-rx, cy)
+	c.op("c", cx-rx, cy-ky, cx-kx, cy-ry, cx, cy-ry)
+	c.op("c", cx+kx, cy-ry, cx+rx, cy-ky, cx+rx, cy)
+}
@@ -0,0 +1,182 @@
+package pdf
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gogpu/gg"
+	"github.com/gogpu/gg/recording"
+)
+
+// pageAnnotations writes doc and returns the annotations of its first page.
+func pageAnnotations(t *testing.T, doc *Document) ([]pdfDict, *pdfReader) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	r, catalog := readOutput(t, buf.Bytes())
+	pagesRoot, _ := r.resolveDict(catalog["Pages"])
+	kids, _ := pagesRoot["Kids"].(pdfArray)
+	page, _ := r.resolveDict(kids[0])
+	annots, _ := page["Annots"].(pdfArray)
+	dicts := make([]pdfDict, len(annots))
+	for i, a := range annots {
+		dicts[i], _ = r.resolveDict(a)
+	}
+	return dicts, r
+}
+
+// annotate adds a to the page.
+func annotate(t *testing.T, page recording.Backend, a Annotation) {
+	t.Helper()
+
+	if err := page.(*pageBackend).AddAnnotation(a); err != nil {
+		t.Fatalf("AddAnnotation failed: %v", err)
+	}
+}
+
+func TestAnnotationTextNote(t *testing.T) {
+	doc := NewDocument()
+	page := doc.NewPage(300, 200)
+	annotate(t, page, Annotation{
+		Kind:     AnnotationText,
+		Rect:     recording.NewRect(250, 20, 20, 20),
+		Author:   "Reviewer",
+		Contents: "Q3 looks low",
+		Created:  time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC),
+		Open:     true,
+		Icon:     "Comment",
+	})
+
+	annots, r := pageAnnotations(t, doc)
+	if len(annots) != 2 {
+		t.Fatalf("page has %d annotations, want the note and its pop-up", len(annots))
+	}
+	note, popup := annots[0], annots[1]
+	rect, _ := note["Rect"].(pdfArray)
+	if note["Subtype"] != pdfName("Text") || note["T"] != pdfString("Reviewer") || note["Contents"] != pdfString("Q3 looks low") ||
+		note["Name"] != pdfName("Comment") || note["Open"] != true || note["CreationDate"] != pdfString("D:20261018120000Z") ||
+		formatArray(rect) != "[250.00 160.00 270.00 180.00]" {
+		t.Errorf("note = %v", note)
+	}
+	if color, _ := note["C"].(pdfArray); formatArray(color) != "[1.00 0.85 0.00]" {
+		t.Errorf("note /C = %v, want the default yellow", note["C"])
+	}
+	if popup["Subtype"] != pdfName("Popup") || popup["Open"] != true || note["Popup"] == nil {
+		t.Errorf("pop-up = %v, want it linked and open", popup)
+	}
+	// The pop-up is kept on the page, left of the note at the right edge.
+	if prect, _ := popup["Rect"].(pdfArray); formatArray(prect) != "[100.00 60.00 300.00 180.00]" {
+		t.Errorf("pop-up /Rect = %v", popup["Rect"])
+	}
+	if content := appearanceContent(t, r, note, ""); !strings.Contains(content, "re\nB\n") {
+		t.Errorf("note appearance does not draw the icon:\n%s", content)
+	}
+}
+
+func TestAnnotationMarkup(t *testing.T) {
+	doc := NewDocument()
+	page := doc.NewPage(300, 200)
+	lines := []recording.Rect{recording.NewRect(10, 10, 100, 12), recording.NewRect(10, 24, 60, 12)}
+	annotate(t, page, Annotation{Kind: AnnotationHighlight, Quads: lines, Color: gg.RGBA{R: 0, G: 1, B: 0, A: 0.5}})
+	annotate(t, page, Annotation{Kind: AnnotationUnderline, Quads: lines[:1]})
+	annotate(t, page, Annotation{Kind: AnnotationSquare, Rect: recording.NewRect(50, 50, 40, 30)})
+	annotate(t, page, Annotation{Kind: AnnotationCircle, Rect: recording.NewRect(100, 50, 40, 30), Contents: "here"})
+
+	annots, r := pageAnnotations(t, doc)
+	if len(annots) != 5 {
+		t.Fatalf("page has %d annotations, want four and one pop-up", len(annots))
+	}
+	highlight := annots[0]
+	rect, _ := highlight["Rect"].(pdfArray)
+	quads, _ := highlight["QuadPoints"].(pdfArray)
+	if formatArray(rect) != "[10.00 164.00 110.00 190.00]" || len(quads) != 16 || highlight["CA"] != 0.5 {
+		t.Errorf("highlight = %v, want the union of two quads at half opacity", highlight)
+	}
+	if formatArray(quads[:8]) != "[10.00 190.00 110.00 190.00 10.00 178.00 110.00 178.00]" {
+		t.Errorf("first quad = %v", quads[:8])
+	}
+	ap, _ := highlight["AP"].(pdfDict)
+	obj, _ := r.resolve(ap["N"])
+	form, _ := obj.(*pdfStream)
+	res, _ := form.Dict["Resources"].(pdfDict)
+	gs, _ := res["ExtGState"].(pdfDict)
+	if state, _ := r.resolveDict(gs["GS1"]); state["BM"] != pdfName("Multiply") {
+		t.Errorf("highlight graphics state = %v, want Multiply", state)
+	}
+
+	for i, want := range []string{"S\n", "re\nS\n", " c\nS\n"} {
+		if content := appearanceContent(t, r, annots[i+1], ""); !strings.HasSuffix(content, want) {
+			t.Errorf("%v appearance does not end with %q:\n%s", annots[i+1]["Subtype"], want, content)
+		}
+	}
+	if annots[4]["Subtype"] != pdfName("Popup") {
+		t.Errorf("last annotation = %v, want the circle's pop-up", annots[4])
+	}
+}
+
+func TestAnnotationFreeTextAndStamp(t *testing.T) {
+	doc := NewDocument()
+	page := doc.NewPage(300, 200)
+	annotate(t, page, Annotation{Kind: AnnotationFreeText, Rect: recording.NewRect(10, 10, 150, 40), Contents: "Check\nthis"})
+	annotate(t, page, Annotation{Kind: AnnotationStamp, Rect: recording.NewRect(10, 100, 150, 40), Icon: "NotApproved"})
+
+	rec := recording.NewRecorder(30, 10)
+	rec.SetFillRGB(1, 0, 0)
+	rec.DrawRectangle(0, 0, 30, 10)
+	rec.Fill()
+	annotate(t, page, Annotation{Kind: AnnotationStamp, Rect: recording.NewRect(200, 100, 60, 20), Appearance: rec.FinishRecording()})
+
+	annots, r := pageAnnotations(t, doc)
+	if len(annots) != 3 {
+		t.Fatalf("page has %d annotations, want three without pop-ups", len(annots))
+	}
+	free := appearanceContent(t, r, annots[0], "")
+	if !strings.Contains(free, "(Check) Tj") || !strings.Contains(free, "(this) Tj") || annots[0]["DA"] == nil {
+		t.Errorf("free text appearance does not show the contents:\n%s", free)
+	}
+	if annots[1]["Name"] != pdfName("NotApproved") {
+		t.Errorf("stamp /Name = %v", annots[1]["Name"])
+	}
+	if stamp := appearanceContent(t, r, annots[1], ""); !strings.Contains(stamp, "(NOT APPROVED) Tj") {
+		t.Errorf("stamp appearance does not show its name:\n%s", stamp)
+	}
+
+	ap, _ := annots[2]["AP"].(pdfDict)
+	obj, _ := r.resolve(ap["N"])
+	form, _ := obj.(*pdfStream)
+	if bbox, _ := form.Dict["BBox"].(pdfArray); formatArray(bbox) != "[0.00 0.00 30.00 10.00]" {
+		t.Errorf("recorded appearance /BBox = %v, want the recording's size", form.Dict["BBox"])
+	}
+	if content := appearanceContent(t, r, annots[2], ""); !strings.Contains(content, "1 0 0 rg\n") {
+		t.Errorf("recorded appearance does not draw the recording:\n%s", content)
+	}
+}
+
+func TestAnnotationErrors(t *testing.T) {
+	page := NewDocument().NewPage(100, 100).(*pageBackend)
+	for _, a := range []Annotation{
+		{Kind: AnnotationKind(42), Rect: recording.NewRect(0, 0, 10, 10)},
+		{Kind: AnnotationText},
+		{Kind: AnnotationHighlight},
+		{Kind: AnnotationSquare, Rect: recording.NewRect(95, 10, 10, 10)},
+		{Kind: AnnotationUnderline, Quads: []recording.Rect{recording.NewRect(10, -5, 20, 10)}},
+	} {
+		if err := page.AddAnnotation(a); err == nil {
+			t.Errorf("AddAnnotation(%+v) succeeded", a)
+		}
+	}
+
+	pdfa := NewDocument()
+	if err := pdfa.SetConformance(ConformancePDFA2B); err != nil {
+		t.Fatal(err)
+	}
+	stamp := Annotation{Kind: AnnotationStamp, Rect: recording.NewRect(0, 0, 10, 10)}
+	if err := pdfa.NewPage(100, 100).(*pageBackend).AddAnnotation(stamp); err == nil {
+		t.Error("AddAnnotation succeeded with the standard font under PDF/A")
+	}
+}
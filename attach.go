@@ -0,0 +1,197 @@
+package pdf
+
+import (
+	"crypto/md5" //nolint:gosec // the checksum PDF defines for embedded files
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Relationship is how an embedded file relates to the document, written as
+// its /AFRelationship. PDF/A-3 requires it, and invoice formats such as
+// ZUGFeRD and Factur-X prescribe the value for their XML.
+type Relationship int
+
+const (
+	// RelationshipUnspecified leaves the relationship open.
+	RelationshipUnspecified Relationship = iota
+
+	// RelationshipSource marks the original content the document was
+	// created from, such as a word processor file.
+	RelationshipSource
+
+	// RelationshipData marks data that content of the document presents,
+	// such as the values behind a chart.
+	RelationshipData
+
+	// RelationshipAlternative marks another representation of the
+	// document, such as the invoice XML of ZUGFeRD.
+	RelationshipAlternative
+
+	// RelationshipSupplement marks a representation that adds to the
+	// document, such as a version that is easier to process.
+	RelationshipSupplement
+)
+
+// relationships are the /AFRelationship names of the relationships.
+var relationships = [...]pdfName{
+	RelationshipUnspecified: "Unspecified",
+	RelationshipSource:      "Source",
+	RelationshipData:        "Data",
+	RelationshipAlternative: "Alternative",
+	RelationshipSupplement:  "Supplement",
+}
+
+// defaultMIMEType is the MIME type of embedded files without one.
+const defaultMIMEType = "application/octet-stream"
+
+// EmbeddedFile is a file carried inside the PDF, attached to the document
+// with AttachFile or to a page with a file attachment annotation. Viewers
+// list the attachments and let readers save or open them.
+type EmbeddedFile struct {
+	// Name is the file name shown to readers, such as "revenue.csv".
+	// Document-level attachments must have distinct names.
+	Name string
+
+	// Data is the content of the file.
+	Data []byte
+
+	// MIMEType is the media type of the file, such as "text/csv"; empty
+	// uses application/octet-stream.
+	MIMEType string
+
+	// Description is shown next to the name in the attachments list.
+	Description string
+
+	// Created is the creation date of the file; the zero value omits it.
+	// Modified is the modification date; the zero value uses the
+	// modification date of the document.
+	Created, Modified time.Time
+
+	// Relationship is how the file relates to the document.
+	Relationship Relationship
+}
+
+// validate reports whether f can be embedded.
+func (f *EmbeddedFile) validate() error {
+	if f.Name == "" {
+		return fmt.Errorf("pdf: embedded file has no name")
+	}
+	if f.Relationship < RelationshipUnspecified || f.Relationship > RelationshipSupplement {
+		return fmt.Errorf("pdf: unknown relationship %d for embedded file %q", f.Relationship, f.Name)
+	}
+	if f.MIMEType != "" && !strings.Contains(f.MIMEType, "/") {
+		return fmt.Errorf("pdf: embedded file %q has invalid MIME type %q", f.Name, f.MIMEType)
+	}
+	return nil
+}
+
+// AttachFile embeds f in the document, such as the CSV behind a chart:
+//
+//	_ = b.AttachFile(pdf.EmbeddedFile{
+//		Name:         "revenue.csv",
+//		Data:         csv,
+//		MIMEType:     "text/csv",
+//		Description:  "Quarterly revenue shown in figure 1",
+//		Relationship: pdf.RelationshipData,
+//	})
+//
+// The file is listed in the document's /EmbeddedFiles name tree and its
+// associated files. PDF/A-2b does not allow embedded files; use
+// ConformancePDFA3B.
+func (b *Backend) AttachFile(f EmbeddedFile) error {
+	return b.shared.attachFile(f)
+}
+
+// attachFile adds f to the document-level embedded files.
+func (s *sharedResources) attachFile(f EmbeddedFile) error {
+	if err := f.validate(); err != nil {
+		return err
+	}
+	for _, other := range s.attachments {
+		if other.Name == f.Name {
+			return fmt.Errorf("pdf: duplicate embedded file %q", f.Name)
+		}
+	}
+	f.Data = append([]byte(nil), f.Data...)
+	s.attachments = append(s.attachments, &f)
+	return nil
+}
+
+// hasEmbeddedFiles reports whether the document or its annotations embed
+// any files.
+func (s *sharedResources) hasEmbeddedFiles() bool {
+	if len(s.attachments) > 0 {
+		return true
+	}
+	for _, a := range s.annotations {
+		if a.Kind == AnnotationFileAttachment {
+			return true
+		}
+	}
+	return false
+}
+
+// writeAttachments adds the document-level embedded files to the catalog's
+// /EmbeddedFiles name tree and its /AF associated files.
+func writeAttachments(out *outputFile, shared *sharedResources, meta *metadata) {
+	files := append([]*EmbeddedFile(nil), shared.attachments...)
+	// Name tree keys are sorted by their bytes.
+	sort.Slice(files, func(i, j int) bool {
+		return textString(files[i].Name) < textString(files[j].Name)
+	})
+	names := make(pdfArray, 0, 2*len(files))
+	af := make(pdfArray, 0, len(files))
+	for _, f := range files {
+		spec := f.filespec(meta.modified)
+		names = append(names, textString(f.Name), spec)
+		af = append(af, spec)
+	}
+
+	dict, _ := out.catalog["Names"].(pdfDict)
+	if dict == nil {
+		dict = pdfDict{}
+	}
+	dict["EmbeddedFiles"] = pdfDict{"Names": names}
+	out.catalog["Names"] = dict
+	out.catalog["AF"] = af
+}
+
+// filespec returns the file specification that embeds f. Modified is used
+// when f has no modification date.
+func (f *EmbeddedFile) filespec(modified time.Time) *pdfIndirect {
+	if !f.Modified.IsZero() {
+		modified = f.Modified
+	}
+	sum := md5.Sum(f.Data) //nolint:gosec // the checksum PDF defines for embedded files
+	params := pdfDict{
+		"Size":     len(f.Data),
+		"CheckSum": pdfHexString(sum[:]),
+		"ModDate":  pdfString(formatPDFDate(modified)),
+	}
+	if !f.Created.IsZero() {
+		params["CreationDate"] = pdfString(formatPDFDate(f.Created))
+	}
+	mime := f.MIMEType
+	if mime == "" {
+		mime = defaultMIMEType
+	}
+	stream := flateStream(pdfDict{
+		"Type":    pdfName("EmbeddedFile"),
+		"Subtype": pdfName(mime),
+		"Params":  params,
+	}, f.Data)
+
+	spec := pdfDict{
+		"Type":           pdfName("Filespec"),
+		"F":              textString(f.Name),
+		"UF":             textString(f.Name),
+		"EF":             pdfDict{"F": stream, "UF": stream},
+		"AFRelationship": relationships[f.Relationship],
+	}
+	if f.Description != "" {
+		spec["Desc"] = textString(f.Description)
+	}
+	return newIndirect(spec)
+}
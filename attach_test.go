@@ -0,0 +1,163 @@
+package pdf
+
+import (
+	"bytes"
+	"crypto/md5" //nolint:gosec // the checksum PDF defines for embedded files
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gogpu/gg/recording"
+)
+
+// embeddedData returns the embedded file stream of a file specification and
+// its decoded content.
+func embeddedData(t *testing.T, r *pdfReader, spec pdfDict) (*pdfStream, []byte) {
+	t.Helper()
+
+	ef, _ := spec["EF"].(pdfDict)
+	obj, _ := r.resolve(ef["F"])
+	stream, ok := obj.(*pdfStream)
+	if !ok {
+		t.Fatalf("file specification %v has no embedded file stream", spec)
+	}
+	data, err := decodeStream(stream)
+	if err != nil {
+		t.Fatalf("failed to decode embedded file: %v", err)
+	}
+	return stream, data
+}
+
+func TestAttachFile(t *testing.T) {
+	csv := []byte("quarter,revenue\nQ1,120\nQ2,135\n")
+	doc := NewDocument()
+	doc.NewPage(100, 100)
+	doc.SetModDate(time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC))
+	for _, f := range []EmbeddedFile{
+		{
+			Name:         "revenue.csv",
+			Data:         csv,
+			MIMEType:     "text/csv",
+			Description:  "Revenue chart data",
+			Created:      time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC),
+			Relationship: RelationshipData,
+		},
+		{Name: "invoice.xml", Data: []byte("<Invoice/>"), Relationship: RelationshipAlternative},
+	} {
+		if err := doc.AttachFile(f); err != nil {
+			t.Fatalf("AttachFile(%q) failed: %v", f.Name, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	r, catalog := readOutput(t, buf.Bytes())
+	names, _ := r.resolveDict(catalog["Names"])
+	tree, _ := r.resolveDict(names["EmbeddedFiles"])
+	entries, _ := tree["Names"].(pdfArray)
+	af, _ := catalog["AF"].(pdfArray)
+	if len(entries) != 4 || entries[0] != pdfString("invoice.xml") || entries[2] != pdfString("revenue.csv") || len(af) != 2 {
+		t.Fatalf("/EmbeddedFiles = %v, /AF = %v, want both files sorted by name", entries, af)
+	}
+
+	spec, _ := r.resolveDict(entries[3])
+	if spec["Type"] != pdfName("Filespec") || spec["UF"] != pdfString("revenue.csv") ||
+		spec["Desc"] != pdfString("Revenue chart data") || spec["AFRelationship"] != pdfName("Data") {
+		t.Errorf("file specification = %v", spec)
+	}
+	stream, data := embeddedData(t, r, spec)
+	if !bytes.Equal(data, csv) || stream.Dict["Subtype"] != pdfName("text/csv") {
+		t.Errorf("embedded file %v holds %q, want the CSV", stream.Dict, data)
+	}
+	params, _ := stream.Dict["Params"].(pdfDict)
+	sum := md5.Sum(csv) //nolint:gosec // the checksum PDF defines for embedded files
+	if params["Size"] != len(csv) || params["CheckSum"] != pdfString(sum[:]) ||
+		params["CreationDate"] != pdfString("D:20261001080000Z") || params["ModDate"] != pdfString("D:20261018120000Z") {
+		t.Errorf("/Params = %v, want the size, checksum, and dates", params)
+	}
+
+	invoice, _ := r.resolveDict(entries[1])
+	stream, _ = embeddedData(t, r, invoice)
+	if invoice["AFRelationship"] != pdfName("Alternative") || stream.Dict["Subtype"] != pdfName(defaultMIMEType) {
+		t.Errorf("invoice = %v, %v, want the alternative relationship and default MIME type", invoice, stream.Dict)
+	}
+}
+
+func TestAttachFileAnnotation(t *testing.T) {
+	doc := NewDocument()
+	page := doc.NewPage(300, 200)
+	annotate(t, page, Annotation{
+		Kind:     AnnotationFileAttachment,
+		Rect:     recording.NewRect(250, 20, 16, 20),
+		Contents: "Source data",
+		Icon:     "Graph",
+		File:     &EmbeddedFile{Name: "chart.csv", Data: []byte("x,y\n1,2\n"), MIMEType: "text/csv", Relationship: RelationshipSource},
+	})
+
+	annots, r := pageAnnotations(t, doc)
+	if len(annots) != 2 {
+		t.Fatalf("page has %d annotations, want the attachment and its pop-up", len(annots))
+	}
+	attachment := annots[0]
+	spec, _ := r.resolveDict(attachment["FS"])
+	af, _ := attachment["AF"].(pdfArray)
+	if attachment["Subtype"] != pdfName("FileAttachment") || attachment["Name"] != pdfName("Graph") ||
+		spec["AFRelationship"] != pdfName("Source") || len(af) != 1 {
+		t.Errorf("attachment = %v, file specification = %v", attachment, spec)
+	}
+	if _, data := embeddedData(t, r, spec); string(data) != "x,y\n1,2\n" {
+		t.Errorf("embedded file holds %q", data)
+	}
+	if content := appearanceContent(t, r, attachment, ""); !strings.Contains(content, "h\nB\n") {
+		t.Errorf("attachment appearance does not draw the icon:\n%s", content)
+	}
+}
+
+func TestAttachFileConformance(t *testing.T) {
+	file := EmbeddedFile{Name: "data.csv", Data: []byte("1,2\n"), MIMEType: "text/csv"}
+	for _, tt := range []struct {
+		level Conformance
+		ok    bool
+	}{
+		{ConformancePDFA2B, false},
+		{ConformancePDFA3B, true},
+	} {
+		doc := newPDFADocument(t, tt.level)
+		doc.NewPage(100, 100)
+		if err := doc.AttachFile(file); err != nil {
+			t.Fatalf("AttachFile failed: %v", err)
+		}
+		if _, err := doc.WriteTo(&bytes.Buffer{}); (err == nil) != tt.ok {
+			t.Errorf("%s: WriteTo error = %v, want success %v", tt.level, err, tt.ok)
+		}
+	}
+}
+
+func TestAttachFileErrors(t *testing.T) {
+	doc := NewDocument()
+	if err := doc.AttachFile(EmbeddedFile{Name: "a.csv"}); err != nil {
+		t.Fatalf("AttachFile failed: %v", err)
+	}
+	for _, f := range []EmbeddedFile{
+		{},
+		{Name: "a.csv"},
+		{Name: "b.csv", Relationship: Relationship(9)},
+		{Name: "c.csv", MIMEType: "csv"},
+	} {
+		if err := doc.AttachFile(f); err == nil {
+			t.Errorf("AttachFile(%+v) succeeded", f)
+		}
+	}
+
+	page := doc.NewPage(100, 100).(*pageBackend)
+	for _, a := range []Annotation{
+		{Kind: AnnotationFileAttachment, Rect: recording.NewRect(0, 0, 10, 10)},
+		{Kind: AnnotationFileAttachment, Rect: recording.NewRect(0, 0, 10, 10), File: &EmbeddedFile{}},
+	} {
+		if err := page.AddAnnotation(a); err == nil {
+			t.Errorf("AddAnnotation(%+v) succeeded", a)
+		}
+	}
+}
@@ -1,28 +1,26 @@
 package pdf
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
 	"image"
-	"image/png"
 	"io"
+	"math"
+	"unicode/utf8"
 
-	"github.com/coregx/gxpdf/creator"
 	"github.com/gogpu/gg"
 	"github.com/gogpu/gg/recording"
+	"github.com/gogpu/gg/scene"
 	"github.com/gogpu/gg/text"
 )
 
 // Backend implements recording.Backend for PDF output.
-// It uses gxpdf to generate PDF documents from recorded drawing commands.
+// It writes the document structure and the page content stream itself, so
+// that fonts, images, and transparency are under its control.
 //
 // PDF coordinates use a bottom-left origin, while gg uses a top-left origin.
 // This backend handles the coordinate transformation automatically.
 type Backend struct {
-	creator *creator.Creator
-	page    *creator.Page
-	surface *creator.Surface
-
 	width  float64
 	height float64
 
@@ -31,11 +29,68 @@ type Backend struct {
 
 	// Current graphics state
 	currentTransform recording.Matrix
+
+	// spanOpen is set while an alternate or actual text span set at the
+	// current Save level is open.
+	spanOpen bool
+
+	// blendMode is the blend mode of the current graphics state.
+	blendMode scene.BlendMode
+
+	// clips and mask are the clip paths and the soft mask of the current
+	// graphics state, for content drawn as pixels. mask holds the calls
+	// that draw it.
+	clips []clipPath
+	mask  []func(*rasterizer)
+
+	// Drawing calls kept for the rasterizer while a transparency group,
+	// mask, or captured content is open, as the backdrop of content drawn
+	// in it as pixels; nil otherwise. Content drawn on the page needs no
+	// backdrop.
+	history []func(*rasterizer)
+
+	// captured is set while content is captured for a form, pattern cell,
+	// or appearance, which is drawn as pixels on a transparent backdrop
+	// instead of over the page.
+	captured bool
+
+	// groups are the open transparency groups and soft masks, innermost
+	// last.
+	groups []groupState
+
+	// layers are the open optional content groups, innermost last.
+	layers []layerState
+
+	// Page content and the resources shared with other pages of the file
+	content *contentStream
+	shared  *sharedResources
+	meta    *metadata
+
+	// Explicit trim and bleed boxes in gg coordinates, if set
+	trimBox, bleedBox *recording.Rect
+
+	// source is the recording embedded in the page for later editing.
+	source *recording.Recording
+
+	// err is the first drawing error. Drawing methods cannot return errors,
+	// so it is reported by End and WriteTo instead.
+	err error
 }
 
 // backendState stores the graphics state for Save/Restore operations.
 type backendState struct {
 	transform recording.Matrix
+	spanOpen  bool
+	blendMode scene.BlendMode
+	clips     []clipPath
+	mask      []func(*rasterizer)
+}
+
+// clipPath is a clip path with the transform it was set with.
+type clipPath struct {
+	path      *gg.Path
+	rule      recording.FillRule
+	transform recording.Matrix
 }
 
 // NewBackend creates a new PDF backend.
@@ -44,93 +99,390 @@ type backendState struct {
 func NewBackend() *Backend {
 	return &Backend{
 		stateStack: make([]backendState, 0, 8),
+		shared:     newSharedResources(),
+		meta:       newMetadata(),
+	}
+}
+
+// SetConformance selects a standard, such as PDF/A-2b, that the output must
+// conform to. It should be called before drawing so that violations are
+// reported by the drawing operation that causes them.
+func (b *Backend) SetConformance(level Conformance) error {
+	if err := level.validate(); err != nil {
+		return err
+	}
+	if level.isPDFX() {
+		return fmt.Errorf("pdf: %s output requires a Document", level)
+	}
+	b.shared.conformance = level
+	return nil
+}
+
+// RegisterFont registers a TrueType or OpenType font for DrawText and embeds
+// it in the output. Text is drawn with the registered font whose family name
+// matches the face's font source, or with the first registered font if none
+// matches. Without registered fonts, text uses the standard Helvetica font,
+// which is not embedded and covers only the WinAnsi character set.
+func (b *Backend) RegisterFont(data []byte) error {
+	return b.shared.registerFont(data)
+}
+
+// SetColorManagement selects the color model that fills, strokes,
+// gradients, and text are written in and how gg's sRGB colors are
+// converted to it. It should be called before drawing.
+func (b *Backend) SetColorManagement(cm ColorManagement) error {
+	return b.shared.setColorManagement(cm)
+}
+
+// RegisterSpotColor maps a gg color to a spot color: wherever the color is
+// drawn, it is written as a Separation colorant with the given name, such
+// as "PANTONE 185 C", and an alternate CMYK for devices without the ink.
+// Colors are matched on their RGB components.
+func (b *Backend) RegisterSpotColor(name string, color gg.RGBA, cmyk [4]float64) error {
+	return b.shared.colors.registerSpot(name, color, cmyk)
+}
+
+// SetLanguage sets the natural language of the document as a BCP 47 tag,
+// such as "en-US". Screen readers use it to choose a pronunciation.
+func (b *Backend) SetLanguage(lang string) {
+	b.meta.lang = lang
+}
+
+// BeginStructure opens a structure element for the content drawn until the
+// matching EndStructure, which makes the output a tagged PDF. Elements nest:
+// an element opened while another is open becomes its child. Once the first
+// element is opened, content drawn outside any element is marked as an
+// artifact. Elements opened before Begin apply to the new page.
+func (b *Backend) BeginStructure(kind StructureType, attrs StructureAttributes) error {
+	return b.shared.tags.begin(kind, attrs)
+}
+
+// EndStructure closes the structure element opened last.
+func (b *Backend) EndStructure() error {
+	return b.shared.tags.end()
+}
+
+// markContent opens the marked-content sequence for the next drawing
+// operation in the current structure element. Content in a transparency
+// group is marked where the group is painted.
+func (b *Backend) markContent() {
+	if len(b.groups) > 0 {
+		return
 	}
+	b.content.mark(b.shared.tags.target())
 }
 
 // Begin initializes the backend for rendering at the given dimensions.
 // This creates a new PDF document with a single page of the specified size.
 func (b *Backend) Begin(width, height int) error {
-	widthF := float64(width)
-	heightF := float64(height)
-	newCreator := creator.New()
-
 	// Page dimensions are expressed in PDF points, matching the coordinate
 	// units used by the recording backend. Keep them exact instead of using a
-	// standard-size page as a placeholder.
-	page, err := newCreator.NewPageWithDimensions(widthF, heightF)
-	if err != nil {
-		return fmt.Errorf("pdf: failed to create page: %w", err)
+	// standard-size page as a placeholder. A failed Begin leaves the page from
+	// a previous successful Begin as it was.
+	if width <= 0 || height <= 0 {
+		return fmt.Errorf("pdf: failed to create page: page size must be positive, got %dx%d", width, height)
 	}
-	surface := page.Surface()
-
-	// Stage the complete new state before replacing the current state. A
-	// failed Begin must not leave dimensions/creator from the attempted page
-	// combined with the page and surface from a previous successful Begin.
-	b.width = widthF
-	b.height = heightF
-	b.creator = newCreator
-	b.page = page
-	b.surface = surface
+	b.width = float64(width)
+	b.height = float64(height)
 
 	// Initialize state
 	b.currentTransform = recording.Identity()
 	b.stateStack = b.stateStack[:0]
-
-	// Apply Y-flip transform to convert from top-left to bottom-left origin.
-	flipTransform := creator.Scale(1, -1).Then(creator.Translate(0, b.height))
-	b.surface.PushTransform(flipTransform)
-
+	b.spanOpen = false
+	b.blendMode = scene.BlendNormal
+	b.clips, b.mask, b.history = nil, nil, nil
+	b.groups = nil
+	b.layers = nil
+	b.err = nil
+	b.shared.standardFontUsed = false
+	b.shared.tags.reset()
+	b.shared.fields = nil
+	b.shared.annotations = nil
+	b.beginContent()
 	return nil
 }
 
 // End finalizes the rendering and prepares the output.
 // After End is called, WriteTo and SaveToFile can be used to get the PDF.
 func (b *Backend) End() error {
-	// Pop the initial Y-flip transform
-	b.surface.Pop()
-	return nil
+	b.endContent()
+	return b.err
+}
+
+// beginContent starts the page content stream with the Y-flip transform,
+// which converts gg's top-left origin to the bottom-left origin of PDF.
+func (b *Backend) beginContent() {
+	b.content = newContentStream(b.shared)
+	b.content.op("cm", 1.0, 0.0, 0.0, -1.0, 0.0, b.height)
+}
+
+// endContent closes the groups and masks, layers, marked-content sequences,
+// and graphics states left open by unbalanced calls.
+func (b *Backend) endContent() {
+	for len(b.groups) > 0 {
+		if b.groups[len(b.groups)-1].mask != nil {
+			b.EndMask()
+		} else {
+			b.EndGroup()
+		}
+	}
+	b.endLayers()
+	b.endSpan()
+	for i := len(b.stateStack) - 1; i >= 0; i-- {
+		b.content.op("Q")
+		b.spanOpen = b.stateStack[i].spanOpen
+		b.endSpan()
+	}
+	b.stateStack = b.stateStack[:0]
+}
+
+// SetBlendMode sets how the content drawn from now until the Restore
+// matching the last Save is composited with what lies below it. The
+// separable and non-separable modes, Multiply through Luminosity, are
+// written as the /BM of the graphics state. PDF has no equivalent for the
+// Porter-Duff operators other than source-over, so content drawn with them
+// is rasterized. Plus is only supported inside a transparency group, as it
+// depends on the page content below it.
+func (b *Backend) SetBlendMode(mode scene.BlendMode) {
+	if err := validateBlendMode(mode); err != nil {
+		b.fail(err)
+		return
+	}
+	b.setBlendMode(mode)
+	b.record(func(r *rasterizer) { r.mode = mode })
+}
+
+// setBlendMode sets the blend mode of the graphics state.
+func (b *Backend) setBlendMode(mode scene.BlendMode) {
+	b.blendMode = mode
+	b.content.blendMode = ""
+	if name := pdfBlendModes[mode]; name != "Normal" {
+		b.content.blendMode = name
+	}
+}
+
+// record keeps a call for the rasterizer while a group, mask, or captured
+// content is open.
+func (b *Backend) record(call func(*rasterizer)) {
+	if b.history != nil {
+		b.history = append(b.history, call)
+	}
+}
+
+// rasterState returns a call that sets up a rasterizer with the current
+// transform, blend mode, clip, and mask.
+func (b *Backend) rasterState() func(*rasterizer) {
+	transform, mode := b.currentTransform, b.blendMode
+	clips, mask := b.clips, b.mask
+	return func(r *rasterizer) {
+		for _, call := range mask {
+			call(r)
+		}
+		r.clip = nil
+		for _, c := range clips {
+			r.setTransform(c.transform)
+			r.setClip(c.path, c.rule)
+		}
+		r.setTransform(transform)
+		r.mode = mode
+	}
+}
+
+// rasterize records a drawing call and reports whether it must be drawn as
+// pixels because the blend mode has no PDF equivalent. In that case the
+// area it changes is painted as an image. area bounds the content in user
+// space, or is nil when unknown.
+func (b *Backend) rasterize(call func(*rasterizer), area *recording.Rect) bool {
+	if _, ok := porterDuff[b.blendMode]; !ok {
+		b.record(call)
+		return false
+	}
+	if b.blendMode == scene.BlendPlus && len(b.groups) == 0 {
+		b.fail(errPlusOnPage)
+		return true
+	}
+	if f := porterDuff[b.blendMode]; f[1](0) != 1 {
+		// The mode changes the backdrop where nothing is drawn.
+		area = nil
+	}
+	b.drawRaster(call, area)
+	return true
+}
+
+// errPlusOnPage is reported for content drawn on the page in the Plus
+// blend mode.
+var errPlusOnPage = errors.New("pdf: the Plus blend mode is only supported inside a transparency group")
+
+// drawRaster draws a call as pixels and paints the area it changes as an
+// image.
+func (b *Backend) drawRaster(call func(*rasterizer), area *recording.Rect) {
+	bounds := image.Rect(0, 0, int(math.Ceil(b.width*rasterScale)), int(math.Ceil(b.height*rasterScale)))
+	if area != nil {
+		bounds = bounds.Intersect(deviceRect(b.currentTransform, *area))
+	}
+	for _, c := range b.clips {
+		bounds = bounds.Intersect(deviceRect(c.transform, pathBounds(c.path)))
+	}
+	if bounds.Empty() {
+		b.record(call)
+		return
+	}
+	if bounds.Dx()*bounds.Dy() > maxRasterPixels {
+		b.fail(fmt.Errorf("pdf: content drawn as pixels covers %dx%d pixels, more than the limit of %d",
+			bounds.Dx(), bounds.Dy(), maxRasterPixels))
+		return
+	}
+
+	r := newRasterizer(bounds, !b.captured)
+	if b.history != nil {
+		for _, h := range b.history {
+			h(r)
+		}
+	} else {
+		b.rasterState()(r)
+	}
+	r.dirty = image.Rectangle{}
+	call(r)
+	b.record(call)
+	if r.dirty.Empty() {
+		return
+	}
+	pixels, space, channels, err := b.shared.imageSpace(r.target.SubImage(r.dirty))
+	if err != nil {
+		b.fail(err)
+		return
+	}
+	xobj := imageXObject(pixels, space, channels)
+	dst := recording.NewRect(
+		float64(r.dirty.Min.X)/rasterScale, float64(r.dirty.Min.Y)/rasterScale,
+		float64(r.dirty.Dx())/rasterScale, float64(r.dirty.Dy())/rasterScale,
+	)
+	b.paintImage(xobj, dst, recording.Identity(), 1)
+}
+
+// SetAltText attaches alternate text to the content drawn from now until
+// the Restore matching the last Save, or until the end of the page when no
+// Save is active. Screen readers read the text in place of the content, so
+// a group of paths forming a chart can be described as a whole:
+//
+//	b.Save()
+//	b.SetAltText("Revenue by quarter, Q1 to Q4")
+//	// ... FillPath and StrokePath calls drawing the chart ...
+//	b.Restore()
+//
+// It replaces alternate or actual text set earlier at the same Save level;
+// an empty string ends it. The text is written as a marked-content span and
+// does not require a tagged document.
+func (b *Backend) SetAltText(alt string) {
+	b.beginSpan("Alt", alt)
+}
+
+// SetActualText attaches the exact text that the content drawn until the
+// matching Restore represents, such as text drawn as paths or an image of
+// a word, so that it can be searched and copied. It replaces alternate or
+// actual text set earlier at the same Save level; an empty string ends it.
+func (b *Backend) SetActualText(text string) {
+	b.beginSpan("ActualText", text)
+}
+
+// beginSpan ends the span open at the current Save level and, unless text
+// is empty, opens a new one with the text under key.
+func (b *Backend) beginSpan(key pdfName, text string) {
+	b.endSpan()
+	if text == "" {
+		return
+	}
+	b.content.span(key, text)
+	b.spanOpen = true
+}
+
+// endSpan closes the span open at the current Save level together with any
+// marked-content sequence nested in it.
+func (b *Backend) endSpan() {
+	b.content.endMarked()
+	if b.spanOpen {
+		b.content.op("EMC")
+		b.spanOpen = false
+	}
+}
+
+// pageBoxes returns the trim and bleed boxes to write for the page: the
+// explicit boxes if set, otherwise the media box inset by the document bleed
+// when a bleed is set or the conformance level requires page boxes.
+func (b *Backend) pageBoxes() (trim, bleed recording.Rect, ok bool) {
+	if b.trimBox != nil && b.bleedBox != nil {
+		return *b.trimBox, *b.bleedBox, true
+	}
+	if b.shared.bleed == 0 && !b.shared.conformance.isPDFX() {
+		return trim, bleed, false
+	}
+	d := b.shared.bleed
+	media := recording.NewRect(0, 0, b.width, b.height)
+	return recording.NewRect(d, d, b.width-2*d, b.height-2*d), media, true
+}
+
+// fail records err as the drawing error unless one is already recorded.
+func (b *Backend) fail(err error) {
+	if b.err == nil {
+		b.err = err
+	}
 }
 
 // Save saves the current graphics state onto a stack.
 func (b *Backend) Save() {
 	b.stateStack = append(b.stateStack, backendState{
 		transform: b.currentTransform,
+		spanOpen:  b.spanOpen,
+		blendMode: b.blendMode,
+		clips:     b.clips,
+		mask:      b.mask,
 	})
-	// Push an identity layer in gxpdf for proper state restoration
-	b.surface.PushTransform(creator.Identity())
+	b.record((*rasterizer).save)
+	b.spanOpen = false
+	b.content.endMarked()
+	b.content.op("q")
 }
 
 // Restore restores the graphics state from the stack.
 func (b *Backend) Restore() {
-	if len(b.stateStack) == 0 {
-		return // No-op if stack is empty
+	if len(b.stateStack) <= b.saveDepth() {
+		return // No-op without a matching Save
 	}
+	b.restore()
+}
 
+// restore pops the saved graphics state.
+func (b *Backend) restore() {
 	// Pop the saved state
 	state := b.stateStack[len(b.stateStack)-1]
 	b.stateStack = b.stateStack[:len(b.stateStack)-1]
 
 	b.currentTransform = state.transform
-	b.surface.Pop()
+	b.setBlendMode(state.blendMode)
+	b.clips, b.mask = state.clips, state.mask
+	b.record((*rasterizer).restore)
+	b.endSpan()
+	b.content.op("Q")
+	b.spanOpen = state.spanOpen
 }
 
 // SetTransform sets the current transformation matrix.
 // The transform is in gg coordinates (top-left origin).
 func (b *Backend) SetTransform(m recording.Matrix) {
 	b.currentTransform = m
-
-	// Apply the transform via gxpdf
-	// Note: We need to pop the current transform and push the new one
-	pdfTransform := b.matrixToTransform(m)
-	b.surface.Pop() // Pop old transform (or identity from Save)
-	b.surface.PushTransform(pdfTransform)
+	b.record(func(r *rasterizer) { r.setTransform(m) })
 }
 
 // SetClip sets the clipping region to the given path.
 func (b *Backend) SetClip(path *gg.Path, rule recording.FillRule) {
-	pdfPath := b.translatePath(path)
-	fillRule := b.translateFillRule(rule)
-	_ = b.surface.PushClipPath(pdfPath, fillRule)
+	b.clips = append(b.clips[:len(b.clips):len(b.clips)], clipPath{path: path, rule: rule, transform: b.currentTransform})
+	b.record(func(r *rasterizer) { r.setClip(path, rule) })
+
+	// The clip is part of the graphics state until the matching Restore, so
+	// it is not wrapped in q/Q; the transform is applied to the points instead.
+	b.content.path(path, b.currentTransform)
+	b.content.op(clipOperator(rule))
+	b.content.op("n")
 }
 
 // ClearClip removes any clipping region.
@@ -144,296 +496,250 @@ func (b *Backend) ClearClip() {
 
 // FillPath fills the given path with the brush color/pattern.
 func (b *Backend) FillPath(path *gg.Path, brush recording.Brush, rule recording.FillRule) {
-	pdfPath := b.translatePath(path)
-	fill := b.translateBrushToFill(brush)
-	fill.Rule = b.translateFillRule(rule)
-
-	b.surface.SetFill(fill)
-	b.surface.SetStroke(nil)
-	_ = b.surface.FillPath(pdfPath)
+	area := pathBounds(path)
+	if b.rasterize(func(r *rasterizer) { r.fillPath(path, brush, rule) }, &area) {
+		return
+	}
+	b.markContent()
+	b.fillContent(brush, rule, func() {
+		b.content.path(path, recording.Identity())
+	})
 }
 
 // StrokePath strokes the given path with the brush and stroke style.
 func (b *Backend) StrokePath(path *gg.Path, brush recording.Brush, stroke recording.Stroke) {
-	pdfPath := b.translatePath(path)
-	pdfStroke := b.translateStroke(brush, stroke)
-
-	b.surface.SetStroke(pdfStroke)
-	b.surface.SetFill(nil)
-	_ = b.surface.StrokePath(pdfPath)
+	// Miter joins and square caps reach furthest beyond the path.
+	pad := stroke.Width / 2 * max(stroke.MiterLimit, math.Sqrt2)
+	area := pathBounds(path).Inset(-pad, -pad)
+	if b.rasterize(func(r *rasterizer) { r.strokePath(path, brush, stroke) }, &area) {
+		return
+	}
+	b.markContent()
+	c := b.content
+	c.op("q")
+	c.transform(b.currentTransform)
+	if name, ok := b.pattern(brush); ok {
+		c.opacity(1, 1)
+		c.op("CS", pdfName("Pattern"))
+		c.op("SCN", name)
+	} else {
+		color := solidColor(brush)
+		c.opacity(1, color.A)
+		c.strokeColor(color)
+	}
+	c.strokeStyle(stroke)
+	c.path(path, recording.Identity())
+	c.op("S")
+	c.op("Q")
 }
 
 // FillRect fills an axis-aligned rectangle with the brush.
 func (b *Backend) FillRect(rect recording.Rect, brush recording.Brush) {
-	pdfRect := creator.Rect{
-		X:      rect.MinX,
-		Y:      rect.MinY,
-		Width:  rect.Width(),
-		Height: rect.Height(),
+	if b.rasterize(func(r *rasterizer) { r.fillRect(rect, brush) }, &rect) {
+		return
+	}
+	b.markContent()
+	b.fillContent(brush, recording.FillRuleNonZero, func() {
+		b.content.op("re", rect.MinX, rect.MinY, rect.Width(), rect.Height())
+	})
+}
+
+// fillContent fills the path constructed by build with brush. Gradients are
+// painted as shadings clipped to the path, and registered patterns are
+// selected as the fill color.
+func (b *Backend) fillContent(brush recording.Brush, rule recording.FillRule, build func()) {
+	c := b.content
+	c.op("q")
+	c.transform(b.currentTransform)
+	if name, ok := c.shading(brush); ok {
+		c.opacity(1, 1)
+		build()
+		c.op(clipOperator(rule))
+		c.op("n")
+		c.op("sh", name)
+		c.op("Q")
+		return
 	}
+	if name, ok := b.pattern(brush); ok {
+		c.opacity(1, 1)
+		c.op("cs", pdfName("Pattern"))
+		c.op("scn", name)
+	} else {
+		color := solidColor(brush)
+		c.opacity(color.A, 1)
+		c.fillColor(color)
+	}
+	build()
+	if rule == recording.FillRuleEvenOdd {
+		c.op("f*")
+	} else {
+		c.op("f")
+	}
+	c.op("Q")
+}
 
-	fill := b.translateBrushToFill(brush)
-	b.surface.SetFill(fill)
-	b.surface.SetStroke(nil)
-	_ = b.surface.DrawRect(pdfRect)
+// clipOperator returns the clipping operator for a fill rule.
+func clipOperator(rule recording.FillRule) string {
+	if rule == recording.FillRuleEvenOdd {
+		return "W*"
+	}
+	return "W"
 }
 
 // DrawImage draws an image from the source rectangle to the destination rectangle.
 func (b *Backend) DrawImage(img image.Image, src, dst recording.Rect, opts recording.ImageOptions) {
-	// Encode Go image to PNG for gxpdf
-	var buf bytes.Buffer
-	if err := png.Encode(&buf, img); err != nil {
-		return // Silently fail on encode error
+	r := image.Rect(
+		int(math.Floor(src.MinX)), int(math.Floor(src.MinY)),
+		int(math.Ceil(src.MaxX)), int(math.Ceil(src.MaxY)),
+	).Intersect(img.Bounds())
+	if r.Empty() {
+		return
+	}
+	if b.rasterize(func(r *rasterizer) { r.drawImage(img, src, dst, opts) }, &dst) {
+		return
 	}
 
-	// Load image from encoded bytes
-	pdfImg, err := creator.LoadImageFromReader(&buf)
+	xobj, err := b.shared.image(img, r)
 	if err != nil {
-		return // Silently fail on load error
+		b.fail(err)
+		return
 	}
+	b.paintImage(xobj, dst, b.currentTransform, opts.Alpha)
+}
 
-	// Apply opacity if not fully opaque
-	if opts.Alpha < 1.0 {
-		_ = b.surface.PushOpacity(opts.Alpha)
+// paintImage draws an image XObject onto dst in the space given by m.
+func (b *Backend) paintImage(xobj *pdfStream, dst recording.Rect, m recording.Matrix, alpha float64) {
+	b.markContent()
+	c := b.content
+	if _, ok := xobj.Dict["SMask"]; ok {
+		c.transparency = true
 	}
-
-	// Draw the image at the destination rectangle
-	// gxpdf DrawImage takes (img, x, y, width, height)
-	_ = b.page.DrawImage(pdfImg, dst.MinX, dst.MinY, dst.Width(), dst.Height())
-
-	// Pop opacity if applied
-	if opts.Alpha < 1.0 {
-		b.surface.Pop()
+	switch xobj.Dict["ColorSpace"] {
+	case pdfName("DeviceRGB"):
+		c.deviceRGB = true
+	case pdfName("DeviceCMYK"):
+		c.deviceCMYK = true
 	}
+	name := c.res.add("XObject", "Im", xobj, func() pdfObject { return xobj })
+
+	// Image space is the unit square with the first row at the top, so map
+	// it onto the destination with the y axis reversed.
+	c.op("q")
+	c.transform(m)
+	c.opacity(alpha, 1)
+	c.op("cm", dst.Width(), 0.0, 0.0, -dst.Height(), dst.MinX, dst.MaxY)
+	c.op("Do", name)
+	c.op("Q")
 }
 
 // DrawText draws text at the given position with the specified font face and brush.
 func (b *Backend) DrawText(s string, x, y float64, face text.Face, brush recording.Brush) {
-	// Extract color from brush
-	color := b.brushToColor(brush)
-
-	// Get font size from face
-	fontSize := 12.0
-	if face != nil {
-		// text.Face exposes Size() method
-		fontSize = face.Size()
-		if fontSize <= 0 {
-			// Fallback to line height if size not available
-			metrics := face.Metrics()
-			fontSize = metrics.LineHeight()
-			if fontSize <= 0 {
-				fontSize = 12.0
-			}
+	// Glyphs are drawn unrotated, within about an em of each other.
+	reach := faceSize(face) * float64(utf8.RuneCountInString(s)+1)
+	area := recording.NewRect(x-reach, y-reach, 2*reach, 2*reach)
+	if b.rasterize(func(r *rasterizer) { r.drawText(s, x, y, face, brush) }, &area) {
+		return
+	}
+
+	fontSize := faceSize(face)
+
+	c := b.content
+	var (
+		fontName pdfName
+		shown    any
+	)
+	if font := b.shared.fontFor(face); font != nil {
+		encoded, missing, ok := font.encode(s)
+		if !ok && b.shared.conformance.requiresEmbeddedFonts() {
+			b.fail(fmt.Errorf("pdf: font %q has no glyph for %q, which %s forbids", font.name, missing, b.shared.conformance))
+			return
+		}
+		fontName = c.res.add("Font", "F", font, func() pdfObject { return font.obj })
+		shown = encoded
+	} else {
+		if b.shared.conformance.requiresEmbeddedFonts() {
+			b.fail(fmt.Errorf("%w (required by %s)", errNoEmbeddedFont, b.shared.conformance))
+			return
 		}
+		helvetica := b.shared.helvetica()
+		fontName = c.res.add("Font", "F", standardFontName, func() pdfObject { return helvetica })
+		shown = encodeWinAnsi(s)
 	}
 
-	// Use default Helvetica font for now
-	// TODO: Support custom fonts via face parameter
-	_ = b.page.AddTextColor(s, x, y, creator.Helvetica, fontSize, color)
+	// Text space is y-up, so flip it back at the baseline origin.
+	b.markContent()
+	c.op("q")
+	c.transform(b.currentTransform)
+	c.op("cm", 1.0, 0.0, 0.0, -1.0, x, y)
+	if name, ok := b.pattern(brush); ok {
+		c.opacity(1, 1)
+		c.op("cs", pdfName("Pattern"))
+		c.op("scn", name)
+	} else {
+		color := solidColor(brush)
+		c.opacity(color.A, 1)
+		c.fillColor(color)
+	}
+	c.op("BT")
+	c.op("Tf", fontName, fontSize)
+	c.op("Tj", shown)
+	c.op("ET")
+	c.op("Q")
 }
 
 // WriteTo writes the PDF to the given writer.
 // This implements recording.WriterBackend.
 func (b *Backend) WriteTo(w io.Writer) (int64, error) {
-	return b.creator.WriteTo(w)
+	if b.content == nil {
+		return 0, fmt.Errorf("pdf: backend has no page; call Begin first")
+	}
+	return writeDocument(w, []*Backend{b}, b.shared, b.meta)
 }
 
 // SaveToFile saves the PDF to a file at the given path.
 // This implements recording.FileBackend.
 func (b *Backend) SaveToFile(path string) error {
-	return b.creator.WriteToFile(path)
-}
-
-// translatePath converts a gg.Path to a gxpdf Path.
-// Note: Y coordinates are NOT flipped here because we apply a global Y-flip
-// transform in Begin(). The paths are drawn in gg coordinate space.
-func (b *Backend) translatePath(path *gg.Path) *creator.Path {
-	pdfPath := creator.NewPath()
-
-	for _, elem := range path.Elements() {
-		switch e := elem.(type) {
-		case gg.MoveTo:
-			pdfPath.MoveTo(e.Point.X, e.Point.Y)
-		case gg.LineTo:
-			pdfPath.LineTo(e.Point.X, e.Point.Y)
-		case gg.QuadTo:
-			pdfPath.QuadraticTo(
-				e.Control.X, e.Control.Y,
-				e.Point.X, e.Point.Y,
-			)
-		case gg.CubicTo:
-			pdfPath.CubicTo(
-				e.Control1.X, e.Control1.Y,
-				e.Control2.X, e.Control2.Y,
-				e.Point.X, e.Point.Y,
-			)
-		case gg.Close:
-			pdfPath.Close()
-		}
-	}
-
-	return pdfPath
-}
-
-// translateBrushToFill converts a recording.Brush to a gxpdf Fill.
-func (b *Backend) translateBrushToFill(brush recording.Brush) *creator.Fill {
-	switch br := brush.(type) {
-	case recording.SolidBrush:
-		color := colorFromGG(br.Color)
-		fill := creator.NewFill(color)
-		opacity := br.Color.A
-		if opacity < 1.0 {
-			return fill.WithOpacity(opacity)
-		}
-		return fill
-
-	case *recording.LinearGradientBrush:
-		return b.translateLinearGradient(br)
-
-	case *recording.RadialGradientBrush:
-		return b.translateRadialGradient(br)
-
-	case *recording.SweepGradientBrush:
-		// Sweep gradients are not directly supported in PDF
-		// Fallback to solid color from first stop
-		if len(br.Stops) > 0 {
-			stop := br.Stops[0]
-			color := colorFromGG(stop.Color)
-			return creator.NewFill(color)
-		}
-		return creator.NewFill(creator.Black)
-
-	default:
-		return creator.NewFill(creator.Black)
-	}
+	return writeFile(path, b.WriteTo)
 }
 
-// translateLinearGradient converts a recording.LinearGradientBrush to a gxpdf Fill.
-func (b *Backend) translateLinearGradient(br *recording.LinearGradientBrush) *creator.Fill {
-	grad := creator.NewLinearGradient(
-		br.Start.X, br.Start.Y,
-		br.End.X, br.End.Y,
-	)
-
-	for _, stop := range br.Stops {
-		color := colorFromGG(stop.Color)
-		_ = grad.AddColorStop(stop.Offset, color)
+// faceSize returns the font size of face, 12 points without one.
+func faceSize(face text.Face) float64 {
+	if face == nil {
+		return 12
 	}
-
-	return creator.NewFill(grad)
-}
-
-// translateRadialGradient converts a recording.RadialGradientBrush to a gxpdf Fill.
-func (b *Backend) translateRadialGradient(br *recording.RadialGradientBrush) *creator.Fill {
-	grad := creator.NewRadialGradient(
-		br.Center.X, br.Center.Y, br.StartRadius,
-		br.Focus.X, br.Focus.Y, br.EndRadius,
-	)
-
-	for _, stop := range br.Stops {
-		color := colorFromGG(stop.Color)
-		_ = grad.AddColorStop(stop.Offset, color)
-	}
-
-	return creator.NewFill(grad)
-}
-
-// translateStroke converts brush and stroke settings to a gxpdf Stroke.
-func (b *Backend) translateStroke(brush recording.Brush, stroke recording.Stroke) *creator.Stroke {
-	color := b.brushToColor(brush)
-	s := creator.NewStroke(color)
-
-	s.Width = stroke.Width
-	s.LineCap = b.translateLineCap(stroke.Cap)
-	s.LineJoin = b.translateLineJoin(stroke.Join)
-	s.MiterLimit = stroke.MiterLimit
-
-	if len(stroke.DashPattern) > 0 {
-		s.DashArray = make([]float64, len(stroke.DashPattern))
-		copy(s.DashArray, stroke.DashPattern)
-		s.DashPhase = stroke.DashOffset
+	// text.Face exposes Size() method
+	size := face.Size()
+	if size <= 0 {
+		// Fallback to line height if size not available
+		size = face.Metrics().LineHeight()
+		if size <= 0 {
+			size = 12
+		}
 	}
-
-	return s
+	return size
 }
 
-// brushToColor extracts a Color from a brush for text and stroke operations.
-func (b *Backend) brushToColor(brush recording.Brush) creator.Color {
+// solidColor returns the single color used for a brush where PDF needs one:
+// the brush color for solid brushes and the first stop for gradients.
+// Gradient stop colors are drawn opaque.
+func solidColor(brush recording.Brush) gg.RGBA {
+	var stops []recording.GradientStop
 	switch br := brush.(type) {
 	case recording.SolidBrush:
-		return colorFromGG(br.Color)
+		return br.Color
 	case *recording.LinearGradientBrush:
-		if len(br.Stops) > 0 {
-			stop := br.Stops[0]
-			return colorFromGG(stop.Color)
-		}
+		stops = br.Stops
 	case *recording.RadialGradientBrush:
-		if len(br.Stops) > 0 {
-			stop := br.Stops[0]
-			return colorFromGG(stop.Color)
-		}
+		stops = br.Stops
 	case *recording.SweepGradientBrush:
-		if len(br.Stops) > 0 {
-			stop := br.Stops[0]
-			return colorFromGG(stop.Color)
-		}
-	}
-	return creator.Black
-}
-
-// colorFromGG converts gg's normalized RGBA components to a gxpdf RGB color.
-// Both packages represent RGB values in the range [0, 1], so no rescaling is
-// needed here. Alpha is handled separately by fills because gxpdf gradients
-// and RGB colors do not carry an alpha component.
-func colorFromGG(c gg.RGBA) creator.Color {
-	return creator.Color{R: c.R, G: c.G, B: c.B}
-}
-
-// translateFillRule converts a recording.FillRule to a gxpdf FillRule.
-func (b *Backend) translateFillRule(rule recording.FillRule) creator.FillRule {
-	switch rule {
-	case recording.FillRuleEvenOdd:
-		return creator.FillRuleEvenOdd
-	default:
-		return creator.FillRuleNonZero
-	}
-}
-
-// translateLineCap converts a recording.LineCap to a gxpdf LineCap.
-func (b *Backend) translateLineCap(lineCap recording.LineCap) creator.LineCap {
-	switch lineCap {
-	case recording.LineCapRound:
-		return creator.LineCapRound
-	case recording.LineCapSquare:
-		return creator.LineCapSquare
-	default:
-		return creator.LineCapButt
+		stops = br.Stops
 	}
-}
-
-// translateLineJoin converts a recording.LineJoin to a gxpdf LineJoin.
-func (b *Backend) translateLineJoin(join recording.LineJoin) creator.LineJoin {
-	switch join {
-	case recording.LineJoinRound:
-		return creator.LineJoinRound
-	case recording.LineJoinBevel:
-		return creator.LineJoinBevel
-	default:
-		return creator.LineJoinMiter
-	}
-}
-
-// matrixToTransform converts a recording.Matrix to a gxpdf Transform.
-func (b *Backend) matrixToTransform(m recording.Matrix) creator.Transform {
-	// recording.Matrix is row-major: [A B C; D E F]
-	// gxpdf Transform is: [A B C D E F] where A,D=scale, B,C=skew, E,F=translate
-	// Actually gxpdf uses [a b c d e f] for the matrix [ a c e; b d f; 0 0 1 ]
-	// Which matches our recording.Matrix if we map: A->a, B->c, C->e, D->b, E->d, F->f
-	return creator.Transform{
-		A: m.A, B: m.D,
-		C: m.B, D: m.E,
-		E: m.C, F: m.F,
+	if len(stops) > 0 {
+		c := stops[0].Color
+		c.A = 1
+		return c
 	}
+	return gg.RGBA{A: 1}
 }
 
 // Ensure Backend implements the required interfaces.
@@ -2,11 +2,13 @@ package pdf
 
 import (
 	"bytes"
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
-	"github.com/coregx/gxpdf/creator"
 	"github.com/gogpu/gg"
 	"github.com/gogpu/gg/recording"
 )
@@ -41,75 +43,102 @@ func TestBackendInterfaces(t *testing.T) {
 	var _ recording.FileBackend = backend
 }
 
-func TestColorTranslationPreservesNormalizedComponents(t *testing.T) {
+// drawnContent returns the content stream drawn so far on a new 100x100
+// page by draw.
+func drawnContent(t *testing.T, draw func(*Backend)) (*Backend, string) {
+	t.Helper()
+
 	backend := NewBackend()
+	if err := backend.Begin(100, 100); err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	draw(backend)
+	return backend, backend.content.buf.String()
+}
+
+// backendShading returns the only shading drawn by backend.
+func backendShading(t *testing.T, backend *Backend) pdfDict {
+	t.Helper()
+
+	shadings, _ := backend.content.res.dict()["Shading"].(pdfDict)
+	if len(shadings) != 1 {
+		t.Fatalf("page has %d shadings, want 1", len(shadings))
+	}
+	for _, shading := range shadings {
+		if dict, ok := shading.(pdfDict); ok {
+			return dict
+		}
+		t.Fatalf("shading has type %T, want a dictionary", shading)
+	}
+	return nil
+}
+
+func TestColorTranslationPreservesNormalizedComponents(t *testing.T) {
 	input := gg.RGBA2(0.2, 0.4, 0.8, 0.35)
 	brush := recording.NewSolidBrush(input)
-	want := creator.Color{R: input.R, G: input.G, B: input.B}
+	path := gg.NewPath()
+	path.Rectangle(10, 10, 50, 50)
 
-	fill := backend.translateBrushToFill(brush)
-	if got, ok := fill.Paint.(creator.Color); !ok {
-		t.Fatalf("solid fill paint has type %T, want creator.Color", fill.Paint)
-	} else if got != want {
-		t.Errorf("solid fill color = %+v, want %+v", got, want)
+	backend, content := drawnContent(t, func(b *Backend) {
+		b.FillPath(path, brush, recording.FillRuleNonZero)
+		b.StrokePath(path, brush, recording.DefaultStroke())
+	})
+	if !strings.Contains(content, "0.2 0.4 0.8 rg\n") {
+		t.Errorf("solid fill color is not written as 0.2 0.4 0.8 rg:\n%s", content)
 	}
-	if fill.Opacity != input.A {
-		t.Errorf("solid fill opacity = %v, want %v", fill.Opacity, input.A)
+	if !strings.Contains(content, "0.2 0.4 0.8 RG\n") {
+		t.Errorf("stroke color is not written as 0.2 0.4 0.8 RG:\n%s", content)
 	}
 
-	if got := backend.brushToColor(brush); got != want {
-		t.Errorf("brush color = %+v, want %+v", got, want)
+	states, _ := backend.content.res.dict()["ExtGState"].(pdfDict)
+	opacities := make(map[float64]bool)
+	for _, state := range states {
+		if dict, ok := state.(pdfDict); ok {
+			opacities[dict["ca"].(float64)] = true
+			opacities[dict["CA"].(float64)] = true
+		}
 	}
-
-	stroke := backend.translateStroke(brush, recording.DefaultStroke())
-	if got, ok := stroke.Paint.(creator.Color); !ok {
-		t.Fatalf("stroke paint has type %T, want creator.Color", stroke.Paint)
-	} else if got != want {
-		t.Errorf("stroke color = %+v, want %+v", got, want)
+	if !opacities[input.A] {
+		t.Errorf("graphics states %v do not carry opacity %v", states, input.A)
 	}
 }
 
 func TestGradientTranslationPreservesNormalizedComponents(t *testing.T) {
-	backend := NewBackend()
 	stops := []gg.RGBA{
 		gg.RGB(0.1, 0.25, 0.5),
 		gg.RGB(0.75, 0.5, 0.2),
 	}
+	path := gg.NewPath()
+	path.Rectangle(10, 10, 50, 50)
 
 	linear := recording.NewLinearGradientBrush(0, 0, 100, 100).
 		AddColorStop(0, stops[0]).
 		AddColorStop(1, stops[1])
-	linearFill := backend.translateBrushToFill(linear)
-	assertGradientColors(t, linearFill, stops)
+	backend, _ := drawnContent(t, func(b *Backend) {
+		b.FillPath(path, linear, recording.FillRuleNonZero)
+	})
+	assertGradientColors(t, backendShading(t, backend), stops)
 
 	radial := recording.NewRadialGradientBrush(50, 50, 0, 50).
 		AddColorStop(0, stops[0]).
 		AddColorStop(1, stops[1])
-	radialFill := backend.translateBrushToFill(radial)
-	assertGradientColors(t, radialFill, stops)
+	backend, _ = drawnContent(t, func(b *Backend) {
+		b.FillPath(path, radial, recording.FillRuleNonZero)
+	})
+	assertGradientColors(t, backendShading(t, backend), stops)
 
 	sweep := recording.NewSweepGradientBrush(50, 50, 0).
 		AddColorStop(0, stops[0]).
 		AddColorStop(1, stops[1])
-	sweepFill := backend.translateBrushToFill(sweep)
-	if got, ok := sweepFill.Paint.(creator.Color); !ok {
-		t.Fatalf("sweep fallback paint has type %T, want creator.Color", sweepFill.Paint)
-	} else if want := (creator.Color{R: stops[0].R, G: stops[0].G, B: stops[0].B}); got != want {
-		t.Errorf("sweep fallback color = %+v, want %+v", got, want)
+	_, content := drawnContent(t, func(b *Backend) {
+		b.FillPath(path, sweep, recording.FillRuleNonZero)
+	})
+	if !strings.Contains(content, "0.1 0.25 0.5 rg\n") {
+		t.Errorf("sweep fallback does not fill with the first stop color:\n%s", content)
 	}
 }
 
 func TestGradientStrokeUsesNormalizedFirstStopColor(t *testing.T) {
-	backend := NewBackend()
-	if err := backend.Begin(100, 100); err != nil {
-		t.Fatalf("Begin failed: %v", err)
-	}
-	t.Cleanup(func() {
-		if err := backend.End(); err != nil {
-			t.Errorf("End failed: %v", err)
-		}
-	})
-
 	want := gg.RGB(0.2, 0.4, 0.8)
 	path := gg.NewPath()
 	path.MoveTo(10, 10)
@@ -141,37 +170,27 @@ func TestGradientStrokeUsesNormalizedFirstStopColor(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			backend.StrokePath(path, tt.brush, recording.DefaultStroke())
-
-			stroke := backend.surface.CurrentStroke()
-			if stroke == nil {
-				t.Fatal("StrokePath did not configure a stroke")
-			}
-			got, ok := stroke.Paint.(creator.Color)
-			if !ok {
-				t.Fatalf("stroke paint has type %T, want creator.Color", stroke.Paint)
-			}
-			if expected := (creator.Color{R: want.R, G: want.G, B: want.B}); got != expected {
-				t.Errorf("stroke color = %+v, want first gradient stop %+v", got, expected)
+			_, content := drawnContent(t, func(b *Backend) {
+				b.StrokePath(path, tt.brush, recording.DefaultStroke())
+			})
+			if !strings.Contains(content, "0.2 0.4 0.8 RG\n") {
+				t.Errorf("stroke color is not the first gradient stop 0.2 0.4 0.8:\n%s", content)
 			}
 		})
 	}
 }
 
-func assertGradientColors(t *testing.T, fill *creator.Fill, stops []gg.RGBA) {
+func assertGradientColors(t *testing.T, shading pdfDict, stops []gg.RGBA) {
 	t.Helper()
 
-	gradient, ok := fill.Paint.(*creator.Gradient)
+	function, ok := shading["Function"].(pdfDict)
 	if !ok {
-		t.Fatalf("gradient fill paint has type %T, want *creator.Gradient", fill.Paint)
-	}
-	if len(gradient.ColorStops) != len(stops) {
-		t.Fatalf("gradient has %d color stops, want %d", len(gradient.ColorStops), len(stops))
+		t.Fatalf("shading function has type %T, want a dictionary", shading["Function"])
 	}
-	for i, stop := range stops {
-		want := creator.Color{R: stop.R, G: stop.G, B: stop.B}
-		if got := gradient.ColorStops[i].Color; got != want {
-			t.Errorf("gradient color stop %d = %+v, want %+v", i, got, want)
+	for i, key := range []pdfName{"C0", "C1"} {
+		want := pdfArray{stops[i].R, stops[i].G, stops[i].B}
+		if got, _ := function[key].(pdfArray); !reflect.DeepEqual(got, want) {
+			t.Errorf("gradient color stop %d = %v, want %v", i, got, want)
 		}
 	}
 }
@@ -200,9 +219,9 @@ func TestBackendYFlipTransform(t *testing.T) {
 		t.Fatalf("Begin failed: %v", err)
 	}
 
-	want := creator.Scale(1, -1).Then(creator.Translate(0, height))
-	if got := backend.surface.CurrentTransform(); got != want {
-		t.Fatalf("Y-flip transform = %#v, want %#v", got, want)
+	const want = "1 0 0 -1 0 240 cm\n"
+	if got := backend.content.buf.String(); !strings.HasPrefix(got, want) {
+		t.Fatalf("content stream starts with %q, want the Y-flip transform %q", got, want)
 	}
 
 	if err := backend.End(); err != nil {
@@ -219,9 +238,9 @@ func TestDocumentYFlipTransform(t *testing.T) {
 		t.Fatal("Document.NewPage returned an unexpected backend type")
 	}
 
-	want := creator.Scale(1, -1).Then(creator.Translate(0, height))
-	if got := backend.surface.CurrentTransform(); got != want {
-		t.Fatalf("Y-flip transform = %#v, want %#v", got, want)
+	const want = "1 0 0 -1 0 240 cm\n"
+	if got := backend.content.buf.String(); !strings.HasPrefix(got, want) {
+		t.Fatalf("content stream starts with %q, want the Y-flip transform %q", got, want)
 	}
 
 	if err := doc.Finish(); err != nil {
@@ -692,24 +711,24 @@ func TestDocumentMetadata(t *testing.T) {
 }
 
 func TestFillRuleTranslation(t *testing.T) {
-	backend := NewBackend()
-
-	// Test NonZero rule
-	rule := backend.translateFillRule(recording.FillRuleNonZero)
-	if rule != 0 { // FillRuleNonZero = 0 in gxpdf
-		t.Errorf("Expected NonZero fill rule")
-	}
+	path := gg.NewPath()
+	path.Rectangle(10, 10, 50, 50)
+	brush := recording.NewSolidBrush(gg.Black)
 
-	// Test EvenOdd rule
-	rule = backend.translateFillRule(recording.FillRuleEvenOdd)
-	if rule != 1 { // FillRuleEvenOdd = 1 in gxpdf
-		t.Errorf("Expected EvenOdd fill rule")
+	for rule, op := range map[recording.FillRule]string{
+		recording.FillRuleNonZero: "\nf\n",
+		recording.FillRuleEvenOdd: "\nf*\n",
+	} {
+		_, content := drawnContent(t, func(b *Backend) {
+			b.FillPath(path, brush, rule)
+		})
+		if !strings.Contains(content, op) {
+			t.Errorf("fill rule %d is not written as %q:\n%s", rule, strings.TrimSpace(op), content)
+		}
 	}
 }
 
 func TestLineCapTranslation(t *testing.T) {
-	backend := NewBackend()
-
 	tests := []struct {
 		input    recording.LineCap
 		expected int
@@ -719,17 +738,29 @@ func TestLineCapTranslation(t *testing.T) {
 		{recording.LineCapSquare, 2},
 	}
 
+	path := gg.NewPath()
+	path.MoveTo(10, 10)
+	path.LineTo(90, 90)
 	for _, tt := range tests {
-		result := backend.translateLineCap(tt.input)
-		if int(result) != tt.expected {
-			t.Errorf("translateLineCap(%d) = %d, expected %d", tt.input, result, tt.expected)
+		stroke := recording.DefaultStroke()
+		stroke.Cap = tt.input
+		_, content := drawnContent(t, func(b *Backend) {
+			b.StrokePath(path, recording.NewSolidBrush(gg.Black), stroke)
+		})
+		// Butt caps are the PDF default, whether written or not.
+		got := 0
+		for _, v := range []int{1, 2} {
+			if strings.Contains(content, fmt.Sprintf("\n%d J\n", v)) {
+				got = v
+			}
+		}
+		if got != tt.expected {
+			t.Errorf("line cap %d is written as %d J, want %d J", tt.input, got, tt.expected)
 		}
 	}
 }
 
 func TestLineJoinTranslation(t *testing.T) {
-	backend := NewBackend()
-
 	tests := []struct {
 		input    recording.LineJoin
 		expected int
@@ -739,26 +770,49 @@ func TestLineJoinTranslation(t *testing.T) {
 		{recording.LineJoinBevel, 2},
 	}
 
+	path := gg.NewPath()
+	path.MoveTo(10, 10)
+	path.LineTo(50, 90)
+	path.LineTo(90, 10)
 	for _, tt := range tests {
-		result := backend.translateLineJoin(tt.input)
-		if int(result) != tt.expected {
-			t.Errorf("translateLineJoin(%d) = %d, expected %d", tt.input, result, tt.expected)
+		stroke := recording.DefaultStroke()
+		stroke.Join = tt.input
+		_, content := drawnContent(t, func(b *Backend) {
+			b.StrokePath(path, recording.NewSolidBrush(gg.Black), stroke)
+		})
+		// Miter joins are the PDF default, whether written or not.
+		got := 0
+		for _, v := range []int{1, 2} {
+			if strings.Contains(content, fmt.Sprintf("\n%d j\n", v)) {
+				got = v
+			}
+		}
+		if got != tt.expected {
+			t.Errorf("line join %d is written as %d j, want %d j", tt.input, got, tt.expected)
 		}
 	}
 }
 
 func TestMatrixTranslation(t *testing.T) {
-	backend := NewBackend()
-
-	// Test identity matrix
-	identity := recording.Identity()
-	transform := backend.matrixToTransform(identity)
+	rect := recording.NewRect(10, 10, 30, 30)
+	brush := recording.NewSolidBrush(gg.Black)
 
-	if transform.A != 1 || transform.D != 1 {
-		t.Error("Identity matrix should have A=1, D=1")
+	// The identity matrix adds no transform to the drawing.
+	_, content := drawnContent(t, func(b *Backend) {
+		b.SetTransform(recording.Identity())
+		b.FillRect(rect, brush)
+	})
+	if strings.Count(content, " cm\n") != 1 {
+		t.Errorf("identity transform is written to the content:\n%s", content)
 	}
-	if transform.E != 0 || transform.F != 0 {
-		t.Error("Identity matrix should have E=0, F=0")
+
+	// gg's row-major matrix [A B C; D E F] is written as [A D B E C F].
+	_, content = drawnContent(t, func(b *Backend) {
+		b.SetTransform(recording.Matrix{A: 1, B: 2, C: 3, D: 4, E: 5, F: 6})
+		b.FillRect(rect, brush)
+	})
+	if !strings.Contains(content, "\n1 4 2 5 3 6 cm\n") {
+		t.Errorf("transform is not written as 1 4 2 5 3 6 cm:\n%s", content)
 	}
 }
 
@@ -0,0 +1,207 @@
+package pdf
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/gogpu/gg/scene"
+)
+
+// pdfBlendModes maps the blend modes PDF supports to their /BM names.
+// Source-over is PDF's normal painting.
+var pdfBlendModes = map[scene.BlendMode]pdfName{
+	scene.BlendNormal:     "Normal",
+	scene.BlendSourceOver: "Normal",
+	scene.BlendMultiply:   "Multiply",
+	scene.BlendScreen:     "Screen",
+	scene.BlendOverlay:    "Overlay",
+	scene.BlendDarken:     "Darken",
+	scene.BlendLighten:    "Lighten",
+	scene.BlendColorDodge: "ColorDodge",
+	scene.BlendColorBurn:  "ColorBurn",
+	scene.BlendHardLight:  "HardLight",
+	scene.BlendSoftLight:  "SoftLight",
+	scene.BlendDifference: "Difference",
+	scene.BlendExclusion:  "Exclusion",
+	scene.BlendHue:        "Hue",
+	scene.BlendSaturation: "Saturation",
+	scene.BlendColor:      "Color",
+	scene.BlendLuminosity: "Luminosity",
+}
+
+// porterDuff holds the source and destination factors of the Porter-Duff
+// operators PDF cannot express; content drawn with them is rasterized. A
+// factor is 1, 0, or the other layer's alpha or its complement.
+var porterDuff = map[scene.BlendMode][2]factor{
+	scene.BlendClear:           {zero, zero},
+	scene.BlendCopy:            {one, zero},
+	scene.BlendDestination:     {zero, one},
+	scene.BlendDestinationOver: {oneMinusAlpha, one},
+	scene.BlendSourceIn:        {alpha, zero},
+	scene.BlendDestinationIn:   {zero, alpha},
+	scene.BlendSourceOut:       {oneMinusAlpha, zero},
+	scene.BlendDestinationOut:  {zero, oneMinusAlpha},
+	scene.BlendSourceAtop:      {alpha, oneMinusAlpha},
+	scene.BlendDestinationAtop: {oneMinusAlpha, alpha},
+	scene.BlendXor:             {oneMinusAlpha, oneMinusAlpha},
+	scene.BlendPlus:            {one, one},
+}
+
+// factor is a Porter-Duff weight as a function of the other layer's alpha.
+type factor func(a float64) float64
+
+func zero(float64) float64            { return 0 }
+func one(float64) float64             { return 1 }
+func alpha(a float64) float64         { return a }
+func oneMinusAlpha(a float64) float64 { return 1 - a }
+
+// validateBlendMode reports an error for modes neither PDF nor the
+// rasterizer supports.
+func validateBlendMode(mode scene.BlendMode) error {
+	if _, ok := pdfBlendModes[mode]; ok {
+		return nil
+	}
+	if _, ok := porterDuff[mode]; ok {
+		return nil
+	}
+	return fmt.Errorf("pdf: unknown blend mode %d", int(mode))
+}
+
+// composite combines a premultiplied source pixel with a premultiplied
+// backdrop pixel in mode.
+func composite(mode scene.BlendMode, s, d [4]float64) [4]float64 {
+	sa, da := s[3], d[3]
+	if f, ok := porterDuff[mode]; ok {
+		fa, fb := f[0](da), f[1](sa)
+		var out [4]float64
+		for i := range out {
+			out[i] = min(1, s[i]*fa+d[i]*fb)
+		}
+		return out
+	}
+	if sa == 0 {
+		return d
+	}
+
+	// The general formula of ISO 32000 11.3.6, on premultiplied values.
+	cs := [3]float64{s[0] / sa, s[1] / sa, s[2] / sa}
+	cb := [3]float64{1, 1, 1}
+	if da > 0 {
+		cb = [3]float64{d[0] / da, d[1] / da, d[2] / da}
+	}
+	mixed := blend(mode, cb, cs)
+	var out [4]float64
+	for i := range 3 {
+		out[i] = (1-da)*s[i] + (1-sa)*d[i] + sa*da*mixed[i]
+	}
+	out[3] = sa + da - sa*da
+	return out
+}
+
+// blend is the blend function B(cb, cs) of a separable or non-separable
+// blend mode, on unpremultiplied backdrop and source colors.
+func blend(mode scene.BlendMode, cb, cs [3]float64) [3]float64 {
+	switch mode {
+	case scene.BlendHue:
+		return setLum(setSat(cs, sat(cb)), lum(cb))
+	case scene.BlendSaturation:
+		return setLum(setSat(cb, sat(cs)), lum(cb))
+	case scene.BlendColor:
+		return setLum(cs, lum(cb))
+	case scene.BlendLuminosity:
+		return setLum(cb, lum(cs))
+	}
+	var out [3]float64
+	for i := range out {
+		out[i] = blendChannel(mode, cb[i], cs[i])
+	}
+	return out
+}
+
+// blendChannel is the blend function of a separable blend mode.
+func blendChannel(mode scene.BlendMode, b, s float64) float64 {
+	switch mode {
+	case scene.BlendMultiply:
+		return b * s
+	case scene.BlendScreen:
+		return b + s - b*s
+	case scene.BlendOverlay:
+		return blendChannel(scene.BlendHardLight, s, b)
+	case scene.BlendDarken:
+		return min(b, s)
+	case scene.BlendLighten:
+		return max(b, s)
+	case scene.BlendColorDodge:
+		switch {
+		case b == 0:
+			return 0
+		case s >= 1:
+			return 1
+		}
+		return min(1, b/(1-s))
+	case scene.BlendColorBurn:
+		switch {
+		case b == 1:
+			return 1
+		case s <= 0:
+			return 0
+		}
+		return 1 - min(1, (1-b)/s)
+	case scene.BlendHardLight:
+		if s <= 0.5 {
+			return b * 2 * s
+		}
+		return blendChannel(scene.BlendScreen, b, 2*s-1)
+	case scene.BlendSoftLight:
+		if s <= 0.5 {
+			return b - (1-2*s)*b*(1-b)
+		}
+		d := math.Sqrt(b)
+		if b <= 0.25 {
+			d = ((16*b-12)*b + 4) * b
+		}
+		return b + (2*s-1)*(d-b)
+	case scene.BlendDifference:
+		return math.Abs(b - s)
+	case scene.BlendExclusion:
+		return b + s - 2*b*s
+	}
+	return s
+}
+
+func lum(c [3]float64) float64 {
+	return 0.3*c[0] + 0.59*c[1] + 0.11*c[2]
+}
+
+func sat(c [3]float64) float64 {
+	return max(c[0], c[1], c[2]) - min(c[0], c[1], c[2])
+}
+
+// setLum shifts c to luminosity l and clips the result into gamut.
+func setLum(c [3]float64, l float64) [3]float64 {
+	d := l - lum(c)
+	c = [3]float64{c[0] + d, c[1] + d, c[2] + d}
+	l = lum(c)
+	lo, hi := min(c[0], c[1], c[2]), max(c[0], c[1], c[2])
+	for i := range c {
+		if lo < 0 {
+			c[i] = l + (c[i]-l)*l/(l-lo)
+		}
+		if hi > 1 {
+			c[i] = l + (c[i]-l)*(1-l)/(hi-l)
+		}
+	}
+	return c
+}
+
+// setSat scales the components of c so that their range is s.
+func setSat(c [3]float64, s float64) [3]float64 {
+	lo, hi := min(c[0], c[1], c[2]), max(c[0], c[1], c[2])
+	var out [3]float64
+	if hi > lo {
+		for i := range c {
+			out[i] = (c[i] - lo) * s / (hi - lo)
+		}
+	}
+	return out
+}
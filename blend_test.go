@@ -0,0 +1,221 @@
+package pdf
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/gogpu/gg"
+	"github.com/gogpu/gg/recording"
+	"github.com/gogpu/gg/scene"
+)
+
+func TestBlendModeExtGState(t *testing.T) {
+	red := recording.NewSolidBrush(gg.RGB(1, 0, 0))
+	gradient := recording.NewLinearGradientBrush(0, 0, 10, 0).
+		AddColorStop(0, gg.RGB(1, 0, 0)).
+		AddColorStop(1, gg.RGB(0, 0, 1))
+	write := backendWriter(t, 100, 100, func(b *Backend) {
+		b.Save()
+		b.SetBlendMode(scene.BlendMultiply)
+		b.FillRect(recording.NewRect(0, 0, 10, 10), red)
+		b.FillRect(recording.NewRect(20, 0, 10, 10), red)
+		b.FillRect(recording.NewRect(40, 0, 10, 10), gradient)
+		b.FillRect(recording.NewRect(60, 0, 10, 10), recording.NewSolidBrush(gg.RGBA2(1, 0, 0, 0.5)))
+		b.SetBlendMode(scene.BlendSourceOver)
+		b.FillRect(recording.NewRect(80, 0, 10, 10), red)
+		b.Restore()
+		b.FillRect(recording.NewRect(0, 20, 10, 10), red)
+	})
+
+	content, res, _ := pageContent(t, write, 0)
+	if strings.Count(content, "/GS1 gs\n") != 3 {
+		t.Errorf("multiplied fills do not share one ExtGState:\n%s", content)
+	}
+	if !strings.Contains(content, "/GS2 gs\n") {
+		t.Errorf("translucent multiplied fill has no ExtGState of its own:\n%s", content)
+	}
+	if strings.Count(content, " gs\n") != 4 {
+		t.Errorf("normal fills select an ExtGState:\n%s", content)
+	}
+	states, _ := res["ExtGState"].(pdfDict)
+	for name, want := range map[pdfName]float64{"GS1": 1, "GS2": 0.5} {
+		state, _ := states[name].(pdfDict)
+		if state["BM"] != pdfName("Multiply") || state["ca"] != want {
+			t.Errorf("/%s = %v, want Multiply with fill alpha %v", name, state, want)
+		}
+	}
+	if len(states) != 2 {
+		t.Errorf("page has %d ExtGStates, want 2", len(states))
+	}
+}
+
+func TestBlendModePorterDuffIsRasterized(t *testing.T) {
+	write := backendWriter(t, 100, 100, func(b *Backend) {
+		b.FillRect(recording.NewRect(0, 0, 40, 40), recording.NewSolidBrush(gg.RGB(1, 0, 0)))
+		b.Save()
+		b.SetBlendMode(scene.BlendDestinationOut)
+		b.FillRect(recording.NewRect(20, 20, 40, 40), recording.NewSolidBrush(gg.RGB(0, 0, 1)))
+		b.Restore()
+		b.FillRect(recording.NewRect(80, 80, 10, 10), recording.NewSolidBrush(gg.RGB(0, 1, 0)))
+	})
+
+	content, res, r := pageContent(t, write, 0)
+	if strings.Contains(content, "0 0 1 rg") {
+		t.Errorf("content drawn with a Porter-Duff operator is written as vectors:\n%s", content)
+	}
+	if !strings.Contains(content, "/Im1 Do\n") || !strings.Contains(content, "0 1 0 rg") {
+		t.Errorf("rasterized content is not painted as an image before later vectors:\n%s", content)
+	}
+
+	xobjects, _ := res["XObject"].(pdfDict)
+	obj, _ := r.resolve(xobjects["Im1"])
+	im, ok := obj.(*pdfStream)
+	if !ok {
+		t.Fatalf("/Im1 = %v, want an image", obj)
+	}
+	// The blue square cuts through the page to the paper, so it is painted
+	// as white; the page below is not rasterized.
+	w, _ := im.Dict["Width"].(int)
+	h, _ := im.Dict["Height"].(int)
+	want := int(math.Round(40 * rasterScale))
+	if math.Abs(float64(w-want)) > 2 || math.Abs(float64(h-want)) > 2 {
+		t.Errorf("image is %dx%d pixels, want about %dx%d covering the blue square", w, h, want, want)
+	}
+	pixels, _ := decodeStream(im)
+	mid := (h/2*w + w/2) * 3
+	if len(pixels) != w*h*3 || pixels[mid] != 0xFF || pixels[mid+1] != 0xFF || pixels[mid+2] != 0xFF {
+		t.Errorf("blue square is not cleared to white")
+	}
+}
+
+func TestBlendModePorterDuffRasterLimit(t *testing.T) {
+	draw := func(clip bool) error {
+		backend := NewBackend()
+		if err := backend.Begin(14400, 14400); err != nil {
+			t.Fatalf("Begin failed: %v", err)
+		}
+		if clip {
+			path := gg.NewPath()
+			path.Rectangle(100, 100, 50, 50)
+			backend.SetClip(path, recording.FillRuleNonZero)
+		}
+		backend.SetBlendMode(scene.BlendClear)
+		backend.FillRect(recording.NewRect(0, 0, 10, 10), recording.NewSolidBrush(gg.Black))
+		return backend.End()
+	}
+
+	// Clear changes the whole clip, which is the page when unclipped.
+	if err := draw(false); err == nil || !strings.Contains(err.Error(), "limit") {
+		t.Errorf("clearing a 14400pt page: err = %v, want the pixel limit", err)
+	}
+	if err := draw(true); err != nil {
+		t.Errorf("clearing within a small clip failed: %v", err)
+	}
+}
+
+func TestBlendModeKeepsNoHistoryOnPage(t *testing.T) {
+	backend := NewBackend()
+	if err := backend.Begin(100, 100); err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	red := recording.NewSolidBrush(gg.RGB(1, 0, 0))
+	backend.FillRect(recording.NewRect(0, 0, 10, 10), red)
+	backend.SetBlendMode(scene.BlendXor)
+	backend.FillRect(recording.NewRect(5, 5, 10, 10), red)
+	if backend.history != nil {
+		t.Errorf("page keeps %d drawing calls outside groups", len(backend.history))
+	}
+	backend.BeginGroup(TransparencyGroup{Opacity: 1})
+	backend.FillRect(recording.NewRect(0, 0, 10, 10), red)
+	if len(backend.history) == 0 {
+		t.Error("group content is not kept as a backdrop")
+	}
+	backend.EndGroup()
+	if backend.history != nil {
+		t.Errorf("page keeps %d drawing calls after the group", len(backend.history))
+	}
+	if err := backend.End(); err != nil {
+		t.Fatalf("End failed: %v", err)
+	}
+}
+
+func TestBlendModePlusNeedsGroup(t *testing.T) {
+	red := recording.NewSolidBrush(gg.RGB(1, 0, 0))
+	backend := NewBackend()
+	if err := backend.Begin(100, 100); err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	backend.SetBlendMode(scene.BlendPlus)
+	backend.FillRect(recording.NewRect(0, 0, 10, 10), red)
+	if err := backend.End(); err == nil {
+		t.Error("End succeeded after adding to the page")
+	}
+
+	write := backendWriter(t, 100, 100, func(b *Backend) {
+		b.BeginGroup(TransparencyGroup{Opacity: 1})
+		b.FillRect(recording.NewRect(0, 0, 10, 10), red)
+		b.SetBlendMode(scene.BlendPlus)
+		b.FillRect(recording.NewRect(5, 5, 10, 10), recording.NewSolidBrush(gg.RGB(0, 0, 1)))
+		b.EndGroup()
+	})
+	_, res, r := pageContent(t, write, 0)
+	if _, data := formXObject(t, res, r, "Fm1"); !strings.Contains(data, "/Im1 Do\n") {
+		t.Errorf("content added in a group is not painted as an image:\n%s", data)
+	}
+}
+
+func TestBlendModeUnknown(t *testing.T) {
+	backend := NewBackend()
+	if err := backend.Begin(10, 10); err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	backend.SetBlendMode(scene.BlendMode(100))
+	if err := backend.End(); err == nil {
+		t.Error("End succeeded after an unknown blend mode")
+	}
+}
+
+func TestComposite(t *testing.T) {
+	white := [4]float64{1, 1, 1, 1}
+	gray := [4]float64{0.5, 0.5, 0.5, 1}
+	red := [4]float64{1, 0, 0, 1}
+	halfBlue := [4]float64{0, 0, 0.5, 0.5}
+
+	for _, tt := range []struct {
+		name string
+		mode scene.BlendMode
+		s, d [4]float64
+		want [4]float64
+	}{
+		{"normal", scene.BlendNormal, halfBlue, white, [4]float64{0.5, 0.5, 1, 1}},
+		{"multiply", scene.BlendMultiply, gray, red, [4]float64{0.5, 0, 0, 1}},
+		{"screen", scene.BlendScreen, gray, red, [4]float64{1, 0.5, 0.5, 1}},
+		{"difference", scene.BlendDifference, white, red, [4]float64{0, 1, 1, 1}},
+		{"luminosity", scene.BlendLuminosity, gray, gray, gray},
+		{"clear", scene.BlendClear, red, white, [4]float64{}},
+		{"destination out", scene.BlendDestinationOut, halfBlue, red, [4]float64{0.5, 0, 0, 0.5}},
+		{"xor", scene.BlendXor, halfBlue, [4]float64{}, halfBlue},
+		{"plus", scene.BlendPlus, red, gray, [4]float64{1, 0.5, 0.5, 1}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := composite(tt.mode, tt.s, tt.d)
+			for i := range got {
+				if math.Abs(got[i]-tt.want[i]) > 1e-9 {
+					t.Fatalf("composite = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestBlendHueKeepsBackdropLuminosity(t *testing.T) {
+	cb := [3]float64{0.2, 0.6, 0.4}
+	out := blend(scene.BlendHue, cb, [3]float64{1, 0, 0})
+	if math.Abs(lum(out)-lum(cb)) > 1e-9 {
+		t.Errorf("hue blend changed luminosity from %v to %v", lum(cb), lum(out))
+	}
+	if out[0] <= out[1] || out[0] <= out[2] {
+		t.Errorf("hue blend = %v, want the red hue of the source", out)
+	}
+}
@@ -0,0 +1,399 @@
+package pdf
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/gogpu/gg"
+)
+
+// ColorModel is the color space that gg's sRGB colors are written in.
+type ColorModel int
+
+const (
+	// ColorModelRGB writes colors as RGB: DeviceRGB, or ICC-based sRGB
+	// where the conformance level requires device-independent color.
+	ColorModelRGB ColorModel = iota
+
+	// ColorModelCMYK converts colors to DeviceCMYK for print.
+	ColorModelCMYK
+
+	// ColorModelGray converts colors to DeviceGray.
+	ColorModelGray
+)
+
+// String returns the name of the color model.
+func (m ColorModel) String() string {
+	switch m {
+	case ColorModelRGB:
+		return "RGB"
+	case ColorModelCMYK:
+		return "CMYK"
+	case ColorModelGray:
+		return "Gray"
+	default:
+		return fmt.Sprintf("ColorModel(%d)", int(m))
+	}
+}
+
+// ColorManagement selects how the colors of fills, strokes, gradients, and
+// text are written. Images keep their RGB or gray pixels; CMYK images stay
+// CMYK under the CMYK model.
+type ColorManagement struct {
+	Model ColorModel
+
+	// Profile is an ICC output profile for the model's color space, such as
+	// the press profile of a CMYK print condition. Colors are converted
+	// through its perceptual rendering table. Without a profile a naive
+	// formula is used: CMYK from the complement of RGB with full black
+	// generation, and gray from the luma of the RGB components.
+	Profile []byte
+
+	// ICCBased writes RGB colors and images, and grayscale images, in
+	// ICC-based color spaces instead of device spaces, so that viewers and
+	// printers reproduce gg's sRGB colors consistently. Each profile is
+	// embedded once and shared by all pages.
+	ICCBased bool
+
+	// SourceProfile is the RGB profile that ICCBased uses for RGB content.
+	// Without it, a built-in sRGB profile is embedded.
+	SourceProfile []byte
+}
+
+// colorManager converts gg colors for output and holds the spot colors.
+type colorManager struct {
+	model     ColorModel
+	transform *iccTransform
+
+	// mu guards the conversions and DeviceN spaces cached while pages are
+	// drawn.
+	mu    sync.Mutex
+	cache map[[3]float64][]float64
+
+	iccBased      bool
+	sourceProfile []byte
+
+	spots   map[[3]float64]*spotColor
+	names   map[string]bool
+	deviceN map[string]*pdfIndirect
+}
+
+// spotColor is a named colorant printed with its own ink.
+type spotColor struct {
+	name  pdfName
+	cmyk  [4]float64
+	space *pdfIndirect
+}
+
+// rgbKey identifies a color by its RGB components; alpha is drawn as
+// opacity and does not change the color.
+func rgbKey(c gg.RGBA) [3]float64 {
+	return [3]float64{clamp01(c.R), clamp01(c.G), clamp01(c.B)}
+}
+
+// configure sets the color model and, if given, the conversion profile.
+func (m *colorManager) configure(cm ColorManagement) error {
+	var transform *iccTransform
+	switch cm.Model {
+	case ColorModelRGB:
+		if len(cm.Profile) > 0 {
+			return fmt.Errorf("pdf: a conversion profile requires the CMYK or Gray color model")
+		}
+	case ColorModelCMYK, ColorModelGray:
+		if len(cm.Profile) > 0 {
+			space := "CMYK"
+			if cm.Model == ColorModelGray {
+				space = "GRAY"
+			}
+			var err error
+			if transform, err = newICCTransform(cm.Profile, space); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("pdf: unknown color model %d", int(cm.Model))
+	}
+	if len(cm.SourceProfile) > 0 {
+		if !cm.ICCBased {
+			return fmt.Errorf("pdf: a source profile requires ICCBased")
+		}
+		if n, err := iccComponents(cm.SourceProfile); err != nil || n != 3 {
+			return fmt.Errorf("pdf: source profile is not an RGB ICC profile")
+		}
+	}
+	m.model = cm.Model
+	m.iccBased = cm.ICCBased
+	m.sourceProfile = cm.SourceProfile
+	m.transform = transform
+	m.mu.Lock()
+	m.cache = nil
+	m.mu.Unlock()
+	return nil
+}
+
+// registerSpot maps the RGB components of c to a Separation colorant.
+func (m *colorManager) registerSpot(name string, c gg.RGBA, cmyk [4]float64) error {
+	if name == "" || name == "All" || name == "None" {
+		return fmt.Errorf("pdf: invalid spot color name %q", name)
+	}
+	for _, v := range cmyk {
+		if v < 0 || v > 1 || math.IsNaN(v) {
+			return fmt.Errorf("pdf: spot color %q has alternate CMYK %v outside [0, 1]", name, cmyk)
+		}
+	}
+	key := rgbKey(c)
+	if existing, ok := m.spots[key]; ok {
+		return fmt.Errorf("pdf: color %v is already registered as spot color %q", key, string(existing.name))
+	}
+	if m.names[name] {
+		return fmt.Errorf("pdf: spot color %q is already registered", name)
+	}
+	if m.spots == nil {
+		m.spots = make(map[[3]float64]*spotColor)
+		m.names = make(map[string]bool)
+	}
+	spot := &spotColor{name: pdfName(name), cmyk: cmyk}
+	spot.space = newIndirect(pdfArray{
+		pdfName("Separation"),
+		spot.name,
+		pdfName("DeviceCMYK"),
+		pdfDict{
+			"FunctionType": 2,
+			"Domain":       pdfArray{0.0, 1.0},
+			"C0":           pdfArray{0.0, 0.0, 0.0, 0.0},
+			"C1":           pdfArray{cmyk[0], cmyk[1], cmyk[2], cmyk[3]},
+			"N":            1.0,
+		},
+	})
+	m.spots[key] = spot
+	m.names[name] = true
+	return nil
+}
+
+// spot returns the spot color registered for c, or nil.
+func (m *colorManager) spot(c gg.RGBA) *spotColor {
+	return m.spots[rgbKey(c)]
+}
+
+// process returns the components of c in the color model. In CMYK, spot
+// colors convert to their alternate.
+func (m *colorManager) process(c gg.RGBA) []float64 {
+	key := rgbKey(c)
+	if m.model == ColorModelRGB {
+		return key[:]
+	}
+	if spot := m.spots[key]; spot != nil && m.model == ColorModelCMYK {
+		return spot.cmyk[:]
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if v, ok := m.cache[key]; ok {
+		return v
+	}
+	var v []float64
+	switch {
+	case m.transform != nil:
+		v = m.transform.convert(key[0], key[1], key[2])
+	case m.model == ColorModelCMYK:
+		v = naiveCMYK(key[0], key[1], key[2])
+	default:
+		v = []float64{0.299*key[0] + 0.587*key[1] + 0.114*key[2]}
+	}
+	if m.cache == nil {
+		m.cache = make(map[[3]float64][]float64)
+	}
+	m.cache[key] = v
+	return v
+}
+
+// naiveCMYK converts RGB to CMYK with full black generation.
+func naiveCMYK(r, g, b float64) []float64 {
+	k := 1 - max(r, g, b)
+	if k >= 1 {
+		return []float64{0, 0, 0, 1}
+	}
+	return []float64{(1 - r - k) / (1 - k), (1 - g - k) / (1 - k), (1 - b - k) / (1 - k), k}
+}
+
+// deviceNSpace returns a DeviceN color space for several spot colors. Its
+// tint transform adds the alternates of the colorants, limited to full ink.
+func (m *colorManager) deviceNSpace(spots []*spotColor) *pdfIndirect {
+	names := make([]string, len(spots))
+	for i, s := range spots {
+		names[i] = string(s.name)
+	}
+	key := strings.Join(names, "\x00")
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if space, ok := m.deviceN[key]; ok {
+		return space
+	}
+
+	// The stack holds the n tints; each component is accumulated on top of
+	// the components already computed, then the tints are dropped.
+	n := len(spots)
+	var program strings.Builder
+	program.WriteString("{")
+	for j := 0; j < 4; j++ {
+		program.WriteString(" 0")
+		for i, s := range spots {
+			fmt.Fprintf(&program, " %d index %s mul add", n-1-i+j+1, formatNumber(s.cmyk[j]))
+		}
+		program.WriteString(" dup 1 gt { pop 1 } if")
+	}
+	fmt.Fprintf(&program, " %d 4 roll", n+4)
+	for range n {
+		program.WriteString(" pop")
+	}
+	program.WriteString(" }")
+
+	colorants := pdfArray{}
+	domain := pdfArray{}
+	for _, s := range spots {
+		colorants = append(colorants, s.name)
+		domain = append(domain, 0.0, 1.0)
+	}
+	space := newIndirect(pdfArray{
+		pdfName("DeviceN"),
+		colorants,
+		pdfName("DeviceCMYK"),
+		&pdfStream{
+			Dict: pdfDict{
+				"FunctionType": 4,
+				"Domain":       domain,
+				"Range":        pdfArray{0.0, 1.0, 0.0, 1.0, 0.0, 1.0, 0.0, 1.0},
+			},
+			Data: []byte(program.String()),
+		},
+	})
+	if m.deviceN == nil {
+		m.deviceN = make(map[string]*pdfIndirect)
+	}
+	m.deviceN[key] = space
+	return space
+}
+
+// colorOperators are the operators setting a fill or a stroke color.
+type colorOperators struct {
+	gray, rgb, cmyk, space, color string
+}
+
+var (
+	fillOperators   = colorOperators{gray: "g", rgb: "rg", cmyk: "k", space: "cs", color: "scn"}
+	strokeOperators = colorOperators{gray: "G", rgb: "RG", cmyk: "K", space: "CS", color: "SCN"}
+)
+
+// fillColor and strokeColor set a color in the output color model, or in
+// the Separation space of a registered spot color.
+func (c *contentStream) fillColor(col gg.RGBA) {
+	c.color(col, fillOperators)
+}
+
+func (c *contentStream) strokeColor(col gg.RGBA) {
+	c.color(col, strokeOperators)
+}
+
+func (c *contentStream) color(col gg.RGBA, ops colorOperators) {
+	colors := &c.shared.colors
+	if spot := colors.spot(col); spot != nil {
+		c.deviceCMYK = true
+		name := c.res.add("ColorSpace", "CS", spot.space, func() pdfObject { return spot.space })
+		c.op(ops.space, name)
+		c.op(ops.color, 1.0)
+		return
+	}
+	v := colors.process(col)
+	switch colors.model {
+	case ColorModelCMYK:
+		c.deviceCMYK = true
+		c.op(ops.cmyk, v[0], v[1], v[2], v[3])
+		return
+	case ColorModelGray:
+		c.op(ops.gray, v[0])
+		return
+	}
+	space := c.rgbSpace()
+	if space == pdfName("DeviceRGB") {
+		c.op(ops.rgb, v[0], v[1], v[2])
+		return
+	}
+	name := c.res.add("ColorSpace", "CS", space, func() pdfObject { return space })
+	c.op(ops.space, name)
+	c.op(ops.color, v[0], v[1], v[2])
+}
+
+// gradientSpace returns the color space of a gradient and the components of
+// each stop color in it. Gradients between spot colors and white are drawn
+// in the spot colors' Separation or DeviceN space, all others in the
+// process color space.
+func (c *contentStream) gradientSpace(colorsOf []gg.RGBA) (pdfObject, func(gg.RGBA) pdfArray) {
+	colors := &c.shared.colors
+	var spots []*spotColor
+	spotGradient := true
+	for _, col := range colorsOf {
+		spot := colors.spot(col)
+		if spot == nil {
+			if rgbKey(col) != [3]float64{1, 1, 1} {
+				spotGradient = false
+				break
+			}
+			continue
+		}
+		known := false
+		for _, s := range spots {
+			known = known || s == spot
+		}
+		if !known {
+			spots = append(spots, spot)
+		}
+	}
+	if spotGradient && len(spots) > 0 {
+		c.deviceCMYK = true
+		space := spots[0].space
+		if len(spots) > 1 {
+			space = colors.deviceNSpace(spots)
+		}
+		return space, func(col gg.RGBA) pdfArray {
+			spot := colors.spot(col)
+			tints := pdfArray{}
+			for _, s := range spots {
+				if s == spot {
+					tints = append(tints, 1.0)
+				} else {
+					tints = append(tints, 0.0)
+				}
+			}
+			return tints
+		}
+	}
+
+	components := func(col gg.RGBA) pdfArray {
+		out := pdfArray{}
+		for _, v := range colors.process(col) {
+			out = append(out, v)
+		}
+		return out
+	}
+	switch colors.model {
+	case ColorModelCMYK:
+		c.deviceCMYK = true
+		return pdfName("DeviceCMYK"), components
+	case ColorModelGray:
+		return pdfName("DeviceGray"), components
+	}
+	return c.rgbSpace(), components
+}
+
+// blendSpace returns the color space of page transparency groups: the
+// process color space of the output.
+func (s *sharedResources) blendSpace() pdfObject {
+	switch s.colors.model {
+	case ColorModelCMYK:
+		return pdfName("DeviceCMYK")
+	case ColorModelGray:
+		return pdfName("DeviceGray")
+	}
+	return s.rgbSpace()
+}
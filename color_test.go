@@ -0,0 +1,389 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gogpu/gg"
+	"github.com/gogpu/gg/recording"
+)
+
+// testICCProfile assembles an ICC profile with the given device color
+// space, connection space, and tags.
+func testICCProfile(space, pcs string, tags map[string][]byte) []byte {
+	offset := 128 + 4 + 12*len(tags)
+	var table, data bytes.Buffer
+	table.Write(binary.BigEndian.AppendUint32(nil, uint32(len(tags))))
+	for _, sig := range slices.Sorted(maps.Keys(tags)) {
+		table.WriteString(sig)
+		table.Write(binary.BigEndian.AppendUint32(nil, uint32(offset+data.Len())))
+		table.Write(binary.BigEndian.AppendUint32(nil, uint32(len(tags[sig]))))
+		data.Write(tags[sig])
+		for data.Len()%4 != 0 {
+			data.WriteByte(0)
+		}
+	}
+	header := make([]byte, 128)
+	binary.BigEndian.PutUint32(header, uint32(offset+data.Len()))
+	copy(header[12:], "prtr")
+	copy(header[16:], space)
+	copy(header[20:], pcs)
+	copy(header[36:], "acsp")
+	return append(append(header, table.Bytes()...), data.Bytes()...)
+}
+
+// testCMYKLut returns a lut16Type from Lab with a 2-point grid whose cyan
+// output is the inverse of the encoded lightness and whose black output is
+// the encoded a* value.
+func testCMYKLut() []byte {
+	lut := []byte("mft2\x00\x00\x00\x00")
+	lut = append(lut, 3, 4, 2, 0)
+	for i := 0; i < 9; i++ {
+		v := uint32(0)
+		if i%4 == 0 {
+			v = 0x10000
+		}
+		lut = binary.BigEndian.AppendUint32(lut, v)
+	}
+	lut = binary.BigEndian.AppendUint16(lut, 2)
+	lut = binary.BigEndian.AppendUint16(lut, 2)
+	for range 3 {
+		lut = binary.BigEndian.AppendUint16(lut, 0)
+		lut = binary.BigEndian.AppendUint16(lut, 0xFFFF)
+	}
+	for l := 0; l < 2; l++ {
+		for a := 0; a < 2; a++ {
+			for b := 0; b < 2; b++ {
+				lut = binary.BigEndian.AppendUint16(lut, uint16(0xFFFF*(1-l)))
+				lut = binary.BigEndian.AppendUint16(lut, 0)
+				lut = binary.BigEndian.AppendUint16(lut, 0)
+				lut = binary.BigEndian.AppendUint16(lut, uint16(0xFFFF*a))
+			}
+		}
+	}
+	for range 4 {
+		lut = binary.BigEndian.AppendUint16(lut, 0)
+		lut = binary.BigEndian.AppendUint16(lut, 0xFFFF)
+	}
+	return lut
+}
+
+func TestColorModelCMYK(t *testing.T) {
+	write := backendWriter(t, 100, 100, func(b *Backend) {
+		if err := b.SetColorManagement(ColorManagement{Model: ColorModelCMYK}); err != nil {
+			t.Fatalf("SetColorManagement failed: %v", err)
+		}
+		b.FillRect(recording.NewRect(0, 0, 10, 10), recording.NewSolidBrush(gg.RGB(1, 0, 0)))
+		path := gg.NewPath()
+		path.Rectangle(20, 20, 10, 10)
+		b.StrokePath(path, recording.NewSolidBrush(gg.RGB(0.5, 0.5, 0.5)), recording.DefaultStroke())
+		b.DrawText("Hi", 10, 50, nil, recording.NewSolidBrush(gg.RGB(0, 0, 1)))
+		b.FillRect(recording.NewRect(0, 60, 10, 10), recording.NewLinearGradientBrush(0, 0, 10, 0).
+			AddColorStop(0, gg.RGB(1, 1, 1)).AddColorStop(1, gg.RGB(0, 0, 0)))
+	})
+
+	content, res, _ := pageContent(t, write, 0)
+	for _, want := range []string{"0 1 1 0 k\n", "0 0 0 0.5 K\n", "1 1 0 0 k\nBT\n"} {
+		if !strings.Contains(content, want) {
+			t.Errorf("content missing %q:\n%s", want, content)
+		}
+	}
+	if strings.Contains(content, " rg\n") || strings.Contains(content, " RG\n") {
+		t.Errorf("CMYK content still sets RGB colors:\n%s", content)
+	}
+	shadings, _ := res["Shading"].(pdfDict)
+	sh, _ := shadings["Sh1"].(pdfDict)
+	fn, _ := sh["Function"].(pdfDict)
+	if sh["ColorSpace"] != pdfName("DeviceCMYK") || len(fn["C0"].(pdfArray)) != 4 {
+		t.Errorf("gradient shading = %v, want DeviceCMYK stops", sh)
+	}
+}
+
+func TestColorModelGray(t *testing.T) {
+	write := backendWriter(t, 100, 100, func(b *Backend) {
+		if err := b.SetColorManagement(ColorManagement{Model: ColorModelGray}); err != nil {
+			t.Fatalf("SetColorManagement failed: %v", err)
+		}
+		b.FillRect(recording.NewRect(0, 0, 10, 10), recording.NewSolidBrush(gg.RGB(1, 1, 1)))
+		b.FillRect(recording.NewRect(0, 0, 10, 10), recording.NewSolidBrush(gg.RGB(0, 1, 0)))
+	})
+
+	content, _, _ := pageContent(t, write, 0)
+	if !strings.Contains(content, "1 g\n") || !strings.Contains(content, "0.587 g\n") {
+		t.Errorf("content does not set luma gray levels:\n%s", content)
+	}
+}
+
+func TestColorModelICCTransform(t *testing.T) {
+	profile := testICCProfile("CMYK", "Lab ", map[string][]byte{"B2A0": testCMYKLut()})
+	transform, err := newICCTransform(profile, "CMYK")
+	if err != nil {
+		t.Fatalf("newICCTransform failed: %v", err)
+	}
+
+	white := transform.convert(1, 1, 1)
+	black := transform.convert(0, 0, 0)
+	if math.Abs(white[0]-(1-652.8/655.35)) > 1e-3 || black[0] < 0.99 {
+		t.Errorf("cyan for white = %v and black = %v, want the inverse of encoded lightness", white[0], black[0])
+	}
+	// Pure red has a strongly positive a*, about 80 for sRGB red.
+	red := transform.convert(1, 0, 0)
+	if want := (80 + 128) * 256 / 65535.0; math.Abs(red[3]-want) > 0.01 {
+		t.Errorf("black for red = %v, want about %v from a*", red[3], want)
+	}
+
+	doc := NewDocument()
+	if err := doc.SetColorManagement(ColorManagement{Model: ColorModelCMYK, Profile: profile}); err != nil {
+		t.Fatalf("SetColorManagement failed: %v", err)
+	}
+	doc.NewPage(10, 10).FillRect(recording.NewRect(0, 0, 10, 10), recording.NewSolidBrush(gg.RGB(0, 0, 0)))
+	content, _, _ := pageContent(t, documentWriter(doc), 0)
+	// Black has L* 0 and a* 0, which the table maps to full cyan and the
+	// encoded a* as black.
+	if !strings.Contains(content, "1 0 0 0.500008 k\n") {
+		t.Errorf("content does not use the profile conversion:\n%s", content)
+	}
+}
+
+func TestColorModelICCGrayCurve(t *testing.T) {
+	// A gamma 2.0 tone curve from device gray to luminance.
+	curve := binary.BigEndian.AppendUint32([]byte("curv\x00\x00\x00\x00"), 1)
+	curve = binary.BigEndian.AppendUint16(curve, 2<<8)
+	profile := testICCProfile("GRAY", "XYZ ", map[string][]byte{"kTRC": curve})
+	transform, err := newICCTransform(profile, "GRAY")
+	if err != nil {
+		t.Fatalf("newICCTransform failed: %v", err)
+	}
+	// Mid-gray sRGB has a luminance of about 0.214.
+	if got := transform.convert(0.5, 0.5, 0.5)[0]; math.Abs(got-math.Sqrt(0.2140)) > 0.002 {
+		t.Errorf("gray for sRGB 0.5 = %v, want the inverse of the curve at Y 0.214", got)
+	}
+}
+
+func TestColorManagementValidation(t *testing.T) {
+	cmyk := testICCProfile("CMYK", "Lab ", map[string][]byte{"B2A0": testCMYKLut()})
+	for _, tt := range []struct {
+		name string
+		cm   ColorManagement
+	}{
+		{"unknown model", ColorManagement{Model: ColorModel(7)}},
+		{"RGB with profile", ColorManagement{Model: ColorModelRGB, Profile: cmyk}},
+		{"gray with CMYK profile", ColorManagement{Model: ColorModelGray, Profile: cmyk}},
+		{"profile without table", ColorManagement{Model: ColorModelCMYK, Profile: testCMYKProfile()}},
+		{"not a profile", ColorManagement{Model: ColorModelCMYK, Profile: []byte("nope")}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := NewDocument().SetColorManagement(tt.cm); err == nil {
+				t.Error("SetColorManagement succeeded")
+			}
+		})
+	}
+}
+
+func TestSpotColorFill(t *testing.T) {
+	brand := gg.RGB(0.9, 0.1, 0.2)
+	write := backendWriter(t, 100, 100, func(b *Backend) {
+		if err := b.RegisterSpotColor("PANTONE 185 C", brand, [4]float64{0, 0.93, 0.79, 0}); err != nil {
+			t.Fatalf("RegisterSpotColor failed: %v", err)
+		}
+		b.FillRect(recording.NewRect(0, 0, 10, 10), recording.NewSolidBrush(brand))
+		b.DrawText("Brand", 10, 50, nil, recording.NewSolidBrush(brand))
+		b.FillRect(recording.NewRect(0, 0, 10, 10), recording.NewSolidBrush(gg.RGB(0.9, 0.1, 0.3)))
+	})
+
+	content, res, r := pageContent(t, write, 0)
+	if strings.Count(content, "/CS1 cs\n1 scn\n") != 2 {
+		t.Errorf("spot color is not set as a full tint of its separation:\n%s", content)
+	}
+	if !strings.Contains(content, "0.9 0.1 0.3 rg\n") {
+		t.Errorf("other colors do not stay process colors:\n%s", content)
+	}
+	spaces, _ := res["ColorSpace"].(pdfDict)
+	obj, _ := r.resolve(spaces["CS1"])
+	sep, _ := obj.(pdfArray)
+	if len(sep) != 4 || sep[0] != pdfName("Separation") || sep[1] != pdfName("PANTONE 185 C") || sep[2] != pdfName("DeviceCMYK") {
+		t.Fatalf("/CS1 = %v, want a Separation with a CMYK alternate", obj)
+	}
+	fn, _ := r.resolveDict(sep[3])
+	if c1, _ := fn["C1"].(pdfArray); len(c1) != 4 || c1[1] != 0.93 {
+		t.Errorf("tint transform = %v, want the alternate CMYK at full tint", fn)
+	}
+}
+
+func TestSpotColorGradients(t *testing.T) {
+	red, blue := gg.RGB(0.9, 0.1, 0.2), gg.RGB(0.1, 0.2, 0.8)
+	doc := NewDocument()
+	if err := doc.RegisterSpotColor("Brand Red", red, [4]float64{0, 0.9, 0.8, 0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.RegisterSpotColor("Brand Blue", blue, [4]float64{0.9, 0.7, 0, 0.1}); err != nil {
+		t.Fatal(err)
+	}
+	page := doc.NewPage(100, 100)
+	page.FillRect(recording.NewRect(0, 0, 100, 50), recording.NewLinearGradientBrush(0, 0, 100, 0).
+		AddColorStop(0, gg.RGB(1, 1, 1)).AddColorStop(1, red))
+	page.FillRect(recording.NewRect(0, 50, 100, 50), recording.NewLinearGradientBrush(0, 0, 100, 0).
+		AddColorStop(0, red).AddColorStop(1, blue))
+
+	_, res, r := pageContent(t, documentWriter(doc), 0)
+	shadings, _ := res["Shading"].(pdfDict)
+
+	tint, _ := shadings["Sh1"].(pdfDict)
+	obj, _ := r.resolve(tint["ColorSpace"])
+	if sep, _ := obj.(pdfArray); len(sep) == 0 || sep[0] != pdfName("Separation") {
+		t.Errorf("white-to-spot gradient space = %v, want the Separation", obj)
+	}
+	fn, _ := tint["Function"].(pdfDict)
+	if c0, _ := fn["C0"].(pdfArray); len(c0) != 1 || c0[0] != 0.0 {
+		t.Errorf("white stop = %v, want tint 0", fn["C0"])
+	}
+
+	mixed, _ := shadings["Sh2"].(pdfDict)
+	obj, _ = r.resolve(mixed["ColorSpace"])
+	devn, _ := obj.(pdfArray)
+	if len(devn) != 4 || devn[0] != pdfName("DeviceN") {
+		t.Fatalf("two-spot gradient space = %v, want DeviceN", obj)
+	}
+	if colorants, _ := devn[1].(pdfArray); len(colorants) != 2 {
+		t.Errorf("DeviceN colorants = %v, want both spot colors", devn[1])
+	}
+	obj, _ = r.resolve(devn[3])
+	program, _ := obj.(*pdfStream)
+	if program == nil || program.Dict["FunctionType"] != 4 {
+		t.Fatalf("tint transform = %v, want a PostScript calculator function", obj)
+	}
+	data, _ := decodeStream(program)
+	got := evalCalculator(t, string(data), 1, 0.5)
+	want := []float64{0.45, 1, 0.8, 0.05}
+	for i := range want {
+		if math.Abs(got[i]-want[i]) > 1e-9 {
+			t.Errorf("tint transform(1, 0.5) = %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+// evalCalculator runs a PostScript calculator function using the operators
+// the tint transforms are built from.
+func evalCalculator(t *testing.T, program string, inputs ...float64) []float64 {
+	t.Helper()
+
+	tokens := strings.Fields(strings.NewReplacer("{", " { ", "}", " } ").Replace(program))
+	if len(tokens) < 2 || tokens[0] != "{" || tokens[len(tokens)-1] != "}" {
+		t.Fatalf("program %q is not a procedure", program)
+	}
+	stack := append([]float64(nil), inputs...)
+	pop := func() float64 {
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return v
+	}
+	var run func(tokens []string)
+	run = func(tokens []string) {
+		for i := 0; i < len(tokens); i++ {
+			switch tok := tokens[i]; tok {
+			case "index":
+				n := int(pop())
+				stack = append(stack, stack[len(stack)-1-n])
+			case "mul":
+				b, a := pop(), pop()
+				stack = append(stack, a*b)
+			case "add":
+				b, a := pop(), pop()
+				stack = append(stack, a+b)
+			case "dup":
+				stack = append(stack, stack[len(stack)-1])
+			case "pop":
+				pop()
+			case "gt":
+				b, a := pop(), pop()
+				stack = append(stack, map[bool]float64{true: 1}[a > b])
+			case "roll":
+				j, n := int(pop()), int(pop())
+				top := append([]float64(nil), stack[len(stack)-n:]...)
+				for k := range top {
+					stack[len(stack)-n+(k+j)%n] = top[k]
+				}
+			case "{":
+				depth, end := 1, i+1
+				for ; depth > 0; end++ {
+					switch tokens[end] {
+					case "{":
+						depth++
+					case "}":
+						depth--
+					}
+				}
+				if tokens[end] != "if" {
+					t.Fatalf("procedure not followed by if in %q", program)
+				}
+				if pop() != 0 {
+					run(tokens[i+1 : end-1])
+				}
+				i = end
+			default:
+				v, err := strconv.ParseFloat(tok, 64)
+				if err != nil {
+					t.Fatalf("unexpected operator %q", tok)
+				}
+				stack = append(stack, v)
+			}
+		}
+	}
+	run(tokens[1 : len(tokens)-1])
+	return stack
+}
+
+func TestSpotColorValidation(t *testing.T) {
+	doc := NewDocument()
+	if err := doc.RegisterSpotColor("", gg.Black, [4]float64{}); err == nil {
+		t.Error("RegisterSpotColor accepted an empty name")
+	}
+	if err := doc.RegisterSpotColor("Gold", gg.RGB(0.8, 0.7, 0.2), [4]float64{0, 0.2, 1.5, 0}); err == nil {
+		t.Error("RegisterSpotColor accepted an alternate outside [0, 1]")
+	}
+	if err := doc.RegisterSpotColor("Gold", gg.RGB(0.8, 0.7, 0.2), [4]float64{0, 0.2, 0.9, 0}); err != nil {
+		t.Fatalf("RegisterSpotColor failed: %v", err)
+	}
+	if err := doc.RegisterSpotColor("Silver", gg.RGBA2(0.8, 0.7, 0.2, 0.5), [4]float64{}); err == nil {
+		t.Error("RegisterSpotColor accepted a color that is already registered")
+	}
+	if err := doc.RegisterSpotColor("Gold", gg.RGB(0.7, 0.7, 0.7), [4]float64{}); err == nil {
+		t.Error("RegisterSpotColor accepted a name that is already registered")
+	}
+}
+
+func TestColorModelConformance(t *testing.T) {
+	pdfa := newPDFADocument(t, ConformancePDFA2B)
+	if err := pdfa.SetColorManagement(ColorManagement{Model: ColorModelCMYK}); err != nil {
+		t.Fatal(err)
+	}
+	pdfa.NewPage(10, 10).FillRect(recording.NewRect(0, 0, 10, 10), recording.NewSolidBrush(gg.Black))
+	if _, err := pdfa.WriteTo(&bytes.Buffer{}); err == nil || !strings.Contains(err.Error(), "CMYK") {
+		t.Errorf("PDF/A with CMYK content: WriteTo error = %v, want a CMYK error", err)
+	}
+
+	pdfx := newPDFXDocument(t)
+	if err := pdfx.SetColorManagement(ColorManagement{Model: ColorModelCMYK}); err != nil {
+		t.Fatal(err)
+	}
+	pdfx.NewPage(10, 10).FillRect(recording.NewRect(0, 0, 10, 10), recording.NewSolidBrush(gg.RGBA2(1, 0, 0, 0.5)))
+	var buf bytes.Buffer
+	if _, err := pdfx.WriteTo(&buf); err != nil {
+		t.Fatalf("PDF/X with CMYK content: WriteTo failed: %v", err)
+	}
+	r, catalog := readOutput(t, buf.Bytes())
+	pagesRoot, _ := r.resolveDict(catalog["Pages"])
+	kids, _ := pagesRoot["Kids"].(pdfArray)
+	page, _ := r.resolveDict(kids[0])
+	group, _ := page["Group"].(pdfDict)
+	if group["CS"] != pdfName("DeviceCMYK") {
+		t.Errorf("page group = %v, want blending in DeviceCMYK", group)
+	}
+}
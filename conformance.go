@@ -0,0 +1,248 @@
+package pdf
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Conformance selects a PDF standard that the output must satisfy. Drawing
+// operations that the standard forbids fail, and the file is marked as
+// conforming when it is written.
+type Conformance int
+
+const (
+	// ConformanceNone writes plain PDF without additional restrictions.
+	ConformanceNone Conformance = iota
+
+	// ConformancePDFA2B writes PDF/A-2b (ISO 19005-2, level B) for long-term
+	// archiving. All fonts must be embedded, so text requires a font
+	// registered with RegisterFont.
+	ConformancePDFA2B
+
+	// ConformancePDFA3B writes PDF/A-3b (ISO 19005-3, level B). It has the
+	// same requirements as PDF/A-2b and additionally permits embedded files
+	// of any type.
+	ConformancePDFA3B
+
+	// ConformancePDFX4 writes PDF/X-4 (ISO 15930-7) for print production.
+	// It is available on Document only and requires a CMYK output intent
+	// set with SetOutputIntent, a title, and embedded fonts. Every page gets
+	// a TrimBox and BleedBox, and RGB content is tagged as ICC-based sRGB so
+	// that the printer can convert it.
+	ConformancePDFX4
+)
+
+// Namespace URIs of the conformance identification schemas.
+const (
+	xmpNamespacePDFAID = "http://www.aiim.org/pdfa/ns/id/"
+	xmpNamespacePDFXID = "http://www.npes.org/pdfx/ns/id/"
+	xmpNamespaceXMPMM  = "http://ns.adobe.com/xap/1.0/mm/"
+	xmpNamespacePDFUA  = "http://www.aiim.org/pdfua/ns/id/"
+)
+
+// errNoEmbeddedFont is reported when output that requires embedded fonts
+// would need the standard Helvetica font, which is not embedded.
+var errNoEmbeddedFont = errors.New("pdf: text requires an embedded font; register a font with RegisterFont before drawing text")
+
+// String returns the name of the conformance level.
+func (c Conformance) String() string {
+	switch c {
+	case ConformanceNone:
+		return "none"
+	case ConformancePDFA2B:
+		return "PDF/A-2b"
+	case ConformancePDFA3B:
+		return "PDF/A-3b"
+	case ConformancePDFX4:
+		return "PDF/X-4"
+	default:
+		return fmt.Sprintf("Conformance(%d)", int(c))
+	}
+}
+
+// validate reports whether c is a known conformance level.
+func (c Conformance) validate() error {
+	if c < ConformanceNone || c > ConformancePDFX4 {
+		return fmt.Errorf("pdf: unknown conformance level %d", int(c))
+	}
+	return nil
+}
+
+// isPDFA reports whether c is one of the PDF/A levels.
+func (c Conformance) isPDFA() bool {
+	return c == ConformancePDFA2B || c == ConformancePDFA3B
+}
+
+// isPDFX reports whether c is a PDF/X level.
+func (c Conformance) isPDFX() bool {
+	return c == ConformancePDFX4
+}
+
+// requiresEmbeddedFonts reports whether c forbids non-embedded fonts.
+func (c Conformance) requiresEmbeddedFonts() bool {
+	return c.isPDFA() || c.isPDFX()
+}
+
+// pdfaPart returns the part of ISO 19005 that c belongs to.
+func (c Conformance) pdfaPart() int {
+	if c == ConformancePDFA3B {
+		return 3
+	}
+	return 2
+}
+
+// applyConformance checks the file against the conformance level and adds
+// the entries the level requires to the catalog. It returns the
+// identification properties for the XMP packet and extra Info entries.
+func applyConformance(out *outputFile, pages []*Backend, shared *sharedResources, meta *metadata) ([]xmpNamespacedProperties, pdfDict, error) {
+	c := shared.conformance
+	meta.extensionSchemas = c.isPDFA()
+	if c.requiresEmbeddedFonts() && shared.standardFontUsed {
+		return nil, nil, fmt.Errorf("%w (required by %s)", errNoEmbeddedFont, c)
+	}
+	switch {
+	case c.isPDFA():
+		if c == ConformancePDFA2B && shared.hasEmbeddedFiles() {
+			return nil, nil, fmt.Errorf("pdf: %s allows only embedded PDF/A files; use %s to attach other files", c, ConformancePDFA3B)
+		}
+		for i, b := range pages {
+			if b.content.deviceCMYK {
+				return nil, nil, fmt.Errorf(
+					"pdf: page %d has CMYK content, which %s does not allow with its sRGB output intent",
+					i+1, c,
+				)
+			}
+		}
+		out.catalog["OutputIntents"] = pdfArray{srgbOutputIntent("GTS_PDFA1")}
+		return []xmpNamespacedProperties{{
+			namespace: xmpNamespace{prefix: "pdfaid", uri: xmpNamespacePDFAID},
+			properties: [][2]string{
+				{"part", fmt.Sprint(c.pdfaPart())},
+				{"conformance", "B"},
+			},
+		}}, nil, nil
+	case c.isPDFX():
+		return applyPDFX(out, pages, shared, meta)
+	}
+	return nil, nil, nil
+}
+
+// applyPDFX checks and marks a PDF/X-4 file.
+func applyPDFX(out *outputFile, pages []*Backend, shared *sharedResources, meta *metadata) ([]xmpNamespacedProperties, pdfDict, error) {
+	c := shared.conformance
+	if len(shared.outputProfile) == 0 {
+		return nil, nil, fmt.Errorf("pdf: %s requires a CMYK output intent; call SetOutputIntent", c)
+	}
+	if meta.title == "" {
+		return nil, nil, fmt.Errorf("pdf: %s requires a document title; call SetTitle", c)
+	}
+	for i, b := range pages {
+		if b.content.deviceRGB {
+			return nil, nil, fmt.Errorf(
+				"pdf: page %d has DeviceRGB content that the CMYK output intent does not cover; set %s before drawing",
+				i+1, c,
+			)
+		}
+	}
+
+	// PDF/X-4 is based on PDF 1.6.
+	out.version = "1.6"
+	out.catalog["OutputIntents"] = pdfArray{pdfDict{
+		"Type":                      pdfName("OutputIntent"),
+		"S":                         pdfName("GTS_PDFX"),
+		"OutputConditionIdentifier": textString(shared.outputCondition),
+		"Info":                      textString(shared.outputCondition),
+		"RegistryName":              pdfString("http://www.color.org"),
+		"DestOutputProfile":         flateStream(pdfDict{"N": 4}, shared.outputProfile),
+	}}
+	if meta.trapped == "" {
+		meta.trapped = "False"
+	}
+
+	xmp := []xmpNamespacedProperties{
+		{
+			namespace:  xmpNamespace{prefix: "pdfxid", uri: xmpNamespacePDFXID},
+			properties: [][2]string{{"GTS_PDFXVersion", "PDF/X-4"}},
+		},
+		{
+			namespace: xmpNamespace{prefix: "xmpMM", uri: xmpNamespaceXMPMM},
+			properties: [][2]string{
+				{"DocumentID", meta.documentID()},
+				{"VersionID", "1"},
+				{"RenditionClass", "default"},
+			},
+		},
+	}
+	return xmp, pdfDict{"GTS_PDFXVersion": pdfString("PDF/X-4")}, nil
+}
+
+// validateOutputProfile checks that profile is an ICC output profile for
+// CMYK printing.
+func validateOutputProfile(profile []byte) error {
+	if len(profile) < 128 || string(profile[36:40]) != "acsp" {
+		return fmt.Errorf("pdf: output intent profile is not an ICC profile")
+	}
+	if string(profile[16:20]) != "CMYK" {
+		return fmt.Errorf("pdf: output intent profile has color space %q, want CMYK", profile[16:20])
+	}
+	if string(profile[12:16]) != "prtr" {
+		return fmt.Errorf("pdf: output intent profile has class %q, want an output (prtr) profile", profile[12:16])
+	}
+	return nil
+}
+
+// srgbOutputIntent returns an output intent dictionary with the sRGB profile.
+func srgbOutputIntent(subtype pdfName) pdfDict {
+	return pdfDict{
+		"Type":                      pdfName("OutputIntent"),
+		"S":                         subtype,
+		"OutputConditionIdentifier": pdfString(srgbOutputCondition),
+		"Info":                      pdfString(srgbOutputCondition),
+		"RegistryName":              pdfString("http://www.color.org"),
+		"DestOutputProfile":         flateStream(pdfDict{"N": 3}, srgbProfile()),
+	}
+}
+
+// applyPDFUA checks a file marked as PDF/UA-1 (ISO 14289-1) against the
+// requirements the backend can verify: a tagged structure covering all
+// content, alternate text for figures, a title and language, and embedded
+// fonts. It returns the identification properties for the XMP packet.
+func applyPDFUA(out *outputFile, pages []*Backend, shared *sharedResources, meta *metadata) ([]xmpNamespacedProperties, error) {
+	if !shared.pdfua {
+		return nil, nil
+	}
+	if meta.title == "" {
+		return nil, fmt.Errorf("pdf: PDF/UA requires a document title; call SetTitle")
+	}
+	if meta.lang == "" {
+		return nil, fmt.Errorf("pdf: PDF/UA requires a document language; call SetLanguage")
+	}
+	if shared.standardFontUsed {
+		return nil, fmt.Errorf("%w (required by PDF/UA)", errNoEmbeddedFont)
+	}
+	if !shared.tags.enabled {
+		return nil, fmt.Errorf("pdf: PDF/UA requires tagged content; call BeginStructure before drawing")
+	}
+	for i, b := range pages {
+		if b.content.untagged {
+			return nil, fmt.Errorf("pdf: page %d has content drawn before the first structure element", i+1)
+		}
+	}
+	if err := checkFigureAlt(shared.tags.roots); err != nil {
+		return nil, err
+	}
+
+	out.catalog["ViewerPreferences"] = pdfDict{"DisplayDocTitle": true}
+	return []xmpNamespacedProperties{{
+		namespace:  xmpNamespace{prefix: "pdfuaid", uri: xmpNamespacePDFUA},
+		properties: [][2]string{{"part", "1"}},
+		schema: &xmpSchema{
+			namespace:   xmpNamespace{prefix: "pdfuaid", uri: xmpNamespacePDFUA},
+			description: "PDF/UA identification schema",
+			properties: []xmpPropertySchema{{
+				name: "part", valueType: "Integer", category: "internal",
+				description: "Indicates, which part of ISO 14289 standard is followed",
+			}},
+		},
+	}}, nil
+}
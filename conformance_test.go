@@ -0,0 +1,474 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"strings"
+	"testing"
+
+	"github.com/gogpu/gg"
+	"github.com/gogpu/gg/recording"
+	"github.com/gogpu/gg/text"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+// newPDFADocument returns a document at the given conformance level with
+// Go Regular registered.
+func newPDFADocument(t *testing.T, level Conformance) *Document {
+	t.Helper()
+
+	doc := NewDocument()
+	if err := doc.SetConformance(level); err != nil {
+		t.Fatalf("SetConformance failed: %v", err)
+	}
+	if err := doc.RegisterFont(goregular.TTF); err != nil {
+		t.Fatalf("RegisterFont failed: %v", err)
+	}
+	return doc
+}
+
+func goRegularFace(t *testing.T, size float64) text.Face {
+	t.Helper()
+
+	src, err := text.NewFontSource(goregular.TTF)
+	if err != nil {
+		t.Fatalf("failed to parse Go Regular: %v", err)
+	}
+	return src.Face(size)
+}
+
+func TestPDFAOutputIdentification(t *testing.T) {
+	for _, tt := range []struct {
+		level Conformance
+		part  string
+	}{
+		{ConformancePDFA2B, "2"},
+		{ConformancePDFA3B, "3"},
+	} {
+		t.Run(tt.level.String(), func(t *testing.T) {
+			doc := newPDFADocument(t, tt.level)
+			doc.SetTitle("Invoice")
+			page := doc.NewPage(200, 100)
+			page.DrawText("Total: 42 €", 10, 50, goRegularFace(t, 12), recording.NewSolidBrush(gg.Black))
+
+			packet, _ := documentXMP(t, doc)
+			for _, want := range []string{
+				`xmlns:pdfaid="` + xmpNamespacePDFAID + `"`,
+				"<pdfaid:part>" + tt.part + "</pdfaid:part>",
+				"<pdfaid:conformance>B</pdfaid:conformance>",
+			} {
+				if !strings.Contains(packet, want) {
+					t.Errorf("XMP packet missing %q", want)
+				}
+			}
+
+			var buf bytes.Buffer
+			if _, err := doc.WriteTo(&buf); err != nil {
+				t.Fatalf("WriteTo failed: %v", err)
+			}
+			r, catalog := readOutput(t, buf.Bytes())
+			intents, _ := catalog["OutputIntents"].(pdfArray)
+			if len(intents) != 1 {
+				t.Fatalf("/OutputIntents = %v, want one intent", catalog["OutputIntents"])
+			}
+			intent, _ := r.resolveDict(intents[0])
+			if intent["S"] != pdfName("GTS_PDFA1") || intent["OutputConditionIdentifier"] != pdfString(srgbOutputCondition) {
+				t.Errorf("output intent = %v, want GTS_PDFA1 for sRGB", intent)
+			}
+			obj, _ := r.resolve(intent["DestOutputProfile"])
+			profile, ok := obj.(*pdfStream)
+			if !ok || profile.Dict["N"] != 3 {
+				t.Fatalf("/DestOutputProfile = %v, want a three-component ICC stream", obj)
+			}
+			if _, ok := r.trailer["Encrypt"]; ok {
+				t.Error("PDF/A output is encrypted")
+			}
+		})
+	}
+}
+
+func TestPDFAEmbedsRegisteredFont(t *testing.T) {
+	doc := newPDFADocument(t, ConformancePDFA2B)
+	page := doc.NewPage(200, 100)
+	page.DrawText("Hi!", 10, 50, goRegularFace(t, 12), recording.NewSolidBrush(gg.Black))
+
+	content, res, r := pageContent(t, func(buf *bytes.Buffer) error {
+		_, err := doc.WriteTo(buf)
+		return err
+	}, 0)
+	if !strings.Contains(content, "<002B004C0004> Tj\n") {
+		t.Errorf("text is not shown as glyph identifiers:\n%s", content)
+	}
+
+	fonts, _ := res["Font"].(pdfDict)
+	font, _ := r.resolveDict(fonts["F1"])
+	if font["Subtype"] != pdfName("Type0") || font["Encoding"] != pdfName("Identity-H") {
+		t.Fatalf("/F1 = %v, want a Type0 font with Identity-H", font)
+	}
+	descendants, _ := font["DescendantFonts"].(pdfArray)
+	cid, _ := r.resolveDict(descendants[0])
+	if cid["Subtype"] != pdfName("CIDFontType2") || cid["CIDToGIDMap"] != pdfName("Identity") {
+		t.Errorf("descendant font = %v, want CIDFontType2 with an identity map", cid)
+	}
+	if w, _ := cid["W"].(pdfArray); len(w) != 6 {
+		t.Errorf("/W = %v, want widths for the three glyphs in use", cid["W"])
+	}
+	descriptor, _ := r.resolveDict(cid["FontDescriptor"])
+	obj, _ := r.resolve(descriptor["FontFile2"])
+	file, ok := obj.(*pdfStream)
+	if !ok {
+		t.Fatal("font descriptor has no /FontFile2")
+	}
+	data, err := decodeStream(file)
+	if err != nil || !bytes.Equal(data, goregular.TTF) {
+		t.Error("embedded font program differs from the registered font")
+	}
+	if _, ok := font["ToUnicode"]; !ok {
+		t.Error("embedded font has no /ToUnicode map")
+	}
+}
+
+func TestPDFARejectsStandardFont(t *testing.T) {
+	doc := NewDocument()
+	_ = doc.SetConformance(ConformancePDFA2B)
+	page := doc.NewPage(200, 100)
+	page.DrawText("Hello", 10, 50, nil, recording.NewSolidBrush(gg.Black))
+
+	if err := page.End(); !errors.Is(err, errNoEmbeddedFont) {
+		t.Errorf("End error = %v, want errNoEmbeddedFont", err)
+	}
+	if _, err := doc.WriteTo(&bytes.Buffer{}); !errors.Is(err, errNoEmbeddedFont) {
+		t.Errorf("WriteTo error = %v, want errNoEmbeddedFont", err)
+	}
+}
+
+func TestPDFASetAfterDrawingStillChecksFonts(t *testing.T) {
+	backend := NewBackend()
+	if err := backend.Begin(200, 100); err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	backend.DrawText("Hello", 10, 50, nil, recording.NewSolidBrush(gg.Black))
+	if err := backend.End(); err != nil {
+		t.Fatalf("End failed: %v", err)
+	}
+	_ = backend.SetConformance(ConformancePDFA3B)
+
+	if _, err := backend.WriteTo(&bytes.Buffer{}); !errors.Is(err, errNoEmbeddedFont) {
+		t.Errorf("WriteTo error = %v, want errNoEmbeddedFont", err)
+	}
+}
+
+func TestPDFARejectsMissingGlyphs(t *testing.T) {
+	doc := newPDFADocument(t, ConformancePDFA2B)
+	page := doc.NewPage(200, 100)
+	page.DrawText("日本", 10, 50, goRegularFace(t, 12), recording.NewSolidBrush(gg.Black))
+
+	err := page.End()
+	if err == nil || !strings.Contains(err.Error(), "no glyph") {
+		t.Errorf("End error = %v, want a missing glyph error", err)
+	}
+}
+
+func TestPDFADeclaresPageTransparency(t *testing.T) {
+	doc := newPDFADocument(t, ConformancePDFA2B)
+	doc.NewPage(100, 100).FillRect(recording.NewRect(0, 0, 50, 50), recording.NewSolidBrush(gg.RGBA2(1, 0, 0, 0.5)))
+	doc.NewPage(100, 100).FillRect(recording.NewRect(0, 0, 50, 50), recording.NewSolidBrush(gg.Black))
+
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	r, catalog := readOutput(t, buf.Bytes())
+	pagesRoot, _ := r.resolveDict(catalog["Pages"])
+	kids := pagesRoot["Kids"].(pdfArray)
+
+	first, _ := r.resolveDict(kids[0])
+	group, _ := first["Group"].(pdfDict)
+	if group["S"] != pdfName("Transparency") || group["CS"] != pdfName("DeviceRGB") {
+		t.Errorf("transparent page /Group = %v, want a transparency group in DeviceRGB", first["Group"])
+	}
+	second, _ := r.resolveDict(kids[1])
+	if _, ok := second["Group"]; ok {
+		t.Error("opaque page has a transparency group")
+	}
+}
+
+func TestPDFADescribesCustomXMPNamespaces(t *testing.T) {
+	const uri = "http://example.com/invoice/1.0/"
+
+	doc := newPDFADocument(t, ConformancePDFA2B)
+	_ = doc.NewPage(100, 100)
+	_ = doc.RegisterXMPNamespace("inv", uri)
+	_ = doc.SetXMPProperty(uri, "Number", "2026-0042")
+
+	packet, _ := documentXMP(t, doc)
+	for _, want := range []string{
+		"<pdfaSchema:namespaceURI>" + uri + "</pdfaSchema:namespaceURI>",
+		"<pdfaSchema:prefix>inv</pdfaSchema:prefix>",
+		"<pdfaProperty:name>Number</pdfaProperty:name>",
+	} {
+		if !strings.Contains(packet, want) {
+			t.Errorf("XMP packet missing %q:\n%s", want, packet)
+		}
+	}
+}
+
+func TestSetConformanceRejectsUnknownLevel(t *testing.T) {
+	if err := NewDocument().SetConformance(Conformance(99)); err == nil {
+		t.Error("Document.SetConformance accepted an unknown level")
+	}
+	if err := NewBackend().SetConformance(Conformance(-1)); err == nil {
+		t.Error("Backend.SetConformance accepted an unknown level")
+	}
+}
+
+func TestSRGBProfileHeader(t *testing.T) {
+	profile := srgbProfile()
+	if got := binary.BigEndian.Uint32(profile); int(got) != len(profile) {
+		t.Errorf("profile size field = %d, want %d", got, len(profile))
+	}
+	if string(profile[36:40]) != "acsp" || string(profile[12:16]) != "mntr" || string(profile[16:20]) != "RGB " {
+		t.Error("profile header does not describe an RGB display profile")
+	}
+	count := binary.BigEndian.Uint32(profile[128:])
+	for i := range count {
+		entry := profile[132+12*i:]
+		offset, size := binary.BigEndian.Uint32(entry[4:]), binary.BigEndian.Uint32(entry[8:])
+		if offset%4 != 0 || int(offset+size) > len(profile) {
+			t.Errorf("tag %q at %d+%d is misaligned or out of range", entry[:4], offset, size)
+		}
+	}
+}
+
+func TestRegisterFontRejectsInvalidData(t *testing.T) {
+	for _, data := range [][]byte{nil, []byte("not a font"), []byte("ttcf\x00\x01\x00\x00")} {
+		if err := NewDocument().RegisterFont(data); err == nil {
+			t.Errorf("RegisterFont(%q) succeeded, want an error", data)
+		}
+	}
+}
+
+// testCMYKProfile returns the header of a CMYK output profile, which is all
+// SetOutputIntent inspects.
+func testCMYKProfile() []byte {
+	profile := make([]byte, 132)
+	binary.BigEndian.PutUint32(profile, uint32(len(profile)))
+	copy(profile[12:], "prtr")
+	copy(profile[16:], "CMYK")
+	copy(profile[20:], "Lab ")
+	copy(profile[36:], "acsp")
+	return profile
+}
+
+// newPDFXDocument returns a PDF/X-4 document ready for drawing.
+func newPDFXDocument(t *testing.T) *Document {
+	t.Helper()
+
+	doc := newPDFADocument(t, ConformancePDFX4)
+	doc.SetTitle("Brochure")
+	if err := doc.SetOutputIntent(testCMYKProfile(), "FOGRA39"); err != nil {
+		t.Fatalf("SetOutputIntent failed: %v", err)
+	}
+	return doc
+}
+
+func TestPDFX4Output(t *testing.T) {
+	doc := newPDFXDocument(t)
+	page := doc.NewPage(200, 100)
+	page.FillRect(recording.NewRect(0, 0, 50, 50), recording.NewSolidBrush(gg.RGB(1, 0.5, 0)))
+	page.DrawText("Sale", 10, 80, goRegularFace(t, 12), recording.NewSolidBrush(gg.Black))
+
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("%PDF-1.6\n")) {
+		t.Errorf("header = %q, want PDF 1.6", buf.Bytes()[:9])
+	}
+
+	r, catalog := readOutput(t, buf.Bytes())
+	intents, _ := catalog["OutputIntents"].(pdfArray)
+	if len(intents) != 1 {
+		t.Fatalf("/OutputIntents = %v, want one intent", catalog["OutputIntents"])
+	}
+	intent, _ := r.resolveDict(intents[0])
+	if intent["S"] != pdfName("GTS_PDFX") || intent["OutputConditionIdentifier"] != pdfString("FOGRA39") {
+		t.Errorf("output intent = %v, want GTS_PDFX for FOGRA39", intent)
+	}
+	obj, _ := r.resolve(intent["DestOutputProfile"])
+	if profile, ok := obj.(*pdfStream); !ok || profile.Dict["N"] != 4 {
+		t.Errorf("/DestOutputProfile = %v, want a four-component ICC stream", obj)
+	}
+
+	info, _ := r.resolveDict(r.trailer["Info"])
+	if info["GTS_PDFXVersion"] != pdfString("PDF/X-4") || info["Trapped"] != pdfName("False") {
+		t.Errorf("Info = %v, want /GTS_PDFXVersion (PDF/X-4) and /Trapped /False", info)
+	}
+	packet, _ := documentXMP(t, doc)
+	for _, want := range []string{
+		"<pdfxid:GTS_PDFXVersion>PDF/X-4</pdfxid:GTS_PDFXVersion>",
+		"<pdf:Trapped>False</pdf:Trapped>",
+		"<xmpMM:DocumentID>uuid:",
+		"<xmpMM:RenditionClass>default</xmpMM:RenditionClass>",
+	} {
+		if !strings.Contains(packet, want) {
+			t.Errorf("XMP packet missing %q", want)
+		}
+	}
+
+	pagesRoot, _ := r.resolveDict(catalog["Pages"])
+	first, _ := r.resolveDict(pagesRoot["Kids"].(pdfArray)[0])
+	for _, box := range []pdfName{"TrimBox", "BleedBox"} {
+		if got, _ := first[box].(pdfArray); len(got) != 4 || got[2] != 200.0 || got[3] != 100.0 {
+			t.Errorf("/%s = %v, want the whole page without a bleed", box, first[box])
+		}
+	}
+}
+
+func TestPDFX4TagsRGBContentWithICCProfile(t *testing.T) {
+	doc := newPDFXDocument(t)
+	page := doc.NewPage(100, 100)
+	page.FillRect(recording.NewRect(0, 0, 50, 50), recording.NewSolidBrush(gg.RGB(1, 0, 0)))
+	page.DrawImage(image.NewRGBA(image.Rect(0, 0, 2, 2)), recording.NewRect(0, 0, 2, 2),
+		recording.NewRect(50, 50, 10, 10), recording.DefaultImageOptions())
+
+	content, res, r := pageContent(t, func(buf *bytes.Buffer) error {
+		_, err := doc.WriteTo(buf)
+		return err
+	}, 0)
+	if strings.Contains(content, " rg\n") || !strings.Contains(content, "/CS1 cs\n1 0 0 scn\n") {
+		t.Errorf("fill color is not in the ICC-based space:\n%s", content)
+	}
+	spaces, _ := res["ColorSpace"].(pdfDict)
+	space, _ := r.resolve(spaces["CS1"])
+	if arr, ok := space.(pdfArray); !ok || arr[0] != pdfName("ICCBased") {
+		t.Errorf("/CS1 = %v, want an ICCBased color space", space)
+	}
+	xobjects, _ := res["XObject"].(pdfDict)
+	obj, _ := r.resolve(xobjects["Im1"])
+	im, _ := obj.(*pdfStream)
+	if im == nil {
+		t.Fatal("image XObject missing")
+	}
+	imSpace, _ := r.resolve(im.Dict["ColorSpace"])
+	if arr, ok := imSpace.(pdfArray); !ok || arr[0] != pdfName("ICCBased") {
+		t.Errorf("image /ColorSpace = %v, want an ICCBased color space", imSpace)
+	}
+}
+
+func TestPDFX4RejectsUncoveredRGB(t *testing.T) {
+	doc := NewDocument()
+	doc.SetTitle("Brochure")
+	_ = doc.SetOutputIntent(testCMYKProfile(), "FOGRA39")
+	doc.NewPage(100, 100).FillRect(recording.NewRect(0, 0, 50, 50), recording.NewSolidBrush(gg.RGB(1, 0, 0)))
+	_ = doc.SetConformance(ConformancePDFX4)
+
+	_, err := doc.WriteTo(&bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "DeviceRGB") {
+		t.Errorf("WriteTo error = %v, want an uncovered DeviceRGB error", err)
+	}
+}
+
+func TestPDFX4RequiresOutputIntentAndTitle(t *testing.T) {
+	doc := NewDocument()
+	_ = doc.SetConformance(ConformancePDFX4)
+	doc.SetTitle("Brochure")
+	_ = doc.NewPage(100, 100)
+	if _, err := doc.WriteTo(&bytes.Buffer{}); err == nil || !strings.Contains(err.Error(), "SetOutputIntent") {
+		t.Errorf("WriteTo without output intent error = %v", err)
+	}
+
+	doc = NewDocument()
+	_ = doc.SetConformance(ConformancePDFX4)
+	_ = doc.SetOutputIntent(testCMYKProfile(), "FOGRA39")
+	_ = doc.NewPage(100, 100)
+	if _, err := doc.WriteTo(&bytes.Buffer{}); err == nil || !strings.Contains(err.Error(), "SetTitle") {
+		t.Errorf("WriteTo without title error = %v", err)
+	}
+
+	if err := NewBackend().SetConformance(ConformancePDFX4); err == nil {
+		t.Error("Backend.SetConformance accepted PDF/X-4")
+	}
+}
+
+func TestSetOutputIntentValidatesProfile(t *testing.T) {
+	doc := NewDocument()
+	for name, profile := range map[string][]byte{
+		"empty":   nil,
+		"rgb":     srgbProfile(),
+		"garbage": bytes.Repeat([]byte{1}, 200),
+	} {
+		if err := doc.SetOutputIntent(profile, "X"); err == nil {
+			t.Errorf("SetOutputIntent accepted the %s profile", name)
+		}
+	}
+	if err := doc.SetOutputIntent(testCMYKProfile(), ""); err == nil {
+		t.Error("SetOutputIntent accepted an empty condition name")
+	}
+}
+
+func TestDocumentPageBoxes(t *testing.T) {
+	doc := NewDocument()
+	_ = doc.NewPage(200, 100)
+	_ = doc.NewPage(200, 100)
+	if err := doc.SetBleed(9); err != nil {
+		t.Fatalf("SetBleed failed: %v", err)
+	}
+	if err := doc.SetPageBoxes(1, recording.NewRect(10, 5, 100, 50), recording.NewRect(5, 0, 110, 60)); err != nil {
+		t.Fatalf("SetPageBoxes failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	r, catalog := readOutput(t, buf.Bytes())
+	pagesRoot, _ := r.resolveDict(catalog["Pages"])
+	kids := pagesRoot["Kids"].(pdfArray)
+
+	want := []map[pdfName]pdfArray{
+		{"TrimBox": {9.0, 9.0, 191.0, 91.0}, "BleedBox": {0.0, 0.0, 200.0, 100.0}},
+		// Explicit boxes are converted from the top-left origin.
+		{"TrimBox": {10.0, 45.0, 110.0, 95.0}, "BleedBox": {5.0, 40.0, 115.0, 100.0}},
+	}
+	for i, boxes := range want {
+		page, _ := r.resolveDict(kids[i])
+		for name, box := range boxes {
+			got, _ := page[name].(pdfArray)
+			if len(got) != 4 {
+				t.Errorf("page %d /%s missing", i, name)
+				continue
+			}
+			for j := range box {
+				if got[j] != box[j] {
+					t.Errorf("page %d /%s = %v, want %v", i, name, got, box)
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestDocumentPageBoxValidation(t *testing.T) {
+	doc := NewDocument()
+	_ = doc.NewPage(200, 100)
+	if err := doc.SetBleed(-1); err == nil {
+		t.Error("SetBleed accepted a negative bleed")
+	}
+	if err := doc.SetPageBoxes(1, recording.NewRect(0, 0, 10, 10), recording.NewRect(0, 0, 20, 20)); err == nil {
+		t.Error("SetPageBoxes accepted an out-of-range page")
+	}
+	if err := doc.SetPageBoxes(0, recording.NewRect(0, 0, 30, 30), recording.NewRect(0, 0, 20, 20)); err == nil {
+		t.Error("SetPageBoxes accepted a trim box outside the bleed box")
+	}
+	if err := doc.SetPageBoxes(0, recording.NewRect(0, 0, 10, 10), recording.NewRect(0, 0, 300, 20)); err == nil {
+		t.Error("SetPageBoxes accepted a bleed box outside the page")
+	}
+
+	_ = doc.SetBleed(60)
+	if _, err := doc.WriteTo(&bytes.Buffer{}); err == nil {
+		t.Error("WriteTo accepted a bleed larger than the page")
+	}
+}
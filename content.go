@@ -0,0 +1,323 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+
+	"github.com/gogpu/gg"
+	"github.com/gogpu/gg/recording"
+)
+
+// contentStream accumulates the operators of a page content stream together
+// with the resources they reference.
+type contentStream struct {
+	buf    bytes.Buffer
+	res    *resourceSet
+	shared *sharedResources
+
+	// transparency is set once anything drawn needs transparency
+	// compositing, which PDF/A requires to be declared on the page.
+	transparency bool
+
+	// deviceRGB is set once anything is drawn in the device-dependent RGB
+	// space, which PDF/X does not allow with a CMYK output intent.
+	deviceRGB bool
+
+	// deviceCMYK is set once anything is drawn in DeviceCMYK, directly or
+	// as the alternate of a spot color.
+	deviceCMYK bool
+
+	// blendMode is the /BM of the current graphics state; empty for Normal.
+	blendMode pdfName
+
+	// Tagged content: the element whose marked-content sequence is open,
+	// the element of each marked-content identifier in order, and whether
+	// anything was drawn outside the structure tree.
+	marked   *structElem
+	parents  []*structElem
+	untagged bool
+}
+
+func newContentStream(shared *sharedResources) *contentStream {
+	return &contentStream{res: newResourceSet(), shared: shared}
+}
+
+// op writes one operator with its operands on a line of its own.
+func (c *contentStream) op(operator string, operands ...any) {
+	for _, operand := range operands {
+		switch v := operand.(type) {
+		case float64:
+			c.buf.WriteString(formatNumber(v))
+		case int:
+			fmt.Fprintf(&c.buf, "%d", v)
+		case pdfName:
+			c.buf.Write(appendName(nil, v))
+		case pdfString:
+			c.buf.Write(appendLiteralString(nil, string(v)))
+		case pdfHexString:
+			c.buf.Write(appendHexString(nil, string(v)))
+		case string:
+			c.buf.WriteString(v)
+		default:
+			panic(fmt.Sprintf("pdf: unsupported operand %T", operand))
+		}
+		c.buf.WriteByte(' ')
+	}
+	c.buf.WriteString(operator)
+	c.buf.WriteByte('\n')
+}
+
+// transform writes a cm operator for m unless it is the identity.
+func (c *contentStream) transform(m recording.Matrix) {
+	if m.IsIdentity() {
+		return
+	}
+	c.op("cm", m.A, m.D, m.B, m.E, m.C, m.F)
+}
+
+// path writes the construction operators for path, transformed by m.
+// Quadratic segments are raised to cubics, which is exact.
+func (c *contentStream) path(path *gg.Path, m recording.Matrix) {
+	var cur gg.Point
+	for _, elem := range path.Elements() {
+		switch e := elem.(type) {
+		case gg.MoveTo:
+			x, y := m.TransformPoint(e.Point.X, e.Point.Y)
+			c.op("m", x, y)
+			cur = e.Point
+		case gg.LineTo:
+			x, y := m.TransformPoint(e.Point.X, e.Point.Y)
+			c.op("l", x, y)
+			cur = e.Point
+		case gg.QuadTo:
+			c1x := cur.X + 2.0/3.0*(e.Control.X-cur.X)
+			c1y := cur.Y + 2.0/3.0*(e.Control.Y-cur.Y)
+			c2x := e.Point.X + 2.0/3.0*(e.Control.X-e.Point.X)
+			c2y := e.Point.Y + 2.0/3.0*(e.Control.Y-e.Point.Y)
+			c.curve(m, c1x, c1y, c2x, c2y, e.Point.X, e.Point.Y)
+			cur = e.Point
+		case gg.CubicTo:
+			c.curve(m, e.Control1.X, e.Control1.Y, e.Control2.X, e.Control2.Y, e.Point.X, e.Point.Y)
+			cur = e.Point
+		case gg.Close:
+			c.op("h")
+		}
+	}
+}
+
+func (c *contentStream) curve(m recording.Matrix, x1, y1, x2, y2, x3, y3 float64) {
+	x1, y1 = m.TransformPoint(x1, y1)
+	x2, y2 = m.TransformPoint(x2, y2)
+	x3, y3 = m.TransformPoint(x3, y3)
+	c.op("c", x1, y1, x2, y2, x3, y3)
+}
+
+// rgbSpace returns the color space for RGB content, noting any use of
+// DeviceRGB.
+func (c *contentStream) rgbSpace() pdfObject {
+	space := c.shared.rgbSpace()
+	if space == pdfName("DeviceRGB") {
+		c.deviceRGB = true
+	}
+	return space
+}
+
+// extGStateKey identifies an ExtGState by the entries it sets.
+type extGStateKey struct {
+	fill, stroke float64
+	blendMode    pdfName
+}
+
+// opacity selects an ExtGState with the given fill and stroke alpha and the
+// current blend mode.
+func (c *contentStream) opacity(fill, stroke float64) {
+	fill, stroke = clamp01(fill), clamp01(stroke)
+	if fill >= 1 && stroke >= 1 && c.blendMode == "" {
+		return
+	}
+	c.transparency = true
+	key := extGStateKey{fill: fill, stroke: stroke, blendMode: c.blendMode}
+	name := c.res.add("ExtGState", "GS", key, func() pdfObject {
+		dict := pdfDict{
+			"Type": pdfName("ExtGState"),
+			"ca":   fill,
+			"CA":   stroke,
+		}
+		if key.blendMode != "" {
+			dict["BM"] = key.blendMode
+		}
+		return dict
+	})
+	c.op("gs", name)
+}
+
+// strokeStyle writes the line style operators of a stroke.
+func (c *contentStream) strokeStyle(s recording.Stroke) {
+	c.op("w", s.Width)
+	c.op("J", int(s.Cap))
+	c.op("j", int(s.Join))
+	if s.MiterLimit >= 1 {
+		c.op("M", s.MiterLimit)
+	}
+	if len(s.DashPattern) > 0 {
+		dash := "["
+		for i, d := range s.DashPattern {
+			if i > 0 {
+				dash += " "
+			}
+			dash += formatNumber(d)
+		}
+		c.op("d", dash+"]", s.DashOffset)
+	}
+}
+
+// shading returns the resource name of a shading for a gradient brush, or
+// false if the brush is not a gradient PDF can express.
+func (c *contentStream) shading(brush recording.Brush) (pdfName, bool) {
+	var (
+		dict  pdfDict
+		stops []recording.GradientStop
+	)
+	switch br := brush.(type) {
+	case *recording.LinearGradientBrush:
+		stops = br.Stops
+		dict = pdfDict{
+			"ShadingType": 2,
+			"Coords":      pdfArray{br.Start.X, br.Start.Y, br.End.X, br.End.Y},
+		}
+	case *recording.RadialGradientBrush:
+		stops = br.Stops
+		dict = pdfDict{
+			"ShadingType": 3,
+			"Coords": pdfArray{
+				br.Focus.X, br.Focus.Y, br.StartRadius,
+				br.Center.X, br.Center.Y, br.EndRadius,
+			},
+		}
+	default:
+		return "", false
+	}
+	if len(stops) == 0 {
+		return "", false
+	}
+
+	stopColors := make([]gg.RGBA, len(stops))
+	for i, stop := range stops {
+		stopColors[i] = stop.Color
+	}
+	space, color := c.gradientSpace(stopColors)
+	dict["ColorSpace"] = space
+	dict["Function"] = gradientFunction(stops, color)
+	dict["Extend"] = pdfArray{true, true}
+	name := c.res.add("Shading", "Sh", brush, func() pdfObject { return dict })
+	return name, true
+}
+
+// gradientFunction builds a function mapping [0 1] to the stop colors,
+// given as components by color: a single exponential function for two
+// stops, a stitching function otherwise.
+func gradientFunction(stops []recording.GradientStop, color func(gg.RGBA) pdfArray) pdfObject {
+	segment := func(a, b gg.RGBA) pdfDict {
+		return pdfDict{
+			"FunctionType": 2,
+			"Domain":       pdfArray{0.0, 1.0},
+			"C0":           color(a),
+			"C1":           color(b),
+			"N":            1.0,
+		}
+	}
+
+	if len(stops) == 1 {
+		return segment(stops[0].Color, stops[0].Color)
+	}
+	if len(stops) == 2 && stops[0].Offset <= 0 && stops[1].Offset >= 1 {
+		return segment(stops[0].Color, stops[1].Color)
+	}
+
+	// Pad the stop list so the stitching domain covers [0 1].
+	padded := make([]recording.GradientStop, 0, len(stops)+2)
+	if stops[0].Offset > 0 {
+		padded = append(padded, recording.GradientStop{Offset: 0, Color: stops[0].Color})
+	}
+	padded = append(padded, stops...)
+	if last := stops[len(stops)-1]; last.Offset < 1 {
+		padded = append(padded, recording.GradientStop{Offset: 1, Color: last.Color})
+	}
+
+	functions := pdfArray{}
+	bounds := pdfArray{}
+	encode := pdfArray{}
+	for i := 0; i+1 < len(padded); i++ {
+		functions = append(functions, segment(padded[i].Color, padded[i+1].Color))
+		encode = append(encode, 0.0, 1.0)
+		if i > 0 {
+			bounds = append(bounds, clamp01(padded[i].Offset))
+		}
+	}
+	return pdfDict{
+		"FunctionType": 3,
+		"Domain":       pdfArray{0.0, 1.0},
+		"Functions":    functions,
+		"Bounds":       bounds,
+		"Encode":       encode,
+	}
+}
+
+// clamp01 limits v to the range [0, 1].
+func clamp01(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
+
+// resourceSet holds the named resources referenced by a content stream.
+// Resources are keyed so that repeated use maps to a single name.
+type resourceSet struct {
+	categories map[pdfName]pdfDict
+	names      map[resourceKey]pdfName
+	counts     map[string]int
+}
+
+// resourceKey identifies a resource within its category.
+type resourceKey struct {
+	category pdfName
+	key      any
+}
+
+func newResourceSet() *resourceSet {
+	return &resourceSet{
+		categories: make(map[pdfName]pdfDict),
+		names:      make(map[resourceKey]pdfName),
+		counts:     make(map[string]int),
+	}
+}
+
+// add returns the name of the resource identified by key, calling value to
+// create it the first time the key is seen. key must be comparable.
+func (r *resourceSet) add(category pdfName, prefix string, key any, value func() pdfObject) pdfName {
+	rk := resourceKey{category: category, key: key}
+	if name, ok := r.names[rk]; ok {
+		return name
+	}
+	r.counts[prefix]++
+	name := pdfName(fmt.Sprintf("%s%d", prefix, r.counts[prefix]))
+	if r.categories[category] == nil {
+		r.categories[category] = pdfDict{}
+	}
+	r.categories[category][name] = value()
+	r.names[rk] = name
+	return name
+}
+
+// dict returns the /Resources dictionary.
+func (r *resourceSet) dict() pdfDict {
+	res := pdfDict{
+		"ProcSet": pdfArray{
+			pdfName("PDF"), pdfName("Text"),
+			pdfName("ImageB"), pdfName("ImageC"), pdfName("ImageI"),
+		},
+	}
+	for category, entries := range r.categories {
+		res[category] = entries
+	}
+	return res
+}
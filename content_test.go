@@ -0,0 +1,225 @@
+package pdf
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/gogpu/gg"
+	"github.com/gogpu/gg/recording"
+)
+
+// pageContent writes a file with write and returns the decoded content
+// stream and the resources of page i.
+func pageContent(t *testing.T, write func(*bytes.Buffer) error, i int) (string, pdfDict, *pdfReader) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	r, catalog := readOutput(t, buf.Bytes())
+	pagesRoot, _ := r.resolveDict(catalog["Pages"])
+	kids, _ := pagesRoot["Kids"].(pdfArray)
+	if i >= len(kids) {
+		t.Fatalf("page %d requested, file has %d", i, len(kids))
+	}
+	page, err := r.resolveDict(kids[i])
+	if err != nil {
+		t.Fatalf("failed to resolve page: %v", err)
+	}
+	obj, err := r.resolve(page["Contents"])
+	if err != nil {
+		t.Fatalf("failed to resolve /Contents: %v", err)
+	}
+	stream, ok := obj.(*pdfStream)
+	if !ok {
+		t.Fatalf("/Contents is %T, want a stream", obj)
+	}
+	data, err := decodeStream(stream)
+	if err != nil {
+		t.Fatalf("failed to decode content: %v", err)
+	}
+	res, err := r.resolveDict(page["Resources"])
+	if err != nil {
+		t.Fatalf("failed to resolve /Resources: %v", err)
+	}
+	return string(data), res, r
+}
+
+// backendWriter draws on a fresh single-page backend and returns a function
+// writing its output.
+func backendWriter(t *testing.T, width, height int, draw func(*Backend)) func(*bytes.Buffer) error {
+	t.Helper()
+
+	backend := NewBackend()
+	if err := backend.Begin(width, height); err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	draw(backend)
+	if err := backend.End(); err != nil {
+		t.Fatalf("End failed: %v", err)
+	}
+	return func(buf *bytes.Buffer) error {
+		_, err := backend.WriteTo(buf)
+		return err
+	}
+}
+
+func TestBackendContentDrawsPaths(t *testing.T) {
+	write := backendWriter(t, 200, 100, func(b *Backend) {
+		path := gg.NewPath()
+		path.MoveTo(10, 10)
+		path.QuadraticTo(40, 10, 40, 40)
+		path.Close()
+
+		b.Save()
+		b.SetTransform(recording.Translate(5, 7))
+		b.FillPath(path, recording.NewSolidBrush(gg.RGBA2(1, 0, 0, 0.5)), recording.FillRuleEvenOdd)
+		b.StrokePath(path, recording.NewSolidBrush(gg.RGB(0, 0, 1)), recording.Stroke{
+			Width: 2, Cap: recording.LineCapRound, Join: recording.LineJoinBevel,
+			MiterLimit: 4, DashPattern: []float64{3, 1}, DashOffset: 0.5,
+		})
+		b.Restore()
+	})
+
+	content, res, _ := pageContent(t, write, 0)
+	for _, want := range []string{
+		"1 0 0 -1 0 100 cm\n",
+		"q\n1 0 0 1 5 7 cm\n/GS1 gs\n1 0 0 rg\n10 10 m\n",
+		"30 10 40 20 40 40 c\nh\nf*\nQ\n",
+		"0 0 1 RG\n2 w\n1 J\n2 j\n4 M\n[3 1] 0.5 d\n",
+		"S\nQ\nQ\n",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("content missing %q:\n%s", want, content)
+		}
+	}
+
+	gs, _ := res["ExtGState"].(pdfDict)
+	state, _ := gs["GS1"].(pdfDict)
+	if state["ca"] != 0.5 || state["CA"] != 1.0 {
+		t.Errorf("/GS1 = %v, want fill alpha 0.5 and stroke alpha 1", state)
+	}
+}
+
+func TestBackendContentClipsInPageSpace(t *testing.T) {
+	write := backendWriter(t, 100, 100, func(b *Backend) {
+		b.Save()
+		b.SetTransform(recording.Scale(2, 2))
+		clip := gg.NewPath()
+		clip.Rectangle(0, 0, 10, 10)
+		b.SetClip(clip, recording.FillRuleNonZero)
+		b.FillRect(recording.NewRect(0, 0, 5, 5), recording.NewSolidBrush(gg.Black))
+		b.Restore()
+	})
+
+	content, _, _ := pageContent(t, write, 0)
+	// The clip persists until Restore, so its points carry the transform
+	// instead of a cm inside a q/Q pair.
+	if !strings.Contains(content, "q\n0 0 m\n20 0 l\n20 20 l\n0 20 l\nh\nW\nn\n") {
+		t.Errorf("content does not clip with transformed points:\n%s", content)
+	}
+	if !strings.Contains(content, "2 0 0 2 0 0 cm\n0 0 0 rg\n0 0 5 5 re\nf\n") {
+		t.Errorf("content does not fill the rectangle under the transform:\n%s", content)
+	}
+}
+
+func TestBackendContentLinearGradientShading(t *testing.T) {
+	brush := recording.NewLinearGradientBrush(0, 0, 100, 0).
+		AddColorStop(0, gg.RGB(1, 0, 0)).
+		AddColorStop(0.25, gg.RGB(0, 1, 0)).
+		AddColorStop(1, gg.RGB(0, 0, 1))
+	write := backendWriter(t, 100, 100, func(b *Backend) {
+		b.FillRect(recording.NewRect(0, 0, 100, 100), brush)
+	})
+
+	content, res, _ := pageContent(t, write, 0)
+	if !strings.Contains(content, "re\nW\nn\n/Sh1 sh\n") {
+		t.Errorf("gradient fill is not a clipped shading:\n%s", content)
+	}
+	shadings, _ := res["Shading"].(pdfDict)
+	sh, _ := shadings["Sh1"].(pdfDict)
+	if sh["ShadingType"] != 2 {
+		t.Fatalf("/Sh1 = %v, want an axial shading", sh)
+	}
+	fn, _ := sh["Function"].(pdfDict)
+	if fn["FunctionType"] != 3 {
+		t.Fatalf("three-stop gradient function = %v, want a stitching function", fn)
+	}
+	if bounds, _ := fn["Bounds"].(pdfArray); len(bounds) != 1 || bounds[0] != 0.25 {
+		t.Errorf("stitching bounds = %v, want [0.25]", fn["Bounds"])
+	}
+}
+
+func TestBackendContentTextUsesStandardFont(t *testing.T) {
+	write := backendWriter(t, 200, 100, func(b *Backend) {
+		b.DrawText("Café (€)", 10, 50, nil, recording.NewSolidBrush(gg.Black))
+	})
+
+	content, res, r := pageContent(t, write, 0)
+	if !strings.Contains(content, "1 0 0 -1 10 50 cm\n") ||
+		!strings.Contains(content, "BT\n/F1 12 Tf\n(Caf\xE9 \\(\x80\\)) Tj\nET\n") {
+		t.Errorf("content does not show WinAnsi text upright at the origin:\n%s", content)
+	}
+	fonts, _ := res["Font"].(pdfDict)
+	font, err := r.resolveDict(fonts["F1"])
+	if err != nil || font["BaseFont"] != pdfName(standardFontName) || font["Encoding"] != pdfName("WinAnsiEncoding") {
+		t.Errorf("/F1 = %v, want Helvetica with WinAnsiEncoding", font)
+	}
+}
+
+func TestBackendContentImageWithAlpha(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	for i := range img.Pix {
+		img.Pix[i] = 0xFF
+	}
+	img.SetNRGBA(0, 0, color.NRGBA{R: 0x80, A: 0x40})
+
+	write := backendWriter(t, 100, 100, func(b *Backend) {
+		src := recording.NewRect(0, 0, 4, 2)
+		b.DrawImage(img, src, recording.NewRect(10, 20, 40, 20), recording.DefaultImageOptions())
+		b.DrawImage(img, src, recording.NewRect(50, 20, 40, 20), recording.ImageOptions{Alpha: 0.5})
+	})
+
+	content, res, r := pageContent(t, write, 0)
+	if !strings.Contains(content, "q\n40 0 0 -20 10 40 cm\n/Im1 Do\nQ\n") {
+		t.Errorf("image is not mapped onto the destination:\n%s", content)
+	}
+	if !strings.Contains(content, "/GS1 gs\n40 0 0 -20 50 40 cm\n/Im1 Do\n") {
+		t.Errorf("second draw does not reuse the image with opacity:\n%s", content)
+	}
+
+	xobjects, _ := res["XObject"].(pdfDict)
+	if len(xobjects) != 1 {
+		t.Fatalf("page has %d image XObjects, want the image written once", len(xobjects))
+	}
+	obj, _ := r.resolve(xobjects["Im1"])
+	im, ok := obj.(*pdfStream)
+	if !ok || im.Dict["Width"] != 4 || im.Dict["Height"] != 2 || im.Dict["ColorSpace"] != pdfName("DeviceRGB") {
+		t.Fatalf("/Im1 = %v, want a 4x2 DeviceRGB image", obj)
+	}
+	obj, _ = r.resolve(im.Dict["SMask"])
+	mask, ok := obj.(*pdfStream)
+	if !ok {
+		t.Fatal("image with alpha has no /SMask")
+	}
+	alpha, _ := decodeStream(mask)
+	if len(alpha) != 8 || alpha[0] != 0x40 || alpha[1] != 0xFF {
+		t.Errorf("soft mask data = %v, want the image alpha", alpha)
+	}
+}
+
+func TestBackendEndClosesUnbalancedSave(t *testing.T) {
+	write := backendWriter(t, 100, 100, func(b *Backend) {
+		b.Save()
+		b.Save()
+	})
+
+	content, _, _ := pageContent(t, write, 0)
+	if strings.Count(content, "q\n") != strings.Count(content, "Q\n") {
+		t.Errorf("content has unbalanced q/Q:\n%s", content)
+	}
+}
@@ -1,10 +1,13 @@
 package pdf
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"time"
 
-	"github.com/coregx/gxpdf/creator"
+	"github.com/gogpu/gg"
 	"github.com/gogpu/gg/recording"
 )
 
@@ -26,36 +29,45 @@ import (
 //
 //	doc.SaveToFile("output.pdf")
 type Document struct {
-	creator  *creator.Creator
 	pages    []*pageBackend
 	finished bool
-	newPage  func(width, height float64) (*creator.Page, error)
+	meta     *metadata
+	shared   *sharedResources
+
+	// embedRecordings embeds the recording of each page made by Playback.
+	embedRecordings bool
+
+	// stream writes the pages of a document made by NewStreamingDocument
+	// as they end; nil for a document written at once.
+	stream *streamWriter
+
+	// err is the error that failed the document during PlaybackContext or
+	// PlaybackAll; nil while the document can still be used.
+	err error
 }
 
-// pageBackend is a Backend that shares the creator with Document.
+// pageBackend is a Backend for a page of a Document.
 type pageBackend struct {
 	*Backend
 	doc     *Document
 	initErr error
 	ended   bool
+	index   int // position in the document, from zero
 }
 
 // Begin validates the page prepared by Document.NewPage. Recording playback
-// calls Begin before drawing, but a document page must keep using the creator,
-// page, and surface that belong to its Document.
+// calls Begin before drawing, but a document page must keep using the
+// content that belongs to its Document.
 func (b *pageBackend) Begin(width, height int) error {
 	if b.initErr != nil {
 		return b.initErr
 	}
-	if b.Backend == nil || b.doc == nil || b.creator == nil || b.page == nil || b.surface == nil {
-		return fmt.Errorf("pdf: document page is not initialized")
-	}
-	if b.creator != b.doc.creator {
-		return fmt.Errorf("pdf: document page has invalid creator")
-	}
 	if b.ended {
 		return fmt.Errorf("pdf: document page is already finalized")
 	}
+	if b.Backend == nil || b.doc == nil {
+		return fmt.Errorf("pdf: document page is not initialized")
+	}
 	if width != int(b.width) || height != int(b.height) {
 		return fmt.Errorf(
 			"pdf: page dimensions mismatch: got %dx%d, want %dx%d",
@@ -66,7 +78,8 @@ func (b *pageBackend) Begin(width, height int) error {
 }
 
 // End finalizes the document page once. Document.Finish also calls End, so it
-// must be safe when recording playback has already finalized the page.
+// must be safe when recording playback has already finalized the page. A
+// page of a streaming document is written out here.
 func (b *pageBackend) End() error {
 	if b.initErr != nil {
 		return b.initErr
@@ -74,27 +87,34 @@ func (b *pageBackend) End() error {
 	if b.ended {
 		return nil
 	}
-	if b.Backend == nil || b.doc == nil || b.creator == nil || b.page == nil || b.surface == nil {
+	if b.Backend == nil || b.doc == nil {
 		return fmt.Errorf("pdf: document page is not initialized")
 	}
-	if b.creator != b.doc.creator {
-		return fmt.Errorf("pdf: document page has invalid creator")
-	}
 
-	b.surface.Pop()
+	b.endContent()
 	b.ended = true
-	return nil
+	if b.err != nil || b.doc.stream == nil {
+		return b.err
+	}
+	return b.doc.stream.writePage(b)
+}
+
+// WriteTo writes the whole document the page belongs to.
+func (b *pageBackend) WriteTo(w io.Writer) (int64, error) {
+	return b.doc.WriteTo(w)
+}
+
+// SaveToFile saves the whole document the page belongs to.
+func (b *pageBackend) SaveToFile(path string) error {
+	return b.doc.SaveToFile(path)
 }
 
 // NewDocument creates a new multi-page PDF document.
 func NewDocument() *Document {
-	pdfCreator := creator.New()
 	return &Document{
-		creator: pdfCreator,
-		pages:   make([]*pageBackend, 0, 4),
-		newPage: func(width, height float64) (*creator.Page, error) {
-			return pdfCreator.NewPageWithDimensions(width, height)
-		},
+		pages:  make([]*pageBackend, 0, 4),
+		meta:   newMetadata(),
+		shared: newSharedResources(),
 	}
 }
 
@@ -102,49 +122,80 @@ func NewDocument() *Document {
 // Each call to NewPage adds a new page to the document.
 // The returned backend can be used with recording.Playback() to draw on the page.
 func (d *Document) NewPage(width, height int) recording.Backend {
-	// Create new backend using the document's creator
+	return d.newPageBackend(float64(width), float64(height))
+}
+
+// newPageBackend adds a page of the given size in points.
+func (d *Document) newPageBackend(width, height float64) *pageBackend {
 	pb := &pageBackend{
 		Backend: &Backend{
-			width:      float64(width),
-			height:     float64(height),
+			width:      width,
+			height:     height,
 			stateStack: make([]backendState, 0, 8),
+			shared:     d.shared,
+			meta:       d.meta,
 		},
 		doc: d,
 	}
-
-	// Store the creator reference
-	pb.creator = d.creator
+	if d.err != nil {
+		pb.initErr = d.err
+		return pb
+	}
 	if d.finished {
 		pb.initErr = fmt.Errorf("pdf: cannot add page to finished document")
 		return pb
 	}
+	pb.index = len(d.pages)
+	if d.stream != nil {
+		d.stream.addPage(width, height)
+	}
 
 	// Page dimensions are expressed in PDF points, matching the coordinate
 	// units used by the recording backend. Keep them exact for each page.
-	page, err := d.newPage(float64(width), float64(height))
-	if err != nil {
+	if err := checkPageSize(width, height); err != nil {
 		pb.initErr = fmt.Errorf("pdf: failed to create document page: %w", err)
 		d.pages = append(d.pages, pb)
 		return pb
 	}
 
-	pb.page = page
-	pb.surface = page.Surface()
 	pb.currentTransform = recording.Identity()
-
-	// Apply Y-flip transform
-	flipTransform := creator.Scale(1, -1).Then(creator.Translate(0, float64(height)))
-	pb.surface.PushTransform(flipTransform)
+	pb.beginContent()
 
 	d.pages = append(d.pages, pb)
 	return pb
 }
 
+// checkPageSize reports an error for a page width or height that is not a
+// positive, finite number of points.
+func checkPageSize(width, height float64) error {
+	if !(width > 0) || math.IsInf(width, 0) {
+		return fmt.Errorf("page width must be positive, got %.4f", width)
+	}
+	if !(height > 0) || math.IsInf(height, 0) {
+		return fmt.Errorf("page height must be positive, got %.4f", height)
+	}
+	return nil
+}
+
 // Playback replays a recording to a new page with the recording's dimensions.
 // This is a convenience method that creates a page and plays the recording to it.
 func (d *Document) Playback(rec *recording.Recording) error {
-	backend := d.NewPage(rec.Width(), rec.Height())
-	return rec.Playback(backend)
+	return rec.Playback(d.playbackPage(rec))
+}
+
+// playbackPage adds a page with the dimensions of rec.
+func (d *Document) playbackPage(rec *recording.Recording) *pageBackend {
+	backend := d.newPageBackend(float64(rec.Width()), float64(rec.Height()))
+	if d.embedRecordings {
+		backend.source = rec
+	}
+	return backend
+}
+
+// SetEmbedRecordings selects whether Playback embeds each recording in the
+// page it creates. See Backend.EmbedRecording.
+func (d *Document) SetEmbedRecordings(enabled bool) {
+	d.embedRecordings = enabled
 }
 
 // PageCount returns the number of pages in the document.
@@ -155,6 +206,9 @@ func (d *Document) PageCount() int {
 // Finish finalizes all pages in the document.
 // This must be called before WriteTo or SaveToFile.
 func (d *Document) Finish() error {
+	if d.err != nil {
+		return d.err
+	}
 	if d.finished {
 		return nil
 	}
@@ -173,37 +227,285 @@ func (d *Document) Finish() error {
 // WriteTo writes the PDF to the given writer.
 // Finish() is called automatically if not already called.
 func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	if d.stream != nil {
+		return 0, errStreaming
+	}
 	if err := d.Finish(); err != nil {
 		return 0, fmt.Errorf("pdf: failed to finish document: %w", err)
 	}
-	return d.creator.WriteTo(w)
+
+	pages := make([]*Backend, len(d.pages))
+	for i, pb := range d.pages {
+		pages[i] = pb.Backend
+	}
+	return writeDocument(w, pages, d.shared, d.meta)
 }
 
 // SaveToFile saves the PDF to a file at the given path.
 // Finish() is called automatically if not already called.
 func (d *Document) SaveToFile(path string) error {
+	if d.stream != nil {
+		return errStreaming
+	}
 	if err := d.Finish(); err != nil {
 		return fmt.Errorf("pdf: failed to finish document: %w", err)
 	}
-	return d.creator.WriteToFile(path)
+	return writeFile(path, d.WriteTo)
+}
+
+// SetConformance selects a standard, such as PDF/A-2b, that the document
+// must conform to. It should be called before drawing so that violations are
+// reported by the drawing operation that causes them; anything that still
+// violates the standard makes WriteTo fail.
+func (d *Document) SetConformance(level Conformance) error {
+	if err := level.validate(); err != nil {
+		return err
+	}
+	if err := d.checkStreamStarted(); err != nil {
+		return err
+	}
+	d.shared.conformance = level
+	return nil
+}
+
+// SetOutputIntent sets the print condition that PDF/X output is prepared
+// for: a CMYK output ICC profile and the name of the condition, such as
+// "FOGRA39" or "GRACoL2013". The profile is embedded as the destination of
+// the output intent.
+func (d *Document) SetOutputIntent(profile []byte, condition string) error {
+	if err := validateOutputProfile(profile); err != nil {
+		return err
+	}
+	if condition == "" {
+		return fmt.Errorf("pdf: output condition name is empty")
+	}
+	if err := d.checkStreamStarted(); err != nil {
+		return err
+	}
+	d.shared.outputProfile = profile
+	d.shared.outputCondition = condition
+	return nil
+}
+
+// SetBleed sets the bleed, in points, of pages without explicit boxes: the
+// TrimBox is the page inset by the bleed on every side and the BleedBox is
+// the whole page. Pages should then be created at the trimmed size plus
+// twice the bleed. Page boxes are written when a bleed is set and always
+// for PDF/X. A streaming document must set it before its first page ends.
+func (d *Document) SetBleed(bleed float64) error {
+	if bleed < 0 || math.IsNaN(bleed) || math.IsInf(bleed, 0) {
+		return fmt.Errorf("pdf: invalid bleed %v", bleed)
+	}
+	if d.stream != nil && d.stream.started {
+		return errStreamPageBoxes
+	}
+	d.shared.bleed = bleed
+	return nil
+}
+
+// SetPageBoxes sets the TrimBox and BleedBox of a page, indexed from zero,
+// in gg coordinates. The trim box must lie within the bleed box and the
+// bleed box within the page. A streaming document must set them before the
+// page ends.
+func (d *Document) SetPageBoxes(page int, trim, bleed recording.Rect) error {
+	if page < 0 || page >= len(d.pages) {
+		return fmt.Errorf("pdf: page %d out of range [0, %d)", page, len(d.pages))
+	}
+	pb := d.pages[page]
+	if d.stream != nil && pb.ended {
+		return errStreamPageBoxes
+	}
+	media := recording.NewRect(0, 0, pb.width, pb.height)
+	if trim.IsEmpty() || !rectContains(bleed, trim) {
+		return fmt.Errorf("pdf: trim box %v is empty or outside the bleed box %v", trim, bleed)
+	}
+	if !rectContains(media, bleed) {
+		return fmt.Errorf("pdf: bleed box %v is outside the page %v", bleed, media)
+	}
+	pb.trimBox, pb.bleedBox = &trim, &bleed
+	return nil
+}
+
+// rectContains reports whether inner lies within outer.
+func rectContains(outer, inner recording.Rect) bool {
+	return inner.MinX >= outer.MinX && inner.MinY >= outer.MinY &&
+		inner.MaxX <= outer.MaxX && inner.MaxY <= outer.MaxY
+}
+
+// RegisterFont registers a TrueType or OpenType font for DrawText on every
+// page and embeds it in the output. Text is drawn with the registered font
+// whose family name matches the face's font source, or with the first
+// registered font if none matches. Without registered fonts, text uses the
+// standard Helvetica font, which is not embedded.
+func (d *Document) RegisterFont(data []byte) error {
+	return d.shared.registerFont(data)
+}
+
+// SetColorManagement selects the color model that fills, strokes,
+// gradients, and text are written in on every page and how gg's sRGB colors
+// are converted to it. It should be called before drawing. PDF/A does not
+// allow CMYK output; PDF/X accepts all models.
+func (d *Document) SetColorManagement(cm ColorManagement) error {
+	if err := d.checkStreamStarted(); err != nil {
+		return err
+	}
+	return d.shared.setColorManagement(cm)
+}
+
+// RegisterSpotColor maps a gg color to a spot color: wherever the color is
+// drawn, it is written as a Separation colorant with the given name, such
+// as "PANTONE 185 C", and an alternate CMYK for devices without the ink.
+// Colors are matched on their RGB components. Gradients between spot colors
+// and white use the colorants as well, through a DeviceN space when there
+// are several.
+func (d *Document) RegisterSpotColor(name string, color gg.RGBA, cmyk [4]float64) error {
+	if err := d.checkStreamStarted(); err != nil {
+		return err
+	}
+	return d.shared.colors.registerSpot(name, color, cmyk)
+}
+
+// RegisterPattern registers a tiling pattern for all pages and returns the
+// brush that paints it. See Backend.RegisterPattern.
+func (d *Document) RegisterPattern(p TilingPattern) (*recording.PatternBrush, error) {
+	return d.shared.registerPattern(p)
+}
+
+// SetEncryption password-protects the document. See Backend.SetEncryption.
+func (d *Document) SetEncryption(e Encryption) error {
+	if err := e.validate(); err != nil {
+		return err
+	}
+	if err := d.checkStreamStarted(); err != nil {
+		return err
+	}
+	d.shared.encryption = &e
+	return nil
+}
+
+// SetSignature signs the output with a signature field on page, indexed
+// from zero. See Backend.SetSignature.
+func (d *Document) SetSignature(page int, s Signature) error {
+	if d.stream != nil {
+		return errors.New("pdf: a streaming document cannot be signed")
+	}
+	if page < 0 || page >= len(d.pages) {
+		return fmt.Errorf("pdf: page %d out of range [0, %d)", page, len(d.pages))
+	}
+	if err := s.validate(); err != nil {
+		return err
+	}
+	d.shared.signature = &signatureField{Signature: s, page: page}
+	return nil
+}
+
+// AttachFile embeds f in the document. See Backend.AttachFile.
+func (d *Document) AttachFile(f EmbeddedFile) error {
+	return d.shared.attachFile(f)
+}
+
+// NewLayer adds a layer for content on any page of the document. See
+// Backend.NewLayer.
+func (d *Document) NewLayer(name string, opts LayerOptions) (*Layer, error) {
+	return d.shared.newLayer(name, opts)
+}
+
+// AddRadioGroup makes the layers mutually exclusive. See
+// Backend.AddRadioGroup.
+func (d *Document) AddRadioGroup(layers ...*Layer) error {
+	return d.shared.addRadioGroup(layers)
+}
+
+// SetLanguage sets the natural language of the document as a BCP 47 tag,
+// such as "en-US". Screen readers use it to choose a pronunciation.
+func (d *Document) SetLanguage(lang string) {
+	d.meta.lang = lang
+}
+
+// BeginStructure opens a structure element for the content drawn on any
+// page until the matching EndStructure, which makes the document a tagged
+// PDF. Elements nest and may span pages: an element opened while another is
+// open becomes its child. Once the first element is opened, content drawn
+// outside any element is marked as an artifact.
+func (d *Document) BeginStructure(kind StructureType, attrs StructureAttributes) error {
+	return d.shared.tags.begin(kind, attrs)
+}
+
+// EndStructure closes the structure element opened last.
+func (d *Document) EndStructure() error {
+	return d.shared.tags.end()
+}
+
+// SetPDFUA marks the document as conforming to PDF/UA-1 (ISO 14289-1) for
+// accessibility. It combines with SetConformance. WriteTo then fails unless
+// the document has a title and a language, every page is drawn inside
+// structure elements or artifacts, every Figure has alternate text, and all
+// text uses registered fonts.
+func (d *Document) SetPDFUA(enabled bool) {
+	d.shared.pdfua = enabled
 }
 
 // SetTitle sets the document title metadata.
+// The title is written to the Info dictionary and to the XMP dc:title.
 func (d *Document) SetTitle(title string) {
-	d.creator.SetTitle(title)
+	d.meta.title = title
 }
 
 // SetAuthor sets the document author metadata.
+// The author is written to the Info dictionary and to the XMP dc:creator.
 func (d *Document) SetAuthor(author string) {
-	d.creator.SetAuthor(author)
+	d.meta.author = author
 }
 
 // SetSubject sets the document subject metadata.
+// The subject is written to the Info dictionary and to the XMP dc:description.
 func (d *Document) SetSubject(subject string) {
-	d.creator.SetSubject(subject)
+	d.meta.subject = subject
 }
 
 // SetKeywords sets the document keywords metadata.
+// The keywords are written to the Info dictionary and to the XMP
+// pdf:Keywords; comma- or semicolon-separated entries also populate dc:subject.
 func (d *Document) SetKeywords(keywords string) {
-	d.creator.SetKeywords(keywords)
+	d.meta.keywords = keywords
+}
+
+// SetCreator sets the name of the application that created the content.
+// It is written to the Info /Creator entry and to the XMP xmp:CreatorTool.
+func (d *Document) SetCreator(name string) {
+	d.meta.creator = name
+}
+
+// SetProducer overrides the name of the software that produced the PDF.
+// It is written to the Info /Producer entry and to the XMP pdf:Producer.
+func (d *Document) SetProducer(name string) {
+	d.meta.producer = name
+}
+
+// SetCreationDate sets the document creation date. It defaults to the time
+// NewDocument was called. The modification date follows the creation date
+// until SetModDate is called.
+func (d *Document) SetCreationDate(t time.Time) {
+	if d.meta.modified.Equal(d.meta.created) {
+		d.meta.modified = t
+	}
+	d.meta.created = t
+}
+
+// SetModDate sets the document modification date.
+func (d *Document) SetModDate(t time.Time) {
+	d.meta.modified = t
+}
+
+// RegisterXMPNamespace declares a custom XMP namespace so that properties can
+// be added to it with SetXMPProperty. The prefix must be a valid XML name and
+// must not collide with the namespaces the document writes itself.
+func (d *Document) RegisterXMPNamespace(prefix, uri string) error {
+	return d.meta.registerNamespace(prefix, uri)
+}
+
+// SetXMPProperty sets a simple text property in a namespace registered with
+// RegisterXMPNamespace. Setting the same property again replaces its value.
+func (d *Document) SetXMPProperty(namespaceURI, name, value string) error {
+	return d.meta.setProperty(namespaceURI, name, value)
 }
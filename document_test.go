@@ -6,7 +6,6 @@ import (
 	"strings"
 	"testing"
 
-	"github.com/coregx/gxpdf/creator"
 	"github.com/gogpu/gg/recording"
 )
 
@@ -19,23 +18,19 @@ func testRecording(width, height int) *recording.Recording {
 
 func TestDocumentPlaybackPreservesPageOwnership(t *testing.T) {
 	doc := NewDocument()
-	docCreator := doc.creator
 	backend := doc.NewPage(200, 100)
 	page := backend.(*pageBackend)
-	docPage := page.page
+	docBackend := page.Backend
 
 	if err := testRecording(200, 100).Playback(backend); err != nil {
 		t.Fatalf("Playback failed: %v", err)
 	}
 
-	if page.creator != docCreator {
-		t.Fatal("Playback replaced the document-owned creator")
-	}
-	if page.page != docPage {
+	if page.Backend != docBackend || page.doc != doc {
 		t.Fatal("Playback replaced the document-owned page")
 	}
-	if doc.creator.PageCount() != 1 {
-		t.Fatalf("document creator has %d pages, want 1", doc.creator.PageCount())
+	if doc.PageCount() != 1 {
+		t.Fatalf("PageCount = %d, want 1", doc.PageCount())
 	}
 }
 
@@ -65,9 +60,6 @@ func TestDocumentPlaybackTwoPages(t *testing.T) {
 	if doc.PageCount() != 2 {
 		t.Fatalf("PageCount = %d, want 2", doc.PageCount())
 	}
-	if doc.creator.PageCount() != 2 {
-		t.Fatalf("document creator has %d pages, want 2", doc.creator.PageCount())
-	}
 
 	var output bytes.Buffer
 	if _, err := doc.WriteTo(&output); err != nil {
@@ -116,19 +108,6 @@ func TestDocumentPageLifecycleValidation(t *testing.T) {
 		}
 	})
 
-	t.Run("wrong creator", func(t *testing.T) {
-		doc := NewDocument()
-		page := doc.NewPage(200, 100).(*pageBackend)
-		page.creator = NewDocument().creator
-
-		if err := page.Begin(200, 100); err == nil || !strings.Contains(err.Error(), "invalid creator") {
-			t.Fatalf("Begin error = %v, want a creator ownership error", err)
-		}
-		if err := page.End(); err == nil || !strings.Contains(err.Error(), "invalid creator") {
-			t.Fatalf("End error = %v, want a creator ownership error", err)
-		}
-	})
-
 	t.Run("finalized", func(t *testing.T) {
 		doc := NewDocument()
 		page := doc.NewPage(200, 100)
@@ -143,22 +122,17 @@ func TestDocumentPageLifecycleValidation(t *testing.T) {
 
 func TestDocumentPropagatesPageCreationFailure(t *testing.T) {
 	doc := NewDocument()
-	creationErr := errors.New("injected page creation failure")
-	doc.newPage = func(_, _ float64) (*creator.Page, error) {
-		return nil, creationErr
-	}
-
-	page := doc.NewPage(200, 100)
+	page := doc.NewPage(0, 100)
 	if got := doc.PageCount(); got != 1 {
 		t.Fatalf("PageCount = %d, want failed page to remain tracked", got)
 	}
-	if err := page.Begin(200, 100); !errors.Is(err, creationErr) {
-		t.Fatalf("Begin error = %v, want %v", err, creationErr)
+	if err := page.Begin(0, 100); err == nil || !strings.Contains(err.Error(), "width must be positive") {
+		t.Fatalf("Begin error = %v, want a page width error", err)
 	}
 
 	err := doc.Finish()
-	if !errors.Is(err, creationErr) {
-		t.Fatalf("Finish error = %v, want wrapped %v", err, creationErr)
+	if err == nil || !strings.Contains(err.Error(), "width must be positive") {
+		t.Fatalf("Finish error = %v, want the page width error", err)
 	}
 	if !strings.Contains(err.Error(), "failed to finish page") {
 		t.Fatalf("Finish error = %q, want page-finalization context", err)
@@ -195,7 +169,6 @@ func TestDocumentNewPageAfterFinishReturnsLifecycleError(t *testing.T) {
 		t.Fatalf("Finish failed: %v", err)
 	}
 	wantPages := doc.PageCount()
-	wantCreatorPages := doc.creator.PageCount()
 
 	latePage := doc.NewPage(100, 50)
 	if err := latePage.Begin(100, 50); err == nil || !strings.Contains(err.Error(), "finished document") {
@@ -207,9 +180,6 @@ func TestDocumentNewPageAfterFinishReturnsLifecycleError(t *testing.T) {
 	if doc.PageCount() != wantPages {
 		t.Fatalf("PageCount = %d after rejected NewPage, want %d", doc.PageCount(), wantPages)
 	}
-	if doc.creator.PageCount() != wantCreatorPages {
-		t.Fatalf("document creator has %d pages after rejected NewPage, want %d", doc.creator.PageCount(), wantCreatorPages)
-	}
 }
 
 func TestDocumentPlaybackAfterFinishReturnsLifecycleError(t *testing.T) {
@@ -225,7 +195,4 @@ func TestDocumentPlaybackAfterFinishReturnsLifecycleError(t *testing.T) {
 	if doc.PageCount() != 0 {
 		t.Fatalf("PageCount = %d after rejected Playback, want 0", doc.PageCount())
 	}
-	if doc.creator.PageCount() != 0 {
-		t.Fatalf("document creator has %d pages after rejected Playback, want 0", doc.creator.PageCount())
-	}
 }
@@ -0,0 +1,438 @@
+package pdf
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5" //nolint:gosec // MD5 is what the PDF specification uses for AES-128 keys
+	"crypto/rand"
+	"crypto/rc4" //nolint:gosec // RC4 is what the PDF specification uses for AES-128 passwords
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"unicode/utf8"
+)
+
+// EncryptionAlgorithm selects the cipher of an encrypted file.
+type EncryptionAlgorithm int
+
+const (
+	// EncryptAES256 encrypts with AES-256 and revision 6 of the standard
+	// security handler, defined by PDF 2.0 and supported by current
+	// viewers.
+	EncryptAES256 EncryptionAlgorithm = iota
+
+	// EncryptAES128 encrypts with AES-128 and revision 4 of the standard
+	// security handler, for viewers that predate PDF 2.0.
+	EncryptAES128
+)
+
+// Permission is a set of operations a reader who opens an encrypted file
+// with the user password may perform. Opening it with the owner password
+// allows everything.
+type Permission uint32
+
+// Permission flags, at their bit positions in the /P entry.
+const (
+	// PermitPrint allows printing, at low resolution unless
+	// PermitPrintHighQuality is also set.
+	PermitPrint Permission = 1 << 2
+
+	// PermitModify allows changing the document other than by the
+	// operations controlled by PermitAnnotate, PermitFillForms, and
+	// PermitAssemble.
+	PermitModify Permission = 1 << 3
+
+	// PermitCopy allows copying or extracting text and graphics.
+	PermitCopy Permission = 1 << 4
+
+	// PermitAnnotate allows adding or changing annotations and filling in
+	// form fields.
+	PermitAnnotate Permission = 1 << 5
+
+	// PermitFillForms allows filling in form fields even without
+	// PermitAnnotate.
+	PermitFillForms Permission = 1 << 8
+
+	// PermitAccessibility allows extracting text and graphics for
+	// accessibility.
+	PermitAccessibility Permission = 1 << 9
+
+	// PermitAssemble allows inserting, rotating, and deleting pages and
+	// adding bookmarks, even without PermitModify.
+	PermitAssemble Permission = 1 << 10
+
+	// PermitPrintHighQuality allows printing at full resolution.
+	PermitPrintHighQuality Permission = 1 << 11
+
+	// PermitAll allows every operation.
+	PermitAll = PermitPrint | PermitModify | PermitCopy | PermitAnnotate |
+		PermitFillForms | PermitAccessibility | PermitAssemble | PermitPrintHighQuality
+)
+
+// Encryption describes how the output is password-protected.
+type Encryption struct {
+	// UserPassword is required to open the file. If it is empty, anyone
+	// can open the file, but only with Permissions.
+	UserPassword string
+
+	// OwnerPassword opens the file with all permissions. If it is empty, a
+	// random password is used, so that the permissions cannot be lifted.
+	OwnerPassword string
+
+	// Algorithm is the cipher; the zero value is AES-256.
+	Algorithm EncryptionAlgorithm
+
+	// Permissions are the operations allowed with the user password.
+	Permissions Permission
+
+	// PlainMetadata leaves the XMP metadata stream unencrypted, so that
+	// search engines and asset managers can read it.
+	PlainMetadata bool
+}
+
+// validate checks the algorithm and that the passwords can be encoded.
+func (e Encryption) validate() error {
+	switch e.Algorithm {
+	case EncryptAES256:
+		for _, pw := range []string{e.UserPassword, e.OwnerPassword} {
+			if !utf8.ValidString(pw) {
+				return errors.New("pdf: password is not valid UTF-8")
+			}
+		}
+	case EncryptAES128:
+		for _, pw := range []string{e.UserPassword, e.OwnerPassword} {
+			if _, err := latin1Password(pw); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("pdf: unknown encryption algorithm %d", e.Algorithm)
+	}
+	if e.Permissions&^PermitAll != 0 {
+		return fmt.Errorf("pdf: unknown permission bits %#x", uint32(e.Permissions&^PermitAll))
+	}
+	return nil
+}
+
+// SetEncryption password-protects the output of WriteTo and SaveToFile.
+// Strings and streams are encrypted with a key derived from the passwords;
+// Permissions limit what readers who open the file with the user password
+// may do. PDF/A and PDF/X do not allow encryption.
+func (b *Backend) SetEncryption(e Encryption) error {
+	if err := e.validate(); err != nil {
+		return err
+	}
+	b.shared.encryption = &e
+	return nil
+}
+
+// passwordPadding pads and replaces passwords for the AES-128 handler.
+var passwordPadding = []byte{
+	0x28, 0xBF, 0x4E, 0x5E, 0x4E, 0x75, 0x8A, 0x41, 0x64, 0x00, 0x4E, 0x56, 0xFF, 0xFA, 0x01, 0x08,
+	0x2E, 0x2E, 0x00, 0xB6, 0xD0, 0x68, 0x3E, 0x80, 0x2F, 0x0C, 0xA9, 0xFE, 0x64, 0x53, 0x69, 0x7A,
+}
+
+// latin1Password encodes a password for the AES-128 handler, which takes
+// PDFDocEncoding passwords of up to 32 bytes.
+func latin1Password(pw string) ([]byte, error) {
+	out := make([]byte, 0, len(pw))
+	for _, r := range pw {
+		if r > 0xFF {
+			return nil, fmt.Errorf("pdf: AES-128 passwords cannot contain %q", r)
+		}
+		out = append(out, byte(r))
+	}
+	return out, nil
+}
+
+// padPassword pads or truncates a password to 32 bytes.
+func padPassword(pw []byte) []byte {
+	out := make([]byte, 0, 32)
+	out = append(out, pw[:min(len(pw), 32)]...)
+	return append(out, passwordPadding[:32-len(out)]...)
+}
+
+// encryptor encrypts the strings and streams of the objects of a file.
+type encryptor struct {
+	key           []byte
+	aes256        bool
+	plainMetadata bool
+
+	// dict is the encryption dictionary, which is not itself encrypted,
+	// and id the file identifier the key is bound to.
+	dict *pdfIndirect
+	id   pdfHexString
+}
+
+// newEncryptor derives the file key and the encryption dictionary.
+func newEncryptor(e Encryption) (*encryptor, error) {
+	if e.OwnerPassword == "" {
+		e.OwnerPassword = fmt.Sprintf("%x", randomBytes(16))
+	}
+	// Bits 7, 8, and 13 to 32 must be set.
+	p := int32(uint32(e.Permissions) | 0xFFFFF0C0) //nolint:gosec // reinterpreting the bits is intended
+	c := &encryptor{
+		aes256:        e.Algorithm == EncryptAES256,
+		plainMetadata: e.PlainMetadata,
+		id:            pdfHexString(randomBytes(16)),
+	}
+
+	var dict pdfDict
+	var err error
+	if c.aes256 {
+		dict = c.revision6(e, p)
+	} else if dict, err = c.revision4(e, p); err != nil {
+		return nil, err
+	}
+	dict["Filter"] = pdfName("Standard")
+	dict["P"] = int(p)
+	if e.PlainMetadata {
+		dict["EncryptMetadata"] = false
+	}
+	c.dict = newIndirect(dict)
+	return c, nil
+}
+
+// revision4 derives the key and dictionary of the AES-128 handler,
+// algorithms 2, 3, and 5 of ISO 32000-1.
+func (c *encryptor) revision4(e Encryption, p int32) (pdfDict, error) {
+	user, err := latin1Password(e.UserPassword)
+	if err != nil {
+		return nil, err
+	}
+	owner, err := latin1Password(e.OwnerPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	// The owner entry encrypts the user password with a key derived from
+	// the owner password.
+	h := md5Sum(padPassword(owner))
+	for range 50 {
+		h = md5Sum(h)
+	}
+	o := rc4Rounds(h, padPassword(user))
+
+	// The file key hashes the user password with the owner entry, the
+	// permissions, and the file identifier.
+	var buf bytes.Buffer
+	buf.Write(padPassword(user))
+	buf.Write(o)
+	_ = binary.Write(&buf, binary.LittleEndian, p)
+	buf.WriteString(string(c.id))
+	if e.PlainMetadata {
+		buf.Write([]byte{0xFF, 0xFF, 0xFF, 0xFF})
+	}
+	c.key = md5Sum(buf.Bytes())
+	for range 50 {
+		c.key = md5Sum(c.key)
+	}
+
+	u := rc4Rounds(c.key, md5Sum(append(slices.Clone(passwordPadding), c.id...)))
+	u = append(u, make([]byte, 16)...)
+
+	filter := pdfDict{
+		"CFM":       pdfName("AESV2"),
+		"AuthEvent": pdfName("DocOpen"),
+		"Length":    16,
+	}
+	return pdfDict{
+		"V":      4,
+		"R":      4,
+		"Length": 128,
+		"CF":     pdfDict{"StdCF": filter},
+		"StmF":   pdfName("StdCF"),
+		"StrF":   pdfName("StdCF"),
+		"O":      pdfHexString(o),
+		"U":      pdfHexString(u),
+	}, nil
+}
+
+// revision6 derives the key and dictionary of the AES-256 handler,
+// algorithms 8 to 10 of ISO 32000-2.
+func (c *encryptor) revision6(e Encryption, p int32) pdfDict {
+	c.key = randomBytes(32)
+	user := passwordUTF8(e.UserPassword)
+	owner := passwordUTF8(e.OwnerPassword)
+
+	salts := randomBytes(16)
+	u := append(hashR6(user, salts[:8], nil), salts...)
+	ue := aesNoPadding(hashR6(user, salts[8:], nil), c.key)
+
+	salts = randomBytes(16)
+	o := append(hashR6(owner, salts[:8], u), salts...)
+	oe := aesNoPadding(hashR6(owner, salts[8:], u), c.key)
+
+	perms := make([]byte, 16)
+	binary.LittleEndian.PutUint32(perms, uint32(p)) //nolint:gosec // reinterpreting the bits is intended
+	copy(perms[4:], []byte{0xFF, 0xFF, 0xFF, 0xFF, 'T', 'a', 'd', 'b'})
+	if e.PlainMetadata {
+		perms[8] = 'F'
+	}
+	copy(perms[12:], randomBytes(4))
+	block, _ := aes.NewCipher(c.key)
+	block.Encrypt(perms, perms)
+
+	filter := pdfDict{
+		"CFM":       pdfName("AESV3"),
+		"AuthEvent": pdfName("DocOpen"),
+		"Length":    32,
+	}
+	return pdfDict{
+		"V":      5,
+		"R":      6,
+		"Length": 256,
+		"CF":     pdfDict{"StdCF": filter},
+		"StmF":   pdfName("StdCF"),
+		"StrF":   pdfName("StdCF"),
+		"O":      pdfHexString(o),
+		"U":      pdfHexString(u),
+		"OE":     pdfHexString(oe),
+		"UE":     pdfHexString(ue),
+		"Perms":  pdfHexString(perms),
+	}
+}
+
+// passwordUTF8 truncates a password to the 127 bytes the AES-256 handler
+// uses, at a character boundary.
+func passwordUTF8(pw string) []byte {
+	for len(pw) > 127 {
+		_, size := utf8.DecodeLastRuneInString(pw)
+		pw = pw[:len(pw)-size]
+	}
+	return []byte(pw)
+}
+
+// hashR6 is the password hash of the AES-256 handler, algorithm 2.B of
+// ISO 32000-2. udata is the user entry when hashing the owner password.
+func hashR6(password, salt, udata []byte) []byte {
+	sum := sha256.Sum256(bytes.Join([][]byte{password, salt, udata}, nil))
+	k := sum[:]
+	for round := 0; ; round++ {
+		k1 := bytes.Repeat(bytes.Join([][]byte{password, k, udata}, nil), 64)
+		block, _ := aes.NewCipher(k[:16])
+		e := make([]byte, len(k1))
+		cipher.NewCBCEncrypter(block, k[16:32]).CryptBlocks(e, k1)
+
+		mod := 0
+		for _, b := range e[:16] {
+			mod += int(b)
+		}
+		switch mod % 3 {
+		case 0:
+			s := sha256.Sum256(e)
+			k = s[:]
+		case 1:
+			s := sha512.Sum384(e)
+			k = s[:]
+		default:
+			s := sha512.Sum512(e)
+			k = s[:]
+		}
+		if round >= 63 && int(e[len(e)-1]) <= round-31 {
+			return k[:32]
+		}
+	}
+}
+
+// aesNoPadding encrypts data, a multiple of the block size, with AES-256 in
+// CBC mode with a zero IV, as the key entries of the AES-256 handler are.
+func aesNoPadding(key, data []byte) []byte {
+	block, _ := aes.NewCipher(key)
+	out := make([]byte, len(data))
+	cipher.NewCBCEncrypter(block, make([]byte, aes.BlockSize)).CryptBlocks(out, data)
+	return out
+}
+
+// rc4Rounds encrypts data with RC4 under key, then 19 more times under the
+// key with each byte XORed with the round number.
+func rc4Rounds(key, data []byte) []byte {
+	out := slices.Clone(data)
+	round := make([]byte, len(key))
+	for i := range 20 {
+		for j := range key {
+			round[j] = key[j] ^ byte(i)
+		}
+		rc, _ := rc4.NewCipher(round) //nolint:gosec // see import comment
+		rc.XORKeyStream(out, out)
+	}
+	return out
+}
+
+func md5Sum(data []byte) []byte {
+	sum := md5.Sum(data) //nolint:gosec // see import comment
+	return sum[:]
+}
+
+// randomBytes returns n bytes from the system's secure random source.
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return b
+}
+
+// objectKey returns the key for the strings and streams of object num.
+func (c *encryptor) objectKey(num int) []byte {
+	if c.aes256 {
+		return c.key
+	}
+	buf := append(slices.Clone(c.key), byte(num), byte(num>>8), byte(num>>16), 0, 0)
+	buf = append(buf, "sAlT"...)
+	return md5Sum(buf)
+}
+
+// encrypt encrypts data with AES in CBC mode under a random IV, which is
+// prepended, and with PKCS #7 padding.
+func (c *encryptor) encrypt(key, data []byte) []byte {
+	block, _ := aes.NewCipher(key)
+	pad := aes.BlockSize - len(data)%aes.BlockSize
+	out := make([]byte, aes.BlockSize+len(data)+pad)
+	copy(out, randomBytes(aes.BlockSize))
+	copy(out[aes.BlockSize:], data)
+	for i := len(out) - pad; i < len(out); i++ {
+		out[i] = byte(pad)
+	}
+	cipher.NewCBCEncrypter(block, out[:aes.BlockSize]).CryptBlocks(out[aes.BlockSize:], out[aes.BlockSize:])
+	return out
+}
+
+// object returns the value of object num with its strings and stream data
+// encrypted. Referenced objects are encrypted when they are written.
+func (c *encryptor) object(num int, value pdfObject) pdfObject {
+	key := c.objectKey(num)
+	if stream, ok := value.(*pdfStream); ok {
+		data := stream.Data
+		if !c.plainMetadata || stream.Dict["Type"] != pdfName("Metadata") {
+			data = c.encrypt(key, data)
+		}
+		return &pdfStream{Dict: c.value(key, stream.Dict).(pdfDict), Data: data}
+	}
+	return c.value(key, value)
+}
+
+// value copies v with its strings encrypted under key.
+func (c *encryptor) value(key []byte, v pdfObject) pdfObject {
+	switch v := v.(type) {
+	case pdfString:
+		return pdfHexString(c.encrypt(key, []byte(v)))
+	case pdfHexString:
+		return pdfHexString(c.encrypt(key, []byte(v)))
+	case pdfArray:
+		out := make(pdfArray, len(v))
+		for i, item := range v {
+			out[i] = c.value(key, item)
+		}
+		return out
+	case pdfDict:
+		out := make(pdfDict, len(v))
+		for k, item := range v {
+			out[k] = c.value(key, item)
+		}
+		return out
+	}
+	return v
+}
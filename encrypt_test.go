@@ -0,0 +1,315 @@
+package pdf
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5" //nolint:gosec // the specification's algorithms
+	"crypto/rc4" //nolint:gosec // the specification's algorithms
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/gogpu/gg"
+	"github.com/gogpu/gg/recording"
+)
+
+// referenceDecryptor decrypts a written file independently of the
+// encryptor, following the standard security handler of ISO 32000-2.
+type referenceDecryptor struct {
+	r      *pdfReader
+	key    []byte
+	aes256 bool
+	owner  bool
+}
+
+// openEncrypted authenticates password against the file and returns a
+// decryptor, or false if the password is wrong.
+func openEncrypted(t *testing.T, data []byte, password string) (*referenceDecryptor, bool) {
+	t.Helper()
+
+	r, _ := readOutput(t, data)
+	enc, err := r.resolveDict(r.trailer["Encrypt"])
+	if err != nil || enc == nil {
+		t.Fatalf("trailer has no /Encrypt: %v", err)
+	}
+	str := func(key pdfName) []byte { s, _ := enc[key].(pdfString); return []byte(s) }
+	o, u := str("O"), str("U")
+	d := &referenceDecryptor{r: r, aes256: enc["R"] == 6}
+
+	if d.aes256 {
+		pw := []byte(password)
+		unwrap := func(key, data []byte) []byte {
+			block, _ := aes.NewCipher(key)
+			out := make([]byte, len(data))
+			cipher.NewCBCDecrypter(block, make([]byte, 16)).CryptBlocks(out, data)
+			return out
+		}
+		switch {
+		case bytes.Equal(referenceHash(pw, o[32:40], u[:48]), o[:32]):
+			d.key, d.owner = unwrap(referenceHash(pw, o[40:48], u[:48]), str("OE")), true
+		case bytes.Equal(referenceHash(pw, u[32:40], nil), u[:32]):
+			d.key = unwrap(referenceHash(pw, u[40:48], nil), str("UE"))
+		default:
+			return nil, false
+		}
+		perms := make([]byte, 16)
+		block, _ := aes.NewCipher(d.key)
+		block.Decrypt(perms, str("Perms"))
+		p, _ := enc["P"].(int)
+		if string(perms[9:12]) != "adb" || int32(binary.LittleEndian.Uint32(perms)) != int32(p) {
+			t.Fatalf("/Perms does not match /P")
+		}
+		return d, true
+	}
+
+	id, _ := r.trailer["ID"].(pdfArray)
+	id0, _ := id[0].(pdfString)
+	pad := func(pw []byte) []byte {
+		return append(pw[:min(len(pw), 32):min(len(pw), 32)], passwordPadding[:32-min(len(pw), 32)]...)
+	}
+	rounds := func(key, data []byte, decrypt bool) []byte {
+		out := append([]byte(nil), data...)
+		for i := range 20 {
+			if decrypt {
+				i = 19 - i
+			}
+			k := make([]byte, len(key))
+			for j := range key {
+				k[j] = key[j] ^ byte(i)
+			}
+			c, _ := rc4.NewCipher(k) //nolint:gosec // see import comment
+			c.XORKeyStream(out, out)
+		}
+		return out
+	}
+	fileKey := func(user []byte) []byte {
+		p, _ := enc["P"].(int)
+		buf := append(pad(user), o...)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(int32(p)))
+		buf = append(buf, id0...)
+		if enc["EncryptMetadata"] == false {
+			buf = append(buf, 0xFF, 0xFF, 0xFF, 0xFF)
+		}
+		sum := md5.Sum(buf) //nolint:gosec // see import comment
+		for range 50 {
+			sum = md5.Sum(sum[:16]) //nolint:gosec // see import comment
+		}
+		return sum[:16]
+	}
+	checkUser := func(key []byte) bool {
+		sum := md5.Sum(append(append([]byte(nil), passwordPadding...), id0...)) //nolint:gosec // see import comment
+		return bytes.Equal(rounds(key, sum[:], false), u[:16])
+	}
+
+	if key := fileKey([]byte(password)); checkUser(key) {
+		d.key = key
+		return d, true
+	}
+	sum := md5.Sum(pad([]byte(password))) //nolint:gosec // see import comment
+	for range 50 {
+		sum = md5.Sum(sum[:]) //nolint:gosec // see import comment
+	}
+	if key := fileKey(rounds(sum[:], o, true)); checkUser(key) {
+		d.key, d.owner = key, true
+		return d, true
+	}
+	return nil, false
+}
+
+// referenceHash is algorithm 2.B of ISO 32000-2.
+func referenceHash(password, salt, udata []byte) []byte {
+	h := sha256.Sum256(append(append(append([]byte(nil), password...), salt...), udata...))
+	k := h[:]
+	for i := 1; ; i++ {
+		seq := append(append(append([]byte(nil), password...), k...), udata...)
+		k1 := bytes.Repeat(seq, 64)
+		block, _ := aes.NewCipher(k[:16])
+		e := make([]byte, len(k1))
+		cipher.NewCBCEncrypter(block, k[16:32]).CryptBlocks(e, k1)
+		var sum int
+		for _, b := range e[:16] {
+			sum += int(b)
+		}
+		switch sum % 3 {
+		case 0:
+			s := sha256.Sum256(e)
+			k = s[:]
+		case 1:
+			s := sha512.Sum384(e)
+			k = s[:]
+		case 2:
+			s := sha512.Sum512(e)
+			k = s[:]
+		}
+		if i >= 64 && int(e[len(e)-1]) <= i-32 {
+			return k[:32]
+		}
+	}
+}
+
+// decrypt decrypts a string or stream of object num.
+func (d *referenceDecryptor) decrypt(t *testing.T, num int, data []byte) []byte {
+	t.Helper()
+
+	key := d.key
+	if !d.aes256 {
+		buf := append(append([]byte(nil), d.key...), byte(num), byte(num>>8), byte(num>>16), 0, 0)
+		sum := md5.Sum(append(buf, "sAlT"...)) //nolint:gosec // see import comment
+		key = sum[:]
+	}
+	if len(data) < 32 || len(data)%16 != 0 {
+		t.Fatalf("encrypted data of object %d has %d bytes", num, len(data))
+	}
+	block, _ := aes.NewCipher(key)
+	out := make([]byte, len(data)-16)
+	cipher.NewCBCDecrypter(block, data[:16]).CryptBlocks(out, data[16:])
+	pad := int(out[len(out)-1])
+	if pad < 1 || pad > 16 {
+		t.Fatalf("object %d has invalid padding", num)
+	}
+	return out[:len(out)-pad]
+}
+
+// stream decrypts and decodes the stream ref points to.
+func (d *referenceDecryptor) stream(t *testing.T, ref pdfObject) []byte {
+	t.Helper()
+
+	num, _ := ref.(pdfRef)
+	obj, _ := d.r.resolve(ref)
+	stream, ok := obj.(*pdfStream)
+	if !ok {
+		t.Fatalf("%v is not a stream", ref)
+	}
+	plain := &pdfStream{Dict: stream.Dict, Data: d.decrypt(t, num.Num, stream.Data)}
+	data, err := decodeStream(plain)
+	if err != nil {
+		t.Fatalf("failed to decode decrypted stream: %v", err)
+	}
+	return data
+}
+
+func encryptedDocument(t *testing.T, e Encryption) []byte {
+	t.Helper()
+
+	doc := NewDocument()
+	doc.SetTitle("Payroll ✓")
+	if err := doc.SetEncryption(e); err != nil {
+		t.Fatalf("SetEncryption failed: %v", err)
+	}
+	page := doc.NewPage(100, 100)
+	page.FillRect(recording.NewRect(10, 10, 20, 20), recording.NewSolidBrush(gg.Red))
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		alg  EncryptionAlgorithm
+		r    int
+	}{
+		{"AES-256", EncryptAES256, 6},
+		{"AES-128", EncryptAES128, 4},
+	} {
+		for _, plain := range []bool{false, true} {
+			name := tt.name
+			if plain {
+				name += " plain metadata"
+			}
+			t.Run(name, func(t *testing.T) {
+				data := encryptedDocument(t, Encryption{
+					UserPassword:  "reader",
+					OwnerPassword: "payroll",
+					Algorithm:     tt.alg,
+					Permissions:   PermitPrint | PermitAccessibility,
+					PlainMetadata: plain,
+				})
+				if _, ok := openEncrypted(t, data, "wrong"); ok {
+					t.Fatal("wrong password authenticated")
+				}
+				if d, ok := openEncrypted(t, data, "payroll"); !ok || !d.owner {
+					t.Fatal("owner password did not authenticate as the owner")
+				}
+				d, ok := openEncrypted(t, data, "reader")
+				if !ok || d.owner {
+					t.Fatal("user password did not authenticate as the user")
+				}
+
+				enc, _ := d.r.resolveDict(d.r.trailer["Encrypt"])
+				p, _ := enc["P"].(int)
+				if enc["R"] != tt.r || Permission(uint32(int32(p)))&PermitAll != PermitPrint|PermitAccessibility {
+					t.Errorf("/Encrypt = %v, want revision %d with print and accessibility", enc, tt.r)
+				}
+
+				catalog, _ := d.r.resolveDict(d.r.trailer["Root"])
+				pagesRoot, _ := d.r.resolveDict(catalog["Pages"])
+				kids, _ := pagesRoot["Kids"].(pdfArray)
+				page, _ := d.r.resolveDict(kids[0])
+				if content := string(d.stream(t, page["Contents"])); !strings.Contains(content, "10 10 20 20 re\nf\n") {
+					t.Errorf("decrypted content = %q, want the rectangle", content)
+				}
+
+				infoRef, _ := d.r.trailer["Info"].(pdfRef)
+				info, _ := d.r.resolveDict(infoRef)
+				title, _ := info["Title"].(pdfString)
+				if got := d.decrypt(t, infoRef.Num, []byte(title)); string(got) != string(textString("Payroll ✓")) {
+					t.Errorf("decrypted title = %q", got)
+				}
+				if bytes.Contains(data, []byte(textString("Payroll ✓"))) {
+					t.Error("title appears in the file unencrypted")
+				}
+
+				obj, _ := d.r.resolve(catalog["Metadata"])
+				xmp, _ := obj.(*pdfStream)
+				if plain != bytes.Contains(xmp.Data, []byte("<x:xmpmeta")) {
+					t.Errorf("metadata readable = %v, want %v", !plain, plain)
+				}
+				if !plain {
+					if got := d.stream(t, catalog["Metadata"]); !bytes.Contains(got, []byte("Payroll")) {
+						t.Error("decrypted metadata does not hold the title")
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestEncryptionWithoutOwnerPassword(t *testing.T) {
+	data := encryptedDocument(t, Encryption{Permissions: PermitPrint})
+	d, ok := openEncrypted(t, data, "")
+	if !ok || d.owner {
+		t.Fatal("empty user password did not open the file as the user")
+	}
+	r, catalog := readOutput(t, data)
+	if ext, _ := r.resolveDict(catalog["Extensions"]); ext["ADBE"] == nil {
+		t.Errorf("AES-256 file has no Adobe extension level: %v", catalog)
+	}
+}
+
+func TestEncryptionErrors(t *testing.T) {
+	doc := NewDocument()
+	if err := doc.SetEncryption(Encryption{Algorithm: EncryptionAlgorithm(9)}); err == nil {
+		t.Error("SetEncryption succeeded with an unknown algorithm")
+	}
+	if err := doc.SetEncryption(Encryption{Algorithm: EncryptAES128, UserPassword: "пароль"}); err == nil {
+		t.Error("SetEncryption succeeded with an AES-128 password outside Latin-1")
+	}
+	if err := doc.SetEncryption(Encryption{Permissions: 1 << 30}); err == nil {
+		t.Error("SetEncryption succeeded with unknown permission bits")
+	}
+
+	pdfa := newPDFADocument(t, ConformancePDFA2B)
+	if err := pdfa.SetEncryption(Encryption{UserPassword: "x"}); err != nil {
+		t.Fatalf("SetEncryption failed: %v", err)
+	}
+	pdfa.NewPage(10, 10)
+	if _, err := pdfa.WriteTo(&bytes.Buffer{}); err == nil {
+		t.Error("encrypted PDF/A document was written")
+	}
+}
@@ -0,0 +1,467 @@
+package pdf
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/gogpu/gg"
+	"github.com/gogpu/gg/recording"
+	"github.com/gogpu/gg/text"
+)
+
+// FieldKind is the type of an interactive form field.
+type FieldKind int
+
+const (
+	// FieldText is a box the user types text into.
+	FieldText FieldKind = iota
+
+	// FieldCheckbox is a box that is either checked or not.
+	FieldCheckbox
+
+	// FieldRadio is a button of a group of which one can be selected.
+	FieldRadio
+
+	// FieldChoice selects one of a list of options, from a drop-down or a
+	// list box.
+	FieldChoice
+)
+
+// Field flags, at their bit positions in the /Ff entry.
+const (
+	fieldReadOnly      = 1 << 0
+	fieldRequired      = 1 << 1
+	fieldMultiline     = 1 << 12
+	fieldNoToggleToOff = 1 << 14
+	fieldRadio         = 1 << 15
+	fieldCombo         = 1 << 17
+)
+
+// Field is an interactive form field placed on a page with AddField. The
+// page's own drawing provides the labels and boxes; the field adds the
+// interactive area and the appearance of its value.
+type Field struct {
+	Kind FieldKind
+
+	// Name identifies the field in the filled-in data. Radio buttons with
+	// the same name form a group; other fields must have unique names.
+	Name string
+
+	// Rect is the area of the field in gg coordinates.
+	Rect recording.Rect
+
+	// Value is the initial and default text of a text field and the
+	// selected option of a choice field. For a checkbox or radio button it
+	// is the value the field has when on: empty uses "Yes" for checkboxes,
+	// and each radio button of a group needs a value of its own.
+	Value string
+
+	// Checked turns a checkbox or radio button on initially and by
+	// default.
+	Checked bool
+
+	// Options are the choices of a choice field.
+	Options []string
+
+	// Multiline lets a text field hold several lines. List shows a choice
+	// field as a list box instead of a drop-down.
+	Multiline, List bool
+
+	// Face selects the registered font and the size of the text of text
+	// and choice fields, as for DrawText. Without it, fields use 12 point
+	// Helvetica, or the first registered font.
+	Face text.Face
+
+	// Tooltip describes the field to users and assistive technology.
+	Tooltip string
+
+	// Required fields must be filled in before the form is submitted;
+	// ReadOnly fields cannot be changed.
+	Required, ReadOnly bool
+}
+
+// formField is a field together with the page it is placed on.
+type formField struct {
+	Field
+	page *Backend
+}
+
+// AddField places an interactive form field on the page:
+//
+//	_ = b.AddField(pdf.Field{
+//		Kind:     pdf.FieldText,
+//		Name:     "email",
+//		Rect:     recording.NewRect(120, 80, 200, 20),
+//		Required: true,
+//	})
+//
+// Appearances are generated for the field's value, so the form displays
+// correctly in any viewer; the /AcroForm dictionary collecting the fields
+// of all pages is written with the file.
+func (b *Backend) AddField(f Field) error {
+	if err := b.validateField(f); err != nil {
+		return err
+	}
+	b.shared.fields = append(b.shared.fields, &formField{Field: f, page: b})
+	return nil
+}
+
+// validateField checks f and its name against the fields already added.
+func (b *Backend) validateField(f Field) error {
+	if f.Name == "" || strings.Contains(f.Name, ".") {
+		return fmt.Errorf("pdf: field name %q is empty or contains a period", f.Name)
+	}
+	if f.Rect.IsEmpty() || !rectContains(recording.NewRect(0, 0, b.width, b.height), f.Rect) {
+		return fmt.Errorf("pdf: field %q rectangle %v is empty or outside the page", f.Name, f.Rect)
+	}
+	switch f.Kind {
+	case FieldText, FieldCheckbox:
+	case FieldRadio:
+		if f.Value == "" {
+			return fmt.Errorf("pdf: radio button of %q has no value", f.Name)
+		}
+	case FieldChoice:
+		if len(f.Options) == 0 {
+			return fmt.Errorf("pdf: choice field %q has no options", f.Name)
+		}
+		if f.Value != "" && !slices.Contains(f.Options, f.Value) {
+			return fmt.Errorf("pdf: value %q of choice field %q is not an option", f.Value, f.Name)
+		}
+	default:
+		return fmt.Errorf("pdf: unknown field kind %d", f.Kind)
+	}
+	if (f.Kind == FieldText || f.Kind == FieldChoice) && b.shared.fontFor(f.Face) == nil &&
+		(b.shared.conformance.requiresEmbeddedFonts() || b.shared.pdfua) {
+		return fmt.Errorf("%w (field %q)", errNoEmbeddedFont, f.Name)
+	}
+
+	for _, other := range b.shared.fields {
+		if other.Name != f.Name {
+			continue
+		}
+		if f.Kind != FieldRadio || other.Kind != FieldRadio {
+			return fmt.Errorf("pdf: duplicate field name %q", f.Name)
+		}
+		if other.Value == f.Value {
+			return fmt.Errorf("pdf: radio group %q has two buttons with value %q", f.Name, f.Value)
+		}
+		if other.Checked && f.Checked {
+			return fmt.Errorf("pdf: radio group %q has more than one button checked", f.Name)
+		}
+	}
+	return nil
+}
+
+// writeFields adds the fields to their pages and the interactive form
+// dictionary to the catalog. Appearance streams share the form's default
+// resources.
+func writeFields(out *outputFile, pages []*Backend, shared *sharedResources) {
+	targets := make(map[*Backend]*pdfIndirect, len(pages))
+	for i, b := range pages {
+		targets[b] = out.pages[i]
+	}
+	res := newResourceSet()
+	var appearances []*pdfStream
+	appearance := func(f *formField, draw func(c *contentStream)) *pdfStream {
+		c := &contentStream{res: res, shared: shared}
+		if draw != nil {
+			draw(c)
+		}
+		s := flateStream(pdfDict{
+			"Type":    pdfName("XObject"),
+			"Subtype": pdfName("Form"),
+			"BBox":    rectArray(0, 0, f.Rect.Width(), f.Rect.Height()),
+		}, c.buf.Bytes())
+		appearances = append(appearances, s)
+		return s
+	}
+
+	var fields pdfArray
+	radios := make(map[string]*pdfIndirect)
+	for _, f := range shared.fields {
+		page, ok := targets[f.page]
+		if !ok {
+			continue
+		}
+		widget := pdfDict{
+			"Type":    pdfName("Annot"),
+			"Subtype": pdfName("Widget"),
+			"Rect":    pageRect(f.Rect, f.page.height),
+			"P":       page,
+			"F":       4, // print
+		}
+		flags := 0
+		if f.ReadOnly {
+			flags |= fieldReadOnly
+		}
+		if f.Required {
+			flags |= fieldRequired
+		}
+
+		// Fields other than radio buttons are merged with their widget.
+		field := widget
+		switch f.Kind {
+		case FieldText, FieldChoice:
+			font := newFieldFont(res, shared, f.Face)
+			field["DA"] = font.defaultAppearance()
+			font.allowTyping(f)
+			value := textString(f.Value)
+			field["V"], field["DV"] = value, value
+			if f.Kind == FieldText {
+				field["FT"] = pdfName("Tx")
+				if f.Multiline {
+					flags |= fieldMultiline
+				}
+				field["AP"] = pdfDict{"N": appearance(f, func(c *contentStream) {
+					font.textAppearance(c, f.Rect, f.Value, f.Multiline)
+				})}
+				break
+			}
+			field["FT"] = pdfName("Ch")
+			opts := make(pdfArray, len(f.Options))
+			for i, o := range f.Options {
+				opts[i] = textString(o)
+			}
+			field["Opt"] = opts
+			if !f.List {
+				flags |= fieldCombo
+			}
+			field["AP"] = pdfDict{"N": appearance(f, func(c *contentStream) {
+				if f.List {
+					font.listAppearance(c, f.Rect, f.Options, f.Value)
+				} else {
+					font.textAppearance(c, f.Rect, f.Value, false)
+				}
+			})}
+
+		case FieldCheckbox, FieldRadio:
+			on := pdfName(f.Value)
+			if on == "" {
+				on = "Yes"
+			}
+			state := pdfName("Off")
+			if f.Checked {
+				state = on
+			}
+			caption := pdfString("4") // ZapfDingbats check mark
+			draw := checkAppearance
+			if f.Kind == FieldRadio {
+				caption, draw = "l", radioAppearance // ZapfDingbats dot
+			}
+			widget["AP"] = pdfDict{"N": pdfDict{
+				on:    appearance(f, func(c *contentStream) { draw(c, f.Rect) }),
+				"Off": appearance(f, nil),
+			}}
+			widget["AS"] = state
+			widget["MK"] = pdfDict{"CA": caption}
+
+			if f.Kind == FieldCheckbox {
+				field["FT"] = pdfName("Btn")
+				field["V"], field["DV"] = state, state
+				break
+			}
+			// Radio buttons are the widgets of one field per group.
+			parent, ok := radios[f.Name]
+			if !ok {
+				parent = newIndirect(pdfDict{
+					"FT": pdfName("Btn"),
+					"T":  textString(f.Name),
+					"Ff": fieldRadio | fieldNoToggleToOff,
+					"V":  pdfName("Off"),
+				})
+				radios[f.Name] = parent
+				fields = append(fields, parent)
+			}
+			dict := indirectDict(parent)
+			ff, _ := dict["Ff"].(int)
+			dict["Ff"] = ff | flags
+			if f.Checked {
+				dict["V"], dict["DV"] = on, on
+			}
+			if f.Tooltip != "" {
+				dict["TU"] = textString(f.Tooltip)
+			}
+			widget["Parent"] = parent
+			kid := newIndirect(widget)
+			kids, _ := dict["Kids"].(pdfArray)
+			dict["Kids"] = append(kids, kid)
+			addAnnotation(page, kid)
+			continue
+		}
+
+		field["T"] = textString(f.Name)
+		if flags != 0 {
+			field["Ff"] = flags
+		}
+		if f.Tooltip != "" {
+			field["TU"] = textString(f.Tooltip)
+		}
+		ind := newIndirect(field)
+		fields = append(fields, ind)
+		addAnnotation(page, ind)
+	}
+
+	// Viewers look up the fonts for text and for the check mark and dot
+	// captions under these names when they draw a field anew.
+	dr := res.dict()
+	fonts, _ := dr["Font"].(pdfDict)
+	if fonts == nil {
+		fonts = pdfDict{}
+		dr["Font"] = fonts
+	}
+	helvetica := shared.standardFont
+	if helvetica == nil {
+		helvetica = newIndirect(standardFontDict())
+	}
+	fonts["Helv"] = helvetica
+	fonts["ZaDb"] = newIndirect(pdfDict{
+		"Type":     pdfName("Font"),
+		"Subtype":  pdfName("Type1"),
+		"BaseFont": pdfName("ZapfDingbats"),
+	})
+	for _, s := range appearances {
+		s.Dict["Resources"] = dr
+	}
+	out.catalog["AcroForm"] = pdfDict{
+		"Fields": fields,
+		"DR":     dr,
+	}
+}
+
+// addAnnotation appends annot to the annotations of page.
+func addAnnotation(page *pdfIndirect, annot *pdfIndirect) {
+	dict := indirectDict(page)
+	annots, _ := dict["Annots"].(pdfArray)
+	dict["Annots"] = append(annots, annot)
+}
+
+// fieldFont is the font of the text in a text or choice field, a free-text
+// annotation, or a stamp.
+type fieldFont struct {
+	name pdfName       // in the form's default resources
+	font *embeddedFont // nil for the standard font
+	size float64
+}
+
+// newFieldFont adds the font for face to res.
+func newFieldFont(res *resourceSet, shared *sharedResources, face text.Face) fieldFont {
+	f := fieldFont{font: shared.fontFor(face), size: faceSize(face)}
+	if f.font != nil {
+		font := f.font
+		f.name = res.add("Font", "F", font, func() pdfObject { return font.obj })
+	} else {
+		helvetica := shared.helvetica()
+		f.name = res.add("Font", "F", standardFontName, func() pdfObject { return helvetica })
+	}
+	return f
+}
+
+// allowTyping makes the glyphs f may show in a viewer available: every
+// glyph for an editable text field, whose text the user types, and those of
+// the options of a choice field.
+func (f fieldFont) allowTyping(field *formField) {
+	if f.font == nil {
+		return
+	}
+	switch {
+	case field.Kind == FieldText && !field.ReadOnly:
+		f.font.mu.Lock()
+		f.font.complete = true
+		f.font.mu.Unlock()
+	case field.Kind == FieldChoice:
+		for _, o := range field.Options {
+			f.font.encode(o)
+		}
+	}
+}
+
+// defaultAppearance returns the /DA string viewers use for typed text.
+func (f fieldFont) defaultAppearance() pdfString {
+	return pdfString(fmt.Sprintf("%s %s Tf 0 g", appendName(nil, f.name), formatNumber(f.size)))
+}
+
+// show writes a line of text at x, y.
+func (f fieldFont) show(c *contentStream, x, y float64, s string) {
+	c.op("BT")
+	c.op("Tf", f.name, f.size)
+	c.fillColor(gg.Black)
+	c.op("Td", x, y)
+	if f.font != nil {
+		encoded, _, _ := f.font.encode(s)
+		c.op("Tj", encoded)
+	} else {
+		c.op("Tj", encodeWinAnsi(s))
+	}
+	c.op("ET")
+}
+
+// textAppearance draws the value of a text field, or of a drop-down, in a
+// box of the size of r: one line centered vertically, or lines from the top.
+func (f fieldFont) textAppearance(c *contentStream, r recording.Rect, value string, multiline bool) {
+	const padding = 2.0
+	w, h := r.Width(), r.Height()
+	c.op("BMC", pdfName("Tx"))
+	c.op("q")
+	c.op("re", padding/2, padding/2, w-padding, h-padding)
+	c.op("W")
+	c.op("n")
+	if multiline {
+		for i, line := range strings.Split(value, "\n") {
+			f.show(c, padding, h-padding-f.size*(0.8+1.15*float64(i)), line)
+		}
+	} else {
+		f.show(c, padding, (h-f.size)/2+0.22*f.size, value)
+	}
+	c.op("Q")
+	c.op("EMC")
+}
+
+// listAppearance draws the options of a list box with the selected one
+// highlighted.
+func (f fieldFont) listAppearance(c *contentStream, r recording.Rect, options []string, value string) {
+	const padding = 2.0
+	w, h := r.Width(), r.Height()
+	line := f.size * 1.15
+	c.op("BMC", pdfName("Tx"))
+	c.op("q")
+	c.op("re", padding/2, padding/2, w-padding, h-padding)
+	c.op("W")
+	c.op("n")
+	for i, option := range options {
+		top := h - padding/2 - line*float64(i)
+		if option == value {
+			c.fillColor(gg.RGBA{R: 0.6, G: 0.75, B: 0.85, A: 1})
+			c.op("re", padding/2, top-line, w-padding, line)
+			c.op("f")
+		}
+		f.show(c, padding, top-0.9*f.size, option)
+	}
+	c.op("Q")
+	c.op("EMC")
+}
+
+// checkAppearance draws the check mark of a checked checkbox in a box of
+// the size of r.
+func checkAppearance(c *contentStream, r recording.Rect) {
+	w, h := r.Width(), r.Height()
+	c.strokeColor(gg.Black)
+	c.op("w", 0.1*math.Min(w, h))
+	c.op("J", 1)
+	c.op("j", 1)
+	c.op("m", 0.22*w, 0.5*h)
+	c.op("l", 0.42*w, 0.27*h)
+	c.op("l", 0.78*w, 0.75*h)
+	c.op("S")
+}
+
+// radioAppearance draws the dot of a selected radio button in a box of
+// the size of r.
+func radioAppearance(c *contentStream, r recording.Rect) {
+	w, h := r.Width(), r.Height()
+	rad := 0.3 * math.Min(w, h)
+	c.fillColor(gg.Black)
+	ellipse(c, w/2, h/2, rad, rad)
+	c.op("f")
+}
@@ -0,0 +1,308 @@
+package pdf
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"strings"
+	"testing"
+
+	"github.com/gogpu/gg/recording"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+// acroForm writes doc and returns the catalog /AcroForm and the page
+// annotations.
+func acroForm(t *testing.T, doc *Document) (pdfDict, pdfArray, *pdfReader) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	r, catalog := readOutput(t, buf.Bytes())
+	form, err := r.resolveDict(catalog["AcroForm"])
+	if err != nil || form == nil {
+		t.Fatalf("catalog has no /AcroForm: %v", err)
+	}
+	pagesRoot, _ := r.resolveDict(catalog["Pages"])
+	kids, _ := pagesRoot["Kids"].(pdfArray)
+	page, _ := r.resolveDict(kids[0])
+	annots, _ := page["Annots"].(pdfArray)
+	return form, annots, r
+}
+
+// addField adds f to the page.
+func addField(t *testing.T, page recording.Backend, f Field) {
+	t.Helper()
+
+	if err := page.(*pageBackend).AddField(f); err != nil {
+		t.Fatalf("AddField(%q) failed: %v", f.Name, err)
+	}
+}
+
+// appearanceContent returns the decoded normal appearance of a widget, or
+// its appearance in state for a button.
+func appearanceContent(t *testing.T, r *pdfReader, widget pdfDict, state pdfName) string {
+	t.Helper()
+
+	ap, _ := widget["AP"].(pdfDict)
+	n := ap["N"]
+	if states, ok := n.(pdfDict); ok {
+		n = states[state]
+	}
+	obj, _ := r.resolve(n)
+	stream, ok := obj.(*pdfStream)
+	if !ok {
+		t.Fatalf("widget %v has no appearance stream", widget)
+	}
+	data, err := decodeStream(stream)
+	if err != nil {
+		t.Fatalf("failed to decode appearance: %v", err)
+	}
+	return string(data)
+}
+
+func TestFieldText(t *testing.T) {
+	doc := NewDocument()
+	page := doc.NewPage(300, 200)
+	addField(t, page, Field{
+		Kind:     FieldText,
+		Name:     "email",
+		Rect:     recording.NewRect(100, 20, 150, 20),
+		Value:    "jane@example.com",
+		Tooltip:  "Email address",
+		Required: true,
+	})
+	addField(t, page, Field{
+		Kind:      FieldText,
+		Name:      "notes",
+		Rect:      recording.NewRect(100, 60, 150, 60),
+		Value:     "first\nsecond",
+		Multiline: true,
+		ReadOnly:  true,
+	})
+
+	form, annots, r := acroForm(t, doc)
+	fields, _ := form["Fields"].(pdfArray)
+	if len(fields) != 2 || len(annots) != 2 {
+		t.Fatalf("/Fields = %v, /Annots = %v, want two text fields", fields, annots)
+	}
+	dr, _ := form["DR"].(pdfDict)
+	fonts, _ := dr["Font"].(pdfDict)
+	if len(fonts) != 3 || fonts["F1"] == nil {
+		t.Errorf("/DR = %v, want the field font, Helv, and ZaDb", dr)
+	}
+	for name, base := range map[pdfName]pdfName{"Helv": "Helvetica", "ZaDb": "ZapfDingbats"} {
+		if font, _ := r.resolveDict(fonts[name]); font["BaseFont"] != base {
+			t.Errorf("/DR font %s = %v, want %s", name, font, base)
+		}
+	}
+
+	email, _ := r.resolveDict(fields[0])
+	rect, _ := email["Rect"].(pdfArray)
+	if email["FT"] != pdfName("Tx") || email["T"] != pdfString("email") || email["Ff"] != fieldRequired ||
+		email["V"] != pdfString("jane@example.com") || email["TU"] != pdfString("Email address") ||
+		formatArray(rect) != "[100.00 160.00 250.00 180.00]" {
+		t.Errorf("email field = %v", email)
+	}
+	if email["DA"] != pdfString("/F1 12 Tf 0 g") {
+		t.Errorf("/DA = %v, want 12 point F1", email["DA"])
+	}
+	if content := appearanceContent(t, r, email, ""); !strings.Contains(content, "/Tx BMC\n") ||
+		!strings.Contains(content, "(jane@example.com) Tj") {
+		t.Errorf("email appearance does not show the value:\n%s", content)
+	}
+
+	notes, _ := r.resolveDict(fields[1])
+	if notes["Ff"] != fieldReadOnly|fieldMultiline {
+		t.Errorf("notes /Ff = %v, want read-only and multiline", notes["Ff"])
+	}
+	content := appearanceContent(t, r, notes, "")
+	if !strings.Contains(content, "(first) Tj") || !strings.Contains(content, "(second) Tj") {
+		t.Errorf("notes appearance does not show both lines:\n%s", content)
+	}
+}
+
+func TestFieldButtons(t *testing.T) {
+	doc := NewDocument()
+	page := doc.NewPage(300, 200)
+	addField(t, page, Field{Kind: FieldCheckbox, Name: "subscribe", Rect: recording.NewRect(10, 10, 12, 12), Checked: true})
+	for i, size := range []string{"S", "M", "L"} {
+		addField(t, page, Field{
+			Kind:    FieldRadio,
+			Name:    "size",
+			Value:   size,
+			Rect:    recording.NewRect(10+20*float64(i), 40, 12, 12),
+			Checked: size == "M",
+		})
+	}
+
+	form, annots, r := acroForm(t, doc)
+	fields, _ := form["Fields"].(pdfArray)
+	if len(fields) != 2 || len(annots) != 4 {
+		t.Fatalf("/Fields = %v, /Annots = %v, want a checkbox and a radio group of three", fields, annots)
+	}
+
+	box, _ := r.resolveDict(fields[0])
+	if box["FT"] != pdfName("Btn") || box["V"] != pdfName("Yes") || box["AS"] != pdfName("Yes") {
+		t.Errorf("checkbox = %v, want it checked", box)
+	}
+	if content := appearanceContent(t, r, box, "Yes"); !strings.HasSuffix(content, " l\nS\n") {
+		t.Errorf("checked appearance does not draw a check mark:\n%s", content)
+	}
+	if content := appearanceContent(t, r, box, "Off"); content != "" {
+		t.Errorf("unchecked appearance = %q, want empty", content)
+	}
+
+	group, _ := r.resolveDict(fields[1])
+	kids, _ := group["Kids"].(pdfArray)
+	if group["Ff"] != fieldRadio|fieldNoToggleToOff || group["V"] != pdfName("M") || len(kids) != 3 {
+		t.Fatalf("radio group = %v, want three buttons with M selected", group)
+	}
+	for i, want := range []pdfName{"Off", "M", "Off"} {
+		kid, _ := r.resolveDict(kids[i])
+		if kid["AS"] != want || kid["Parent"] == nil {
+			t.Errorf("radio button %d = %v, want state %s", i, kid, want)
+		}
+	}
+}
+
+func TestFieldChoice(t *testing.T) {
+	doc := NewDocument()
+	page := doc.NewPage(300, 200)
+	options := []string{"Red", "Green", "Blue"}
+	addField(t, page, Field{Kind: FieldChoice, Name: "color", Rect: recording.NewRect(10, 10, 100, 20), Options: options, Value: "Green"})
+	addField(t, page, Field{Kind: FieldChoice, Name: "shade", Rect: recording.NewRect(10, 40, 100, 60), Options: options, List: true})
+
+	form, _, r := acroForm(t, doc)
+	fields, _ := form["Fields"].(pdfArray)
+	combo, _ := r.resolveDict(fields[0])
+	opts, _ := combo["Opt"].(pdfArray)
+	if combo["FT"] != pdfName("Ch") || combo["Ff"] != fieldCombo || combo["V"] != pdfString("Green") || len(opts) != 3 {
+		t.Errorf("drop-down = %v", combo)
+	}
+	if content := appearanceContent(t, r, combo, ""); !strings.Contains(content, "(Green) Tj") {
+		t.Errorf("drop-down appearance does not show the value:\n%s", content)
+	}
+	list, _ := r.resolveDict(fields[1])
+	if _, ok := list["Ff"]; ok {
+		t.Errorf("list box /Ff = %v, want none", list["Ff"])
+	}
+	if content := appearanceContent(t, r, list, ""); strings.Count(content, "Tj") != 3 {
+		t.Errorf("list box appearance does not show every option:\n%s", content)
+	}
+}
+
+func TestFieldWithSignature(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	doc := NewDocument()
+	page := doc.NewPage(300, 200)
+	addField(t, page, Field{Kind: FieldText, Name: "name", Rect: recording.NewRect(10, 10, 100, 20)})
+	sig := Signature{Signer: key, Certificates: []*x509.Certificate{testCertificate(t, key)}}
+	if err := doc.SetSignature(0, sig); err != nil {
+		t.Fatalf("SetSignature failed: %v", err)
+	}
+
+	form, annots, _ := acroForm(t, doc)
+	if fields, _ := form["Fields"].(pdfArray); len(fields) != 2 || len(annots) != 2 || form["SigFlags"] != 3 {
+		t.Errorf("/AcroForm = %v, want the text and signature fields", form)
+	}
+
+	sig.Field = "name"
+	if err := doc.SetSignature(0, sig); err != nil {
+		t.Fatalf("SetSignature failed: %v", err)
+	}
+	if _, err := doc.WriteTo(&bytes.Buffer{}); err == nil {
+		t.Error("WriteTo succeeded with a signature named like a form field")
+	}
+}
+
+func TestFieldErrors(t *testing.T) {
+	doc := NewDocument()
+	page := doc.NewPage(100, 100).(*pageBackend)
+	addField(t, page, Field{Kind: FieldRadio, Name: "size", Value: "S", Rect: recording.NewRect(0, 0, 10, 10), Checked: true})
+	for _, f := range []Field{
+		{Kind: FieldText, Name: "", Rect: recording.NewRect(0, 0, 10, 10)},
+		{Kind: FieldText, Name: "a.b", Rect: recording.NewRect(0, 0, 10, 10)},
+		{Kind: FieldText, Name: "outside", Rect: recording.NewRect(95, 0, 10, 10)},
+		{Kind: FieldKind(9), Name: "unknown", Rect: recording.NewRect(0, 0, 10, 10)},
+		{Kind: FieldChoice, Name: "empty", Rect: recording.NewRect(0, 0, 10, 10)},
+		{Kind: FieldChoice, Name: "other", Rect: recording.NewRect(0, 0, 10, 10), Options: []string{"a"}, Value: "b"},
+		{Kind: FieldRadio, Name: "novalue", Rect: recording.NewRect(0, 0, 10, 10)},
+		{Kind: FieldText, Name: "size", Rect: recording.NewRect(0, 0, 10, 10)},
+		{Kind: FieldRadio, Name: "size", Value: "S", Rect: recording.NewRect(0, 0, 10, 10)},
+		{Kind: FieldRadio, Name: "size", Value: "M", Rect: recording.NewRect(0, 0, 10, 10), Checked: true},
+	} {
+		if err := page.AddField(f); err == nil {
+			t.Errorf("AddField(%+v) succeeded", f)
+		}
+	}
+
+	pdfa := NewDocument()
+	if err := pdfa.SetConformance(ConformancePDFA2B); err != nil {
+		t.Fatal(err)
+	}
+	if err := pdfa.NewPage(100, 100).(*pageBackend).AddField(Field{Kind: FieldText, Name: "x", Rect: recording.NewRect(0, 0, 10, 10)}); err == nil {
+		t.Error("AddField succeeded with the standard font under PDF/A")
+	}
+}
+
+func TestFieldRegisteredFont(t *testing.T) {
+	doc := newPDFADocument(t, ConformancePDFA2B)
+	page := doc.NewPage(300, 200)
+	addField(t, page, Field{Kind: FieldText, Name: "city", Rect: recording.NewRect(10, 10, 100, 20), Value: "Zürich", Face: goRegularFace(t, 10)})
+
+	form, _, r := acroForm(t, doc)
+	fields, _ := form["Fields"].(pdfArray)
+	city, _ := r.resolveDict(fields[0])
+	if city["DA"] != pdfString("/F1 10 Tf 0 g") {
+		t.Errorf("/DA = %v, want 10 point F1", city["DA"])
+	}
+	if content := appearanceContent(t, r, city, ""); !strings.Contains(content, "> Tj") {
+		t.Errorf("appearance does not show glyphs of the registered font:\n%s", content)
+	}
+	dr, _ := form["DR"].(pdfDict)
+	fonts, _ := dr["Font"].(pdfDict)
+	font, _ := r.resolveDict(fonts["F1"])
+	if font["Subtype"] != pdfName("Type0") {
+		t.Fatalf("field font = %v, want the embedded Type0 font", font)
+	}
+
+	// Text typed into the field may use any glyph of the font, so the
+	// widths cover all of them, not only those of the value.
+	descendants, _ := font["DescendantFonts"].(pdfArray)
+	cid, _ := r.resolveDict(descendants[0])
+	widths, _ := cid["W"].(pdfArray)
+	if len(widths) != 2 || widths[0] != 0 {
+		t.Fatalf("/W = %.40v, want the widths of every glyph", widths)
+	}
+	if all, _ := widths[1].(pdfArray); len(all) != doc.shared.fonts[0].parsed.NumGlyphs() {
+		t.Errorf("/W has %d widths, want %d", len(all), doc.shared.fonts[0].parsed.NumGlyphs())
+	}
+}
+
+func TestFieldChoiceOptionGlyphs(t *testing.T) {
+	doc := NewDocument()
+	if err := doc.RegisterFont(goregular.TTF); err != nil {
+		t.Fatalf("RegisterFont failed: %v", err)
+	}
+	page := doc.NewPage(300, 200)
+	addField(t, page, Field{
+		Kind:    FieldChoice,
+		Name:    "city",
+		Rect:    recording.NewRect(10, 10, 100, 20),
+		Options: []string{"Zürich", "Genève"},
+		Value:   "Zürich",
+		Face:    goRegularFace(t, 10),
+	})
+	acroForm(t, doc)
+	font := doc.shared.fonts[0]
+	for _, r := range "Genève" {
+		if _, ok := font.used[font.parsed.GlyphIndex(r)]; !ok {
+			t.Errorf("glyph for %q of an option that is not selected is not in the font", r)
+		}
+	}
+}
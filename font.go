@@ -0,0 +1,279 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf16"
+
+	"github.com/gogpu/gg/text"
+)
+
+// standardFontName is the font DrawText uses when no font is registered.
+// It is one of the standard 14 fonts, which viewers supply themselves.
+const standardFontName = "Helvetica"
+
+// embeddedFont is a TrueType or OpenType font registered by the caller and
+// embedded in the file as a composite (Type0) font with Identity-H encoding,
+// so any glyph of the font can be shown.
+type embeddedFont struct {
+	name   string
+	data   []byte
+	parsed text.ParsedFont
+	cff    bool
+
+	// used maps the glyphs shown with the font to the text they represent,
+	// for the widths array and the ToUnicode map. mu guards it while pages
+	// are drawn in parallel.
+	mu   sync.Mutex
+	used map[uint16]rune
+
+	// complete writes the widths and text of every glyph, not only those
+	// in use, for fonts that text typed into a field in a viewer is shown
+	// in.
+	complete bool
+
+	// obj is the font dictionary. It is referenced while pages are drawn and
+	// filled in by finish once every glyph in use is known.
+	obj *pdfIndirect
+}
+
+// newEmbeddedFont parses a TrueType or OpenType font file for embedding.
+func newEmbeddedFont(data []byte) (*embeddedFont, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("pdf: font data is too short")
+	}
+	var cff bool
+	switch string(data[:4]) {
+	case "\x00\x01\x00\x00", "true":
+	case "OTTO":
+		cff = true
+	case "ttcf":
+		return nil, fmt.Errorf("pdf: font collections are not supported; register a single font")
+	default:
+		return nil, fmt.Errorf("pdf: font data is not a TrueType or OpenType font")
+	}
+
+	src, err := text.NewFontSource(data)
+	if err != nil {
+		return nil, fmt.Errorf("pdf: failed to parse font: %w", err)
+	}
+	parsed := src.Parsed()
+	if parsed.UnitsPerEm() <= 0 {
+		return nil, fmt.Errorf("pdf: font %q has no units per em", src.Name())
+	}
+	return &embeddedFont{
+		name:   src.Name(),
+		data:   data,
+		parsed: parsed,
+		cff:    cff,
+		used:   make(map[uint16]rune),
+		obj:    newIndirect(nil),
+	}, nil
+}
+
+// encode returns s as a string of two-byte glyph identifiers, recording the
+// glyphs as used. It also returns the first rune the font has no glyph for.
+func (f *embeddedFont) encode(s string) (pdfHexString, rune, bool) {
+	var (
+		buf     = make([]byte, 0, 2*len(s))
+		missing rune
+		ok      = true
+	)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, r := range s {
+		gid := f.parsed.GlyphIndex(r)
+		if gid == 0 {
+			if ok {
+				missing, ok = r, false
+			}
+		} else if _, seen := f.used[gid]; !seen {
+			f.used[gid] = r
+		}
+		buf = append(buf, byte(gid>>8), byte(gid))
+	}
+	return pdfHexString(buf), missing, ok
+}
+
+// width returns the advance of a glyph in thousandths of an em.
+func (f *embeddedFont) width(gid uint16) int {
+	upem := float64(f.parsed.UnitsPerEm())
+	return int(math.Round(f.parsed.GlyphAdvance(gid, upem) * 1000 / upem))
+}
+
+// baseFont returns the PostScript-style name written as /BaseFont.
+func (f *embeddedFont) baseFont() pdfName {
+	name := f.parsed.FullName()
+	if name == "" {
+		name = f.name
+	}
+	name = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' || strings.ContainsRune("()<>[]{}/%#", r) {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "" {
+		name = "EmbeddedFont"
+	}
+	return pdfName(name)
+}
+
+// finish completes the font dictionary with the widths of the glyphs in
+// use, or of all glyphs for a complete font.
+func (f *embeddedFont) finish() {
+	if f.complete {
+		for r := rune(' '); r <= 0xFFFF; r++ {
+			if r >= 0xD800 && r <= 0xDFFF {
+				continue
+			}
+			if gid := f.parsed.GlyphIndex(r); gid != 0 {
+				if _, seen := f.used[gid]; !seen {
+					f.used[gid] = r
+				}
+			}
+		}
+	}
+	gids := make([]uint16, 0, len(f.used))
+	for gid := range f.used {
+		gids = append(gids, gid)
+	}
+	slices.Sort(gids)
+
+	widths := pdfArray{}
+	if f.complete {
+		all := make(pdfArray, f.parsed.NumGlyphs())
+		for gid := range all {
+			all[gid] = f.width(uint16(gid))
+		}
+		widths = append(widths, 0, all)
+	} else {
+		for _, gid := range gids {
+			widths = append(widths, int(gid), pdfArray{f.width(gid)})
+		}
+	}
+
+	upem := float64(f.parsed.UnitsPerEm())
+	scale := 1000 / upem
+	metrics := f.parsed.Metrics(upem)
+	ascent := int(math.Round(math.Abs(metrics.Ascent) * scale))
+	descent := -int(math.Round(math.Abs(metrics.Descent) * scale))
+	capHeight := int(math.Round(metrics.CapHeight * scale))
+	if capHeight <= 0 {
+		capHeight = ascent
+	}
+
+	// Font bounding box from the glyphs in use; glyph bounds are y-down.
+	bbox := [4]int{0, descent, 1000, ascent}
+	for _, gid := range gids {
+		b := f.parsed.GlyphBounds(gid, upem)
+		bbox[0] = min(bbox[0], int(math.Floor(b.MinX*scale)))
+		bbox[1] = min(bbox[1], int(math.Floor(-b.MaxY*scale)))
+		bbox[2] = max(bbox[2], int(math.Ceil(b.MaxX*scale)))
+		bbox[3] = max(bbox[3], int(math.Ceil(-b.MinY*scale)))
+	}
+
+	baseFont := f.baseFont()
+	descriptor := pdfDict{
+		"Type":        pdfName("FontDescriptor"),
+		"FontName":    baseFont,
+		"Flags":       32, // nonsymbolic
+		"FontBBox":    pdfArray{bbox[0], bbox[1], bbox[2], bbox[3]},
+		"ItalicAngle": 0,
+		"Ascent":      ascent,
+		"Descent":     descent,
+		"CapHeight":   capHeight,
+		"StemV":       80,
+	}
+	cidFont := pdfDict{
+		"Type":     pdfName("Font"),
+		"BaseFont": baseFont,
+		"CIDSystemInfo": pdfDict{
+			"Registry":   pdfString("Adobe"),
+			"Ordering":   pdfString("Identity"),
+			"Supplement": 0,
+		},
+		"DW": 1000,
+		"W":  widths,
+	}
+	if f.cff {
+		descriptor["FontFile3"] = flateStream(pdfDict{"Subtype": pdfName("OpenType")}, f.data)
+		cidFont["Subtype"] = pdfName("CIDFontType0")
+	} else {
+		descriptor["FontFile2"] = flateStream(pdfDict{"Length1": len(f.data)}, f.data)
+		cidFont["Subtype"] = pdfName("CIDFontType2")
+		cidFont["CIDToGIDMap"] = pdfName("Identity")
+	}
+	cidFont["FontDescriptor"] = newIndirect(descriptor)
+
+	f.obj.Value = pdfDict{
+		"Type":            pdfName("Font"),
+		"Subtype":         pdfName("Type0"),
+		"BaseFont":        baseFont,
+		"Encoding":        pdfName("Identity-H"),
+		"DescendantFonts": pdfArray{newIndirect(cidFont)},
+		"ToUnicode":       flateStream(nil, f.toUnicode(gids)),
+	}
+}
+
+// toUnicode builds a CMap mapping the glyphs in use back to their text.
+func (f *embeddedFont) toUnicode(gids []uint16) []byte {
+	var b bytes.Buffer
+	b.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n")
+	b.WriteString("/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n")
+	b.WriteString("/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n")
+	b.WriteString("1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+	for start := 0; start < len(gids); start += 100 {
+		chunk := gids[start:min(start+100, len(gids))]
+		fmt.Fprintf(&b, "%d beginbfchar\n", len(chunk))
+		for _, gid := range chunk {
+			fmt.Fprintf(&b, "<%04X> <", gid)
+			for _, u := range utf16.Encode([]rune{f.used[gid]}) {
+				fmt.Fprintf(&b, "%04X", u)
+			}
+			b.WriteString(">\n")
+		}
+		b.WriteString("endbfchar\n")
+	}
+	b.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend\n")
+	return b.Bytes()
+}
+
+// standardFontDict returns the dictionary of the non-embedded standard font.
+func standardFontDict() pdfDict {
+	return pdfDict{
+		"Type":     pdfName("Font"),
+		"Subtype":  pdfName("Type1"),
+		"BaseFont": pdfName(standardFontName),
+		"Encoding": pdfName("WinAnsiEncoding"),
+	}
+}
+
+// winAnsiHigh maps the characters of the 0x80-0x9F range of WinAnsiEncoding.
+var winAnsiHigh = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87,
+	'ˆ': 0x88, '‰': 0x89, 'Š': 0x8A, '‹': 0x8B, 'Œ': 0x8C, 'Ž': 0x8E,
+	'‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97,
+	'˜': 0x98, '™': 0x99, 'š': 0x9A, '›': 0x9B, 'œ': 0x9C, 'ž': 0x9E, 'Ÿ': 0x9F,
+}
+
+// encodeWinAnsi converts s to WinAnsiEncoding, replacing characters the
+// encoding cannot represent with '?'.
+func encodeWinAnsi(s string) pdfString {
+	buf := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r < 0x80 || (r >= 0xA0 && r <= 0xFF):
+			buf = append(buf, byte(r))
+		case winAnsiHigh[r] != 0:
+			buf = append(buf, winAnsiHigh[r])
+		default:
+			buf = append(buf, '?')
+		}
+	}
+	return pdfString(buf)
+}
@@ -0,0 +1,185 @@
+package pdf
+
+import (
+	"errors"
+	"image"
+
+	"github.com/gogpu/gg"
+	"github.com/gogpu/gg/recording"
+	"github.com/gogpu/gg/scene"
+	"github.com/gogpu/gg/text"
+)
+
+// Form is a recording written once as a Form XObject and placed on any
+// number of pages with DrawForm. Repeated content such as headers, footers,
+// stamps, and map symbols is then stored once in the file, and each
+// placement costs a single operator.
+type Form struct {
+	rec           *recording.Recording // nil for an imported page
+	shared        *sharedResources
+	width, height float64
+
+	// The captured content and the XObject written for it, created the
+	// first time the form is drawn.
+	content *contentStream
+	xobj    *pdfStream
+}
+
+// NewForm returns a Form drawing rec for use on the pages of the document.
+// The form covers the recording's width and height.
+func (d *Document) NewForm(rec *recording.Recording) *Form {
+	return newForm(rec, d.shared)
+}
+
+// NewForm returns a Form drawing rec for use on the backend's page.
+func (b *Backend) NewForm(rec *recording.Recording) *Form {
+	return newForm(rec, b.shared)
+}
+
+func newForm(rec *recording.Recording, shared *sharedResources) *Form {
+	return &Form{
+		rec:    rec,
+		shared: shared,
+		width:  float64(rec.Width()),
+		height: float64(rec.Height()),
+	}
+}
+
+// Width returns the width of the form in points.
+func (f *Form) Width() float64 { return f.width }
+
+// Height returns the height of the form in points.
+func (f *Form) Height() float64 { return f.height }
+
+// DrawForm draws f with its origin mapped by m, in the current transform:
+//
+//	symbol := b.NewForm(symbolRecording)
+//	for _, p := range sites {
+//		b.DrawForm(symbol, recording.Translate(p.X, p.Y))
+//	}
+//
+// A structure element around the form tags it as one piece of content.
+// Forms are shared by the pages of the Document or Backend that created
+// them; drawing a form elsewhere is an error.
+func (b *Backend) DrawForm(f *Form, m recording.Matrix) {
+	if f.shared != b.shared {
+		b.fail(errors.New("pdf: form belongs to another document"))
+		return
+	}
+	base := b.currentTransform.Multiply(m)
+	area := transformRect(m, recording.NewRect(0, 0, f.width, f.height))
+	if f.rec != nil && b.rasterize(func(r *rasterizer) { r.drawRecording(f.rec, base) }, &area) {
+		return
+	}
+
+	if f.xobj == nil {
+		f.content = b.captureContent(func() { b.playInto(f.rec) })
+		f.xobj = flateStream(pdfDict{
+			"Type":      pdfName("XObject"),
+			"Subtype":   pdfName("Form"),
+			"BBox":      rectArray(0, 0, f.width, f.height),
+			"Resources": f.content.res.dict(),
+		}, f.content.buf.Bytes())
+	}
+
+	b.markContent()
+	c := b.content
+	c.transparency = c.transparency || f.content.transparency
+	c.deviceRGB = c.deviceRGB || f.content.deviceRGB
+	c.deviceCMYK = c.deviceCMYK || f.content.deviceCMYK
+	name := c.res.add("XObject", "Fm", f.xobj, func() pdfObject { return f.xobj })
+	c.op("q")
+	c.transform(base)
+	c.opacity(1, 1)
+	c.op("Do", name)
+	c.op("Q")
+}
+
+// captureContent runs draw into a new content stream and returns it. The
+// content is drawn from the identity transform with a graphics state of its
+// own, and without affecting the page. Content drawn as pixels is drawn on
+// a transparent backdrop.
+func (b *Backend) captureContent(draw func()) *contentStream {
+	history, clips, mask, captured := b.history, b.clips, b.mask, b.captured
+	b.history, b.clips, b.mask, b.captured = nil, nil, nil, true
+
+	b.beginCapture(groupState{})
+	b.Save()
+	b.SetTransform(recording.Identity())
+	b.SetBlendMode(scene.BlendNormal)
+	draw()
+	_, content := b.endCapture()
+
+	b.history, b.clips, b.mask, b.captured = history, clips, mask, captured
+	return content
+}
+
+// playInto plays rec into the content being captured.
+func (b *Backend) playInto(rec *recording.Recording) {
+	if err := rec.Playback(recordingPlayback{b}); err != nil {
+		b.fail(err)
+	}
+}
+
+// recordingPlayback receives the playback of a recording nested in the
+// page, such as a form or pattern cell. Begin and End belong to the page
+// and do nothing.
+type recordingPlayback struct {
+	*Backend
+}
+
+func (recordingPlayback) Begin(width, height int) error { return nil }
+
+func (recordingPlayback) End() error { return nil }
+
+// drawRecording plays rec into the rasterizer with its transforms applied
+// after base.
+func (r *rasterizer) drawRecording(rec *recording.Recording, base recording.Matrix) {
+	depth := len(r.stack)
+	r.save()
+	r.setTransform(base)
+	_ = rec.Playback(rasterPlayback{r: r, base: base})
+	for len(r.stack) > depth {
+		r.restore()
+	}
+}
+
+// rasterPlayback adapts the rasterizer to recording playback.
+type rasterPlayback struct {
+	r    *rasterizer
+	base recording.Matrix
+}
+
+func (p rasterPlayback) Begin(width, height int) error { return nil }
+func (p rasterPlayback) End() error                    { return nil }
+func (p rasterPlayback) Save()                         { p.r.save() }
+func (p rasterPlayback) Restore()                      { p.r.restore() }
+func (p rasterPlayback) ClearClip()                    {}
+
+func (p rasterPlayback) SetTransform(m recording.Matrix) {
+	p.r.setTransform(p.base.Multiply(m))
+}
+
+func (p rasterPlayback) SetClip(path *gg.Path, rule recording.FillRule) {
+	p.r.setClip(path, rule)
+}
+
+func (p rasterPlayback) FillPath(path *gg.Path, brush recording.Brush, rule recording.FillRule) {
+	p.r.fillPath(path, brush, rule)
+}
+
+func (p rasterPlayback) StrokePath(path *gg.Path, brush recording.Brush, stroke recording.Stroke) {
+	p.r.strokePath(path, brush, stroke)
+}
+
+func (p rasterPlayback) FillRect(rect recording.Rect, brush recording.Brush) {
+	p.r.fillRect(rect, brush)
+}
+
+func (p rasterPlayback) DrawImage(img image.Image, src, dst recording.Rect, opts recording.ImageOptions) {
+	p.r.drawImage(img, src, dst, opts)
+}
+
+func (p rasterPlayback) DrawText(s string, x, y float64, face text.Face, brush recording.Brush) {
+	p.r.drawText(s, x, y, face, brush)
+}
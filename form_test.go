@@ -0,0 +1,150 @@
+package pdf
+
+import (
+	"bytes"
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/gogpu/gg"
+	"github.com/gogpu/gg/recording"
+	"github.com/gogpu/gg/scene"
+)
+
+// symbol records a red triangle in a 10x10 box.
+func symbol() *recording.Recording {
+	rec := recording.NewRecorder(10, 10)
+	rec.SetFillRGB(1, 0, 0)
+	rec.MoveTo(0, 10)
+	rec.LineTo(5, 0)
+	rec.LineTo(10, 10)
+	rec.ClosePath()
+	rec.Fill()
+	return rec.FinishRecording()
+}
+
+func TestFormReusedAcrossPages(t *testing.T) {
+	doc := NewDocument()
+	form := doc.NewForm(symbol())
+	for range 2 {
+		page := doc.NewPage(100, 100).(*pageBackend)
+		for i := range 3 {
+			page.DrawForm(form, recording.Translate(float64(20*i), 30))
+		}
+	}
+
+	var refs []pdfObject
+	for i := range 2 {
+		content, res, r := pageContent(t, documentWriter(doc), i)
+		if strings.Count(content, "/Fm1 Do\n") != 3 || strings.Contains(content, " l\n") {
+			t.Errorf("page %d does not place the form three times:\n%s", i, content)
+		}
+		if !strings.Contains(content, "q\n1 0 0 1 40 30 cm\n/Fm1 Do\nQ\n") {
+			t.Errorf("page %d does not place the form at its transform:\n%s", i, content)
+		}
+		xobjects, _ := res["XObject"].(pdfDict)
+		refs = append(refs, xobjects["Fm1"])
+		form, data := formXObject(t, res, r, "Fm1")
+		if strings.Count(data, " l\n") != 2 || !strings.Contains(data, "1 0 0 rg\n") {
+			t.Errorf("form content = %q, want the triangle", data)
+		}
+		if bbox, _ := form.Dict["BBox"].(pdfArray); len(bbox) != 4 || bbox[2] != 10.0 || bbox[3] != 10.0 {
+			t.Errorf("/BBox = %v, want the recording size", form.Dict["BBox"])
+		}
+	}
+	if refs[0] != refs[1] {
+		t.Errorf("pages reference forms %v and %v, want one shared object", refs[0], refs[1])
+	}
+}
+
+func TestFormIsSmallerThanPlayback(t *testing.T) {
+	rec := recording.NewRecorder(10, 10)
+	rec.SetFillRGB(0, 0, 1)
+	for i := range 20 {
+		rec.DrawCircle(5, 5, float64(i)/4+0.5)
+		rec.Fill()
+	}
+	stamp := rec.FinishRecording()
+
+	size := func(draw func(b *Backend)) int {
+		var buf bytes.Buffer
+		if err := backendWriter(t, 500, 500, draw)(&buf); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+		return buf.Len()
+	}
+	replayed := size(func(b *Backend) {
+		for i := range 200 {
+			b.SetTransform(recording.Translate(float64(i%50*10), float64(i/50*10)))
+			_ = stamp.Playback(recordingPlayback{b})
+		}
+	})
+	placed := size(func(b *Backend) {
+		form := b.NewForm(stamp)
+		for i := range 200 {
+			b.DrawForm(form, recording.Translate(float64(i%50*10), float64(i/50*10)))
+		}
+	})
+	if placed*2 > replayed {
+		t.Errorf("file with the form is %d bytes, replaying is %d", placed, replayed)
+	}
+}
+
+func TestFormTagged(t *testing.T) {
+	doc := NewDocument()
+	form := doc.NewForm(symbol())
+	page := doc.NewPage(100, 100).(*pageBackend)
+	mustStructure(t, doc.BeginStructure(StructureFigure, StructureAttributes{Alt: "Warning"}))
+	page.DrawForm(form, recording.Identity())
+	mustStructure(t, doc.EndStructure())
+
+	content, res, r := pageContent(t, documentWriter(doc), 0)
+	if !strings.Contains(content, "/Figure << /MCID 0 >> BDC\nq\n/Fm1 Do\nQ\nEMC\n") {
+		t.Errorf("form is not marked as one figure:\n%s", content)
+	}
+	if _, data := formXObject(t, res, r, "Fm1"); strings.Contains(data, "BDC") {
+		t.Errorf("form content is marked:\n%s", data)
+	}
+}
+
+func TestFormOtherDocument(t *testing.T) {
+	form := NewDocument().NewForm(symbol())
+	backend := NewBackend()
+	if err := backend.Begin(10, 10); err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	backend.DrawForm(form, recording.Identity())
+	if err := backend.End(); err == nil {
+		t.Error("End succeeded after drawing a form of another document")
+	}
+}
+
+func TestFormPorterDuffIsRasterized(t *testing.T) {
+	write := backendWriter(t, 100, 100, func(b *Backend) {
+		form := b.NewForm(symbol())
+		b.FillRect(recording.NewRect(0, 0, 100, 100), recording.NewSolidBrush(gg.Black))
+		b.SetBlendMode(scene.BlendXor)
+		b.DrawForm(form, recording.Scale(2, 2))
+	})
+
+	content, res, _ := pageContent(t, write, 0)
+	xobjects, _ := res["XObject"].(pdfDict)
+	if _, ok := xobjects["Fm1"]; ok || !strings.Contains(content, "/Im1 Do\n") {
+		t.Errorf("form drawn with a Porter-Duff operator is not painted as an image:\n%s", content)
+	}
+}
+
+func TestRasterizerDrawRecording(t *testing.T) {
+	r := layerRasterizer(100, 100)
+	r.drawRecording(symbol(), recording.Translate(50, 50).Multiply(recording.Scale(2, 2)))
+	red, clear := color.RGBA{0xFF, 0, 0, 0xFF}, color.RGBA{}
+	if got := rasterPixel(r, 60, 65); got != red {
+		t.Errorf("pixel inside the placed triangle = %v, want %v", got, red)
+	}
+	if got := rasterPixel(r, 5, 8); got != clear {
+		t.Errorf("pixel inside the untransformed triangle = %v, want %v", got, clear)
+	}
+	if !r.transform.IsIdentity() {
+		t.Errorf("transform after the recording = %v, want the identity", r.transform)
+	}
+}
@@ -3,11 +3,8 @@ module github.com/gogpu/gg-pdf
 go 1.25
 
 require (
-	github.com/coregx/gxpdf v0.4.0
 	github.com/gogpu/gg v0.23.0
+	golang.org/x/image v0.35.0
 )
 
-require (
-	golang.org/x/image v0.35.0 // indirect
-	golang.org/x/text v0.33.0 // indirect
-)
+require golang.org/x/text v0.33.0 // indirect
@@ -0,0 +1,174 @@
+package pdf
+
+import (
+	"errors"
+
+	"github.com/gogpu/gg/scene"
+)
+
+// TransparencyGroup describes how the content of a group is composited as
+// a whole. Drawing a group at half opacity differs from drawing each shape
+// at half opacity: shapes in the group cover each other as if opaque, and
+// only the result is blended with the page.
+type TransparencyGroup struct {
+	// Opacity is the alpha the group is painted with, from 0 (invisible) to
+	// 1 (opaque).
+	Opacity float64
+
+	// BlendMode composites the group with the content below it.
+	BlendMode scene.BlendMode
+
+	// Isolated composites the group's content on a transparent backdrop
+	// instead of the content below it, so that blend modes inside the group
+	// do not interact with the page.
+	Isolated bool
+
+	// Knockout makes each shape in the group composite with the group's
+	// backdrop instead of the shapes drawn before it in the group.
+	Knockout bool
+}
+
+// groupState is an open transparency group, soft mask, or pattern cell:
+// the content stream it interrupts and the Save depth at which it began.
+type groupState struct {
+	group  TransparencyGroup
+	mask   *MaskMode // nil for a transparency group
+	parent *contentStream
+	depth  int
+}
+
+// BeginGroup starts capturing content into a transparency group, which is
+// written as a Form XObject and painted as a whole by the matching
+// EndGroup. Groups nest. The group starts with a saved graphics state and
+// the normal blend mode; EndGroup restores the state saved by BeginGroup.
+// Structure elements apply to the group as a whole.
+func (b *Backend) BeginGroup(g TransparencyGroup) {
+	if err := validateBlendMode(g.BlendMode); err != nil {
+		b.fail(err)
+		return
+	}
+	b.content.endMarked()
+	b.beginCapture(groupState{group: g})
+	b.record((*rasterizer).beginGroup)
+	b.Save()
+	b.SetBlendMode(scene.BlendNormal)
+}
+
+// EndGroup ends the group begun last and paints it with its opacity and
+// blend mode. Graphics states saved in the group and not restored are
+// restored. Groups with a Porter-Duff blend mode are rasterized; Plus is
+// only supported for groups inside other groups.
+func (b *Backend) EndGroup() {
+	if len(b.groups) == 0 {
+		return
+	}
+	if b.groups[len(b.groups)-1].mask != nil {
+		b.fail(errors.New("pdf: EndGroup called while a mask is open"))
+		return
+	}
+	gs, form := b.endCapture()
+	b.content.transparency = true
+
+	g := gs.group
+	composite := func(r *rasterizer) { r.endGroup(g.Opacity, g.BlendMode) }
+	if _, ok := porterDuff[g.BlendMode]; ok {
+		if g.BlendMode == scene.BlendPlus && len(b.groups) == 0 {
+			b.fail(errPlusOnPage)
+		} else {
+			b.drawRaster(composite, nil)
+		}
+		b.endHistory()
+		return
+	}
+	b.record(composite)
+	b.endHistory()
+
+	group := pdfDict{
+		"Type": pdfName("Group"),
+		"S":    pdfName("Transparency"),
+		"CS":   b.shared.blendSpace(),
+	}
+	if g.Isolated {
+		group["I"] = true
+	}
+	if g.Knockout {
+		group["K"] = true
+	}
+	xobj := b.groupXObject(form, group)
+
+	b.markContent()
+	c := b.content
+	name := c.res.add("XObject", "Fm", xobj, func() pdfObject { return xobj })
+	saved := c.blendMode
+	c.blendMode = ""
+	if mode := pdfBlendModes[g.BlendMode]; mode != "Normal" {
+		c.blendMode = mode
+	}
+	c.op("q")
+	c.opacity(g.Opacity, g.Opacity)
+	c.op("Do", name)
+	c.op("Q")
+	c.blendMode = saved
+}
+
+// beginCapture redirects drawing into a new content stream for the group,
+// mask, or pattern cell gs.
+func (b *Backend) beginCapture(gs groupState) {
+	if b.history == nil {
+		b.history = []func(*rasterizer){b.rasterState()}
+	}
+	gs.parent = b.content
+	gs.depth = len(b.stateStack)
+	b.groups = append(b.groups, gs)
+	b.content = newContentStream(b.shared)
+}
+
+// endCapture ends the layers and restores the graphics states begun and
+// saved since the innermost capture began, switches back to the content stream it interrupted, and
+// returns it with its captured content.
+func (b *Backend) endCapture() (groupState, *contentStream) {
+	b.endLayers()
+	for len(b.stateStack) > b.groups[len(b.groups)-1].depth {
+		b.restore()
+	}
+	gs := b.groups[len(b.groups)-1]
+	b.groups = b.groups[:len(b.groups)-1]
+	form := b.content
+	b.content = gs.parent
+	b.content.deviceRGB = b.content.deviceRGB || form.deviceRGB
+	b.content.deviceCMYK = b.content.deviceCMYK || form.deviceCMYK
+	return gs, form
+}
+
+// endHistory stops recording drawing calls for the rasterizer once the
+// outermost group or mask has ended.
+func (b *Backend) endHistory() {
+	if len(b.groups) == 0 {
+		b.history = nil
+	}
+}
+
+// groupXObject returns a Form XObject covering the page that holds the
+// content and resources of form, with the transparency group group.
+func (b *Backend) groupXObject(form *contentStream, group pdfDict) *pdfStream {
+	return flateStream(pdfDict{
+		"Type":      pdfName("XObject"),
+		"Subtype":   pdfName("Form"),
+		"BBox":      rectArray(0, 0, b.width, b.height),
+		"Group":     group,
+		"Resources": form.res.dict(),
+	}, form.buf.Bytes())
+}
+
+// saveDepth returns the number of saved states Restore may pop: those saved
+// inside the innermost open group, mask, or layer.
+func (b *Backend) saveDepth() int {
+	depth := 0
+	if len(b.groups) > 0 {
+		depth = b.groups[len(b.groups)-1].depth + 1
+	}
+	if len(b.layers) > 0 {
+		depth = max(depth, b.layers[len(b.layers)-1].depth+1)
+	}
+	return depth
+}
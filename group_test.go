@@ -0,0 +1,170 @@
+package pdf
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/gogpu/gg"
+	"github.com/gogpu/gg/recording"
+	"github.com/gogpu/gg/scene"
+)
+
+// formXObject resolves the Form XObject name on a page and returns it with
+// its decoded content.
+func formXObject(t *testing.T, res pdfDict, r *pdfReader, name pdfName) (*pdfStream, string) {
+	t.Helper()
+
+	xobjects, _ := res["XObject"].(pdfDict)
+	obj, _ := r.resolve(xobjects[name])
+	form, ok := obj.(*pdfStream)
+	if !ok || form.Dict["Subtype"] != pdfName("Form") {
+		t.Fatalf("/%s = %v, want a Form XObject", name, obj)
+	}
+	data, err := decodeStream(form)
+	if err != nil {
+		t.Fatalf("failed to decode /%s: %v", name, err)
+	}
+	return form, string(data)
+}
+
+func TestTransparencyGroupOpacity(t *testing.T) {
+	red := recording.NewSolidBrush(gg.RGB(1, 0, 0))
+	write := backendWriter(t, 100, 80, func(b *Backend) {
+		b.BeginGroup(TransparencyGroup{Opacity: 0.5})
+		b.FillRect(recording.NewRect(0, 0, 40, 40), red)
+		b.FillRect(recording.NewRect(20, 20, 40, 40), red)
+		b.EndGroup()
+	})
+
+	content, res, r := pageContent(t, write, 0)
+	if !strings.Contains(content, "q\n/GS1 gs\n/Fm1 Do\nQ\n") {
+		t.Errorf("group is not painted with its opacity:\n%s", content)
+	}
+	if strings.Contains(content, " rg\n") {
+		t.Errorf("group content is drawn on the page:\n%s", content)
+	}
+	states, _ := res["ExtGState"].(pdfDict)
+	if state, _ := states["GS1"].(pdfDict); state["ca"] != 0.5 || state["CA"] != 0.5 {
+		t.Errorf("/GS1 = %v, want alpha 0.5", state)
+	}
+
+	form, data := formXObject(t, res, r, "Fm1")
+	if strings.Count(data, "1 0 0 rg\n") != 2 || strings.Contains(data, " gs\n") {
+		t.Errorf("group content does not hold both opaque shapes:\n%s", data)
+	}
+	if strings.Count(data, "q\n") != strings.Count(data, "Q\n") {
+		t.Errorf("group content has unbalanced q/Q:\n%s", data)
+	}
+	group, _ := form.Dict["Group"].(pdfDict)
+	if group["S"] != pdfName("Transparency") || group["I"] != nil || group["K"] != nil {
+		t.Errorf("/Group = %v, want a non-isolated, non-knockout transparency group", group)
+	}
+	if bbox, _ := form.Dict["BBox"].(pdfArray); len(bbox) != 4 || bbox[2] != 100.0 || bbox[3] != 80.0 {
+		t.Errorf("/BBox = %v, want the page", form.Dict["BBox"])
+	}
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	out, catalog := readOutput(t, buf.Bytes())
+	pagesRoot, _ := out.resolveDict(catalog["Pages"])
+	kids, _ := pagesRoot["Kids"].(pdfArray)
+	page, _ := out.resolveDict(kids[0])
+	if _, ok := page["Group"]; !ok {
+		t.Error("page with a group has no transparency group")
+	}
+}
+
+func TestTransparencyGroupOptions(t *testing.T) {
+	write := backendWriter(t, 100, 100, func(b *Backend) {
+		b.BeginGroup(TransparencyGroup{Opacity: 1, BlendMode: scene.BlendMultiply, Isolated: true, Knockout: true})
+		b.BeginGroup(TransparencyGroup{Opacity: 1})
+		b.FillRect(recording.NewRect(0, 0, 10, 10), recording.NewSolidBrush(gg.Black))
+		b.EndGroup()
+		b.EndGroup()
+	})
+
+	content, res, r := pageContent(t, write, 0)
+	if !strings.Contains(content, "q\n/GS1 gs\n/Fm1 Do\nQ\n") {
+		t.Errorf("group is not painted with its blend mode:\n%s", content)
+	}
+	states, _ := res["ExtGState"].(pdfDict)
+	if state, _ := states["GS1"].(pdfDict); state["BM"] != pdfName("Multiply") {
+		t.Errorf("/GS1 = %v, want the Multiply blend mode", state)
+	}
+	outer, data := formXObject(t, res, r, "Fm1")
+	group, _ := outer.Dict["Group"].(pdfDict)
+	if group["I"] != true || group["K"] != true {
+		t.Errorf("/Group = %v, want an isolated knockout group", group)
+	}
+	if !strings.Contains(data, "/Fm1 Do\n") {
+		t.Errorf("outer group does not paint the nested group:\n%s", data)
+	}
+	inner, _ := outer.Dict["Resources"].(pdfDict)
+	if _, data := formXObject(t, inner, r, "Fm1"); !strings.Contains(data, "0 0 0 rg\n") {
+		t.Errorf("nested group content = %q, want the fill", data)
+	}
+}
+
+func TestTransparencyGroupBalancesState(t *testing.T) {
+	write := backendWriter(t, 100, 100, func(b *Backend) {
+		b.Save()
+		b.BeginGroup(TransparencyGroup{Opacity: 0.5})
+		b.Save()
+		b.Restore()
+		b.Restore() // no matching Save in the group
+		b.Save()
+		b.SetAltText("Logo")
+		b.FillRect(recording.NewRect(0, 0, 10, 10), recording.NewSolidBrush(gg.Black))
+		b.EndGroup()
+		b.Restore()
+		b.BeginGroup(TransparencyGroup{Opacity: 0.5}) // closed by End
+		b.FillRect(recording.NewRect(0, 0, 10, 10), recording.NewSolidBrush(gg.Black))
+	})
+
+	content, res, r := pageContent(t, write, 0)
+	if strings.Count(content, "q\n") != strings.Count(content, "Q\n") {
+		t.Errorf("page content has unbalanced q/Q:\n%s", content)
+	}
+	if strings.Count(content, " Do\n") != 2 {
+		t.Errorf("page does not paint both groups:\n%s", content)
+	}
+	_, data := formXObject(t, res, r, "Fm1")
+	if strings.Count(data, "q\n") != strings.Count(data, "Q\n") || strings.Count(data, "BDC\n") != strings.Count(data, "EMC\n") {
+		t.Errorf("group content is unbalanced:\n%s", data)
+	}
+}
+
+func TestTransparencyGroupTagged(t *testing.T) {
+	doc := NewDocument()
+	page := doc.NewPage(100, 100).(*pageBackend)
+	mustStructure(t, doc.BeginStructure(StructureFigure, StructureAttributes{Alt: "Badge"}))
+	page.BeginGroup(TransparencyGroup{Opacity: 0.5})
+	page.FillRect(recording.NewRect(0, 0, 10, 10), recording.NewSolidBrush(gg.Black))
+	page.EndGroup()
+	mustStructure(t, doc.EndStructure())
+
+	content, res, r := pageContent(t, documentWriter(doc), 0)
+	if !strings.Contains(content, "/Figure << /MCID 0 >> BDC\nq\n/GS1 gs\n/Fm1 Do\nQ\nEMC\n") {
+		t.Errorf("group is not marked as one figure:\n%s", content)
+	}
+	if _, data := formXObject(t, res, r, "Fm1"); strings.Contains(data, "BDC") {
+		t.Errorf("group content is marked:\n%s", data)
+	}
+}
+
+func TestTransparencyGroupPorterDuffIsRasterized(t *testing.T) {
+	write := backendWriter(t, 100, 100, func(b *Backend) {
+		b.FillRect(recording.NewRect(0, 0, 50, 50), recording.NewSolidBrush(gg.RGB(1, 0, 0)))
+		b.BeginGroup(TransparencyGroup{Opacity: 1, BlendMode: scene.BlendDestinationOut})
+		b.FillRect(recording.NewRect(25, 25, 50, 50), recording.NewSolidBrush(gg.Black))
+		b.EndGroup()
+	})
+
+	content, res, _ := pageContent(t, write, 0)
+	xobjects, _ := res["XObject"].(pdfDict)
+	if _, ok := xobjects["Fm1"]; ok || !strings.Contains(content, "/Im1 Do\n") {
+		t.Errorf("Porter-Duff group is not painted as an image:\n%s", content)
+	}
+}
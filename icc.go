@@ -0,0 +1,598 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// srgbOutputCondition identifies the sRGB output condition in OutputIntents.
+const srgbOutputCondition = "sRGB IEC61966-2.1"
+
+// srgbProfile returns a compact ICC version 2 display profile for sRGB. It is
+// generated rather than shipped so that the package stays self-contained;
+// the colorants are the D50-adapted sRGB primaries and the tone curve is the
+// sRGB transfer function sampled at 1024 points.
+var srgbProfile = sync.OnceValue(func() []byte {
+	curve := srgbToneCurve()
+	return buildDisplayProfile("RGB ", []iccTag{
+		{"desc", iccDescription(srgbOutputCondition)},
+		{"cprt", iccText("No copyright, use freely")},
+		{"wtpt", iccXYZ(0.9642, 1.0, 0.8249)},
+		{"rXYZ", iccXYZ(0.4361, 0.2225, 0.0139)},
+		{"gXYZ", iccXYZ(0.3851, 0.7169, 0.0971)},
+		{"bXYZ", iccXYZ(0.1431, 0.0606, 0.7141)},
+		{"rTRC", curve},
+		{"gTRC", curve},
+		{"bTRC", curve},
+	})
+})
+
+// sgrayProfile returns a gray display profile with the sRGB tone curve, the
+// gray counterpart of srgbProfile for grayscale images.
+var sgrayProfile = sync.OnceValue(func() []byte {
+	return buildDisplayProfile("GRAY", []iccTag{
+		{"desc", iccDescription("sGray")},
+		{"cprt", iccText("No copyright, use freely")},
+		{"wtpt", iccXYZ(0.9642, 1.0, 0.8249)},
+		{"kTRC", srgbToneCurve()},
+	})
+})
+
+// iccTag is one tagged element of a generated profile.
+type iccTag struct {
+	sig  string
+	data []byte
+}
+
+func iccXYZ(x, y, z float64) []byte {
+	b := []byte("XYZ \x00\x00\x00\x00")
+	for _, v := range []float64{x, y, z} {
+		b = binary.BigEndian.AppendUint32(b, uint32(int32(math.Round(v*65536))))
+	}
+	return b
+}
+
+func iccText(s string) []byte {
+	return append([]byte("text\x00\x00\x00\x00"), s+"\x00"...)
+}
+
+func iccDescription(s string) []byte {
+	b := []byte("desc\x00\x00\x00\x00")
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)+1))
+	b = append(b, s...)
+	b = append(b, 0)
+	// Empty Unicode and ScriptCode descriptions.
+	b = append(b, make([]byte, 4+4+2+1+67)...)
+	return b
+}
+
+// srgbToneCurve samples the sRGB transfer function.
+func srgbToneCurve() []byte {
+	const n = 1024
+	b := []byte("curv\x00\x00\x00\x00")
+	b = binary.BigEndian.AppendUint32(b, n)
+	for i := 0; i < n; i++ {
+		v := float64(i) / (n - 1)
+		if v <= 0.04045 {
+			v /= 12.92
+		} else {
+			v = math.Pow((v+0.055)/1.055, 2.4)
+		}
+		b = binary.BigEndian.AppendUint16(b, uint16(math.Round(v*65535)))
+	}
+	return b
+}
+
+// buildDisplayProfile lays out a version 2.1 display profile for the given
+// color space. Tag data follows the header and tag table, 4-byte aligned;
+// tags with identical data share one copy.
+func buildDisplayProfile(space string, tags []iccTag) []byte {
+	offset := 128 + 4 + 12*len(tags)
+	var table, data bytes.Buffer
+	table.Write(binary.BigEndian.AppendUint32(nil, uint32(len(tags))))
+	for i, tag := range tags {
+		at := offset + data.Len()
+		shared := false
+		for j := range i {
+			if bytes.Equal(tags[j].data, tag.data) {
+				at, shared = int(binary.BigEndian.Uint32(table.Bytes()[4+12*j+4:])), true
+				break
+			}
+		}
+		if !shared {
+			data.Write(tag.data)
+			for data.Len()%4 != 0 {
+				data.WriteByte(0)
+			}
+		}
+		table.WriteString(tag.sig)
+		table.Write(binary.BigEndian.AppendUint32(nil, uint32(at)))
+		table.Write(binary.BigEndian.AppendUint32(nil, uint32(len(tag.data))))
+	}
+
+	size := offset + data.Len()
+	header := make([]byte, 128)
+	binary.BigEndian.PutUint32(header[0:], uint32(size))
+	binary.BigEndian.PutUint32(header[8:], 0x02100000) // version 2.1
+	copy(header[12:], "mntr")
+	copy(header[16:], space)
+	copy(header[20:], "XYZ ")
+	// Creation date: 2026-01-01 00:00:00.
+	for i, v := range []uint16{2026, 1, 1, 0, 0, 0} {
+		binary.BigEndian.PutUint16(header[24+2*i:], v)
+	}
+	copy(header[36:], "acsp")
+	copy(header[68:], iccXYZ(0.9642, 1.0, 0.8249)[8:])
+
+	profile := make([]byte, 0, size)
+	profile = append(profile, header...)
+	profile = append(profile, table.Bytes()...)
+	profile = append(profile, data.Bytes()...)
+	return profile
+}
+
+// iccTransform converts sRGB colors to the device space of an output ICC
+// profile through the profile's perceptual PCS-to-device table, or for gray
+// profiles without one, by inverting the gray tone curve.
+type iccTransform struct {
+	channels int
+	pcsLab   bool
+	lut      *iccLut
+	grayTRC  iccCurve
+}
+
+// newICCTransform parses profile as an output profile for the given device
+// color space signature ("CMYK" or "GRAY").
+func newICCTransform(profile []byte, space string) (*iccTransform, error) {
+	if len(profile) < 132 || string(profile[36:40]) != "acsp" {
+		return nil, fmt.Errorf("pdf: color profile is not an ICC profile")
+	}
+	if got := string(profile[16:20]); got != space {
+		return nil, fmt.Errorf("pdf: color profile has color space %q, want %q", got, space)
+	}
+	t := &iccTransform{pcsLab: string(profile[20:24]) == "Lab "}
+	switch space {
+	case "CMYK":
+		t.channels = 4
+	case "GRAY":
+		t.channels = 1
+	}
+
+	tags := make(map[string][]byte)
+	count := int(binary.BigEndian.Uint32(profile[128:]))
+	for i := 0; i < count && 132+12*(i+1) <= len(profile); i++ {
+		entry := profile[132+12*i:]
+		offset := int(binary.BigEndian.Uint32(entry[4:]))
+		size := int(binary.BigEndian.Uint32(entry[8:]))
+		if offset < 0 || size < 0 || offset+size > len(profile) || offset+size < offset {
+			return nil, fmt.Errorf("pdf: color profile tag %q is out of bounds", entry[:4])
+		}
+		tags[string(entry[:4])] = profile[offset : offset+size]
+	}
+
+	if data, ok := tags["B2A0"]; ok {
+		lut, err := parseICCLut(data)
+		if err != nil {
+			return nil, err
+		}
+		if lut.inputs != 3 || lut.outputs != t.channels {
+			return nil, fmt.Errorf("pdf: color profile table maps %d to %d channels, want 3 to %d",
+				lut.inputs, lut.outputs, t.channels)
+		}
+		t.lut = lut
+		return t, nil
+	}
+	if data, ok := tags["kTRC"]; ok && t.channels == 1 {
+		curve, _, err := parseICCCurve(data)
+		if err != nil {
+			return nil, err
+		}
+		t.grayTRC = curve
+		return t, nil
+	}
+	return nil, fmt.Errorf("pdf: color profile has no perceptual rendering table (B2A0)")
+}
+
+// convert returns the device components for an sRGB color.
+func (t *iccTransform) convert(r, g, b float64) []float64 {
+	x, y, z := srgbToXYZ(r, g, b)
+	if t.lut == nil {
+		// Gray tone curves map device values to luminance (XYZ) or
+		// lightness (Lab); invert by bisection, as curves are monotonic.
+		target := y
+		if t.pcsLab {
+			l, _, _ := xyzToLab(x, y, z)
+			target = l / 100
+		}
+		lo, hi := 0.0, 1.0
+		increasing := t.grayTRC.eval(1) >= t.grayTRC.eval(0)
+		for range 32 {
+			mid := (lo + hi) / 2
+			if (t.grayTRC.eval(mid) < target) == increasing {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		return []float64{(lo + hi) / 2}
+	}
+
+	var in []float64
+	if t.pcsLab {
+		l, a, bb := xyzToLab(x, y, z)
+		if t.lut.legacyLab {
+			// Version 2 16-bit Lab encodes L* 100 as 0xFF00.
+			in = []float64{l * 652.8 / 65535, (a + 128) * 256 / 65535, (bb + 128) * 256 / 65535}
+		} else {
+			in = []float64{l / 100, (a + 128) / 255, (bb + 128) / 255}
+		}
+	} else {
+		// XYZ is encoded as u1Fixed15, where 1 + 32767/32768 is full scale.
+		const scale = 1 + 32767.0/32768
+		in = []float64{x / scale, y / scale, z / scale}
+	}
+	out := t.lut.eval(in)
+	for i := range out {
+		out[i] = clamp01(out[i])
+	}
+	return out
+}
+
+// srgbToXYZ converts an sRGB color to D50 XYZ, the profile connection space.
+func srgbToXYZ(r, g, b float64) (x, y, z float64) {
+	lin := func(v float64) float64 {
+		v = clamp01(v)
+		if v <= 0.04045 {
+			return v / 12.92
+		}
+		return math.Pow((v+0.055)/1.055, 2.4)
+	}
+	r, g, b = lin(r), lin(g), lin(b)
+	x = 0.4360747*r + 0.3850649*g + 0.1430804*b
+	y = 0.2225045*r + 0.7168786*g + 0.0606169*b
+	z = 0.0139322*r + 0.0971045*g + 0.7141733*b
+	return x, y, z
+}
+
+// xyzToLab converts D50 XYZ to CIELAB.
+func xyzToLab(x, y, z float64) (l, a, b float64) {
+	f := func(t float64) float64 {
+		if t > 216.0/24389 {
+			return math.Cbrt(t)
+		}
+		return (24389.0/27*t + 16) / 116
+	}
+	fx, fy, fz := f(x/0.9642), f(y), f(z/0.8249)
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
+
+// iccLut is a multidimensional lookup table: lut8Type, lut16Type, or
+// lutBToAType. Stages that a table type lacks are left nil.
+type iccLut struct {
+	inputs, outputs int
+	legacyLab       bool // lut16Type uses the version 2 Lab encoding
+
+	matrix     []float64 // 3x3, or 3x3 plus offsets
+	preCurves  []iccCurve
+	midCurves  []iccCurve
+	grid       []int
+	clut       []float64
+	postCurves []iccCurve
+
+	// matrixFirst is set for lut8Type and lut16Type, whose matrix precedes
+	// the input curves; lutBToAType applies it after them.
+	matrixFirst bool
+}
+
+// parseICCLut parses a lut8Type, lut16Type, or lutBToAType tag.
+func parseICCLut(data []byte) (*iccLut, error) {
+	bad := fmt.Errorf("pdf: color profile lookup table is malformed")
+	if len(data) < 32 {
+		return nil, bad
+	}
+	lut := &iccLut{inputs: int(data[8]), outputs: int(data[9])}
+	if lut.inputs < 1 || lut.inputs > 4 || lut.outputs < 1 || lut.outputs > 15 {
+		return nil, bad
+	}
+	s15 := func(b []byte) float64 { return float64(int32(binary.BigEndian.Uint32(b))) / 65536 }
+
+	switch string(data[:4]) {
+	case "mft1", "mft2":
+		if len(data) < 52 {
+			return nil, bad
+		}
+		wide := string(data[:4]) == "mft2"
+		points := int(data[10])
+		lut.matrixFirst = true
+		lut.legacyLab = wide
+		lut.matrix = make([]float64, 9)
+		for i := range lut.matrix {
+			lut.matrix[i] = s15(data[12+4*i:])
+		}
+		inEntries, outEntries, size, pos := 256, 256, 1, 48
+		if wide {
+			inEntries, outEntries = int(binary.BigEndian.Uint16(data[48:])), int(binary.BigEndian.Uint16(data[50:]))
+			size, pos = 2, 52
+		}
+		read := func(n int) ([]float64, bool) {
+			if n < 0 || pos+n*size > len(data) {
+				return nil, false
+			}
+			v := make([]float64, n)
+			for i := range v {
+				if size == 2 {
+					v[i] = float64(binary.BigEndian.Uint16(data[pos+2*i:])) / 65535
+				} else {
+					v[i] = float64(data[pos+i]) / 255
+				}
+			}
+			pos += n * size
+			return v, true
+		}
+		for range lut.inputs {
+			table, ok := read(inEntries)
+			if !ok || inEntries < 2 {
+				return nil, bad
+			}
+			lut.preCurves = append(lut.preCurves, iccCurve{table: table})
+		}
+		lut.grid = make([]int, lut.inputs)
+		cells := lut.outputs
+		for i := range lut.grid {
+			lut.grid[i] = points
+			cells *= points
+		}
+		clut, ok := read(cells)
+		if !ok || points < 2 {
+			return nil, bad
+		}
+		lut.clut = clut
+		for range lut.outputs {
+			table, ok := read(outEntries)
+			if !ok || outEntries < 2 {
+				return nil, bad
+			}
+			lut.postCurves = append(lut.postCurves, iccCurve{table: table})
+		}
+		return lut, nil
+
+	case "mBA ":
+		offset := func(at int) int { return int(binary.BigEndian.Uint32(data[at:])) }
+		curves := func(at, n int) ([]iccCurve, error) {
+			var out []iccCurve
+			pos := offset(at)
+			for range n {
+				if pos <= 0 || pos >= len(data) {
+					return nil, bad
+				}
+				curve, size, err := parseICCCurve(data[pos:])
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, curve)
+				pos += (size + 3) &^ 3
+			}
+			return out, nil
+		}
+		var err error
+		if lut.preCurves, err = curves(12, lut.inputs); err != nil {
+			return nil, err
+		}
+		if at := offset(16); at != 0 {
+			if at+48 > len(data) {
+				return nil, bad
+			}
+			lut.matrix = make([]float64, 12)
+			for i := range lut.matrix {
+				lut.matrix[i] = s15(data[at+4*i:])
+			}
+		}
+		if offset(20) != 0 {
+			if lut.midCurves, err = curves(20, lut.inputs); err != nil {
+				return nil, err
+			}
+		}
+		if at := offset(24); at != 0 {
+			if at+20 > len(data) {
+				return nil, bad
+			}
+			lut.grid = make([]int, lut.inputs)
+			cells := lut.outputs
+			for i := range lut.grid {
+				lut.grid[i] = int(data[at+i])
+				if lut.grid[i] < 2 {
+					return nil, bad
+				}
+				cells *= lut.grid[i]
+			}
+			size := int(data[at+16])
+			pos := at + 20
+			if (size != 1 && size != 2) || pos+cells*size > len(data) {
+				return nil, bad
+			}
+			lut.clut = make([]float64, cells)
+			for i := range lut.clut {
+				if size == 2 {
+					lut.clut[i] = float64(binary.BigEndian.Uint16(data[pos+2*i:])) / 65535
+				} else {
+					lut.clut[i] = float64(data[pos+i]) / 255
+				}
+			}
+		} else if lut.inputs != lut.outputs {
+			return nil, bad
+		}
+		if offset(28) != 0 {
+			if lut.postCurves, err = curves(28, lut.outputs); err != nil {
+				return nil, err
+			}
+		}
+		return lut, nil
+	}
+	return nil, fmt.Errorf("pdf: color profile lookup table type %q is not supported", data[:4])
+}
+
+// eval runs in, normalized to [0 1], through the table.
+func (l *iccLut) eval(in []float64) []float64 {
+	v := append([]float64(nil), in...)
+	applyMatrix := func() {
+		if l.matrix == nil || len(v) != 3 {
+			return
+		}
+		m := l.matrix
+		out := make([]float64, 3)
+		for i := range 3 {
+			out[i] = m[3*i]*v[0] + m[3*i+1]*v[1] + m[3*i+2]*v[2]
+			if len(m) == 12 {
+				out[i] += m[9+i]
+			}
+			out[i] = clamp01(out[i])
+		}
+		v = out
+	}
+	curves := func(cs []iccCurve) {
+		for i := range cs {
+			if i < len(v) {
+				v[i] = cs[i].eval(v[i])
+			}
+		}
+	}
+
+	if l.matrixFirst {
+		applyMatrix()
+		curves(l.preCurves)
+	} else {
+		curves(l.preCurves)
+		applyMatrix()
+		curves(l.midCurves)
+	}
+	if l.clut != nil {
+		v = l.interpolate(v)
+	}
+	curves(l.postCurves)
+	return v
+}
+
+// interpolate looks up v in the color table by multilinear interpolation.
+func (l *iccLut) interpolate(v []float64) []float64 {
+	n := len(l.grid)
+	base := make([]int, n)
+	frac := make([]float64, n)
+	for i := range n {
+		p := clamp01(v[i]) * float64(l.grid[i]-1)
+		base[i] = min(int(p), l.grid[i]-2)
+		frac[i] = p - float64(base[i])
+	}
+	out := make([]float64, l.outputs)
+	for corner := 0; corner < 1<<n; corner++ {
+		weight, index := 1.0, 0
+		for i := range n {
+			index *= l.grid[i]
+			if corner&(1<<(n-1-i)) != 0 {
+				weight *= frac[i]
+				index += base[i] + 1
+			} else {
+				weight *= 1 - frac[i]
+				index += base[i]
+			}
+		}
+		if weight == 0 {
+			continue
+		}
+		for o := range out {
+			out[o] += weight * l.clut[index*l.outputs+o]
+		}
+	}
+	return out
+}
+
+// iccCurve is a tone curve: a sampled table, a gamma, or a parametric
+// function. The zero value is the identity.
+type iccCurve struct {
+	table  []float64
+	gamma  float64
+	params []float64 // parametric function type and parameters
+}
+
+// parseICCCurve parses a curveType or parametricCurveType and returns it
+// with its size in bytes.
+func parseICCCurve(data []byte) (iccCurve, int, error) {
+	bad := fmt.Errorf("pdf: color profile curve is malformed")
+	if len(data) < 12 {
+		return iccCurve{}, 0, bad
+	}
+	switch string(data[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(data[8:]))
+		size := 12 + 2*n
+		if n < 0 || size > len(data) {
+			return iccCurve{}, 0, bad
+		}
+		switch n {
+		case 0:
+			return iccCurve{}, size, nil
+		case 1:
+			return iccCurve{gamma: float64(binary.BigEndian.Uint16(data[12:])) / 256}, size, nil
+		}
+		table := make([]float64, n)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(data[12+2*i:])) / 65535
+		}
+		return iccCurve{table: table}, size, nil
+	case "para":
+		kind := int(binary.BigEndian.Uint16(data[8:]))
+		counts := []int{1, 3, 4, 5, 7}
+		if kind >= len(counts) || 12+4*counts[kind] > len(data) {
+			return iccCurve{}, 0, bad
+		}
+		params := []float64{float64(kind)}
+		for i := range counts[kind] {
+			params = append(params, float64(int32(binary.BigEndian.Uint32(data[12+4*i:])))/65536)
+		}
+		return iccCurve{params: params}, 12 + 4*counts[kind], nil
+	}
+	return iccCurve{}, 0, fmt.Errorf("pdf: color profile curve type %q is not supported", data[:4])
+}
+
+// eval applies the curve to x in [0 1].
+func (c iccCurve) eval(x float64) float64 {
+	x = clamp01(x)
+	switch {
+	case c.table != nil:
+		p := x * float64(len(c.table)-1)
+		i := min(int(p), len(c.table)-2)
+		return c.table[i] + (p-float64(i))*(c.table[i+1]-c.table[i])
+	case c.gamma != 0:
+		return math.Pow(x, c.gamma)
+	case c.params != nil:
+		p := c.params[1:]
+		g := p[0]
+		switch int(c.params[0]) {
+		case 0:
+			return math.Pow(x, g)
+		case 1:
+			if x >= -p[2]/p[1] {
+				return math.Pow(p[1]*x+p[2], g)
+			}
+			return 0
+		case 2:
+			if x >= -p[2]/p[1] {
+				return math.Pow(p[1]*x+p[2], g) + p[3]
+			}
+			return p[3]
+		case 3:
+			if x >= p[4] {
+				return math.Pow(p[1]*x+p[2], g)
+			}
+			return p[3] * x
+		case 4:
+			if x >= p[4] {
+				return math.Pow(p[1]*x+p[2], g) + p[5]
+			}
+			return p[3]*x + p[6]
+		}
+	}
+	return x
+}
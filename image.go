@@ -0,0 +1,263 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg" // registered for DecodeImage
+	_ "image/png"  // registered for DecodeImage
+	"io"
+)
+
+// imageKey identifies an image for reuse across draws and pages by the
+// hash of its pixels, so that the cache does not keep the images drawn
+// alive.
+type imageKey struct {
+	sum      [32]byte
+	space    pdfObject
+	channels int
+}
+
+// imageHash returns a hash of the pixels of img inside r. The pixels of the
+// common image types are hashed as stored; those of other types by their
+// 16-bit colors.
+func imageHash(img image.Image, r image.Rectangle) [32]byte {
+	h := sha256.New()
+	fmt.Fprintf(h, "%T %v\n", img, r)
+	var (
+		pix    []byte
+		stride int
+		size   int // bytes per pixel
+	)
+	switch m := img.(type) {
+	case *image.RGBA:
+		pix, stride, size = m.Pix[m.PixOffset(r.Min.X, r.Min.Y):], m.Stride, 4
+	case *image.NRGBA:
+		pix, stride, size = m.Pix[m.PixOffset(r.Min.X, r.Min.Y):], m.Stride, 4
+	case *image.Gray:
+		pix, stride, size = m.Pix[m.PixOffset(r.Min.X, r.Min.Y):], m.Stride, 1
+	case *image.CMYK:
+		pix, stride, size = m.Pix[m.PixOffset(r.Min.X, r.Min.Y):], m.Stride, 4
+	}
+	if pix != nil {
+		for y := 0; y < r.Dy(); y++ {
+			h.Write(pix[y*stride : y*stride+r.Dx()*size])
+		}
+		return [32]byte(h.Sum(nil))
+	}
+	var px [8]byte
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			cr, cg, cb, ca := img.At(x, y).RGBA()
+			binary.BigEndian.PutUint16(px[0:], uint16(cr))
+			binary.BigEndian.PutUint16(px[2:], uint16(cg))
+			binary.BigEndian.PutUint16(px[4:], uint16(cb))
+			binary.BigEndian.PutUint16(px[6:], uint16(ca))
+			h.Write(px[:])
+		}
+	}
+	return [32]byte(h.Sum(nil))
+}
+
+// subImage returns the part of img inside r.
+func subImage(img image.Image, r image.Rectangle) image.Image {
+	if r == img.Bounds() {
+		return img
+	}
+	if s, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return s.SubImage(r)
+	}
+	return &croppedImage{Image: img, rect: r}
+}
+
+// croppedImage restricts an image without SubImage support to a rectangle.
+type croppedImage struct {
+	image.Image
+	rect image.Rectangle
+}
+
+func (c *croppedImage) Bounds() image.Rectangle { return c.rect }
+
+// ProfiledImage is an image together with the ICC profile that describes
+// its colors, such as one embedded in a PNG or JPEG file. DrawImage writes
+// it in an ICC-based color space with that profile, so it keeps its colors
+// whatever color management the document uses. The profile must be an RGB
+// profile, or match grayscale or CMYK pixels; otherwise it is ignored.
+type ProfiledImage struct {
+	image.Image
+	Profile []byte
+}
+
+// DecodeImage decodes a PNG or JPEG image. If the file embeds an ICC
+// profile, the result is a *ProfiledImage carrying it.
+func DecodeImage(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("pdf: failed to read image: %w", err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("pdf: failed to decode image: %w", err)
+	}
+	if profile := embeddedProfile(data); profile != nil {
+		return &ProfiledImage{Image: img, Profile: profile}, nil
+	}
+	return img, nil
+}
+
+// embeddedProfile extracts the ICC profile from PNG or JPEG data, or
+// returns nil if there is none or it is not a valid profile.
+func embeddedProfile(data []byte) []byte {
+	var profile []byte
+	switch {
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		profile = pngProfile(data[8:])
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		profile = jpegProfile(data[2:])
+	}
+	if _, err := iccComponents(profile); err != nil {
+		return nil
+	}
+	return profile
+}
+
+// pngProfile returns the decompressed iCCP chunk of a PNG file.
+func pngProfile(data []byte) []byte {
+	for len(data) >= 12 {
+		n := int(binary.BigEndian.Uint32(data))
+		kind := string(data[4:8])
+		if n < 0 || 12+n > len(data) || kind == "IDAT" {
+			return nil
+		}
+		if kind == "iCCP" {
+			chunk := data[8 : 8+n]
+			// Profile name, null separator, compression method 0 (zlib).
+			i := bytes.IndexByte(chunk, 0)
+			if i < 0 || i+2 > len(chunk) || chunk[i+1] != 0 {
+				return nil
+			}
+			zr, err := zlib.NewReader(bytes.NewReader(chunk[i+2:]))
+			if err != nil {
+				return nil
+			}
+			profile, err := io.ReadAll(zr)
+			if err != nil {
+				return nil
+			}
+			return profile
+		}
+		data = data[12+n:]
+	}
+	return nil
+}
+
+// jpegProfile reassembles the ICC profile from the APP2 segments of a JPEG
+// file, which carry it in numbered chunks.
+func jpegProfile(data []byte) []byte {
+	const marker = "ICC_PROFILE\x00"
+	chunks := map[int][]byte{}
+	count := 0
+	for len(data) >= 4 && data[0] == 0xFF {
+		kind := data[1]
+		if kind == 0xDA || kind == 0xD9 { // start of scan, end of image
+			break
+		}
+		n := int(binary.BigEndian.Uint16(data[2:]))
+		if n < 2 || 2+n > len(data) {
+			return nil
+		}
+		segment := data[4 : 2+n]
+		if kind == 0xE2 && len(segment) > len(marker)+2 && string(segment[:len(marker)]) == marker {
+			seq := int(segment[len(marker)])
+			count = int(segment[len(marker)+1])
+			chunks[seq] = segment[len(marker)+2:]
+		}
+		data = data[2+n:]
+	}
+	if count == 0 || len(chunks) != count {
+		return nil
+	}
+	var profile []byte
+	for seq := 1; seq <= count; seq++ {
+		chunk, ok := chunks[seq]
+		if !ok {
+			return nil
+		}
+		profile = append(profile, chunk...)
+	}
+	return profile
+}
+
+// iccComponents returns the number of color components of an ICC profile.
+func iccComponents(profile []byte) (int, error) {
+	if len(profile) < 128 || string(profile[36:40]) != "acsp" {
+		return 0, fmt.Errorf("pdf: image profile is not an ICC profile")
+	}
+	switch string(profile[16:20]) {
+	case "GRAY":
+		return 1, nil
+	case "RGB ":
+		return 3, nil
+	case "CMYK":
+		return 4, nil
+	}
+	return 0, fmt.Errorf("pdf: image profile has unsupported color space %q", profile[16:20])
+}
+
+// imageXObject encodes img as an image XObject in the given color space
+// with the given number of components: gray, RGB, or CMYK pixels. Any
+// alpha becomes a soft mask.
+func imageXObject(img image.Image, space pdfObject, channels int) *pdfStream {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	pixels := make([]byte, 0, w*h*channels)
+	alpha := make([]byte, 0, w*h)
+	opaque := true
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if channels == 4 {
+				c := color.CMYKModel.Convert(img.At(x, y)).(color.CMYK)
+				pixels = append(pixels, c.C, c.M, c.Y, c.K)
+				alpha = append(alpha, 0xFF)
+				continue
+			}
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if channels == 1 {
+				pixels = append(pixels, c.R)
+			} else {
+				pixels = append(pixels, c.R, c.G, c.B)
+			}
+			alpha = append(alpha, c.A)
+			if c.A != 0xFF {
+				opaque = false
+			}
+		}
+	}
+
+	dict := pdfDict{
+		"Type":             pdfName("XObject"),
+		"Subtype":          pdfName("Image"),
+		"Width":            w,
+		"Height":           h,
+		"ColorSpace":       space,
+		"BitsPerComponent": 8,
+	}
+	if !opaque {
+		dict["SMask"] = flateStream(pdfDict{
+			"Type":             pdfName("XObject"),
+			"Subtype":          pdfName("Image"),
+			"Width":            w,
+			"Height":           h,
+			"ColorSpace":       pdfName("DeviceGray"),
+			"BitsPerComponent": 8,
+		}, alpha)
+	}
+	return flateStream(dict, pixels)
+}
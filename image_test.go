@@ -0,0 +1,246 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/gogpu/gg"
+	"github.com/gogpu/gg/recording"
+)
+
+// imageSpaces returns the color spaces of the page's image XObjects.
+func imageSpaces(t *testing.T, res pdfDict, r *pdfReader) map[pdfName]pdfObject {
+	t.Helper()
+
+	xobjects, _ := res["XObject"].(pdfDict)
+	spaces := make(map[pdfName]pdfObject, len(xobjects))
+	for name, ref := range xobjects {
+		obj, _ := r.resolve(ref)
+		im, ok := obj.(*pdfStream)
+		if !ok {
+			t.Fatalf("/%s = %v, want an image stream", name, obj)
+		}
+		spaces[name] = im.Dict["ColorSpace"]
+	}
+	return spaces
+}
+
+// iccSpaceProfile resolves an ICCBased color space and returns its number
+// of components and its profile.
+func iccSpaceProfile(t *testing.T, r *pdfReader, space pdfObject) (int, []byte) {
+	t.Helper()
+
+	obj, _ := r.resolve(space)
+	arr, _ := obj.(pdfArray)
+	if len(arr) != 2 || arr[0] != pdfName("ICCBased") {
+		t.Fatalf("color space = %v, want an ICCBased space", obj)
+	}
+	obj, _ = r.resolve(arr[1])
+	stream, ok := obj.(*pdfStream)
+	if !ok {
+		t.Fatalf("ICCBased space has no profile stream: %v", arr[1])
+	}
+	profile, err := decodeStream(stream)
+	if err != nil {
+		t.Fatalf("failed to decode profile: %v", err)
+	}
+	n, _ := stream.Dict["N"].(int)
+	return n, profile
+}
+
+func TestICCBasedColorsAndImages(t *testing.T) {
+	doc := NewDocument()
+	if err := doc.SetColorManagement(ColorManagement{ICCBased: true}); err != nil {
+		t.Fatalf("SetColorManagement failed: %v", err)
+	}
+	rgb := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	gray := image.NewGray(image.Rect(0, 0, 2, 2))
+	for range 2 {
+		page := doc.NewPage(100, 100)
+		page.FillRect(recording.NewRect(0, 0, 10, 10), recording.NewSolidBrush(gg.RGB(1, 0, 0)))
+		page.DrawImage(rgb, recording.NewRect(0, 0, 2, 2), recording.NewRect(0, 0, 20, 20), recording.DefaultImageOptions())
+		page.DrawImage(gray, recording.NewRect(0, 0, 2, 2), recording.NewRect(20, 0, 20, 20), recording.DefaultImageOptions())
+	}
+
+	var spaceRefs []pdfObject
+	for i := range 2 {
+		content, res, r := pageContent(t, documentWriter(doc), i)
+		if !bytes.Contains([]byte(content), []byte("/CS1 cs\n1 0 0 scn\n")) {
+			t.Errorf("page %d: fill is not set in the ICC-based space:\n%s", i+1, content)
+		}
+		spaces, _ := res["ColorSpace"].(pdfDict)
+		n, profile := iccSpaceProfile(t, r, spaces["CS1"])
+		if n != 3 || !bytes.Equal(profile, srgbProfile()) {
+			t.Errorf("page %d: fill space has N %d, want the sRGB profile", i+1, n)
+		}
+		images := imageSpaces(t, res, r)
+		if images["Im1"] != spaces["CS1"] {
+			t.Errorf("page %d: RGB image space = %v, want the fill space %v", i+1, images["Im1"], spaces["CS1"])
+		}
+		if n, profile := iccSpaceProfile(t, r, images["Im2"]); n != 1 || !bytes.Equal(profile, sgrayProfile()) {
+			t.Errorf("page %d: gray image space has N %d, want the sGray profile", i+1, n)
+		}
+		spaceRefs = append(spaceRefs, spaces["CS1"])
+	}
+	if spaceRefs[0] != spaceRefs[1] {
+		t.Errorf("pages use color spaces %v and %v, want one shared object", spaceRefs[0], spaceRefs[1])
+	}
+}
+
+func TestICCBasedSourceProfile(t *testing.T) {
+	source := testICCProfile("RGB ", "XYZ ", map[string][]byte{"desc": []byte("test")})
+	write := backendWriter(t, 100, 100, func(b *Backend) {
+		if err := b.SetColorManagement(ColorManagement{ICCBased: true, SourceProfile: source}); err != nil {
+			t.Fatalf("SetColorManagement failed: %v", err)
+		}
+		b.FillRect(recording.NewRect(0, 0, 10, 10), recording.NewSolidBrush(gg.Black))
+	})
+
+	_, res, r := pageContent(t, write, 0)
+	spaces, _ := res["ColorSpace"].(pdfDict)
+	if _, profile := iccSpaceProfile(t, r, spaces["CS1"]); !bytes.Equal(profile, source) {
+		t.Error("ICC-based space does not embed the source profile")
+	}
+}
+
+func TestICCBasedValidation(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		cm   ColorManagement
+	}{
+		{"source profile without ICCBased", ColorManagement{SourceProfile: srgbProfile()}},
+		{"invalid source profile", ColorManagement{ICCBased: true, SourceProfile: []byte("not a profile")}},
+		{"gray source profile", ColorManagement{ICCBased: true, SourceProfile: sgrayProfile()}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := NewDocument().SetColorManagement(tt.cm); err == nil {
+				t.Error("SetColorManagement succeeded")
+			}
+		})
+	}
+}
+
+func TestProfiledImageKeepsItsProfile(t *testing.T) {
+	adobe := testICCProfile("RGB ", "XYZ ", map[string][]byte{"desc": []byte("wide gamut")})
+	press := testICCProfile("CMYK", "Lab ", map[string][]byte{"A2B0": testCMYKLut()})
+	cmyk := image.NewCMYK(image.Rect(0, 0, 2, 2))
+	cmyk.SetCMYK(0, 0, color.CMYK{C: 0xFF})
+
+	write := backendWriter(t, 100, 100, func(b *Backend) {
+		src, dst := recording.NewRect(0, 0, 2, 2), recording.NewRect(0, 0, 20, 20)
+		b.DrawImage(&ProfiledImage{Image: image.NewRGBA(image.Rect(0, 0, 2, 2)), Profile: adobe}, src, dst, recording.DefaultImageOptions())
+		b.DrawImage(&ProfiledImage{Image: cmyk, Profile: press}, src, dst, recording.DefaultImageOptions())
+		b.DrawImage(cmyk, src, dst, recording.DefaultImageOptions())
+	})
+
+	_, res, r := pageContent(t, write, 0)
+	images := imageSpaces(t, res, r)
+	if n, profile := iccSpaceProfile(t, r, images["Im1"]); n != 3 || !bytes.Equal(profile, adobe) {
+		t.Errorf("RGB image has N %d, want its own RGB profile", n)
+	}
+	if n, profile := iccSpaceProfile(t, r, images["Im2"]); n != 4 || !bytes.Equal(profile, press) {
+		t.Errorf("CMYK image has N %d, want its own CMYK profile", n)
+	}
+	if images["Im3"] != pdfName("DeviceRGB") {
+		t.Errorf("CMYK image without a profile is in %v, want it converted to DeviceRGB", images["Im3"])
+	}
+
+	xobjects, _ := res["XObject"].(pdfDict)
+	obj, _ := r.resolve(xobjects["Im2"])
+	pixels, _ := decodeStream(obj.(*pdfStream))
+	if len(pixels) != 16 || pixels[0] != 0xFF || pixels[1] != 0 {
+		t.Errorf("CMYK image data = %v, want the CMYK pixels", pixels)
+	}
+}
+
+func TestProfiledImageRejectsInvalidProfile(t *testing.T) {
+	backend := NewBackend()
+	if err := backend.Begin(10, 10); err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	img := &ProfiledImage{Image: image.NewRGBA(image.Rect(0, 0, 1, 1)), Profile: []byte("junk")}
+	backend.DrawImage(img, recording.NewRect(0, 0, 1, 1), recording.NewRect(0, 0, 10, 10), recording.DefaultImageOptions())
+	if err := backend.End(); err == nil {
+		t.Error("End succeeded after drawing an image with an invalid profile")
+	}
+}
+
+// pngChunk encodes a PNG chunk with its length and checksum.
+func pngChunk(kind string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, kind...)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+func TestDecodeImageProfiles(t *testing.T) {
+	profile := testICCProfile("RGB ", "XYZ ", map[string][]byte{"desc": []byte("embedded")})
+	img := image.NewRGBA(image.Rect(0, 0, 3, 2))
+
+	var plain bytes.Buffer
+	if err := png.Encode(&plain, img); err != nil {
+		t.Fatal(err)
+	}
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	zw.Write(profile)
+	zw.Close()
+	// The iCCP chunk follows the 8-byte signature and the 25-byte IHDR chunk.
+	iccp := pngChunk("iCCP", append([]byte("ICC Profile\x00\x00"), compressed.Bytes()...))
+	withPNG := append(append(append([]byte{}, plain.Bytes()[:33]...), iccp...), plain.Bytes()[33:]...)
+
+	var jpg bytes.Buffer
+	if err := jpeg.Encode(&jpg, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Split the profile across two APP2 segments after the SOI marker.
+	var app2 []byte
+	half := len(profile) / 2
+	for i, part := range [][]byte{profile[:half], profile[half:]} {
+		segment := append([]byte("ICC_PROFILE\x00"), byte(i+1), 2)
+		segment = append(segment, part...)
+		app2 = append(app2, 0xFF, 0xE2)
+		app2 = binary.BigEndian.AppendUint16(app2, uint16(len(segment)+2))
+		app2 = append(app2, segment...)
+	}
+	withJPEG := append(append([]byte{0xFF, 0xD8}, app2...), jpg.Bytes()[2:]...)
+
+	for _, tt := range []struct {
+		name string
+		data []byte
+		want []byte
+	}{
+		{"PNG without profile", plain.Bytes(), nil},
+		{"PNG with iCCP", withPNG, profile},
+		{"JPEG without profile", jpg.Bytes(), nil},
+		{"JPEG with APP2", withJPEG, profile},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			decoded, err := DecodeImage(bytes.NewReader(tt.data))
+			if err != nil {
+				t.Fatalf("DecodeImage failed: %v", err)
+			}
+			if decoded.Bounds() != img.Bounds() {
+				t.Errorf("bounds = %v, want %v", decoded.Bounds(), img.Bounds())
+			}
+			p, ok := decoded.(*ProfiledImage)
+			switch {
+			case tt.want == nil && ok:
+				t.Error("image without a profile decoded as a ProfiledImage")
+			case tt.want != nil && (!ok || !bytes.Equal(p.Profile, tt.want)):
+				t.Error("embedded profile was not extracted")
+			}
+		})
+	}
+
+	if _, err := DecodeImage(bytes.NewReader([]byte("not an image"))); err == nil {
+		t.Error("DecodeImage accepted data that is not an image")
+	}
+}
@@ -0,0 +1,284 @@
+package pdf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/gogpu/gg/recording"
+)
+
+// SourcePDF is an existing PDF file whose pages can be imported into a
+// document, for example to draw on a pre-printed template or letterhead.
+// The fonts, images, and other resources of pages imported from the same
+// SourcePDF are shared in the output.
+type SourcePDF struct {
+	r     *pdfReader
+	im    *objectImporter
+	pages []sourcePage
+}
+
+// sourcePage is a page of a source file with the attributes it inherits
+// from the page tree resolved.
+type sourcePage struct {
+	ref       pdfRef
+	dict      pdfDict
+	resources pdfObject
+	box       [4]float64
+	rotate    int
+}
+
+// OpenPDF reads the PDF file of the given size from r. Files with classic
+// cross-reference tables and with cross-reference and object streams are
+// supported; encrypted files are not.
+func OpenPDF(r io.ReaderAt, size int64) (*SourcePDF, error) {
+	data := make([]byte, size)
+	if _, err := r.ReadAt(data, 0); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("pdf: failed to read source file: %w", err)
+	}
+	if !bytes.HasPrefix(bytes.TrimLeft(data, "\x00\t\n\f\r "), []byte("%PDF-")) {
+		return nil, fmt.Errorf("%w: missing %%PDF header", errMalformedPDF)
+	}
+	pr, err := newPDFReader(data)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := pr.trailer["Encrypt"]; ok {
+		return nil, errors.New("pdf: encrypted source files are not supported")
+	}
+	catalog, err := pr.resolveDict(pr.trailer["Root"])
+	if err != nil {
+		return nil, err
+	}
+	if catalog == nil {
+		return nil, fmt.Errorf("%w: trailer has no /Root", errMalformedPDF)
+	}
+
+	s := &SourcePDF{r: pr, im: newObjectImporter(pr)}
+	inherited := sourcePage{box: [4]float64{0, 0, 612, 792}}
+	if err := s.collectPages(catalog["Pages"], inherited, make(map[pdfRef]bool)); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// OpenPDFFile reads the PDF file at path. See OpenPDF.
+func OpenPDFFile(path string) (*SourcePDF, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("pdf: failed to read source file: %w", err)
+	}
+	return OpenPDF(bytes.NewReader(data), int64(len(data)))
+}
+
+// PageCount returns the number of pages in the source file.
+func (s *SourcePDF) PageCount() int {
+	return len(s.pages)
+}
+
+// collectPages appends the leaf pages below node in order. Resources, the
+// media and crop boxes, and the rotation are inherited from page tree nodes.
+func (s *SourcePDF) collectPages(node pdfObject, inherited sourcePage, seen map[pdfRef]bool) error {
+	if ref, ok := node.(pdfRef); ok {
+		if seen[ref] {
+			return fmt.Errorf("%w: page tree has a cycle", errMalformedPDF)
+		}
+		seen[ref] = true
+	}
+	dict, err := s.r.resolveDict(node)
+	if err != nil {
+		return err
+	}
+	if dict == nil {
+		return fmt.Errorf("%w: page tree node is not a dictionary", errMalformedPDF)
+	}
+
+	if res, ok := dict["Resources"]; ok {
+		inherited.resources = res
+	}
+	for _, key := range []pdfName{"MediaBox", "CropBox"} {
+		if box, ok := s.box(dict[key]); ok {
+			inherited.box = box
+		}
+	}
+	if rotate, err := s.r.resolve(dict["Rotate"]); err == nil {
+		if n, ok := rotate.(int); ok {
+			n = (n%360 + 360) % 360
+			inherited.rotate = n - n%90
+		}
+	}
+
+	kids, ok := dict["Kids"]
+	if dict["Type"] == pdfName("Page") || !ok {
+		inherited.ref, _ = node.(pdfRef)
+		inherited.dict = dict
+		s.pages = append(s.pages, inherited)
+		return nil
+	}
+	kidsObj, err := s.r.resolve(kids)
+	if err != nil {
+		return err
+	}
+	list, _ := kidsObj.(pdfArray)
+	for _, kid := range list {
+		if err := s.collectPages(kid, inherited, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// box reads a rectangle, normalizing its corners.
+func (s *SourcePDF) box(obj pdfObject) ([4]float64, bool) {
+	var box [4]float64
+	v, err := s.r.resolve(obj)
+	if err != nil {
+		return box, false
+	}
+	arr, _ := v.(pdfArray)
+	if len(arr) != 4 {
+		return box, false
+	}
+	for i, item := range arr {
+		item, _ = s.r.resolve(item)
+		switch n := item.(type) {
+		case int:
+			box[i] = float64(n)
+		case float64:
+			box[i] = n
+		default:
+			return box, false
+		}
+	}
+	box[0], box[2] = min(box[0], box[2]), max(box[0], box[2])
+	box[1], box[3] = min(box[1], box[3]), max(box[1], box[3])
+	return box, box[2] > box[0] && box[3] > box[1]
+}
+
+// ImportPage imports page i, counted from 0, of src as a Form. It can be
+// placed with DrawForm under any transform or used as the background of a
+// new page with NewPageFrom. The form covers the page's crop box, upright
+// as the page is displayed, with its origin at the top left like other
+// content. Imported content is copied as is and is not checked against the
+// document's conformance level.
+func (d *Document) ImportPage(src *SourcePDF, i int) (*Form, error) {
+	return src.importPage(i, d.shared)
+}
+
+// ImportPage imports page i of src as a Form for the backend's page. See
+// Document.ImportPage.
+func (b *Backend) ImportPage(src *SourcePDF, i int) (*Form, error) {
+	return src.importPage(i, b.shared)
+}
+
+func (s *SourcePDF) importPage(i int, shared *sharedResources) (*Form, error) {
+	if i < 0 || i >= len(s.pages) {
+		return nil, fmt.Errorf("pdf: page %d requested, source file has %d", i, len(s.pages))
+	}
+	page := s.pages[i]
+	s.im.dedup = shared.imported
+
+	data, filter, err := s.pageContent(page.dict["Contents"])
+	if err != nil {
+		return nil, fmt.Errorf("pdf: failed to import page %d: %w", i, err)
+	}
+	resources, err := s.im.value(page.resources)
+	if err != nil {
+		return nil, fmt.Errorf("pdf: failed to import page %d: %w", i, err)
+	}
+	if resources == nil {
+		resources = pdfDict{}
+	}
+
+	// Flip the upright page to the top-left origin of the content it is
+	// drawn in.
+	upright, w, h := page.upright()
+	m := recording.Matrix{A: 1, E: -1, F: h}.Multiply(upright)
+
+	dict := pdfDict{
+		"Type":      pdfName("XObject"),
+		"Subtype":   pdfName("Form"),
+		"BBox":      rectArray(page.box[0], page.box[1], page.box[2], page.box[3]),
+		"Matrix":    pdfArray{m.A, m.D, m.B, m.E, m.C, m.F},
+		"Resources": resources,
+	}
+	for key, value := range filter {
+		dict[key] = value
+	}
+	content := &contentStream{}
+	if group, ok := page.dict["Group"]; ok {
+		if dict["Group"], err = s.im.value(group); err != nil {
+			return nil, fmt.Errorf("pdf: failed to import page %d: %w", i, err)
+		}
+		content.transparency = true
+	}
+	return &Form{
+		shared:  shared,
+		width:   w,
+		height:  h,
+		content: content,
+		xobj:    &pdfStream{Dict: dict, Data: data},
+	}, nil
+}
+
+// upright returns the transform from the page's space to the page as it is
+// displayed, rotated and with the bottom-left of its box at the origin,
+// and the displayed width and height.
+func (p sourcePage) upright() (m recording.Matrix, width, height float64) {
+	x0, y0, x1, y1 := p.box[0], p.box[1], p.box[2], p.box[3]
+	width, height = x1-x0, y1-y0
+	switch p.rotate {
+	case 90:
+		return recording.Matrix{A: 0, B: 1, C: -y0, D: -1, E: 0, F: x1}, height, width
+	case 180:
+		return recording.Matrix{A: -1, B: 0, C: x1, D: 0, E: -1, F: y1}, width, height
+	case 270:
+		return recording.Matrix{A: 0, B: -1, C: y1, D: 1, E: 0, F: -x0}, height, width
+	}
+	return recording.Translate(-x0, -y0), width, height
+}
+
+// pageContent returns the content of a page and the filter entries that
+// describe its encoding. A single stream is copied as is; several streams
+// are decoded and joined.
+func (s *SourcePDF) pageContent(contents pdfObject) ([]byte, pdfDict, error) {
+	obj, err := s.r.resolve(contents)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch v := obj.(type) {
+	case nil:
+		return nil, nil, nil
+	case *pdfStream:
+		filter := pdfDict{}
+		for _, key := range []pdfName{"Filter", "DecodeParms"} {
+			if value, ok := v.Dict[key]; ok {
+				if filter[key], err = s.im.value(value); err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+		return v.Data, filter, nil
+	case pdfArray:
+		data, err := s.contentData(v)
+		if err != nil {
+			return nil, nil, err
+		}
+		joined := flateStream(pdfDict{}, data)
+		return joined.Data, joined.Dict, nil
+	}
+	return nil, nil, fmt.Errorf("%w: /Contents is %T", errMalformedPDF, obj)
+}
+
+// NewPageFrom adds a page the size of f with f drawn as its background,
+// such as an imported letterhead, and returns a backend for drawing on top
+// of it.
+func (d *Document) NewPageFrom(f *Form) recording.Backend {
+	pb := d.newPageBackend(f.width, f.height)
+	if pb.initErr == nil {
+		pb.DrawForm(f, recording.Identity())
+	}
+	return pb
+}
@@ -0,0 +1,262 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gogpu/gg"
+	"github.com/gogpu/gg/recording"
+)
+
+// handcraftedPDF writes objects 1, 2, ... with a classic cross-reference
+// table. Object 1 is the catalog; extra is added to the trailer.
+func handcraftedPDF(extra string, objects ...string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.7\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R %s>>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, extra, xref)
+	return buf.Bytes()
+}
+
+// openSource opens data as a source file.
+func openSource(t *testing.T, data []byte) *SourcePDF {
+	t.Helper()
+
+	src, err := OpenPDF(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("OpenPDF failed: %v", err)
+	}
+	return src
+}
+
+func TestImportPage(t *testing.T) {
+	source := NewDocument()
+	for i := range 2 {
+		page := source.NewPage(200, 100)
+		page.FillRect(recording.NewRect(10, 10, 50, 50), recording.NewSolidBrush(gg.Red))
+		page.DrawText(fmt.Sprintf("Page %d", i+1), 20, 80, goRegularFace(t, 12), recording.NewSolidBrush(gg.Black))
+	}
+	var buf bytes.Buffer
+	if _, err := source.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	src := openSource(t, buf.Bytes())
+	if src.PageCount() != 2 {
+		t.Fatalf("PageCount = %d, want 2", src.PageCount())
+	}
+
+	doc := NewDocument()
+	form, err := doc.ImportPage(src, 1)
+	if err != nil {
+		t.Fatalf("ImportPage failed: %v", err)
+	}
+	if form.Width() != 200 || form.Height() != 100 {
+		t.Errorf("form size = %vx%v, want 200x100", form.Width(), form.Height())
+	}
+	for range 2 {
+		page := doc.NewPage(400, 400).(*pageBackend)
+		page.DrawForm(form, recording.Scale(0.5, 0.5))
+	}
+
+	var refs []pdfObject
+	for i := range 2 {
+		content, res, r := pageContent(t, documentWriter(doc), i)
+		if !strings.Contains(content, "q\n0.5 0 0 0.5 0 0 cm\n/Fm1 Do\nQ\n") {
+			t.Errorf("page %d does not place the imported page:\n%s", i, content)
+		}
+		xobjects, _ := res["XObject"].(pdfDict)
+		refs = append(refs, xobjects["Fm1"])
+		imported, data := formXObject(t, res, r, "Fm1")
+		if !strings.Contains(data, " Tj") && !strings.Contains(data, " TJ") {
+			t.Errorf("form content = %q, want the page text", data)
+		}
+		resources, err := r.resolveDict(imported.Dict["Resources"])
+		if err != nil {
+			t.Fatalf("failed to resolve the form resources: %v", err)
+		}
+		fonts, _ := r.resolveDict(resources["Font"])
+		if len(fonts) == 0 {
+			t.Errorf("form resources = %v, want the page font", resources)
+		}
+		want := pdfArray{1.0, 0.0, 0.0, -1.0, 0.0, 100.0}
+		if m, _ := imported.Dict["Matrix"].(pdfArray); fmt.Sprint(m) != fmt.Sprint(want) {
+			t.Errorf("/Matrix = %v, want %v", imported.Dict["Matrix"], want)
+		}
+	}
+	if refs[0] != refs[1] {
+		t.Errorf("pages reference forms %v and %v, want one shared object", refs[0], refs[1])
+	}
+}
+
+func TestImportPageRotated(t *testing.T) {
+	data := handcraftedPDF("",
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 /MediaBox [0 0 200 100] /Rotate -270 /Resources << >> >>",
+		"<< /Type /Page /Parent 2 0 R /Contents [4 0 R 5 0 R] >>",
+		"<< /Length 15 >>\nstream\n0 0 10 10 re f\n\nendstream",
+		"<< /Length 13 >>\nstream\n1 0 0 rg\n0 g\n\nendstream",
+	)
+	src := openSource(t, data)
+	form, err := NewDocument().ImportPage(src, 0)
+	if err != nil {
+		t.Fatalf("ImportPage failed: %v", err)
+	}
+	if form.Width() != 100 || form.Height() != 200 {
+		t.Errorf("form size = %vx%v, want the rotated 100x200", form.Width(), form.Height())
+	}
+	// Turned a quarter clockwise, the page's bottom-left corner is at the
+	// top-left and its left edge along the top, which transposes it.
+	want := "[0 1 1 0 0 0]"
+	if m := form.xobj.Dict["Matrix"]; fmt.Sprint(m) != want {
+		t.Errorf("/Matrix = %v, want %v", m, want)
+	}
+	content, err := decodeStream(form.xobj)
+	if err != nil {
+		t.Fatalf("failed to decode the form content: %v", err)
+	}
+	if !strings.Contains(string(content), "re f\n") || !strings.Contains(string(content), "rg\n") {
+		t.Errorf("form content = %q, want both content streams", content)
+	}
+}
+
+func TestImportCompressedXref(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.7\n")
+	offsets := map[int]int{}
+	object := func(num int, body string) {
+		offsets[num] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", num, body)
+	}
+	object(3, "<< /Type /Page /Parent 2 0 R /MediaBox [0 0 50 40] /Contents 5 0 R >>")
+	objects := "<< /Type /Catalog /Pages 2 0 R >> << /Type /Pages /Kids [3 0 R] /Count 1 >>"
+	header := "1 0 2 34 "
+	object(4, fmt.Sprintf("<< /Type /ObjStm /N 2 /First %d /Length %d >>\nstream\n%s%s\nendstream",
+		len(header), len(header)+len(objects), header, objects))
+	object(5, "<< /Length 13 >>\nstream\n0 0 5 5 re f\n\nendstream")
+
+	// Rows of /W [1 2 1], encoded with the PNG Up predictor.
+	rows := [][]byte{{0, 0, 0, 0xFF}, {2, 0, 4, 0}, {2, 0, 4, 1}}
+	for num := 3; num <= 6; num++ {
+		off := offsets[num]
+		if num == 6 {
+			off = buf.Len()
+		}
+		rows = append(rows, []byte{1, byte(off >> 8), byte(off), 0})
+	}
+	var raw bytes.Buffer
+	prev := make([]byte, 4)
+	for _, row := range rows {
+		raw.WriteByte(2)
+		for i, c := range row {
+			raw.WriteByte(c - prev[i])
+		}
+		prev = row
+	}
+	var packed bytes.Buffer
+	zw := zlib.NewWriter(&packed)
+	zw.Write(raw.Bytes())
+	zw.Close()
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "6 0 obj\n<< /Type /XRef /Size 7 /W [1 2 1] /Root 1 0 R /Filter /FlateDecode "+
+		"/DecodeParms << /Predictor 12 /Columns 4 >> /Length %d >>\nstream\n", packed.Len())
+	buf.Write(packed.Bytes())
+	fmt.Fprintf(&buf, "\nendstream\nendobj\nstartxref\n%d\n%%%%EOF\n", xref)
+
+	src := openSource(t, buf.Bytes())
+	if src.PageCount() != 1 {
+		t.Fatalf("PageCount = %d, want 1", src.PageCount())
+	}
+	form, err := NewDocument().ImportPage(src, 0)
+	if err != nil {
+		t.Fatalf("ImportPage failed: %v", err)
+	}
+	if form.Width() != 50 || form.Height() != 40 {
+		t.Errorf("form size = %vx%v, want the media box 50x40", form.Width(), form.Height())
+	}
+	if string(form.xobj.Data) != "0 0 5 5 re f\n" {
+		t.Errorf("form content = %q, want the page content", form.xobj.Data)
+	}
+}
+
+func TestNewPageFrom(t *testing.T) {
+	data := handcraftedPDF("",
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 300 200] /CropBox [10 20 110 70] "+
+			"/Group << /S /Transparency /CS /DeviceRGB >> /Contents 4 0 R >>",
+		"<< /Length 13 >>\nstream\n0 0 5 5 re f\n\nendstream",
+	)
+	doc := NewDocument()
+	form, err := doc.ImportPage(openSource(t, data), 0)
+	if err != nil {
+		t.Fatalf("ImportPage failed: %v", err)
+	}
+	page := doc.NewPageFrom(form)
+	page.FillRect(recording.NewRect(0, 0, 10, 10), recording.NewSolidBrush(gg.Blue))
+
+	content, res, r := pageContent(t, documentWriter(doc), 0)
+	if !strings.Contains(content, " cm\nq\n/Fm1 Do\nQ\nq\n0 0 1 rg\n") {
+		t.Errorf("background is not drawn first:\n%s", content)
+	}
+	imported, _ := formXObject(t, res, r, "Fm1")
+	if bbox := fmt.Sprint(imported.Dict["BBox"]); bbox != "[10 20 110 70]" {
+		t.Errorf("/BBox = %v, want the crop box", bbox)
+	}
+	if _, ok := imported.Dict["Group"]; !ok {
+		t.Error("page group is not carried to the form")
+	}
+
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	out := openSource(t, buf.Bytes())
+	if box := out.pages[0].box; box != [4]float64{0, 0, 100, 50} {
+		t.Errorf("page box = %v, want the crop box size", box)
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	encrypted := handcraftedPDF("/Encrypt 3 0 R ",
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [] /Count 0 >>",
+		"<< /Filter /Standard /V 2 >>",
+	)
+	if _, err := OpenPDF(bytes.NewReader(encrypted), int64(len(encrypted))); err == nil {
+		t.Error("OpenPDF succeeded on an encrypted file")
+	}
+	garbage := []byte("not a pdf")
+	if _, err := OpenPDF(bytes.NewReader(garbage), int64(len(garbage))); err == nil {
+		t.Error("OpenPDF succeeded on a file that is not a PDF")
+	}
+	// Cross-reference subsections that claim more entries than the file
+	// holds, whole or truncated in the last entry.
+	for _, xref := range []string{"0 2000000000", "0 1"} {
+		data := []byte("%PDF-1.7\nxref\n" + xref)
+		data = fmt.Appendf(data, "\nstartxref\n9\n%%%%EOF\n")
+		if _, err := OpenPDF(bytes.NewReader(data), int64(len(data))); !errors.Is(err, errMalformedPDF) {
+			t.Errorf("OpenPDF of xref %q = %v, want %v", xref, err, errMalformedPDF)
+		}
+	}
+	empty := openSource(t, handcraftedPDF("",
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [] /Count 0 >>",
+	))
+	if _, err := NewDocument().ImportPage(empty, 0); err == nil {
+		t.Error("ImportPage succeeded on a page the file does not have")
+	}
+}
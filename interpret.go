@@ -0,0 +1,1641 @@
+package pdf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"math"
+
+	"github.com/gogpu/gg"
+	"github.com/gogpu/gg/recording"
+	"github.com/gogpu/gg/text"
+)
+
+// UnsupportedOperator describes content that PlayPage skipped: the content
+// stream operator, why it could not be converted, and how often it occurred.
+type UnsupportedOperator struct {
+	Operator string
+	Reason   string
+	Count    int
+}
+
+// String returns a description such as `Tj: text (3 times)`.
+func (u UnsupportedOperator) String() string {
+	if u.Count == 1 {
+		return fmt.Sprintf("%s: %s", u.Operator, u.Reason)
+	}
+	return fmt.Sprintf("%s: %s (%d times)", u.Operator, u.Reason, u.Count)
+}
+
+// Limits for content that nests: form XObjects drawn from forms, and
+// stitching functions made of stitching functions.
+const (
+	maxFormDepth     = 32
+	maxFunctionDepth = 8
+)
+
+// PlayPage draws page i of the source file, counted from 0, on backend by
+// interpreting its content stream. The backend receives a canvas the size
+// of the page as it is displayed, with the origin at the top left. Paths,
+// fills, strokes, transforms, clips, images, form XObjects, and axial and
+// radial shadings are converted with the mapping this package uses to write
+// them, in reverse; colors are converted to RGB. Content that has no
+// equivalent, such as text, is skipped and returned in order of first
+// occurrence. An error is returned only when the page cannot be read.
+func (s *SourcePDF) PlayPage(i int, backend recording.Backend) ([]UnsupportedOperator, error) {
+	if i < 0 || i >= len(s.pages) {
+		return nil, fmt.Errorf("pdf: page %d requested, source file has %d", i, len(s.pages))
+	}
+	page := s.pages[i]
+	data, err := s.contentData(page.dict["Contents"])
+	if err != nil {
+		return nil, fmt.Errorf("pdf: failed to play page %d: %w", i, err)
+	}
+	resources, err := s.r.resolveDict(page.resources)
+	if err != nil {
+		return nil, fmt.Errorf("pdf: failed to play page %d: %w", i, err)
+	}
+
+	upright, w, h := page.upright()
+	if err := backend.Begin(int(math.Ceil(w)), int(math.Ceil(h))); err != nil {
+		return nil, err
+	}
+	p := &contentPlayer{
+		r:       s.r,
+		backend: backend,
+		width:   w,
+		height:  h,
+		emitted: recording.Identity(),
+		images:  make(map[*pdfStream]image.Image),
+		forms:   make(map[*pdfStream]bool),
+		counts:  make(map[[2]string]int),
+	}
+	p.state = defaultPlayState(recording.Matrix{A: 1, E: -1, F: h}.Multiply(upright))
+	if err := p.run(data, resources, 0); err != nil {
+		return p.unsupported, fmt.Errorf("pdf: failed to play page %d: %w", i, err)
+	}
+	return p.unsupported, backend.End()
+}
+
+// RecordPage converts page i of the source file into a recording. See
+// PlayPage.
+func (s *SourcePDF) RecordPage(i int) (*recording.Recording, []UnsupportedOperator, error) {
+	b := &recordingBuilder{}
+	unsupported, err := s.PlayPage(i, b)
+	if err != nil {
+		return nil, unsupported, err
+	}
+	rec, err := newRecording(b.width, b.height, b.commands, b.paths, b.brushes, b.images)
+	return rec, unsupported, err
+}
+
+// contentData returns the decoded content of a page, joining the streams
+// of a /Contents array.
+func (s *SourcePDF) contentData(contents pdfObject) ([]byte, error) {
+	obj, err := s.r.resolve(contents)
+	if err != nil {
+		return nil, err
+	}
+	switch v := obj.(type) {
+	case nil:
+		return nil, nil
+	case *pdfStream:
+		return decodeStream(v)
+	case pdfArray:
+		var buf bytes.Buffer
+		for _, item := range v {
+			part, err := s.r.resolve(item)
+			if err != nil {
+				return nil, err
+			}
+			stream, ok := part.(*pdfStream)
+			if !ok {
+				return nil, fmt.Errorf("%w: /Contents holds %T", errMalformedPDF, part)
+			}
+			data, err := decodeStream(stream)
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(data)
+			buf.WriteByte('\n')
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, fmt.Errorf("%w: /Contents is %T", errMalformedPDF, obj)
+}
+
+// contentPlayer interprets content streams and drives a backend with the
+// equivalent calls.
+type contentPlayer struct {
+	r             *pdfReader
+	backend       recording.Backend
+	width, height float64
+
+	state playState
+	stack []savedState
+
+	// emitted is the transform last set on the backend.
+	emitted recording.Matrix
+
+	// The path under construction, the rectangle it consists of if it was
+	// built by a single re, and the clipping rule set by W or W*.
+	path     *gg.Path
+	rect     *recording.Rect
+	clipRule *recording.FillRule
+
+	images      map[*pdfStream]image.Image
+	forms       map[*pdfStream]bool
+	counts      map[[2]string]int
+	unsupported []UnsupportedOperator
+}
+
+// playState is the part of the graphics state the player tracks.
+type playState struct {
+	// ctm maps user space to the backend's canvas; base is the ctm at the
+	// start of the content stream being run, which patterns are relative to.
+	ctm, base recording.Matrix
+
+	fill, stroke           paint
+	fillAlpha, strokeAlpha float64
+	line                   recording.Stroke
+	clip                   *pendingClip
+	resources              pdfDict
+}
+
+// savedState is a playState pushed by q. The backend is only sent Save
+// once a clip must be set at the level, since transforms are absolute.
+type savedState struct {
+	playState
+	saved   bool
+	emitted recording.Matrix
+}
+
+// pendingClip is a clip that has been set in the content stream but not yet
+// on the backend. It is held back so that a shading painted right after it
+// becomes a fill of the clip path.
+type pendingClip struct {
+	path *gg.Path
+	rule recording.FillRule
+	ctm  recording.Matrix
+}
+
+// paint is a fill or stroke color: a color in a color space, or a pattern.
+type paint struct {
+	space   *colorSpace
+	color   gg.RGBA
+	pattern pdfDict
+}
+
+func defaultPlayState(ctm recording.Matrix) playState {
+	black := paint{space: deviceGray, color: gg.RGBA{A: 1}}
+	return playState{
+		ctm:         ctm,
+		base:        ctm,
+		fill:        black,
+		stroke:      black,
+		fillAlpha:   1,
+		strokeAlpha: 1,
+		line:        recording.Stroke{Width: 1, MiterLimit: 10},
+	}
+}
+
+// report records an operator that was skipped.
+func (p *contentPlayer) report(op, reason string) {
+	key := [2]string{op, reason}
+	if i, ok := p.counts[key]; ok {
+		p.unsupported[i].Count++
+		return
+	}
+	p.counts[key] = len(p.unsupported)
+	p.unsupported = append(p.unsupported, UnsupportedOperator{Operator: op, Reason: reason, Count: 1})
+}
+
+// run interprets one content stream with the given resources.
+func (p *contentPlayer) run(data []byte, resources pdfDict, depth int) error {
+	p.state.resources = resources
+	p.state.base = p.state.ctm
+	parser := &objectParser{lx: newLexer(data, 0), reader: p.r}
+	var operands []pdfObject
+	for {
+		tok, err := parser.nextToken()
+		if err != nil {
+			return err
+		}
+		switch {
+		case tok.kind == tokEOF:
+			return nil
+		case tok.kind == tokKeyword && tok.text == "BI":
+			if err := skipInlineImage(parser); err != nil {
+				return err
+			}
+			p.report("BI", "inline image")
+			operands = operands[:0]
+		case tok.kind == tokKeyword && tok.text != "true" && tok.text != "false" && tok.text != "null":
+			if !p.operator(tok.text, operands, depth) {
+				p.report(tok.text, "invalid operands")
+			}
+			operands = operands[:0]
+		default:
+			obj, err := parser.parseFrom(tok)
+			if err != nil {
+				return err
+			}
+			operands = append(operands, obj)
+		}
+	}
+}
+
+// skipInlineImage skips the parameters and data of an inline image after
+// its BI operator.
+func skipInlineImage(parser *objectParser) error {
+	for {
+		tok, err := parser.nextToken()
+		if err != nil {
+			return err
+		}
+		if tok.kind == tokEOF {
+			return fmt.Errorf("%w: unterminated inline image", errMalformedPDF)
+		}
+		if tok.kind == tokKeyword && tok.text == "ID" {
+			break
+		}
+	}
+	lx := parser.lx
+	data := lx.data
+	for i := lx.pos + 1; i+1 < len(data); i++ {
+		if data[i] == 'E' && data[i+1] == 'I' && isWhitespace(data[i-1]) &&
+			(i+2 == len(data) || isWhitespace(data[i+2]) || isDelimiter(data[i+2])) {
+			lx.pos = i + 2
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: unterminated inline image", errMalformedPDF)
+}
+
+// operator executes one operator. It returns false if the operands are not
+// valid for it.
+func (p *contentPlayer) operator(op string, operands []pdfObject, depth int) bool {
+	nums, numeric := numberOperands(operands)
+	arity := func(n int) bool { return numeric && len(nums) == n }
+
+	switch op {
+	// Graphics state
+	case "q":
+		p.save()
+	case "Q":
+		p.restore()
+	case "cm":
+		if !arity(6) {
+			return false
+		}
+		p.state.ctm = p.state.ctm.Multiply(matrixOperands(nums))
+	case "w":
+		if !arity(1) {
+			return false
+		}
+		p.state.line.Width = nums[0]
+	case "J":
+		if !arity(1) || nums[0] < 0 || nums[0] > 2 {
+			return false
+		}
+		p.state.line.Cap = recording.LineCap(nums[0])
+	case "j":
+		if !arity(1) || nums[0] < 0 || nums[0] > 2 {
+			return false
+		}
+		p.state.line.Join = recording.LineJoin(nums[0])
+	case "M":
+		if !arity(1) {
+			return false
+		}
+		p.state.line.MiterLimit = nums[0]
+	case "d":
+		if len(operands) != 2 {
+			return false
+		}
+		dash, ok := p.numbers(operands[0])
+		phase, ok2 := number(operands[1])
+		if !ok || !ok2 {
+			return false
+		}
+		p.state.line.DashPattern, p.state.line.DashOffset = dash, phase
+	case "gs":
+		return len(operands) == 1 && p.extGState(operands[0])
+	case "ri", "i":
+		// Rendering intent and flatness have no equivalent and no visible
+		// effect worth reporting.
+
+	// Path construction
+	case "m", "l":
+		if !arity(2) || (op == "l" && !p.hasPoint()) {
+			return false
+		}
+		if p.path == nil {
+			p.path = gg.NewPath()
+		}
+		if op == "m" {
+			p.path.MoveTo(nums[0], nums[1])
+		} else {
+			p.path.LineTo(nums[0], nums[1])
+		}
+		p.rect = nil
+	case "c":
+		if !arity(6) || !p.hasPoint() {
+			return false
+		}
+		p.path.CubicTo(nums[0], nums[1], nums[2], nums[3], nums[4], nums[5])
+		p.rect = nil
+	case "v":
+		if !arity(4) || !p.hasPoint() {
+			return false
+		}
+		cur := p.path.CurrentPoint()
+		p.path.CubicTo(cur.X, cur.Y, nums[0], nums[1], nums[2], nums[3])
+		p.rect = nil
+	case "y":
+		if !arity(4) || !p.hasPoint() {
+			return false
+		}
+		p.path.CubicTo(nums[0], nums[1], nums[2], nums[3], nums[2], nums[3])
+		p.rect = nil
+	case "h":
+		if p.hasPoint() {
+			p.path.Close()
+		}
+	case "re":
+		if !arity(4) {
+			return false
+		}
+		first := p.path == nil
+		if first {
+			p.path = gg.NewPath()
+		}
+		x, y, w, h := nums[0], nums[1], nums[2], nums[3]
+		p.path.MoveTo(x, y)
+		p.path.LineTo(x+w, y)
+		p.path.LineTo(x+w, y+h)
+		p.path.LineTo(x, y+h)
+		p.path.Close()
+		if rect := recording.NewRectFromPoints(x, y, x+w, y+h); first {
+			p.rect = &rect
+		} else {
+			p.rect = nil
+		}
+
+	// Path painting and clipping
+	case "W", "W*":
+		rule := recording.FillRuleNonZero
+		if op == "W*" {
+			rule = recording.FillRuleEvenOdd
+		}
+		p.clipRule = &rule
+	case "f", "F", "f*", "S", "s", "B", "B*", "b", "b*", "n":
+		p.paintPath(op)
+
+	// Color
+	case "CS", "cs":
+		if len(operands) != 1 {
+			return false
+		}
+		space := p.colorSpace(operands[0], 0)
+		if space == nil {
+			p.report(op, "unsupported color space")
+			space = deviceGray
+		}
+		target := p.paintFor(op == "cs")
+		*target = paint{space: space, color: space.rgba(space.initial())}
+	case "SC", "SCN", "sc", "scn":
+		target := p.paintFor(op == "sc" || op == "scn")
+		if target.space.family == "Pattern" {
+			return p.selectPattern(op, target, operands)
+		}
+		if !arity(target.space.n) {
+			return false
+		}
+		target.color = target.space.rgba(nums)
+	case "G", "g":
+		if !arity(1) {
+			return false
+		}
+		*p.paintFor(op == "g") = paint{space: deviceGray, color: deviceGray.rgba(nums)}
+	case "RG", "rg":
+		if !arity(3) {
+			return false
+		}
+		*p.paintFor(op == "rg") = paint{space: deviceRGB, color: deviceRGB.rgba(nums)}
+	case "K", "k":
+		if !arity(4) {
+			return false
+		}
+		*p.paintFor(op == "k") = paint{space: deviceCMYK, color: deviceCMYK.rgba(nums)}
+
+	// Shadings and external objects
+	case "sh":
+		return len(operands) == 1 && p.shade(operands[0])
+	case "Do":
+		return len(operands) == 1 && p.xobject(operands[0], depth)
+
+	// Text
+	case "Tj", "TJ", "'", "\"":
+		p.report(op, "text")
+	case "BT", "ET", "Tc", "Tw", "Tz", "TL", "Tf", "Tr", "Ts", "Td", "TD", "Tm", "T*":
+		// Text state only matters to the text showing operators.
+
+	// Type 3 glyphs, marked content, and compatibility sections
+	case "d0", "d1", "BMC", "BDC", "EMC", "MP", "DP", "BX", "EX":
+
+	default:
+		p.report(op, "unknown operator")
+	}
+	return true
+}
+
+// numberOperands returns the operands as numbers, and whether they all are.
+func numberOperands(operands []pdfObject) ([]float64, bool) {
+	nums := make([]float64, len(operands))
+	for i, obj := range operands {
+		v, ok := number(obj)
+		if !ok {
+			return nil, false
+		}
+		nums[i] = v
+	}
+	return nums, true
+}
+
+// number returns a numeric object as a float64.
+func number(obj pdfObject) (float64, bool) {
+	switch v := obj.(type) {
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// numbers resolves an array of numbers.
+func (p *contentPlayer) numbers(obj pdfObject) ([]float64, bool) {
+	obj, err := p.r.resolve(obj)
+	if err != nil {
+		return nil, false
+	}
+	arr, ok := obj.(pdfArray)
+	if !ok {
+		return nil, false
+	}
+	nums := make([]float64, len(arr))
+	for i, item := range arr {
+		item, _ = p.r.resolve(item)
+		if nums[i], ok = number(item); !ok {
+			return nil, false
+		}
+	}
+	return nums, true
+}
+
+// matrixOperands converts the operands of cm, or a /Matrix array, to a
+// matrix.
+func matrixOperands(v []float64) recording.Matrix {
+	return recording.Matrix{A: v[0], B: v[2], C: v[4], D: v[1], E: v[3], F: v[5]}
+}
+
+func (p *contentPlayer) hasPoint() bool {
+	return p.path != nil && p.path.HasCurrentPoint()
+}
+
+func (p *contentPlayer) paintFor(fill bool) *paint {
+	if fill {
+		return &p.state.fill
+	}
+	return &p.state.stroke
+}
+
+// resource looks up a named resource of the current content stream.
+func (p *contentPlayer) resource(category pdfName, name pdfObject) (pdfObject, error) {
+	dict, err := p.r.resolveDict(p.state.resources[category])
+	if err != nil || dict == nil {
+		return nil, err
+	}
+	key, ok := name.(pdfName)
+	if !ok {
+		return nil, nil
+	}
+	return p.r.resolve(dict[key])
+}
+
+// save pushes the graphics state for q.
+func (p *contentPlayer) save() {
+	p.flushClip()
+	p.stack = append(p.stack, savedState{playState: p.state})
+	p.state.clip = nil
+}
+
+// restore pops the graphics state for Q. Unbalanced Q operators are
+// ignored.
+func (p *contentPlayer) restore() {
+	n := len(p.stack)
+	if n == 0 {
+		return
+	}
+	top := p.stack[n-1]
+	p.stack = p.stack[:n-1]
+	if top.saved {
+		p.backend.Restore()
+		p.emitted = top.emitted
+	}
+	p.state = top.playState
+}
+
+// setTransform makes m the backend's transform.
+func (p *contentPlayer) setTransform(m recording.Matrix) {
+	if m != p.emitted {
+		p.backend.SetTransform(m)
+		p.emitted = m
+	}
+}
+
+// flushClip sets a pending clip on the backend, saving the state of the
+// current level first so that the matching Q can undo it.
+func (p *contentPlayer) flushClip() {
+	clip := p.state.clip
+	if clip == nil {
+		return
+	}
+	p.state.clip = nil
+	if n := len(p.stack); n > 0 && !p.stack[n-1].saved {
+		p.backend.Save()
+		p.stack[n-1].saved = true
+		p.stack[n-1].emitted = p.emitted
+	}
+	p.setTransform(clip.ctm)
+	p.backend.SetClip(clip.path, clip.rule)
+}
+
+// beginDrawing prepares the backend for drawing in the space of m.
+func (p *contentPlayer) beginDrawing(m recording.Matrix) {
+	p.flushClip()
+	p.setTransform(m)
+}
+
+// paintPath ends the current path with a painting operator.
+func (p *contentPlayer) paintPath(op string) {
+	path, rect, clipRule := p.path, p.rect, p.clipRule
+	p.path, p.rect, p.clipRule = nil, nil, nil
+	if path == nil {
+		return
+	}
+
+	switch op {
+	case "s", "b", "b*":
+		path.Close()
+	}
+	rule := recording.FillRuleNonZero
+	if op == "f*" || op == "B*" || op == "b*" {
+		rule = recording.FillRuleEvenOdd
+	}
+	switch op {
+	case "f", "F", "f*", "B", "B*", "b", "b*":
+		p.fillPath(op, path, rect, rule)
+	}
+	switch op {
+	case "S", "s", "B", "B*", "b", "b*":
+		p.strokePath(op, path)
+	}
+
+	if clipRule != nil {
+		p.flushClip()
+		p.state.clip = &pendingClip{path: path, rule: *clipRule, ctm: p.state.ctm}
+	}
+}
+
+func (p *contentPlayer) fillPath(op string, path *gg.Path, rect *recording.Rect, rule recording.FillRule) {
+	fill := p.state.fill
+	if fill.pattern != nil {
+		p.fillPattern(op, path, rule, fill.pattern)
+		return
+	}
+	brush := recording.NewSolidBrush(withAlpha(fill.color, p.state.fillAlpha))
+	p.beginDrawing(p.state.ctm)
+	if rect != nil && rule == recording.FillRuleNonZero {
+		p.backend.FillRect(*rect, brush)
+		return
+	}
+	p.backend.FillPath(path, brush, rule)
+}
+
+func (p *contentPlayer) strokePath(op string, path *gg.Path) {
+	stroke := p.state.stroke
+	if stroke.pattern != nil {
+		p.report(op, "pattern stroke")
+		return
+	}
+	brush := recording.NewSolidBrush(withAlpha(stroke.color, p.state.strokeAlpha))
+	p.beginDrawing(p.state.ctm)
+	p.backend.StrokePath(path, brush, p.state.line.Clone())
+}
+
+// withAlpha returns c with its alpha multiplied by alpha.
+func withAlpha(c gg.RGBA, alpha float64) gg.RGBA {
+	c.A *= alpha
+	return c
+}
+
+// extGState applies the entries of a named graphics state parameter
+// dictionary.
+func (p *contentPlayer) extGState(name pdfObject) bool {
+	obj, err := p.resource("ExtGState", name)
+	if err != nil {
+		return false
+	}
+	dict, _ := obj.(pdfDict)
+	if dict == nil {
+		return false
+	}
+	get := func(key pdfName) (float64, bool) {
+		v, _ := p.r.resolve(dict[key])
+		return number(v)
+	}
+	if v, ok := get("LW"); ok {
+		p.state.line.Width = v
+	}
+	if v, ok := get("LC"); ok && v >= 0 && v <= 2 {
+		p.state.line.Cap = recording.LineCap(v)
+	}
+	if v, ok := get("LJ"); ok && v >= 0 && v <= 2 {
+		p.state.line.Join = recording.LineJoin(v)
+	}
+	if v, ok := get("ML"); ok {
+		p.state.line.MiterLimit = v
+	}
+	if d, _ := p.r.resolve(dict["D"]); d != nil {
+		if arr, ok := d.(pdfArray); ok && len(arr) == 2 {
+			dash, ok := p.numbers(arr[0])
+			phase, ok2 := number(arr[1])
+			if ok && ok2 {
+				p.state.line.DashPattern, p.state.line.DashOffset = dash, phase
+			}
+		}
+	}
+	if v, ok := get("ca"); ok {
+		p.state.fillAlpha = clamp01(v)
+	}
+	if v, ok := get("CA"); ok {
+		p.state.strokeAlpha = clamp01(v)
+	}
+	if bm, _ := p.r.resolve(dict["BM"]); bm != nil {
+		if arr, ok := bm.(pdfArray); ok && len(arr) > 0 {
+			bm, _ = p.r.resolve(arr[0])
+		}
+		if bm != pdfName("Normal") && bm != pdfName("Compatible") {
+			p.report("gs", "blend mode")
+		}
+	}
+	if mask, _ := p.r.resolve(dict["SMask"]); mask != nil && mask != pdfName("None") {
+		p.report("gs", "soft mask")
+	}
+	return true
+}
+
+// selectPattern sets a pattern as the fill or stroke color.
+func (p *contentPlayer) selectPattern(op string, target *paint, operands []pdfObject) bool {
+	if len(operands) == 0 {
+		return false
+	}
+	if len(operands) > 1 {
+		p.report(op, "uncolored pattern")
+		return true
+	}
+	obj, err := p.resource("Pattern", operands[0])
+	if err != nil || obj == nil {
+		return false
+	}
+	switch v := obj.(type) {
+	case pdfDict:
+		target.pattern = v
+	case *pdfStream:
+		target.pattern = v.Dict
+	default:
+		return false
+	}
+	return true
+}
+
+// fillPattern fills path with a shading pattern. The pattern is defined in
+// the space the content stream started in, so the path is drawn there.
+func (p *contentPlayer) fillPattern(op string, path *gg.Path, rule recording.FillRule, pattern pdfDict) {
+	if t, _ := p.r.resolve(pattern["PatternType"]); t != 2 {
+		p.report(op, "tiling pattern")
+		return
+	}
+	space := p.state.base
+	if m, ok := p.numbers(pattern["Matrix"]); ok && len(m) == 6 {
+		space = space.Multiply(matrixOperands(m))
+	}
+	brush, reason := p.shadingBrush(pattern["Shading"])
+	if brush == nil {
+		p.report(op, reason)
+		return
+	}
+	if !invertible(space) {
+		return
+	}
+	p.beginDrawing(space)
+	p.backend.FillPath(transformPath(path, space.Invert().Multiply(p.state.ctm)), brush, rule)
+}
+
+// shade paints a shading with sh. A clip path set just before is filled
+// with the shading; otherwise the shading fills the page.
+func (p *contentPlayer) shade(name pdfObject) bool {
+	obj, err := p.resource("Shading", name)
+	if err != nil || obj == nil {
+		return false
+	}
+	brush, reason := p.shadingBrush(obj)
+	if brush == nil {
+		p.report("sh", reason)
+		return true
+	}
+	ctm := p.state.ctm
+	if !invertible(ctm) {
+		return true
+	}
+	var (
+		path *gg.Path
+		rule = recording.FillRuleNonZero
+	)
+	if clip := p.state.clip; clip != nil {
+		// The clip stays pending, as it also applies to what follows.
+		path, rule = clip.path, clip.rule
+		if clip.ctm != ctm {
+			path = transformPath(path, ctm.Invert().Multiply(clip.ctm))
+		}
+		p.setTransform(ctm)
+	} else {
+		path = gg.NewPath()
+		path.Rectangle(0, 0, p.width, p.height)
+		path = transformPath(path, ctm.Invert())
+		p.beginDrawing(ctm)
+	}
+	p.backend.FillPath(path, brush, rule)
+	return true
+}
+
+// invertible reports whether m maps areas to areas.
+func invertible(m recording.Matrix) bool {
+	return math.Abs(m.Determinant()) > 1e-10
+}
+
+// transformPath returns path with its points transformed by m.
+func transformPath(path *gg.Path, m recording.Matrix) *gg.Path {
+	out := gg.NewPath()
+	for _, elem := range path.Elements() {
+		switch e := elem.(type) {
+		case gg.MoveTo:
+			out.MoveTo(m.TransformPoint(e.Point.X, e.Point.Y))
+		case gg.LineTo:
+			out.LineTo(m.TransformPoint(e.Point.X, e.Point.Y))
+		case gg.QuadTo:
+			cx, cy := m.TransformPoint(e.Control.X, e.Control.Y)
+			x, y := m.TransformPoint(e.Point.X, e.Point.Y)
+			out.QuadraticTo(cx, cy, x, y)
+		case gg.CubicTo:
+			c1x, c1y := m.TransformPoint(e.Control1.X, e.Control1.Y)
+			c2x, c2y := m.TransformPoint(e.Control2.X, e.Control2.Y)
+			x, y := m.TransformPoint(e.Point.X, e.Point.Y)
+			out.CubicTo(c1x, c1y, c2x, c2y, x, y)
+		case gg.Close:
+			out.Close()
+		}
+	}
+	return out
+}
+
+// shadingBrush converts an axial or radial shading to a gradient brush. It
+// returns the reason when the shading cannot be converted.
+func (p *contentPlayer) shadingBrush(obj pdfObject) (recording.Brush, string) {
+	dict, err := p.r.resolveDict(obj)
+	if err != nil || dict == nil {
+		return nil, "invalid shading"
+	}
+	kind, _ := p.r.resolve(dict["ShadingType"])
+	coords, _ := p.numbers(dict["Coords"])
+	switch {
+	case kind == 2 && len(coords) == 4, kind == 3 && len(coords) == 6:
+	case kind == 2 || kind == 3:
+		return nil, "invalid shading"
+	default:
+		return nil, fmt.Sprintf("shading type %v", kind)
+	}
+	space := p.colorSpace(dict["ColorSpace"], 0)
+	if space == nil || space.family == "Pattern" || space.family == "Indexed" {
+		return nil, "unsupported color space"
+	}
+	fn, err := p.function(dict["Function"], 0)
+	if err != nil {
+		return nil, err.Error()
+	}
+	t0, t1 := 0.0, 1.0
+	if domain, ok := p.numbers(dict["Domain"]); ok && len(domain) == 2 {
+		t0, t1 = domain[0], domain[1]
+	}
+	offset := func(t float64) float64 {
+		if t1 == t0 {
+			return 0
+		}
+		return (t - t0) / (t1 - t0)
+	}
+	alpha := p.state.fillAlpha
+	stops := fn.stops(nil, t0, t1, offset, func(c []float64) gg.RGBA {
+		return withAlpha(space.rgba(c), alpha)
+	})
+
+	if kind == 2 {
+		brush := recording.NewLinearGradientBrush(coords[0], coords[1], coords[2], coords[3])
+		brush.Stops = stops
+		return brush, ""
+	}
+	brush := recording.NewRadialGradientBrush(coords[3], coords[4], coords[2], coords[5]).
+		SetFocus(coords[0], coords[1])
+	brush.Stops = stops
+	return brush, ""
+}
+
+// xobject draws a named external object with Do.
+func (p *contentPlayer) xobject(name pdfObject, depth int) bool {
+	obj, err := p.resource("XObject", name)
+	if err != nil {
+		return false
+	}
+	stream, ok := obj.(*pdfStream)
+	if !ok {
+		return false
+	}
+	switch stream.Dict["Subtype"] {
+	case pdfName("Image"):
+		p.drawImage(stream)
+	case pdfName("Form"):
+		p.drawForm(stream, depth)
+	default:
+		p.report("Do", fmt.Sprintf("%v XObject", stream.Dict["Subtype"]))
+	}
+	return true
+}
+
+// drawImage draws an image XObject onto the unit square of user space.
+func (p *contentPlayer) drawImage(stream *pdfStream) {
+	img, cached := p.images[stream]
+	if !cached {
+		var reason string
+		if img, reason = p.decodeImage(stream); img == nil {
+			p.report("Do", reason)
+			return
+		}
+		if mask, _ := stream.Dict["ImageMask"].(bool); !mask {
+			p.images[stream] = img
+		}
+	}
+
+	// Draw the image at its pixel size in a space that maps it onto the
+	// unit square, first row at the top, as the backend does.
+	b := img.Bounds()
+	w, h := float64(b.Dx()), float64(b.Dy())
+	m := p.state.ctm.Multiply(recording.Matrix{A: 1 / w, E: -1 / h, F: 1})
+	if !invertible(m) {
+		return
+	}
+	p.beginDrawing(m)
+	rect := recording.NewRect(0, 0, w, h)
+	opts := recording.DefaultImageOptions()
+	opts.Alpha = p.state.fillAlpha
+	p.backend.DrawImage(img, rect, rect, opts)
+}
+
+// drawForm runs the content of a form XObject, clipped to its bounding box.
+func (p *contentPlayer) drawForm(stream *pdfStream, depth int) {
+	if depth >= maxFormDepth || p.forms[stream] {
+		p.report("Do", "recursive form")
+		return
+	}
+	data, err := decodeStream(stream)
+	if err != nil {
+		p.report("Do", err.Error())
+		return
+	}
+	resources, _ := p.r.resolveDict(stream.Dict["Resources"])
+	if resources == nil {
+		resources = p.state.resources
+	}
+
+	p.save()
+	level := len(p.stack)
+	if m, ok := p.numbers(stream.Dict["Matrix"]); ok && len(m) == 6 {
+		p.state.ctm = p.state.ctm.Multiply(matrixOperands(m))
+	}
+	if box, ok := p.numbers(stream.Dict["BBox"]); ok && len(box) == 4 {
+		clip := gg.NewPath()
+		clip.Rectangle(min(box[0], box[2]), min(box[1], box[3]), math.Abs(box[2]-box[0]), math.Abs(box[3]-box[1]))
+		p.state.clip = &pendingClip{path: clip, rule: recording.FillRuleNonZero, ctm: p.state.ctm}
+	}
+
+	p.forms[stream] = true
+	path, rect, clipRule := p.path, p.rect, p.clipRule
+	p.path, p.rect, p.clipRule = nil, nil, nil
+	err = p.run(data, resources, depth+1)
+	p.path, p.rect, p.clipRule = path, rect, clipRule
+	delete(p.forms, stream)
+	if err != nil {
+		p.report("Do", "malformed form content")
+	}
+	for len(p.stack) >= level {
+		p.restore()
+	}
+}
+
+// decodeImage decodes an image XObject to an image. It returns the reason
+// when the image cannot be decoded.
+func (p *contentPlayer) decodeImage(stream *pdfStream) (image.Image, string) {
+	dict := stream.Dict
+	if m, _ := p.r.resolve(dict["Mask"]); m != nil {
+		p.report("Do", "image with /Mask")
+	}
+	if mask, _ := dict["ImageMask"].(bool); mask {
+		if p.state.fill.pattern != nil {
+			return nil, "stencil mask with pattern"
+		}
+		return p.imageSamples(stream, nil)
+	}
+
+	filters := streamFilters(dict)
+	if n := len(filters); n > 0 && filters[n-1] == "DCTDecode" {
+		prefix := make(pdfArray, 0, n-1)
+		for _, f := range filters[:n-1] {
+			prefix = append(prefix, f)
+		}
+		data, err := decodeStream(&pdfStream{Dict: pdfDict{"Filter": prefix, "DecodeParms": dict["DecodeParms"]}, Data: stream.Data})
+		if err != nil {
+			return nil, err.Error()
+		}
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, "invalid JPEG image"
+		}
+		return p.applySoftMask(img, dict)
+	}
+	space := p.colorSpace(dict["ColorSpace"], 0)
+	if space == nil || space.family == "Pattern" {
+		return nil, "unsupported image color space"
+	}
+	img, reason := p.imageSamples(stream, space)
+	if img == nil {
+		return nil, reason
+	}
+	return p.applySoftMask(img, dict)
+}
+
+// streamFilters returns the names of the filters of a stream.
+func streamFilters(dict pdfDict) []pdfName {
+	switch f := dict["Filter"].(type) {
+	case pdfName:
+		return []pdfName{f}
+	case pdfArray:
+		names := make([]pdfName, 0, len(f))
+		for _, item := range f {
+			if name, ok := item.(pdfName); ok {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+	return nil
+}
+
+// imageSamples decodes the samples of an image in space, or of a stencil
+// mask painted with the fill color when space is nil.
+func (p *contentPlayer) imageSamples(stream *pdfStream, space *colorSpace) (*image.NRGBA, string) {
+	dict := stream.Dict
+	integer := func(key pdfName) int {
+		v, _ := p.r.resolve(dict[key])
+		n, _ := v.(int)
+		return n
+	}
+	width, height, bpc := integer("Width"), integer("Height"), integer("BitsPerComponent")
+	n := 1
+	if space == nil {
+		bpc = 1
+	} else {
+		n = space.n
+	}
+	switch bpc {
+	case 1, 2, 4, 8, 16:
+	default:
+		return nil, fmt.Sprintf("%d bits per component", bpc)
+	}
+	if width <= 0 || height <= 0 || width > math.MaxInt32/4/n || height > math.MaxInt32/width {
+		return nil, "invalid image size"
+	}
+	data, err := decodeStream(stream)
+	if err != nil {
+		return nil, err.Error()
+	}
+	stride := (width*n*bpc + 7) / 8
+	if len(data) < stride*height {
+		return nil, "truncated image data"
+	}
+
+	maxSample := float64(uint32(1)<<bpc - 1)
+	decode := make([]float64, 2*n)
+	for i := 0; i < n; i++ {
+		decode[2*i+1] = 1
+		if space != nil && space.family == "Indexed" {
+			decode[2*i+1] = maxSample
+		}
+	}
+	if d, ok := p.numbers(dict["Decode"]); ok && len(d) == 2*n {
+		decode = d
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	fill := nrgba(p.state.fill.color)
+	comps := make([]float64, n)
+	for y := 0; y < height; y++ {
+		row := data[y*stride : (y+1)*stride]
+		for x := 0; x < width; x++ {
+			for c := 0; c < n; c++ {
+				v := float64(sampleAt(row, x*n+c, bpc))
+				comps[c] = decode[2*c] + v*(decode[2*c+1]-decode[2*c])/maxSample
+			}
+			if space == nil {
+				// Stencil masks paint where the decoded sample is 0.
+				if comps[0] < 0.5 {
+					img.SetNRGBA(x, y, fill)
+				}
+				continue
+			}
+			img.SetNRGBA(x, y, nrgba(space.rgba(comps)))
+		}
+	}
+	return img, ""
+}
+
+// sampleAt returns sample i of an image row.
+func sampleAt(row []byte, i, bpc int) uint32 {
+	switch bpc {
+	case 8:
+		return uint32(row[i])
+	case 16:
+		return uint32(row[2*i])<<8 | uint32(row[2*i+1])
+	}
+	bit := i * bpc
+	return uint32(row[bit/8]>>(8-bpc-bit%8)) & (1<<bpc - 1)
+}
+
+// nrgba converts a color to 8-bit components.
+func nrgba(c gg.RGBA) color.NRGBA {
+	return color.NRGBA{
+		R: uint8(math.Round(clamp01(c.R) * 255)),
+		G: uint8(math.Round(clamp01(c.G) * 255)),
+		B: uint8(math.Round(clamp01(c.B) * 255)),
+		A: uint8(math.Round(clamp01(c.A) * 255)),
+	}
+}
+
+// applySoftMask applies the /SMask of an image as its alpha channel,
+// scaling the mask to the image if their sizes differ.
+func (p *contentPlayer) applySoftMask(img image.Image, dict pdfDict) (image.Image, string) {
+	obj, _ := p.r.resolve(dict["SMask"])
+	smask, ok := obj.(*pdfStream)
+	if !ok {
+		return img, ""
+	}
+	mask, reason := p.imageSamples(smask, deviceGray)
+	if mask == nil {
+		return nil, "soft mask: " + reason
+	}
+	out, ok := img.(*image.NRGBA)
+	if !ok {
+		out = image.NewNRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+		draw.Draw(out, out.Bounds(), img, img.Bounds().Min, draw.Src)
+	}
+	b, mb := out.Bounds(), mask.Bounds()
+	for y := 0; y < b.Dy(); y++ {
+		my := y * mb.Dy() / b.Dy()
+		for x := 0; x < b.Dx(); x++ {
+			mx := x * mb.Dx() / b.Dx()
+			out.Pix[y*out.Stride+4*x+3] = mask.Pix[my*mask.Stride+4*mx]
+		}
+	}
+	return out, ""
+}
+
+// colorSpace is a color space whose colors can be converted to RGB.
+type colorSpace struct {
+	family pdfName
+	n      int
+
+	// base is the alternate space of Separation and DeviceN spaces,
+	// converted to by tint, and the base of Indexed spaces, looked up in
+	// lookup.
+	base   *colorSpace
+	tint   *pdfFunction
+	lookup []byte
+}
+
+var (
+	deviceGray = &colorSpace{family: "DeviceGray", n: 1}
+	deviceRGB  = &colorSpace{family: "DeviceRGB", n: 3}
+	deviceCMYK = &colorSpace{family: "DeviceCMYK", n: 4}
+)
+
+// initial returns the components of the initial color of the space, black
+// or full tint.
+func (cs *colorSpace) initial() []float64 {
+	comps := make([]float64, cs.n)
+	switch cs.family {
+	case "DeviceCMYK":
+		comps[3] = 1
+	case "Separation", "DeviceN":
+		for i := range comps {
+			comps[i] = 1
+		}
+	}
+	return comps
+}
+
+// rgba converts the components of a color in the space to RGB.
+func (cs *colorSpace) rgba(comps []float64) gg.RGBA {
+	switch cs.family {
+	case "DeviceGray":
+		g := clamp01(comps[0])
+		return gg.RGBA{R: g, G: g, B: g, A: 1}
+	case "DeviceRGB":
+		return gg.RGBA{R: clamp01(comps[0]), G: clamp01(comps[1]), B: clamp01(comps[2]), A: 1}
+	case "DeviceCMYK":
+		k := 1 - clamp01(comps[3])
+		return gg.RGBA{
+			R: (1 - clamp01(comps[0])) * k,
+			G: (1 - clamp01(comps[1])) * k,
+			B: (1 - clamp01(comps[2])) * k,
+			A: 1,
+		}
+	case "Separation", "DeviceN":
+		return cs.base.rgba(cs.tint.eval(comps[0], cs.base.n))
+	case "Indexed":
+		n := cs.base.n
+		i := int(math.Round(comps[0]))
+		i = max(0, min(i, len(cs.lookup)/n-1))
+		base := make([]float64, n)
+		for c := range base {
+			base[c] = float64(cs.lookup[i*n+c]) / 255
+		}
+		return cs.base.rgba(base)
+	}
+	return gg.RGBA{A: 1}
+}
+
+// colorSpace resolves a color space operand or entry. Names other than the
+// device spaces are looked up in the ColorSpace resources. It returns nil
+// for spaces that cannot be converted.
+func (p *contentPlayer) colorSpace(obj pdfObject, depth int) *colorSpace {
+	if depth > 4 {
+		return nil
+	}
+	obj, err := p.r.resolve(obj)
+	if err != nil {
+		return nil
+	}
+	if name, ok := obj.(pdfName); ok {
+		switch name {
+		case "DeviceGray", "G", "CalGray":
+			return deviceGray
+		case "DeviceRGB", "RGB", "CalRGB":
+			return deviceRGB
+		case "DeviceCMYK", "CMYK":
+			return deviceCMYK
+		case "Pattern":
+			return &colorSpace{family: "Pattern"}
+		}
+		res, err := p.resource("ColorSpace", name)
+		if err != nil || res == nil {
+			return nil
+		}
+		return p.colorSpace(res, depth+1)
+	}
+
+	arr, _ := obj.(pdfArray)
+	if len(arr) == 0 {
+		return nil
+	}
+	family, _ := p.r.resolve(arr[0])
+	switch family {
+	case pdfName("CalGray"):
+		return deviceGray
+	case pdfName("CalRGB"):
+		return deviceRGB
+	case pdfName("ICCBased"):
+		if len(arr) < 2 {
+			return nil
+		}
+		dict, _ := p.r.resolveDict(arr[1])
+		if alt, ok := dict["Alternate"]; ok {
+			return p.colorSpace(alt, depth+1)
+		}
+		switch n, _ := p.r.resolve(dict["N"]); n {
+		case 1:
+			return deviceGray
+		case 3:
+			return deviceRGB
+		case 4:
+			return deviceCMYK
+		}
+	case pdfName("Separation"), pdfName("DeviceN"):
+		if len(arr) < 4 {
+			return nil
+		}
+		n := 1
+		if family == pdfName("DeviceN") {
+			names, _ := p.r.resolve(arr[1])
+			if list, _ := names.(pdfArray); len(list) != 1 {
+				return nil // Only tint transforms of one input are supported.
+			}
+		}
+		base := p.colorSpace(arr[2], depth+1)
+		tint, err := p.function(arr[3], 0)
+		if base == nil || base.base != nil || err != nil {
+			return nil
+		}
+		return &colorSpace{family: family.(pdfName), n: n, base: base, tint: tint}
+	case pdfName("Indexed"):
+		if len(arr) < 4 {
+			return nil
+		}
+		base := p.colorSpace(arr[1], depth+1)
+		if base == nil || base.base != nil || base.family == "Pattern" {
+			return nil
+		}
+		var lookup []byte
+		switch v, _ := p.r.resolve(arr[3]); v := v.(type) {
+		case pdfString:
+			lookup = []byte(v)
+		case *pdfStream:
+			lookup, _ = decodeStream(v)
+		}
+		if len(lookup) < base.n {
+			return nil
+		}
+		return &colorSpace{family: "Indexed", n: 1, base: base, lookup: lookup}
+	case pdfName("Pattern"):
+		return &colorSpace{family: "Pattern"}
+	}
+	return nil
+}
+
+// pdfFunction is a PDF function of one input: an exponential, stitching,
+// or sampled function, or an array of functions each giving one output.
+type pdfFunction struct {
+	kind   int
+	domain [2]float64
+
+	// Exponential functions.
+	c0, c1   []float64
+	exponent float64
+
+	// Stitching functions, and the functions of an array.
+	parts  []*pdfFunction
+	bounds []float64
+	encode []float64
+
+	// Sampled functions, decoded to output values.
+	samples [][]float64
+}
+
+// functionArray is the kind of an array of functions.
+const functionArray = -1
+
+// function parses a function object.
+func (p *contentPlayer) function(obj pdfObject, depth int) (*pdfFunction, error) {
+	if depth > maxFunctionDepth {
+		return nil, errors.New("function nested too deeply")
+	}
+	obj, err := p.r.resolve(obj)
+	if err != nil {
+		return nil, err
+	}
+	if arr, ok := obj.(pdfArray); ok {
+		f := &pdfFunction{kind: functionArray}
+		for _, item := range arr {
+			part, err := p.function(item, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			f.parts = append(f.parts, part)
+		}
+		if len(f.parts) == 0 {
+			return nil, errors.New("invalid function")
+		}
+		return f, nil
+	}
+
+	dict, err := p.r.resolveDict(obj)
+	if err != nil || dict == nil {
+		return nil, errors.New("invalid function")
+	}
+	kind, _ := p.r.resolve(dict["FunctionType"])
+	f := &pdfFunction{domain: [2]float64{0, 1}}
+	if domain, ok := p.numbers(dict["Domain"]); ok && len(domain) >= 2 {
+		f.domain = [2]float64{domain[0], domain[1]}
+	}
+	switch kind {
+	case 2:
+		f.kind = 2
+		f.c0, f.c1 = []float64{0}, []float64{1}
+		if c0, ok := p.numbers(dict["C0"]); ok {
+			f.c0 = c0
+		}
+		if c1, ok := p.numbers(dict["C1"]); ok {
+			f.c1 = c1
+		}
+		exp, _ := p.r.resolve(dict["N"])
+		var ok bool
+		if f.exponent, ok = number(exp); !ok || len(f.c0) != len(f.c1) {
+			return nil, errors.New("invalid exponential function")
+		}
+	case 3:
+		f.kind = 3
+		funcs, _ := p.r.resolve(dict["Functions"])
+		list, _ := funcs.(pdfArray)
+		for _, item := range list {
+			part, err := p.function(item, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			f.parts = append(f.parts, part)
+		}
+		f.bounds, _ = p.numbers(dict["Bounds"])
+		f.encode, _ = p.numbers(dict["Encode"])
+		if len(f.parts) == 0 || len(f.bounds) != len(f.parts)-1 || len(f.encode) != 2*len(f.parts) {
+			return nil, errors.New("invalid stitching function")
+		}
+	case 0:
+		stream, ok := obj.(*pdfStream)
+		if !ok {
+			return nil, errors.New("invalid sampled function")
+		}
+		return p.sampledFunction(f, stream)
+	default:
+		return nil, fmt.Errorf("function type %v", kind)
+	}
+	return f, nil
+}
+
+// sampledFunction decodes the samples of a sampled function of one input.
+func (p *contentPlayer) sampledFunction(f *pdfFunction, stream *pdfStream) (*pdfFunction, error) {
+	f.kind = 0
+	size, _ := p.numbers(stream.Dict["Size"])
+	rng, _ := p.numbers(stream.Dict["Range"])
+	bps, _ := stream.Dict["BitsPerSample"].(int)
+	if len(size) != 1 || size[0] < 1 || size[0] > 1<<16 || len(rng) < 2 || len(rng)%2 != 0 {
+		return nil, errors.New("unsupported sampled function")
+	}
+	switch bps {
+	case 1, 2, 4, 8, 16:
+	default:
+		return nil, errors.New("unsupported sampled function")
+	}
+	m, outputs := int(size[0]), len(rng)/2
+	f.encode = []float64{0, float64(m - 1)}
+	if encode, ok := p.numbers(stream.Dict["Encode"]); ok && len(encode) == 2 {
+		f.encode = encode
+	}
+	decode := rng
+	if d, ok := p.numbers(stream.Dict["Decode"]); ok && len(d) == len(rng) {
+		decode = d
+	}
+	data, err := decodeStream(stream)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)*8 < m*outputs*bps {
+		return nil, errors.New("truncated sampled function")
+	}
+	maxSample := float64(uint32(1)<<bps - 1)
+	for i := 0; i < m; i++ {
+		out := make([]float64, outputs)
+		for j := range out {
+			v := float64(sampleAt(data, i*outputs+j, bps))
+			out[j] = decode[2*j] + v*(decode[2*j+1]-decode[2*j])/maxSample
+			out[j] = max(rng[2*j], min(out[j], rng[2*j+1]))
+		}
+		f.samples = append(f.samples, out)
+	}
+	return f, nil
+}
+
+// eval evaluates the function at t, padding or truncating the result to n
+// outputs.
+func (f *pdfFunction) eval(t float64, n int) []float64 {
+	out := f.value(t)
+	for len(out) < n {
+		out = append(out, 0)
+	}
+	return out[:n]
+}
+
+func (f *pdfFunction) value(t float64) []float64 {
+	if f.kind == functionArray {
+		var out []float64
+		for _, part := range f.parts {
+			out = append(out, part.value(t)...)
+		}
+		return out
+	}
+	t = max(f.domain[0], min(t, f.domain[1]))
+	switch f.kind {
+	case 2:
+		x := math.Pow(t, f.exponent)
+		out := make([]float64, len(f.c0))
+		for i := range out {
+			out[i] = f.c0[i] + x*(f.c1[i]-f.c0[i])
+		}
+		return out
+	case 3:
+		k, lo, hi := f.segment(t)
+		return f.parts[k].value(interpolate(t, lo, hi, f.encode[2*k], f.encode[2*k+1]))
+	}
+	e := interpolate(t, f.domain[0], f.domain[1], f.encode[0], f.encode[1])
+	e = max(0, min(e, float64(len(f.samples)-1)))
+	i := int(e)
+	if i == len(f.samples)-1 {
+		return f.samples[i]
+	}
+	out := make([]float64, len(f.samples[i]))
+	for j := range out {
+		out[j] = f.samples[i][j] + (e-float64(i))*(f.samples[i+1][j]-f.samples[i][j])
+	}
+	return out
+}
+
+// segment returns the part of a stitching function that t falls in and the
+// part's subdomain.
+func (f *pdfFunction) segment(t float64) (k int, lo, hi float64) {
+	for k < len(f.bounds) && t >= f.bounds[k] {
+		k++
+	}
+	return k, f.bound(k - 1), f.bound(k)
+}
+
+// bound returns bound k of a stitching function, the ends of its domain
+// being bounds -1 and len(parts)-1.
+func (f *pdfFunction) bound(k int) float64 {
+	switch {
+	case k < 0:
+		return f.domain[0]
+	case k >= len(f.bounds):
+		return f.domain[1]
+	}
+	return f.bounds[k]
+}
+
+// interpolate maps x from [x0, x1] to [y0, y1].
+func interpolate(x, x0, x1, y0, y1 float64) float64 {
+	if x1 == x0 {
+		return y0
+	}
+	return y0 + (x-x0)*(y1-y0)/(x1-x0)
+}
+
+// linear reports whether the outputs of the function change linearly with
+// its input.
+func (f *pdfFunction) linear() bool {
+	switch f.kind {
+	case 2:
+		return f.exponent == 1
+	case functionArray:
+		for _, part := range f.parts {
+			if !part.linear() {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gradientSamples is the number of pieces nonlinear functions are sampled
+// in when converted to gradient stops.
+const gradientSamples = 16
+
+// stops appends gradient stops for the function between inputs lo and hi,
+// placed at offset(t) and colored by colorOf. Linear functions and the parts
+// of stitching functions map to stops exactly; others are sampled.
+func (f *pdfFunction) stops(stops []recording.GradientStop, lo, hi float64, offset func(float64) float64, colorOf func([]float64) gg.RGBA) []recording.GradientStop {
+	add := func(t float64) {
+		stop := recording.GradientStop{Offset: clamp01(offset(t)), Color: colorOf(f.value(t))}
+		if n := len(stops); n > 0 && stops[n-1] == stop {
+			return
+		}
+		stops = append(stops, stop)
+	}
+
+	switch {
+	case f.linear():
+		add(lo)
+		add(hi)
+	case f.kind == 3:
+		a, b := min(lo, hi), max(lo, hi)
+		for k, part := range f.parts {
+			s0, s1 := f.bound(k-1), f.bound(k)
+			if s1 < a || s0 > b {
+				continue
+			}
+			s0, s1 = max(s0, a), min(s1, b)
+			e0, e1 := f.encode[2*k], f.encode[2*k+1]
+			t0, t1 := f.bound(k-1), f.bound(k)
+			partOffset := func(e float64) float64 { return offset(interpolate(e, e0, e1, t0, t1)) }
+			stops = part.stops(stops, interpolate(s0, t0, t1, e0, e1), interpolate(s1, t0, t1, e0, e1), partOffset, colorOf)
+		}
+	default:
+		pieces := gradientSamples
+		if f.kind == 0 {
+			pieces = max(pieces, min(len(f.samples)-1, 256))
+		}
+		for i := 0; i <= pieces; i++ {
+			add(lo + (hi-lo)*float64(i)/float64(pieces))
+		}
+	}
+	return stops
+}
+
+// recordingBuilder is a backend that collects the calls it receives as the
+// commands of a recording.
+type recordingBuilder struct {
+	width, height int
+	commands      []recording.Command
+	paths         []*gg.Path
+	brushes       []recording.Brush
+	images        []image.Image
+	imageRefs     map[image.Image]recording.ImageRef
+}
+
+func (b *recordingBuilder) Begin(width, height int) error {
+	*b = recordingBuilder{width: width, height: height, imageRefs: make(map[image.Image]recording.ImageRef)}
+	return nil
+}
+
+func (b *recordingBuilder) End() error { return nil }
+
+func (b *recordingBuilder) Save() { b.commands = append(b.commands, recording.SaveCommand{}) }
+
+func (b *recordingBuilder) Restore() { b.commands = append(b.commands, recording.RestoreCommand{}) }
+
+func (b *recordingBuilder) SetTransform(m recording.Matrix) {
+	b.commands = append(b.commands, recording.SetTransformCommand{Matrix: m})
+}
+
+func (b *recordingBuilder) SetClip(path *gg.Path, rule recording.FillRule) {
+	b.commands = append(b.commands, recording.SetClipCommand{Path: b.path(path), Rule: rule})
+}
+
+func (b *recordingBuilder) ClearClip() {
+	b.commands = append(b.commands, recording.ClearClipCommand{})
+}
+
+func (b *recordingBuilder) FillPath(path *gg.Path, brush recording.Brush, rule recording.FillRule) {
+	b.commands = append(b.commands, recording.FillPathCommand{Path: b.path(path), Brush: b.brush(brush), Rule: rule})
+}
+
+func (b *recordingBuilder) StrokePath(path *gg.Path, brush recording.Brush, stroke recording.Stroke) {
+	b.commands = append(b.commands, recording.StrokePathCommand{Path: b.path(path), Brush: b.brush(brush), Stroke: stroke})
+}
+
+func (b *recordingBuilder) FillRect(rect recording.Rect, brush recording.Brush) {
+	b.commands = append(b.commands, recording.FillRectCommand{Rect: rect, Brush: b.brush(brush)})
+}
+
+func (b *recordingBuilder) DrawImage(img image.Image, src, dst recording.Rect, opts recording.ImageOptions) {
+	ref, ok := b.imageRefs[img]
+	if !ok {
+		ref = recording.ImageRef(len(b.images)) //nolint:gosec // bounded by memory
+		b.images = append(b.images, img)
+		b.imageRefs[img] = ref
+	}
+	b.commands = append(b.commands, recording.DrawImageCommand{Image: ref, SrcRect: src, DstRect: dst, Options: opts})
+}
+
+func (b *recordingBuilder) DrawText(s string, x, y float64, face text.Face, brush recording.Brush) {
+	b.commands = append(b.commands, recording.DrawTextCommand{Text: s, X: x, Y: y, FontSize: faceSize(face), Brush: b.brush(brush)})
+}
+
+func (b *recordingBuilder) path(path *gg.Path) recording.PathRef {
+	b.paths = append(b.paths, path)
+	return recording.PathRef(len(b.paths) - 1) //nolint:gosec // bounded by memory
+}
+
+func (b *recordingBuilder) brush(brush recording.Brush) recording.BrushRef {
+	b.brushes = append(b.brushes, brush)
+	return recording.BrushRef(len(b.brushes) - 1) //nolint:gosec // bounded by memory
+}
@@ -0,0 +1,238 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/gogpu/gg"
+	"github.com/gogpu/gg/recording"
+)
+
+// vectorRecording returns a recording of paths, clips, and gradients only.
+func vectorRecording() *recording.Recording {
+	rec := recording.NewRecorder(200, 100)
+	rec.SetFillRGB(1, 1, 1)
+	rec.DrawRectangle(0, 0, 200, 100)
+	rec.Fill()
+
+	rec.Save()
+	rec.DrawRectangle(20, 10, 160, 80)
+	rec.Clip()
+	rec.SetFillStyle(recording.NewLinearGradientBrush(0, 0, 160, 0).
+		AddColorStop(0, gg.Blue).
+		AddColorStop(0.5, gg.RGBA{R: 1, G: 0.5, A: 1}).
+		AddColorStop(1, gg.Green))
+	rec.DrawRoundedRectangle(30, 20, 40, 60, 5)
+	rec.Fill()
+	rec.SetFillRuleGG(gg.FillRuleEvenOdd)
+	rec.SetFillStyle(recording.NewRadialGradientBrush(110, 50, 0, 20).SetFocus(105, 45).
+		AddColorStop(0, gg.White).
+		AddColorStop(1, gg.Red))
+	rec.DrawCircle(110, 50, 20)
+	rec.Fill()
+	rec.Restore()
+
+	rec.SetFillRGBA(0, 0.5, 0, 0.5)
+	rec.MoveTo(150, 20)
+	rec.LineTo(190, 50)
+	rec.LineTo(150, 80)
+	rec.ClosePath()
+	rec.Fill()
+	rec.SetStrokeRGBA(1, 0, 0, 0.8)
+	rec.SetLineWidth(2)
+	rec.SetLineCap(recording.LineCapRound)
+	rec.SetLineJoin(recording.LineJoinBevel)
+	rec.SetDash(4, 2)
+	rec.MoveTo(20, 90)
+	rec.QuadraticTo(60, 60, 100, 90)
+	rec.Stroke()
+	return rec.FinishRecording()
+}
+
+// playbackPDF writes rec as a one-page document and opens the result.
+func playbackPDF(t *testing.T, rec *recording.Recording) (*Document, *SourcePDF) {
+	t.Helper()
+
+	doc := NewDocument()
+	if err := doc.Playback(rec); err != nil {
+		t.Fatalf("Playback failed: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	return doc, openSource(t, buf.Bytes())
+}
+
+func TestPlayPageRoundTrip(t *testing.T) {
+	doc, src := playbackPDF(t, vectorRecording())
+	got, unsupported, err := src.RecordPage(0)
+	if err != nil {
+		t.Fatalf("RecordPage failed: %v", err)
+	}
+	if len(unsupported) != 0 {
+		t.Errorf("unsupported = %v, want none", unsupported)
+	}
+	if got.Width() != 200 || got.Height() != 100 {
+		t.Errorf("recording size = %dx%d, want 200x100", got.Width(), got.Height())
+	}
+
+	again := NewDocument()
+	if err := again.Playback(got); err != nil {
+		t.Fatalf("Playback of the converted page failed: %v", err)
+	}
+	if want, have := doc.pages[0].content.buf.String(), again.pages[0].content.buf.String(); have != want {
+		t.Errorf("converted page draws\n%s\nwant\n%s", have, want)
+	}
+}
+
+func TestPlayPageImages(t *testing.T) {
+	_, src := playbackPDF(t, chartRecording())
+	got, unsupported, err := src.RecordPage(0)
+	if err != nil {
+		t.Fatalf("RecordPage failed: %v", err)
+	}
+	want := []UnsupportedOperator{{Operator: "Tj", Reason: "text", Count: 1}}
+	if !reflect.DeepEqual(unsupported, want) {
+		t.Errorf("unsupported = %v, want %v", unsupported, want)
+	}
+
+	var (
+		transform = recording.Identity()
+		draws     []recording.Matrix
+		images    []image.Image
+	)
+	for _, cmd := range got.Commands() {
+		switch c := cmd.(type) {
+		case recording.SetTransformCommand:
+			transform = c.Matrix
+		case recording.DrawImageCommand:
+			img := got.Resources().GetImage(c.Image)
+			b := img.Bounds()
+			if c.SrcRect != recording.NewRect(0, 0, float64(b.Dx()), float64(b.Dy())) || c.DstRect != c.SrcRect {
+				t.Errorf("image drawn from %v to %v, want its pixel bounds", c.SrcRect, c.DstRect)
+			}
+			// The mapping of the unit square, as the page content has it.
+			draws = append(draws, transform.Multiply(recording.Matrix{A: float64(b.Dx()), E: -float64(b.Dy()), F: float64(b.Dy())}))
+			images = append(images, img)
+		}
+	}
+	if len(images) != 1 {
+		t.Fatalf("recording draws %d images, want 1", len(images))
+	}
+
+	nrgba, ok := images[0].(*image.NRGBA)
+	if !ok || nrgba.Bounds().Dx() != 2 || nrgba.NRGBAAt(0, 0).A != 128 || nrgba.NRGBAAt(0, 0).R != 255 ||
+		nrgba.NRGBAAt(1, 1).B != 255 || nrgba.NRGBAAt(1, 0).A != 0 {
+		t.Errorf("first image = %#v, want the 2x2 image with its alpha", images[0])
+	}
+	if want := (recording.Matrix{A: 20, E: -20, C: 150, F: 30}); !matricesClose(draws[0], want) {
+		t.Errorf("image is mapped by %+v, want %+v", draws[0], want)
+	}
+}
+
+// matricesClose reports whether a and b differ only by rounding.
+func matricesClose(a, b recording.Matrix) bool {
+	for _, d := range []float64{a.A - b.A, a.B - b.B, a.C - b.C, a.D - b.D, a.E - b.E, a.F - b.F} {
+		if math.Abs(d) > 1e-6 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestPlayPageContent(t *testing.T) {
+	content := "q 2 0 0 2 0 0 cm 0 1 0 0 k 5 5 10 10 re f Q\n" +
+		"/CS0 cs 0.5 sc 0 0 m 10 0 l 10 10 l f\n" +
+		"q 0 0 50 50 re W n /Sh0 sh Q\n" +
+		"/Pattern cs /P0 scn 0 0 20 20 re f\n" +
+		"q 10 0 0 10 60 60 cm /Fm0 Do Q\n" +
+		"BT /F1 12 Tf (Hi) Tj ET\n" +
+		"BI /W 1 /H 1 /BPC 8 /CS /G ID \x00 EI\n" +
+		"1 2 3 l 7 xyz\n"
+	data := handcraftedPDF("",
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 100 100] /Contents 4 0 R /Resources 5 0 R >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+		"<< /ColorSpace << /CS0 [/Separation /Spot /DeviceRGB << /FunctionType 2 /Domain [0 1] /C0 [1 1 1] /C1 [1 0 0] /N 1 >>] >>"+
+			" /Shading << /Sh0 6 0 R >> /Pattern << /P0 << /PatternType 2 /Shading 6 0 R /Matrix [1 0 0 1 0 0] >> >>"+
+			" /XObject << /Fm0 7 0 R >> >>",
+		"<< /ShadingType 2 /ColorSpace /DeviceGray /Coords [0 0 50 0] /Function << /FunctionType 2 /Domain [0 1] /C0 [0] /C1 [1] /N 1 >> >>",
+		"<< /Type /XObject /Subtype /Form /BBox [0 0 1 1] /Length 12 >>\nstream\n0 0 1 1 re f\nendstream",
+	)
+	src := openSource(t, data)
+	got, unsupported, err := src.RecordPage(0)
+	if err != nil {
+		t.Fatalf("RecordPage failed: %v", err)
+	}
+	want := []UnsupportedOperator{
+		{Operator: "Tj", Reason: "text", Count: 1},
+		{Operator: "BI", Reason: "inline image", Count: 1},
+		{Operator: "l", Reason: "invalid operands", Count: 1},
+		{Operator: "xyz", Reason: "unknown operator", Count: 1},
+	}
+	if !reflect.DeepEqual(unsupported, want) {
+		t.Errorf("unsupported = %v, want %v", unsupported, want)
+	}
+
+	pool := got.Resources()
+	var kinds []string
+	for _, cmd := range got.Commands() {
+		kinds = append(kinds, cmd.Type().String())
+	}
+	wantKinds := []string{
+		"SetTransform", "FillRect", // CMYK square
+		"SetTransform", "FillPath", // spot color triangle
+		"FillPath",                                               // shading filling its clip
+		"FillPath",                                               // shading pattern
+		"Save", "SetTransform", "SetClip", "FillPath", "Restore", // form, still filled with the pattern
+	}
+	if !reflect.DeepEqual(kinds, wantKinds) {
+		t.Fatalf("commands = %v, want %v", kinds, wantKinds)
+	}
+	cmds := got.Commands()
+
+	flip := recording.Matrix{A: 1, E: -1, F: 100}
+	if m := cmds[0].(recording.SetTransformCommand).Matrix; m != flip.Multiply(recording.Scale(2, 2)) {
+		t.Errorf("first transform = %+v, want the page flip and cm", m)
+	}
+	if c := pool.GetBrush(cmds[1].(recording.FillRectCommand).Brush).(recording.SolidBrush).Color; c != (gg.RGBA{R: 1, G: 0, B: 1, A: 1}) {
+		t.Errorf("CMYK fill converted to %v, want magenta", c)
+	}
+	if c := pool.GetBrush(cmds[3].(recording.FillPathCommand).Brush).(recording.SolidBrush).Color; c != (gg.RGBA{R: 1, G: 0.5, B: 0.5, A: 1}) {
+		t.Errorf("spot color converted to %v, want the half tint", c)
+	}
+	for _, i := range []int{4, 5, 9} {
+		fill := cmds[i].(recording.FillPathCommand)
+		grad, ok := pool.GetBrush(fill.Brush).(*recording.LinearGradientBrush)
+		if !ok || grad.End != (gg.Point{X: 50}) || len(grad.Stops) != 2 || grad.Stops[1].Color != gg.White {
+			t.Errorf("command %d fills with %#v, want the axial shading", i, pool.GetBrush(fill.Brush))
+		}
+	}
+	if m := cmds[7].(recording.SetTransformCommand).Matrix; m != flip.Multiply(recording.Matrix{A: 10, E: 10, C: 60, F: 60}) {
+		t.Errorf("form transform = %+v", m)
+	}
+}
+
+func TestPlayPageErrors(t *testing.T) {
+	_, src := playbackPDF(t, vectorRecording())
+	if _, err := src.PlayPage(1, &recordingBuilder{}); err == nil {
+		t.Error("PlayPage(1) succeeded for a page out of range")
+	}
+
+	content := "0 0 m (unterminated"
+	data := handcraftedPDF("",
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 10 10] /Contents 4 0 R >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+	)
+	if _, _, err := openSource(t, data).RecordPage(0); err == nil {
+		t.Error("RecordPage succeeded for malformed content")
+	}
+}
@@ -0,0 +1,233 @@
+package pdf
+
+import (
+	"errors"
+	"fmt"
+)
+
+// LayerUsage overrides whether a layer is shown when a file is viewed or
+// printed, independently of the visibility toggled by the reader.
+type LayerUsage int
+
+const (
+	// LayerUsageDefault follows the layer's visibility.
+	LayerUsageDefault LayerUsage = iota
+
+	// LayerUsageOn always shows the layer.
+	LayerUsageOn
+
+	// LayerUsageOff always hides the layer.
+	LayerUsageOff
+)
+
+// LayerOptions describes how a layer is presented in viewers.
+type LayerOptions struct {
+	// Hidden hides the layer when the file is opened.
+	Hidden bool
+
+	// View and Print override the visibility on screen and in print, such as
+	// a grid shown on screen but never printed. Viewers apply them
+	// automatically; PDF/A does not allow them.
+	View, Print LayerUsage
+}
+
+// Layer is an optional content group: named content that readers can show
+// and hide in viewers such as Acrobat. Content is put on a layer between
+// BeginLayer and EndLayer.
+type Layer struct {
+	opts   LayerOptions
+	shared *sharedResources
+	ocg    *pdfIndirect
+}
+
+// layerState is an open layer: the content stream it began in and the Save
+// depth at which it began.
+type layerState struct {
+	content *contentStream
+	depth   int
+}
+
+// newLayer validates opts and adds a layer named name.
+func (s *sharedResources) newLayer(name string, opts LayerOptions) (*Layer, error) {
+	if name == "" {
+		return nil, errors.New("pdf: layer name is empty")
+	}
+	for _, usage := range []LayerUsage{opts.View, opts.Print} {
+		if usage < LayerUsageDefault || usage > LayerUsageOff {
+			return nil, fmt.Errorf("pdf: unknown layer usage %d", usage)
+		}
+	}
+	ocg := pdfDict{
+		"Type": pdfName("OCG"),
+		"Name": textString(name),
+	}
+	usage := pdfDict{}
+	if opts.View != LayerUsageDefault {
+		usage["View"] = pdfDict{"ViewState": usageState(opts.View)}
+	}
+	if opts.Print != LayerUsageDefault {
+		usage["Print"] = pdfDict{"PrintState": usageState(opts.Print)}
+	}
+	if len(usage) > 0 {
+		ocg["Usage"] = usage
+	}
+	l := &Layer{opts: opts, shared: s, ocg: newIndirect(ocg)}
+	s.layers = append(s.layers, l)
+	return l, nil
+}
+
+// usageState returns the state name of a usage other than the default.
+func usageState(u LayerUsage) pdfName {
+	if u == LayerUsageOn {
+		return "ON"
+	}
+	return "OFF"
+}
+
+// errNilLayer is reported for a nil *Layer.
+var errNilLayer = errors.New("pdf: layer is nil")
+
+// addRadioGroup makes the layers mutually exclusive.
+func (s *sharedResources) addRadioGroup(layers []*Layer) error {
+	if len(layers) < 2 {
+		return errors.New("pdf: radio group needs at least two layers")
+	}
+	for _, l := range layers {
+		if l == nil {
+			return errNilLayer
+		}
+		if l.shared != s {
+			return errors.New("pdf: layer belongs to another document")
+		}
+	}
+	s.radioGroups = append(s.radioGroups, layers)
+	return nil
+}
+
+// NewLayer adds a layer named name, listed in viewers in the order layers
+// are created:
+//
+//	dims, _ := b.NewLayer("Dimensions", pdf.LayerOptions{})
+//	grid, _ := b.NewLayer("Grid", pdf.LayerOptions{Print: pdf.LayerUsageOff})
+//	b.BeginLayer(grid)
+//	// ... draw the grid ...
+//	b.EndLayer()
+func (b *Backend) NewLayer(name string, opts LayerOptions) (*Layer, error) {
+	return b.shared.newLayer(name, opts)
+}
+
+// AddRadioGroup makes the layers mutually exclusive, like radio buttons:
+// showing one in a viewer hides the others. A layer may be in several
+// groups.
+func (b *Backend) AddRadioGroup(layers ...*Layer) error {
+	return b.shared.addRadioGroup(layers)
+}
+
+// BeginLayer puts the content drawn until the matching EndLayer on l.
+// Layers nest; content is shown only if all its layers are. BeginLayer
+// saves the graphics state, and EndLayer restores it.
+func (b *Backend) BeginLayer(l *Layer) {
+	if l == nil {
+		b.fail(errNilLayer)
+		return
+	}
+	if l.shared != b.shared {
+		b.fail(errors.New("pdf: layer belongs to another document"))
+		return
+	}
+	c := b.content
+	c.endMarked()
+	name := c.res.add("Properties", "OC", l.ocg, func() pdfObject { return l.ocg })
+	c.op("BDC", pdfName("OC"), name)
+	b.layers = append(b.layers, layerState{content: c, depth: len(b.stateStack)})
+	b.Save()
+}
+
+// EndLayer ends the layer begun last. Graphics states saved in the layer
+// and not restored are restored. A group or mask begun inside the layer
+// must end first.
+func (b *Backend) EndLayer() {
+	if len(b.layers) == 0 {
+		return
+	}
+	top := b.layers[len(b.layers)-1]
+	if top.content != b.content {
+		b.fail(errors.New("pdf: EndLayer called while a group or mask is open"))
+		return
+	}
+	for len(b.stateStack) > top.depth {
+		b.restore()
+	}
+	b.layers = b.layers[:len(b.layers)-1]
+	b.content.endMarked()
+	b.content.op("EMC")
+}
+
+// endLayers ends the layers begun in the current content stream.
+func (b *Backend) endLayers() {
+	for len(b.layers) > 0 && b.layers[len(b.layers)-1].content == b.content {
+		b.EndLayer()
+	}
+}
+
+// writeLayers adds the optional content properties for the layers to the
+// catalog.
+func writeLayers(out *outputFile, shared *sharedResources) error {
+	var all, hidden pdfArray
+	var view, printed pdfArray
+	for _, l := range shared.layers {
+		all = append(all, l.ocg)
+		if l.opts.Hidden {
+			hidden = append(hidden, l.ocg)
+		}
+		if l.opts.View != LayerUsageDefault {
+			view = append(view, l.ocg)
+		}
+		if l.opts.Print != LayerUsageDefault {
+			printed = append(printed, l.ocg)
+		}
+	}
+
+	config := pdfDict{
+		"Name":  textString("Layers"),
+		"Order": all,
+	}
+	if len(hidden) > 0 {
+		config["OFF"] = hidden
+	}
+	if len(shared.radioGroups) > 0 {
+		groups := make(pdfArray, len(shared.radioGroups))
+		for i, layers := range shared.radioGroups {
+			group := make(pdfArray, len(layers))
+			for j, l := range layers {
+				group[j] = l.ocg
+			}
+			groups[i] = group
+		}
+		config["RBGroups"] = groups
+	}
+	if len(view) > 0 || len(printed) > 0 {
+		if shared.conformance.isPDFA() {
+			return fmt.Errorf("pdf: %s does not allow layers with view or print usage", shared.conformance)
+		}
+		var auto pdfArray
+		for _, event := range []struct {
+			name pdfName
+			ocgs pdfArray
+		}{{"View", view}, {"Print", printed}} {
+			if len(event.ocgs) > 0 {
+				auto = append(auto, pdfDict{
+					"Event":    event.name,
+					"OCGs":     event.ocgs,
+					"Category": pdfArray{event.name},
+				})
+			}
+		}
+		config["AS"] = auto
+	}
+	out.catalog["OCProperties"] = pdfDict{
+		"OCGs": all,
+		"D":    config,
+	}
+	return nil
+}
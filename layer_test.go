@@ -0,0 +1,177 @@
+package pdf
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/gogpu/gg"
+	"github.com/gogpu/gg/recording"
+)
+
+// ocProperties writes doc and returns the catalog /OCProperties.
+func ocProperties(t *testing.T, doc *Document) (pdfDict, *pdfReader) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	r, catalog := readOutput(t, buf.Bytes())
+	props, err := r.resolveDict(catalog["OCProperties"])
+	if err != nil || props == nil {
+		t.Fatalf("catalog has no /OCProperties: %v", err)
+	}
+	return props, r
+}
+
+// newLayer adds a layer to doc.
+func newLayer(t *testing.T, doc *Document, name string, opts LayerOptions) *Layer {
+	t.Helper()
+
+	l, err := doc.NewLayer(name, opts)
+	if err != nil {
+		t.Fatalf("NewLayer failed: %v", err)
+	}
+	return l
+}
+
+func TestLayerContent(t *testing.T) {
+	doc := NewDocument()
+	dims := newLayer(t, doc, "Dimensions", LayerOptions{})
+	grid := newLayer(t, doc, "Grid", LayerOptions{Hidden: true})
+	black := recording.NewSolidBrush(gg.Black)
+	for range 2 {
+		page := doc.NewPage(100, 100).(*pageBackend)
+		page.BeginLayer(grid)
+		page.FillRect(recording.NewRect(0, 0, 10, 10), black)
+		page.BeginLayer(dims)
+		page.Save()
+		page.FillRect(recording.NewRect(20, 20, 10, 10), black)
+		page.EndLayer()
+		page.EndLayer()
+		page.FillRect(recording.NewRect(40, 40, 10, 10), black)
+	}
+
+	for i := range 2 {
+		content, res, _ := pageContent(t, documentWriter(doc), i)
+		want := "/OC /OC1 BDC\nq\nq\n0 0 0 rg\n0 0 10 10 re\nf\nQ\n/OC /OC2 BDC\nq\nq\nq\n" +
+			"0 0 0 rg\n20 20 10 10 re\nf\nQ\nQ\nQ\nEMC\nQ\nEMC\nq\n"
+		if !strings.Contains(content, want) {
+			t.Errorf("page %d content does not nest the layers:\n%s", i, content)
+		}
+		props, _ := res["Properties"].(pdfDict)
+		if len(props) != 2 {
+			t.Errorf("page %d /Properties = %v, want both layers", i, props)
+		}
+	}
+
+	props, r := ocProperties(t, doc)
+	ocgs, _ := props["OCGs"].(pdfArray)
+	config, _ := props["D"].(pdfDict)
+	off, _ := config["OFF"].(pdfArray)
+	if len(ocgs) != 2 || len(off) != 1 || off[0] != ocgs[1] {
+		t.Fatalf("/OCProperties = %v, want two layers with the grid hidden", props)
+	}
+	if order, _ := config["Order"].(pdfArray); len(order) != 2 || config["Name"] == nil {
+		t.Errorf("/D = %v, want a named configuration listing both layers", config)
+	}
+	ocg, _ := r.resolveDict(ocgs[0])
+	if ocg["Type"] != pdfName("OCG") || ocg["Name"] != pdfString("Dimensions") {
+		t.Errorf("first layer = %v, want the Dimensions OCG", ocg)
+	}
+}
+
+func TestLayerUsageAndRadioGroups(t *testing.T) {
+	doc := NewDocument()
+	newLayer(t, doc, "Grid", LayerOptions{Print: LayerUsageOff})
+	metric := newLayer(t, doc, "Metric", LayerOptions{})
+	imperial := newLayer(t, doc, "Imperial", LayerOptions{Hidden: true})
+	if err := doc.AddRadioGroup(metric, imperial); err != nil {
+		t.Fatalf("AddRadioGroup failed: %v", err)
+	}
+	doc.NewPage(10, 10)
+
+	props, r := ocProperties(t, doc)
+	config, _ := props["D"].(pdfDict)
+	groups, _ := config["RBGroups"].(pdfArray)
+	if group, _ := groups[0].(pdfArray); len(groups) != 1 || len(group) != 2 {
+		t.Errorf("/RBGroups = %v, want one group of two layers", config["RBGroups"])
+	}
+	auto, _ := config["AS"].(pdfArray)
+	if len(auto) != 1 {
+		t.Fatalf("/AS = %v, want one print event", config["AS"])
+	}
+	event, _ := auto[0].(pdfDict)
+	if ocgs, _ := event["OCGs"].(pdfArray); event["Event"] != pdfName("Print") || len(ocgs) != 1 {
+		t.Errorf("/AS event = %v, want the grid on print", event)
+	}
+	ocgs, _ := props["OCGs"].(pdfArray)
+	ocg, _ := r.resolveDict(ocgs[0])
+	usage, _ := ocg["Usage"].(pdfDict)
+	if printUsage, _ := usage["Print"].(pdfDict); printUsage["PrintState"] != pdfName("OFF") {
+		t.Errorf("grid /Usage = %v, want it hidden in print", usage)
+	}
+}
+
+func TestLayerInGroup(t *testing.T) {
+	doc := NewDocument()
+	l := newLayer(t, doc, "Notes", LayerOptions{})
+	page := doc.NewPage(100, 100).(*pageBackend)
+	page.BeginGroup(TransparencyGroup{Opacity: 0.5})
+	page.BeginLayer(l)
+	page.FillRect(recording.NewRect(0, 0, 10, 10), recording.NewSolidBrush(gg.Black))
+	page.EndGroup()
+
+	content, res, r := pageContent(t, documentWriter(doc), 0)
+	if strings.Contains(content, "BDC") {
+		t.Errorf("layer begun in the group is marked on the page:\n%s", content)
+	}
+	_, data := formXObject(t, res, r, "Fm1")
+	if !strings.Contains(data, "/OC /OC1 BDC\n") || !strings.HasSuffix(data, "Q\nEMC\nQ\n") {
+		t.Errorf("group content does not close the layer:\n%s", data)
+	}
+}
+
+func TestLayerErrors(t *testing.T) {
+	doc := NewDocument()
+	if _, err := doc.NewLayer("", LayerOptions{}); err == nil {
+		t.Error("NewLayer succeeded without a name")
+	}
+	if _, err := doc.NewLayer("Grid", LayerOptions{View: LayerUsage(5)}); err == nil {
+		t.Error("NewLayer succeeded with an unknown usage")
+	}
+	l := newLayer(t, doc, "Grid", LayerOptions{})
+	if err := doc.AddRadioGroup(l); err == nil {
+		t.Error("AddRadioGroup succeeded with one layer")
+	}
+	other := newLayer(t, NewDocument(), "Other", LayerOptions{})
+	if err := doc.AddRadioGroup(l, other); err == nil {
+		t.Error("AddRadioGroup succeeded with a layer of another document")
+	}
+
+	if err := doc.AddRadioGroup(l, nil); err == nil {
+		t.Error("AddRadioGroup succeeded with a nil layer")
+	}
+
+	page := doc.NewPage(10, 10).(*pageBackend)
+	page.BeginLayer(nil)
+	if err := page.End(); err == nil {
+		t.Error("End succeeded after beginning a nil layer")
+	}
+
+	page = doc.NewPage(10, 10).(*pageBackend)
+	page.BeginLayer(l)
+	page.BeginGroup(TransparencyGroup{Opacity: 1})
+	page.EndLayer()
+	if err := page.End(); err == nil {
+		t.Error("End succeeded after ending a layer inside a group")
+	}
+
+	pdfa := newPDFADocument(t, ConformancePDFA2B)
+	newLayer(t, pdfa, "Grid", LayerOptions{View: LayerUsageOn})
+	pdfa.NewPage(10, 10)
+	if _, err := pdfa.WriteTo(&bytes.Buffer{}); err == nil {
+		t.Error("PDF/A document with layer usage was written")
+	}
+}
@@ -0,0 +1,93 @@
+package pdf
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gogpu/gg/scene"
+)
+
+// MaskMode selects how the content of a soft mask is turned into opacity.
+type MaskMode int
+
+const (
+	// MaskLuminosity uses the luminosity of the mask content drawn on
+	// black: white content shows what is masked, black or undrawn areas
+	// hide it.
+	MaskLuminosity MaskMode = iota
+
+	// MaskAlpha uses the opacity of the mask content, regardless of its
+	// color.
+	MaskAlpha
+)
+
+// pdfMaskSubtypes maps mask modes to the /S entry of a soft mask.
+var pdfMaskSubtypes = map[MaskMode]pdfName{
+	MaskLuminosity: "Luminosity",
+	MaskAlpha:      "Alpha",
+}
+
+// BeginMask starts capturing content into a soft mask instead of drawing
+// it. The matching EndMask applies the mask to the content drawn after it,
+// until the Restore matching the last Save before BeginMask:
+//
+//	b.Save()
+//	b.BeginMask(pdf.MaskLuminosity)
+//	b.FillPath(spotlight, fade, recording.FillRuleNonZero)
+//	b.EndMask()
+//	// ... drawing shown through the spotlight ...
+//	b.Restore()
+//
+// The mask is written as a transparency group and referenced from the
+// /SMask of an ExtGState, so both the mask and the masked content stay
+// vector. Like a group, the mask starts with a saved graphics state that
+// EndMask restores.
+func (b *Backend) BeginMask(mode MaskMode) {
+	if _, ok := pdfMaskSubtypes[mode]; !ok {
+		b.fail(fmt.Errorf("pdf: unknown mask mode %d", mode))
+		return
+	}
+	b.content.endMarked()
+	b.beginCapture(groupState{mask: &mode})
+	b.record((*rasterizer).beginMask)
+	b.Save()
+	b.SetBlendMode(scene.BlendNormal)
+}
+
+// EndMask ends the mask begun last and sets it as the soft mask of the
+// graphics state, replacing any mask set before.
+func (b *Backend) EndMask() {
+	if len(b.groups) == 0 {
+		return
+	}
+	if b.groups[len(b.groups)-1].mask == nil {
+		b.fail(errors.New("pdf: EndMask called while a transparency group is open"))
+		return
+	}
+	gs, form := b.endCapture()
+	b.content.transparency = true
+	mode := *gs.mask
+	b.record(func(r *rasterizer) { r.endMask(mode) })
+	if len(b.groups) == 0 {
+		b.mask = b.history
+	}
+	b.endHistory()
+
+	xobj := b.groupXObject(form, pdfDict{
+		"Type": pdfName("Group"),
+		"S":    pdfName("Transparency"),
+		"CS":   b.shared.blendSpace(),
+	})
+	c := b.content
+	name := c.res.add("ExtGState", "GS", xobj, func() pdfObject {
+		return pdfDict{
+			"Type": pdfName("ExtGState"),
+			"SMask": pdfDict{
+				"Type": pdfName("Mask"),
+				"S":    pdfMaskSubtypes[mode],
+				"G":    xobj,
+			},
+		}
+	})
+	c.op("gs", name)
+}
@@ -0,0 +1,145 @@
+package pdf
+
+import (
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/gogpu/gg"
+	"github.com/gogpu/gg/recording"
+)
+
+// softMask returns the /SMask of the ExtGState name on a page.
+func softMask(t *testing.T, res pdfDict, name pdfName) pdfDict {
+	t.Helper()
+
+	states, _ := res["ExtGState"].(pdfDict)
+	state, _ := states[name].(pdfDict)
+	mask, ok := state["SMask"].(pdfDict)
+	if !ok {
+		t.Fatalf("/%s = %v, want a soft mask", name, states[name])
+	}
+	return mask
+}
+
+func TestMaskLuminosity(t *testing.T) {
+	write := backendWriter(t, 100, 100, func(b *Backend) {
+		b.Save()
+		b.BeginMask(MaskLuminosity)
+		b.FillRect(recording.NewRect(0, 0, 50, 100), recording.NewSolidBrush(gg.White))
+		b.EndMask()
+		b.FillRect(recording.NewRect(0, 0, 100, 100), recording.NewSolidBrush(gg.RGB(1, 0, 0)))
+		b.Restore()
+		b.FillRect(recording.NewRect(0, 0, 10, 10), recording.NewSolidBrush(gg.RGB(0, 0, 1)))
+	})
+
+	content, res, r := pageContent(t, write, 0)
+	masked := strings.Index(content, "/GS1 gs\n")
+	red := strings.Index(content, "1 0 0 rg\n")
+	restore := strings.Index(content, "Q\nQ\n")
+	blue := strings.Index(content, "0 0 1 rg\n")
+	if masked < 0 || red < masked || restore < red || blue < restore {
+		t.Errorf("mask does not apply to the content up to Restore:\n%s", content)
+	}
+	if strings.Contains(content, " Do\n") || strings.Contains(content, "1 1 1 rg\n") {
+		t.Errorf("masked content is not drawn as vectors:\n%s", content)
+	}
+
+	mask := softMask(t, res, "GS1")
+	if mask["Type"] != pdfName("Mask") || mask["S"] != pdfName("Luminosity") {
+		t.Errorf("/SMask = %v, want a luminosity mask", mask)
+	}
+	obj, _ := r.resolve(mask["G"])
+	form, ok := obj.(*pdfStream)
+	if !ok || form.Dict["Subtype"] != pdfName("Form") {
+		t.Fatalf("/G = %v, want a Form XObject", obj)
+	}
+	if group, _ := form.Dict["Group"].(pdfDict); group["S"] != pdfName("Transparency") || group["CS"] == nil {
+		t.Errorf("/Group = %v, want a transparency group with a color space", group)
+	}
+	data, _ := decodeStream(form)
+	if !strings.Contains(string(data), "1 1 1 rg\n") {
+		t.Errorf("mask content = %q, want the white fill", data)
+	}
+}
+
+func TestMaskAlphaInGroup(t *testing.T) {
+	write := backendWriter(t, 100, 100, func(b *Backend) {
+		b.BeginGroup(TransparencyGroup{Opacity: 0.5})
+		b.BeginMask(MaskAlpha)
+		b.FillRect(recording.NewRect(0, 0, 50, 50), recording.NewSolidBrush(gg.RGBA2(0, 0, 0, 0.5)))
+		b.EndMask()
+		b.FillRect(recording.NewRect(0, 0, 100, 100), recording.NewSolidBrush(gg.Black))
+		b.EndGroup()
+	})
+
+	_, res, r := pageContent(t, write, 0)
+	form, data := formXObject(t, res, r, "Fm1")
+	if !strings.HasPrefix(data, "q\n/GS1 gs\n") {
+		t.Errorf("group content does not start with the mask:\n%s", data)
+	}
+	inner, _ := form.Dict["Resources"].(pdfDict)
+	if mask := softMask(t, inner, "GS1"); mask["S"] != pdfName("Alpha") {
+		t.Errorf("/SMask = %v, want an alpha mask", mask)
+	}
+}
+
+func TestMaskMismatchedEnd(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		draw func(*Backend)
+	}{
+		{"unknown mode", func(b *Backend) { b.BeginMask(MaskMode(5)) }},
+		{"group ended as mask", func(b *Backend) { b.BeginGroup(TransparencyGroup{Opacity: 1}); b.EndMask() }},
+		{"mask ended as group", func(b *Backend) { b.BeginMask(MaskAlpha); b.EndGroup() }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := NewBackend()
+			if err := backend.Begin(10, 10); err != nil {
+				t.Fatalf("Begin failed: %v", err)
+			}
+			tt.draw(backend)
+			if err := backend.End(); err == nil {
+				t.Error("End succeeded")
+			}
+		})
+	}
+}
+
+func TestMaskUnclosedEndsWithPage(t *testing.T) {
+	write := backendWriter(t, 100, 100, func(b *Backend) {
+		b.BeginMask(MaskLuminosity)
+		b.FillRect(recording.NewRect(0, 0, 10, 10), recording.NewSolidBrush(gg.White))
+	})
+	content, res, _ := pageContent(t, write, 0)
+	if !strings.HasSuffix(content, "/GS1 gs\n") || strings.Count(content, "q\n") != strings.Count(content, "Q\n") {
+		t.Errorf("unclosed mask is not ended with the page:\n%s", content)
+	}
+	softMask(t, res, "GS1")
+}
+
+func TestRasterizerMask(t *testing.T) {
+	r := layerRasterizer(100, 100)
+	r.save()
+	r.beginMask()
+	r.fillRect(recording.NewRect(0, 0, 50, 100), recording.NewSolidBrush(gg.White))
+	r.fillRect(recording.NewRect(50, 0, 50, 100), recording.NewSolidBrush(gg.Black))
+	r.endMask(MaskLuminosity)
+	r.fillRect(recording.NewRect(0, 50, 100, 50), recording.NewSolidBrush(gg.RGB(1, 0, 0)))
+	r.restore()
+	r.fillRect(recording.NewRect(90, 90, 10, 10), recording.NewSolidBrush(gg.RGB(0, 0, 1)))
+
+	red, blue, clear := color.RGBA{0xFF, 0, 0, 0xFF}, color.RGBA{0, 0, 0xFF, 0xFF}, color.RGBA{}
+	for _, tt := range []struct {
+		x, y float64
+		want color.RGBA
+	}{
+		{25, 75, red},   // white in the mask
+		{75, 75, clear}, // black in the mask
+		{95, 95, blue},  // drawn after Restore, unmasked
+	} {
+		if got := rasterPixel(r, tt.x, tt.y); got != tt.want {
+			t.Errorf("pixel at (%v, %v) = %v, want %v", tt.x, tt.y, got, tt.want)
+		}
+	}
+}
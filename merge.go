@@ -0,0 +1,366 @@
+package pdf
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"unicode/utf16"
+)
+
+// outlineItem is a bookmark of the document outline.
+type outlineItem struct {
+	title string
+
+	// page is the target page, or nil for an item that only groups others;
+	// view is the rest of the destination array, such as /XYZ left top zoom,
+	// in the page's PDF coordinates.
+	page *Backend
+	view pdfArray
+
+	// source is the index of the target page in the file the item was
+	// read from, or -1, until page is set.
+	source int
+
+	open     bool
+	children []*outlineItem
+}
+
+// maxOutlineDepth limits the nesting of outlines read from source files.
+const maxOutlineDepth = 32
+
+// AppendPDF appends all pages of src to the document, in order, after the
+// pages added so far, so that cover sheets or terms and conditions can be
+// combined with drawn pages:
+//
+//	terms, err := pdf.OpenPDFFile("terms.pdf")
+//	if err != nil {
+//		return err
+//	}
+//	if err := doc.AppendPDF(terms); err != nil {
+//		return err
+//	}
+//
+// Each page is imported as with ImportPage and placed on a page of its own
+// size, which can still be drawn on through the backends of the document.
+// Fonts and images identical to ones appended before are written once.
+// The bookmarks of src are added to the document outline, pointing to the
+// appended pages, and title, author, subject, and keywords the document
+// does not set are taken from src.
+func (d *Document) AppendPDF(src *SourcePDF) error {
+	if d.finished {
+		return errors.New("pdf: cannot add page to finished document")
+	}
+
+	// The outline is read first, so that a malformed file leaves the
+	// document unchanged.
+	outline, err := src.outline()
+	if err != nil {
+		return fmt.Errorf("pdf: failed to read outline: %w", err)
+	}
+	forms := make([]*Form, len(src.pages))
+	for i := range src.pages {
+		form, err := d.ImportPage(src, i)
+		if err != nil {
+			return err
+		}
+		forms[i] = form
+	}
+	pages := make([]*Backend, len(forms))
+	for i, form := range forms {
+		pb := d.NewPageFrom(form).(*pageBackend)
+		if pb.initErr != nil {
+			return pb.initErr
+		}
+		pages[i] = pb.Backend
+	}
+	resolveOutline(outline, pages)
+	d.shared.outline = append(d.shared.outline, outline...)
+	d.mergeInfo(src)
+	return nil
+}
+
+// mergeInfo takes the descriptive Info entries the document does not set
+// from src.
+func (d *Document) mergeInfo(src *SourcePDF) {
+	info, err := src.r.resolveDict(src.r.trailer["Info"])
+	if err != nil || info == nil {
+		return
+	}
+	for _, entry := range []struct {
+		key string
+		set func(string)
+		cur string
+	}{
+		{"Title", d.SetTitle, d.meta.title},
+		{"Author", d.SetAuthor, d.meta.author},
+		{"Subject", d.SetSubject, d.meta.subject},
+		{"Keywords", d.SetKeywords, d.meta.keywords},
+	} {
+		if entry.cur != "" {
+			continue
+		}
+		if value, ok := src.textString(info[pdfName(entry.key)]); ok && value != "" {
+			entry.set(value)
+		}
+	}
+}
+
+// textString decodes a text string, which is UTF-16BE or UTF-8 with a byte
+// order mark, or otherwise PDFDocEncoding, read here as Latin-1.
+func (s *SourcePDF) textString(obj pdfObject) (string, bool) {
+	obj, err := s.r.resolve(obj)
+	if err != nil {
+		return "", false
+	}
+	str, ok := obj.(pdfString)
+	if !ok {
+		return "", false
+	}
+	switch {
+	case len(str) >= 2 && str[0] == 0xFE && str[1] == 0xFF:
+		units := make([]uint16, 0, len(str)/2)
+		for i := 2; i+1 < len(str); i += 2 {
+			units = append(units, uint16(str[i])<<8|uint16(str[i+1]))
+		}
+		return string(utf16.Decode(units)), true
+	case len(str) >= 3 && str[0] == 0xEF && str[1] == 0xBB && str[2] == 0xBF:
+		return string(str[3:]), true
+	}
+	runes := make([]rune, len(str))
+	for i := range len(str) {
+		runes[i] = rune(str[i])
+	}
+	return string(runes), true
+}
+
+// outline reads the outline of the source file, with destinations mapped to
+// the pages appended for the source pages.
+func (s *SourcePDF) outline() ([]*outlineItem, error) {
+	catalog, err := s.r.resolveDict(s.r.trailer["Root"])
+	if err != nil {
+		return nil, err
+	}
+	root, err := s.r.resolveDict(catalog["Outlines"])
+	if err != nil || root == nil {
+		return nil, err
+	}
+	return s.outlineItems(root["First"], make(map[pdfRef]bool), 0)
+}
+
+// resolveOutline points items and their children to the pages appended for
+// the pages of their source file.
+func resolveOutline(items []*outlineItem, pages []*Backend) {
+	for _, item := range items {
+		if item.source >= 0 {
+			item.page = pages[item.source]
+		}
+		resolveOutline(item.children, pages)
+	}
+}
+
+// outlineItems reads an item and its siblings.
+func (s *SourcePDF) outlineItems(first pdfObject, seen map[pdfRef]bool, depth int) ([]*outlineItem, error) {
+	if depth > maxOutlineDepth {
+		return nil, fmt.Errorf("%w: outline nested too deeply", errMalformedPDF)
+	}
+	var items []*outlineItem
+	for node := first; node != nil; {
+		ref, ok := node.(pdfRef)
+		if !ok || seen[ref] {
+			break
+		}
+		seen[ref] = true
+		dict, err := s.r.resolveDict(ref)
+		if err != nil {
+			return nil, err
+		}
+		if dict == nil {
+			break
+		}
+
+		title, _ := s.textString(dict["Title"])
+		item := &outlineItem{title: title, source: -1}
+		if count, ok := dict["Count"].(int); ok && count > 0 {
+			item.open = true
+		}
+		if page, view, ok := s.destination(dict); ok {
+			item.source, item.view = page, view
+		}
+		if item.children, err = s.outlineItems(dict["First"], seen, depth+1); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		node = dict["Next"]
+	}
+	return items, nil
+}
+
+// destination returns the page index and view of an outline item's /Dest
+// or GoTo action. Named destinations are looked up in the catalog; views
+// are moved with the page box.
+func (s *SourcePDF) destination(item pdfDict) (int, pdfArray, bool) {
+	dest := item["Dest"]
+	if dest == nil {
+		action, _ := s.r.resolveDict(item["A"])
+		if action["S"] != pdfName("GoTo") {
+			return 0, nil, false
+		}
+		dest = action["D"]
+	}
+	dest, _ = s.r.resolve(dest)
+	switch name := dest.(type) {
+	case pdfName:
+		catalog, _ := s.r.resolveDict(s.r.trailer["Root"])
+		dests, _ := s.r.resolveDict(catalog["Dests"])
+		dest, _ = s.r.resolve(dests[name])
+	case pdfString:
+		catalog, _ := s.r.resolveDict(s.r.trailer["Root"])
+		names, _ := s.r.resolveDict(catalog["Names"])
+		dest = s.lookupName(names["Dests"], name, 0)
+	}
+	if dict, ok := dest.(pdfDict); ok {
+		dest, _ = s.r.resolve(dict["D"])
+	}
+
+	arr, _ := dest.(pdfArray)
+	if len(arr) == 0 {
+		return 0, nil, false
+	}
+	ref, _ := arr[0].(pdfRef)
+	page := slices.IndexFunc(s.pages, func(p sourcePage) bool { return p.ref == ref })
+	if page < 0 {
+		return 0, nil, false
+	}
+	view := make(pdfArray, len(arr)-1)
+	for i, item := range arr[1:] {
+		view[i], _ = s.r.resolve(item)
+	}
+	return page, s.pages[page].view(view), true
+}
+
+// lookupName finds key in a name tree.
+func (s *SourcePDF) lookupName(node pdfObject, key pdfString, depth int) pdfObject {
+	dict, err := s.r.resolveDict(node)
+	if err != nil || dict == nil || depth > maxOutlineDepth {
+		return nil
+	}
+	if names, _ := s.r.resolve(dict["Names"]); names != nil {
+		list, _ := names.(pdfArray)
+		for i := 0; i+1 < len(list); i += 2 {
+			if name, _ := s.r.resolve(list[i]); name == key {
+				value, _ := s.r.resolve(list[i+1])
+				return value
+			}
+		}
+	}
+	kids, _ := s.r.resolve(dict["Kids"])
+	list, _ := kids.(pdfArray)
+	for _, kid := range list {
+		if value := s.lookupName(kid, key, depth+1); value != nil {
+			return value
+		}
+	}
+	return nil
+}
+
+// viewAxes lists the coordinates of each kind of destination view after
+// its name: true for an x coordinate, false for a y coordinate.
+var viewAxes = map[pdfName][]bool{
+	"XYZ":   {true, false},
+	"FitH":  {false},
+	"FitBH": {false},
+	"FitV":  {true},
+	"FitBV": {true},
+	"FitR":  {true, false, true, false},
+	"Fit":   nil,
+	"FitB":  nil,
+}
+
+// view maps the view of a destination on the page to the appended page,
+// whose origin is the bottom-left of the page box as displayed. Views of
+// rotated pages fall back to the whole page.
+func (p sourcePage) view(view pdfArray) pdfArray {
+	if len(view) == 0 || p.rotate != 0 {
+		return pdfArray{pdfName("Fit")}
+	}
+	kind, _ := view[0].(pdfName)
+	coords, ok := viewAxes[kind]
+	if !ok {
+		return pdfArray{pdfName("Fit")}
+	}
+	out := view
+	for i, x := range coords {
+		if i+1 >= len(out) {
+			break
+		}
+		var v float64
+		switch n := out[i+1].(type) {
+		case int:
+			v = float64(n)
+		case float64:
+			v = n
+		default:
+			continue
+		}
+		if x {
+			out[i+1] = v - p.box[0]
+		} else {
+			out[i+1] = v - p.box[1]
+		}
+	}
+	return out
+}
+
+// writeOutline adds the outline to the catalog.
+func writeOutline(out *outputFile, pages []*Backend, items []*outlineItem) {
+	targets := make(map[*Backend]*pdfIndirect, len(pages))
+	for i, b := range pages {
+		targets[b] = out.pages[i]
+	}
+	root := newIndirect(nil)
+	dict := pdfDict{"Type": pdfName("Outlines")}
+	if count := linkOutline(root, dict, items, targets); count > 0 {
+		dict["Count"] = count
+	}
+	root.Value = dict
+	out.catalog["Outlines"] = root
+}
+
+// linkOutline writes items as the children of parent, whose dictionary is
+// dict, and returns the number of items visible when parent is open.
+func linkOutline(parent *pdfIndirect, dict pdfDict, items []*outlineItem, targets map[*Backend]*pdfIndirect) int {
+	nodes := make([]*pdfIndirect, len(items))
+	for i := range items {
+		nodes[i] = newIndirect(nil)
+	}
+	visible := len(items)
+	for i, item := range items {
+		d := pdfDict{
+			"Title":  textString(item.title),
+			"Parent": parent,
+		}
+		if i > 0 {
+			d["Prev"] = nodes[i-1]
+		}
+		if i+1 < len(items) {
+			d["Next"] = nodes[i+1]
+		}
+		if page, ok := targets[item.page]; ok {
+			d["Dest"] = append(pdfArray{page}, item.view...)
+		}
+		if count := linkOutline(nodes[i], d, item.children, targets); count > 0 {
+			if item.open {
+				d["Count"] = count
+				visible += count
+			} else {
+				d["Count"] = -count
+			}
+		}
+		nodes[i].Value = d
+	}
+	if len(nodes) > 0 {
+		dict["First"] = nodes[0]
+		dict["Last"] = nodes[len(nodes)-1]
+	}
+	return visible
+}
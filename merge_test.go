@@ -0,0 +1,147 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/gogpu/gg"
+	"github.com/gogpu/gg/recording"
+)
+
+// termsPDF is a two-page file with bookmarks, a named destination, and a
+// UTF-16 title.
+func termsPDF() []byte {
+	return handcraftedPDF("/Info 10 0 R ",
+		"<< /Type /Catalog /Pages 2 0 R /Outlines 5 0 R /Dests << /second [4 0 R /Fit] >> >>",
+		"<< /Type /Pages /Kids [3 0 R 4 0 R] /Count 2 /MediaBox [0 0 200 300] >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 9 0 R /Resources << /XObject << /Im0 11 0 R >> >> >>",
+		"<< /Type /Page /Parent 2 0 R /CropBox [10 20 200 300] /Contents 9 0 R >>",
+		"<< /Type /Outlines /First 6 0 R /Last 7 0 R /Count 3 >>",
+		"<< /Title (Terms) /Parent 5 0 R /Next 7 0 R /First 8 0 R /Last 8 0 R /Count 1 /Dest [3 0 R /XYZ 0 300 0] >>",
+		"<< /Title (Privacy) /Parent 5 0 R /Prev 6 0 R /A << /S /GoTo /D /second >> >>",
+		"<< /Title (Liability) /Parent 6 0 R /Dest [4 0 R /FitH 250] >>",
+		"<< /Length 9 >>\nstream\n/Im0 Do\n\nendstream",
+		"<< /Title <FEFF00540065007200200020006D00730020263A> /Author (Legal) >>",
+		"<< /Type /XObject /Subtype /Image /Width 1 /Height 1 /ColorSpace /DeviceGray /BitsPerComponent 8 /Length 1 >>\nstream\n\x80\nendstream",
+	)
+}
+
+func TestAppendPDF(t *testing.T) {
+	doc := NewDocument()
+	doc.SetAuthor("Sales")
+	page := doc.NewPage(100, 100)
+	page.FillRect(recording.NewRect(0, 0, 50, 50), recording.NewSolidBrush(gg.Red))
+	if err := doc.AppendPDF(openSource(t, termsPDF())); err != nil {
+		t.Fatalf("AppendPDF failed: %v", err)
+	}
+	if doc.PageCount() != 3 {
+		t.Fatalf("PageCount = %d, want 3", doc.PageCount())
+	}
+	if doc.meta.title != "Ter  ms ☺" || doc.meta.author != "Sales" {
+		t.Errorf("title, author = %q, %q; want the appended title and the document author", doc.meta.title, doc.meta.author)
+	}
+
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	out := openSource(t, buf.Bytes())
+	if out.PageCount() != 3 || out.pages[2].box != [4]float64{0, 0, 190, 280} {
+		t.Fatalf("pages = %v, want the drawn page and the two appended pages", out.pages)
+	}
+
+	r := out.r
+	catalog, _ := r.resolveDict(r.trailer["Root"])
+	outlines, err := r.resolveDict(catalog["Outlines"])
+	if err != nil || outlines == nil {
+		t.Fatalf("catalog has no outline: %v", err)
+	}
+	if outlines["Count"] != 3 {
+		t.Errorf("outline /Count = %v, want 3", outlines["Count"])
+	}
+	terms, _ := r.resolveDict(outlines["First"])
+	privacy, _ := r.resolveDict(terms["Next"])
+	liability, _ := r.resolveDict(terms["First"])
+	for _, tt := range []struct {
+		item  pdfDict
+		title string
+		dest  string
+	}{
+		{terms, "Terms", "[1 /XYZ 0.00 300.00 0]"},
+		{privacy, "Privacy", "[2 /Fit]"},
+		{liability, "Liability", "[2 /FitH 230.00]"},
+	} {
+		if tt.item["Title"] != pdfString(tt.title) {
+			t.Errorf("item %v, want title %q", tt.item, tt.title)
+			continue
+		}
+		dest, _ := tt.item["Dest"].(pdfArray)
+		if len(dest) == 0 {
+			t.Errorf("%s has no destination", tt.title)
+			continue
+		}
+		page := -1
+		for i, p := range out.pages {
+			if p.ref == dest[0] {
+				page = i
+			}
+		}
+		got := append(pdfArray{page}, dest[1:]...)
+		if s := formatArray(got); s != tt.dest {
+			t.Errorf("%s destination = %s, want %s", tt.title, s, tt.dest)
+		}
+	}
+}
+
+func TestAppendPDFSharesResources(t *testing.T) {
+	doc := NewDocument()
+	for range 2 {
+		if err := doc.AppendPDF(openSource(t, termsPDF())); err != nil {
+			t.Fatalf("AppendPDF failed: %v", err)
+		}
+	}
+	var refs []pdfObject
+	for _, i := range []int{0, 2} {
+		_, res, r := pageContent(t, documentWriter(doc), i)
+		form, _ := formXObject(t, res, r, "Fm1")
+		inner, _ := r.resolveDict(form.Dict["Resources"])
+		images, _ := r.resolveDict(inner["XObject"])
+		refs = append(refs, images["Im0"])
+	}
+	if refs[0] == nil || refs[0] != refs[1] {
+		t.Errorf("appended copies reference images %v and %v, want one shared object", refs[0], refs[1])
+	}
+}
+
+func TestAppendPDFMalformedOutlineLeavesDocument(t *testing.T) {
+	// Each bookmark nests the next, deeper than outlines may go.
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R /Outlines 4 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 /MediaBox [0 0 200 300] >>",
+		"<< /Type /Page /Parent 2 0 R >>",
+		"<< /Type /Outlines /First 5 0 R >>",
+	}
+	for i := range maxOutlineDepth + 2 {
+		objects = append(objects, fmt.Sprintf("<< /Title (Level %d) /First %d 0 R >>", i, len(objects)+2))
+	}
+	objects = append(objects, "<< /Title (Last) >>")
+
+	doc := NewDocument()
+	doc.NewPage(100, 100)
+	if err := doc.AppendPDF(openSource(t, handcraftedPDF("", objects...))); err == nil {
+		t.Fatal("AppendPDF succeeded with an outline nested too deeply")
+	}
+	if doc.PageCount() != 1 {
+		t.Errorf("PageCount = %d after the failed append, want 1", doc.PageCount())
+	}
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		t.Errorf("WriteTo failed after the failed append: %v", err)
+	}
+}
+
+// formatArray formats a destination for comparison.
+func formatArray(arr pdfArray) string {
+	return string(newObjectWriter(&bytes.Buffer{}).appendValue(nil, arr))
+}
@@ -0,0 +1,199 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"unicode/utf16"
+)
+
+// pdfObject is any PDF object value handled by the reader and writer:
+// nil, bool, int, float64, pdfName, pdfString, pdfArray, pdfDict, pdfRef,
+// *pdfStream or *pdfIndirect.
+type pdfObject any
+
+// pdfName is a PDF name object. The value excludes the leading slash.
+type pdfName string
+
+// pdfString is a PDF string object holding raw bytes.
+type pdfString string
+
+// pdfHexString is a PDF string object that is serialized in hex form.
+type pdfHexString string
+
+// pdfArray is a PDF array object.
+type pdfArray []pdfObject
+
+// pdfDict is a PDF dictionary object.
+type pdfDict map[pdfName]pdfObject
+
+// pdfRef is a reference to an object in a parsed file.
+type pdfRef struct {
+	Num, Gen int
+}
+
+// pdfStream is a PDF stream object. Data holds the encoded stream bytes,
+// so Dict must describe any filters that were applied to it.
+type pdfStream struct {
+	Dict pdfDict
+	Data []byte
+}
+
+// pdfIndirect is an object that the writer emits as an indirect object.
+// Each writer numbers the objects as it reaches them, so a single
+// pdfIndirect may be shared freely between pages, dictionaries, and files.
+type pdfIndirect struct {
+	Value pdfObject
+}
+
+// newIndirect wraps value so that it is written as an indirect object.
+func newIndirect(value pdfObject) *pdfIndirect {
+	return &pdfIndirect{Value: value}
+}
+
+// textString encodes s as a PDF text string. ASCII text is kept as-is;
+// anything else is written as UTF-16BE with a byte order mark.
+func textString(s string) pdfString {
+	ascii := true
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return pdfString(s)
+	}
+
+	units := utf16.Encode([]rune(s))
+	buf := make([]byte, 2, 2+2*len(units))
+	buf[0], buf[1] = 0xFE, 0xFF
+	for _, u := range units {
+		buf = append(buf, byte(u>>8), byte(u))
+	}
+	return pdfString(buf)
+}
+
+// formatReal formats a real number with two decimals, adding digits only
+// when two decimals would lose precision.
+func formatReal(v float64) string {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return "0"
+	}
+	fixed := strconv.FormatFloat(v, 'f', 2, 64)
+	if parsed, err := strconv.ParseFloat(fixed, 64); err == nil && math.Abs(parsed-v) < 1e-9 {
+		if fixed == "-0.00" {
+			return "0.00"
+		}
+		return fixed
+	}
+	s := strconv.FormatFloat(v, 'f', 6, 64)
+	s = trimZeros(s)
+	if s == "-0" {
+		return "0"
+	}
+	return s
+}
+
+// formatNumber formats an operand for a content stream, using the shortest
+// form that keeps six decimals of precision.
+func formatNumber(v float64) string {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return "0"
+	}
+	s := trimZeros(strconv.FormatFloat(v, 'f', 6, 64))
+	if s == "-0" {
+		return "0"
+	}
+	return s
+}
+
+// trimZeros removes trailing zeros and a trailing decimal point.
+func trimZeros(s string) string {
+	if !bytes.ContainsRune([]byte(s), '.') {
+		return s
+	}
+	for s[len(s)-1] == '0' {
+		s = s[:len(s)-1]
+	}
+	if s[len(s)-1] == '.' {
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
+// appendName appends a PDF name token, escaping delimiters and
+// non-printable bytes.
+func appendName(buf []byte, n pdfName) []byte {
+	buf = append(buf, '/')
+	for i := 0; i < len(n); i++ {
+		c := n[i]
+		if c < '!' || c > '~' || c == '#' || isDelimiter(c) {
+			buf = append(buf, fmt.Sprintf("#%02X", c)...)
+			continue
+		}
+		buf = append(buf, c)
+	}
+	return buf
+}
+
+// appendLiteralString appends a PDF literal string, escaping the bytes
+// that cannot appear verbatim.
+func appendLiteralString(buf []byte, s string) []byte {
+	buf = append(buf, '(')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '(', ')', '\\':
+			buf = append(buf, '\\', c)
+		case '\n':
+			buf = append(buf, '\\', 'n')
+		case '\r':
+			buf = append(buf, '\\', 'r')
+		default:
+			buf = append(buf, c)
+		}
+	}
+	return append(buf, ')')
+}
+
+// appendHexString appends a PDF hex string.
+func appendHexString(buf []byte, s string) []byte {
+	const digits = "0123456789ABCDEF"
+	buf = append(buf, '<')
+	for i := 0; i < len(s); i++ {
+		buf = append(buf, digits[s[i]>>4], digits[s[i]&0x0F])
+	}
+	return append(buf, '>')
+}
+
+// sortedKeys returns the dictionary keys with /Type first and the rest in
+// lexical order, so that serialization is deterministic.
+func (d pdfDict) sortedKeys() []pdfName {
+	keys := make([]pdfName, 0, len(d))
+	for k := range d {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i] == "Type" || keys[j] == "Type" {
+			return keys[i] == "Type"
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// clone returns a shallow copy of the dictionary.
+func (d pdfDict) clone() pdfDict {
+	out := make(pdfDict, len(d))
+	for k, v := range d {
+		out[k] = v
+	}
+	return out
+}
+
+// rectArray returns a PDF rectangle array for the given corners.
+func rectArray(llx, lly, urx, ury float64) pdfArray {
+	return pdfArray{llx, lly, urx, ury}
+}
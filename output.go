@@ -0,0 +1,491 @@
+package pdf
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gogpu/gg/recording"
+	"github.com/gogpu/gg/text"
+)
+
+// outputFile is the object graph of a document about to be written: the
+// catalog and page tree, to which the document-level objects are added
+// before the final write.
+type outputFile struct {
+	root    *pdfIndirect
+	catalog pdfDict
+	pages   []*pdfIndirect
+	version string
+	crypt   *encryptor
+}
+
+// newOutputFile returns a file with a catalog and an empty page tree.
+func newOutputFile() *outputFile {
+	catalog := pdfDict{
+		"Type":  pdfName("Catalog"),
+		"Pages": newIndirect(nil),
+	}
+	return &outputFile{root: newIndirect(catalog), catalog: catalog, version: "1.7"}
+}
+
+// addPage appends the dictionary of a page of the given size in points.
+func (f *outputFile) addPage(width, height float64) *pdfIndirect {
+	page := newIndirect(pdfDict{
+		"Type":     pdfName("Page"),
+		"MediaBox": rectArray(0, 0, width, height),
+	})
+	f.pages = append(f.pages, page)
+	return page
+}
+
+// linkPages makes the pages added so far the kids of the page tree root.
+func (f *outputFile) linkPages() {
+	root := f.catalog["Pages"].(*pdfIndirect)
+	kids := make(pdfArray, len(f.pages))
+	for i, page := range f.pages {
+		indirectDict(page)["Parent"] = root
+		kids[i] = page
+	}
+	root.Value = pdfDict{
+		"Type":  pdfName("Pages"),
+		"Kids":  kids,
+		"Count": len(kids),
+	}
+}
+
+// setPage fills in the dictionary of page i, indexed from zero, from what
+// b drew on it: its content and resources, its page boxes, and the
+// recording embedded in it.
+func setPage(page *pdfIndirect, i int, b *Backend, meta *metadata) error {
+	dict := indirectDict(page)
+	dict["Contents"] = flateStream(nil, b.content.buf.Bytes())
+	dict["Resources"] = b.content.res.dict()
+	if b.content.transparency {
+		dict["Group"] = pdfDict{
+			"Type": pdfName("Group"),
+			"S":    pdfName("Transparency"),
+			"CS":   b.shared.blendSpace(),
+		}
+	}
+	// The boxes are given in gg coordinates and converted to the page's
+	// bottom-left origin.
+	if trim, bleed, ok := b.pageBoxes(); ok {
+		if trim.IsEmpty() {
+			return fmt.Errorf("pdf: bleed %v leaves no trimmed area on page %d", b.shared.bleed, i+1)
+		}
+		dict["TrimBox"] = pageRect(trim, b.height)
+		dict["BleedBox"] = pageRect(bleed, b.height)
+	}
+	if b.source != nil {
+		return embedRecording(page, b.source, b.shared, meta.modified)
+	}
+	return nil
+}
+
+// pageRect converts r from gg coordinates to a rectangle on a page of the
+// given height, with its bottom-left origin.
+func pageRect(r recording.Rect, height float64) pdfArray {
+	return rectArray(r.MinX, height-r.MaxY, r.MaxX, height-r.MinY)
+}
+
+// writeTo serializes the file with the given information dictionary.
+func (f *outputFile) writeTo(w io.Writer, info pdfDict) (int64, error) {
+	var infoObj *pdfIndirect
+	if info != nil {
+		infoObj = newIndirect(info)
+	}
+	ow := newObjectWriter(w)
+	ow.version = f.version
+	ow.crypt = f.crypt
+	return ow.writeFile(f.root, infoObj)
+}
+
+// sharedResources holds what the pages of one output file have in common:
+// the conformance level, the registered fonts, and objects such as images
+// that are written once however many times they are drawn.
+type sharedResources struct {
+	conformance Conformance
+	fonts       []*embeddedFont
+
+	// mu guards the objects created while pages are drawn, which pages
+	// drawn in parallel share: the standard font, the ICC-based color
+	// spaces, and the image cache.
+	mu sync.Mutex
+
+	// cells serializes capturing the content of tiling patterns, which
+	// draws on the backend that first paints them; cellOwner is that
+	// backend, which may nest patterns while it holds the lock.
+	cells     sync.Mutex
+	cellOwner atomic.Pointer[Backend]
+
+	standardFont     *pdfIndirect
+	standardFontUsed bool
+
+	images map[imageKey]*pdfStream
+
+	// patterns are the registered tiling patterns, and those read from
+	// embedded recordings, keyed by their brush.
+	patterns map[*recording.PatternBrush]*tilingPattern
+
+	// colors converts gg colors to the output color model.
+	colors colorManager
+
+	// ICC-based color spaces: the RGB space of the source profile, and one
+	// space per distinct profile, keyed by its hash.
+	iccRGB   *pdfIndirect
+	profiles map[[32]byte]*pdfIndirect
+
+	// tags is the logical structure of a tagged file, and pdfua requests
+	// PDF/UA identification and its accessibility checks.
+	tags  structTree
+	pdfua bool
+
+	// Print production settings: the output intent profile for PDF/X and
+	// the bleed applied to pages without explicit boxes.
+	outputProfile   []byte
+	outputCondition string
+	bleed           float64
+
+	// imported holds the streams imported from other files, by hash, so
+	// that identical fonts and images are written once; outline is the
+	// document outline, merged from appended files.
+	imported map[[32]byte]*pdfIndirect
+	outline  []*outlineItem
+
+	// layers are the optional content groups in the order they were
+	// created, and radioGroups the sets of them that exclude each other.
+	layers      []*Layer
+	radioGroups [][]*Layer
+
+	// encryption password-protects the output when set, and signature
+	// signs it.
+	encryption *Encryption
+	signature  *signatureField
+
+	// fields are the interactive form fields of all pages, and
+	// annotations their markup annotations.
+	fields      []*formField
+	annotations []*pageAnnotation
+
+	// attachments are the document-level embedded files.
+	attachments []*EmbeddedFile
+}
+
+func newSharedResources() *sharedResources {
+	return &sharedResources{
+		images:   make(map[imageKey]*pdfStream),
+		imported: make(map[[32]byte]*pdfIndirect),
+	}
+}
+
+// registerFont adds a font for DrawText to use.
+func (s *sharedResources) registerFont(data []byte) error {
+	font, err := newEmbeddedFont(data)
+	if err != nil {
+		return err
+	}
+	s.fonts = append(s.fonts, font)
+	return nil
+}
+
+// fontFor returns the registered font for face: the one whose family name
+// matches the face's font source, otherwise the first one registered. It
+// returns nil when no font is registered.
+func (s *sharedResources) fontFor(face text.Face) *embeddedFont {
+	if len(s.fonts) == 0 {
+		return nil
+	}
+	if face != nil && face.Source() != nil {
+		name := face.Source().Name()
+		for _, font := range s.fonts {
+			if font.name == name {
+				return font
+			}
+		}
+	}
+	return s.fonts[0]
+}
+
+// helvetica returns the font dictionary of the standard font and notes
+// that it is used.
+func (s *sharedResources) helvetica() *pdfIndirect {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.standardFontUsed = true
+	if s.standardFont == nil {
+		s.standardFont = newIndirect(standardFontDict())
+	}
+	return s.standardFont
+}
+
+// rgbSpace returns the color space RGB content is drawn in: DeviceRGB, or
+// an ICC-based space with the source profile when ICC-based color is
+// selected or the conformance level does not allow device-dependent RGB.
+func (s *sharedResources) rgbSpace() pdfObject {
+	if !s.colors.iccBased && !s.conformance.isPDFX() {
+		return pdfName("DeviceRGB")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.iccRGB == nil {
+		profile := s.colors.sourceProfile
+		if profile == nil {
+			profile = srgbProfile()
+		}
+		s.iccRGB = s.profileSpaceLocked(profile, 3)
+	}
+	return s.iccRGB
+}
+
+// graySpace returns the color space of grayscale images: DeviceGray, or an
+// ICC-based gray space when ICC-based color is selected.
+func (s *sharedResources) graySpace() pdfObject {
+	if !s.colors.iccBased {
+		return pdfName("DeviceGray")
+	}
+	return s.profileSpace(sgrayProfile(), 1)
+}
+
+// profileSpace returns an ICCBased color space for profile with n
+// components. Each distinct profile is embedded once.
+func (s *sharedResources) profileSpace(profile []byte, n int) *pdfIndirect {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.profileSpaceLocked(profile, n)
+}
+
+// profileSpaceLocked is profileSpace for callers holding s.mu.
+func (s *sharedResources) profileSpaceLocked(profile []byte, n int) *pdfIndirect {
+	key := sha256.Sum256(profile)
+	if space, ok := s.profiles[key]; ok {
+		return space
+	}
+	alternate := map[int]pdfName{1: "DeviceGray", 3: "DeviceRGB", 4: "DeviceCMYK"}[n]
+	space := newIndirect(pdfArray{
+		pdfName("ICCBased"),
+		flateStream(pdfDict{"N": n, "Alternate": alternate}, profile),
+	})
+	if s.profiles == nil {
+		s.profiles = make(map[[32]byte]*pdfIndirect)
+	}
+	s.profiles[key] = space
+	return space
+}
+
+// setColorManagement configures the color model and drops color spaces
+// built for the previous configuration.
+func (s *sharedResources) setColorManagement(cm ColorManagement) error {
+	if err := s.colors.configure(cm); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.iccRGB = nil
+	s.mu.Unlock()
+	return nil
+}
+
+// imageSpace returns the pixels of img, the color space to write them in,
+// and their number of components. An image's own profile takes precedence
+// over the document's color spaces; CMYK pixels are kept only with a CMYK
+// profile or the CMYK color model and are converted to RGB otherwise.
+func (s *sharedResources) imageSpace(img image.Image) (image.Image, pdfObject, int, error) {
+	var profile []byte
+	if p, ok := img.(*ProfiledImage); ok {
+		img, profile = p.Image, p.Profile
+	}
+	channels := 3
+	switch img.(type) {
+	case *image.Gray, *image.Gray16:
+		channels = 1
+	case *image.CMYK:
+		channels = 4
+	}
+	if len(profile) > 0 {
+		n, err := iccComponents(profile)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		if n == 3 || n == channels {
+			return img, s.profileSpace(profile, n), n, nil
+		}
+	}
+	switch {
+	case channels == 1:
+		return img, s.graySpace(), 1, nil
+	case channels == 4 && s.colors.model == ColorModelCMYK:
+		return img, pdfName("DeviceCMYK"), 4, nil
+	}
+	return img, s.rgbSpace(), 3, nil
+}
+
+// image returns the image XObject for the part r of img, encoding it the
+// first time it is drawn.
+func (s *sharedResources) image(img image.Image, r image.Rectangle) (*pdfStream, error) {
+	pixels, space, channels, err := s.imageSpace(img)
+	if err != nil {
+		return nil, err
+	}
+	key := imageKey{sum: imageHash(pixels, r), space: space, channels: channels}
+	s.mu.Lock()
+	xobj, ok := s.images[key]
+	s.mu.Unlock()
+	if ok {
+		return xobj, nil
+	}
+
+	// Images are encoded without holding the lock so that pages drawn in
+	// parallel encode theirs at the same time; when two pages encode the
+	// same image, the first one stored is kept.
+	xobj = imageXObject(subImage(pixels, r), space, channels)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cached, ok := s.images[key]; ok {
+		return cached, nil
+	}
+	s.images[key] = xobj
+	return xobj, nil
+}
+
+// writeDocument assembles the final file from the content the backends drew
+// on each page and the document-level settings.
+func writeDocument(w io.Writer, pages []*Backend, shared *sharedResources, meta *metadata) (int64, error) {
+	for _, b := range pages {
+		if b.err != nil {
+			return 0, b.err
+		}
+	}
+
+	out := newOutputFile()
+	for i, b := range pages {
+		if err := setPage(out.addPage(b.width, b.height), i, b, meta); err != nil {
+			return 0, err
+		}
+	}
+	out.linkPages()
+	info, sign, err := finishOutput(out, pages, shared, meta)
+	if err != nil {
+		return 0, err
+	}
+	if err := out.encrypt(shared); err != nil {
+		return 0, err
+	}
+	if sign == nil {
+		return out.writeTo(w, info)
+	}
+
+	// The signature covers the complete file, so it is written to memory
+	// and signed before any of it is passed on.
+	var buf bytes.Buffer
+	if _, err := out.writeTo(&buf, info); err != nil {
+		return 0, err
+	}
+	if err := sign.sign(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return buf.WriteTo(w)
+}
+
+// finishOutput adds the document-level objects to out once its pages are
+// set: form fields, annotations, attachments, conformance entries, the
+// structure tree, layers, the outline, a signature field, and the XMP
+// metadata. It completes the fonts and returns the information dictionary,
+// and the signer when the file is to be signed.
+func finishOutput(out *outputFile, pages []*Backend, shared *sharedResources, meta *metadata) (pdfDict, *signer, error) {
+	if len(shared.fields) > 0 {
+		writeFields(out, pages, shared)
+	}
+	if err := writeAnnotations(out, pages, shared, meta); err != nil {
+		return nil, nil, err
+	}
+	if len(shared.attachments) > 0 {
+		writeAttachments(out, shared, meta)
+	}
+
+	extra, infoExtra, err := applyConformance(out, pages, shared, meta)
+	if err != nil {
+		return nil, nil, err
+	}
+	uaExtra, err := applyPDFUA(out, pages, shared, meta)
+	if err != nil {
+		return nil, nil, err
+	}
+	extra = append(extra, uaExtra...)
+	if len(shared.layers) > 0 {
+		if err := writeLayers(out, shared); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(shared.outline) > 0 {
+		writeOutline(out, pages, shared.outline)
+	}
+	var sign *signer
+	if shared.signature != nil {
+		if sign, err = writeSignature(out, pages, shared.signature); err != nil {
+			return nil, nil, err
+		}
+	}
+	// Annotations, including the signature field, are tagged too.
+	if shared.tags.enabled {
+		writeStructTree(out, pages, &shared.tags)
+	}
+	if meta.lang != "" {
+		out.catalog["Lang"] = textString(meta.lang)
+	}
+	for _, font := range shared.fonts {
+		font.finish()
+	}
+	out.catalog["Metadata"] = meta.metadataStream(extra...)
+	info := meta.infoDict()
+	for key, value := range infoExtra {
+		info[key] = value
+	}
+	return info, sign, nil
+}
+
+// encrypt prepares the encryption of the file when shared requests it.
+func (f *outputFile) encrypt(shared *sharedResources) error {
+	if shared.encryption == nil {
+		return nil
+	}
+	if shared.conformance != ConformanceNone {
+		return fmt.Errorf("pdf: %s does not allow encryption", shared.conformance)
+	}
+	crypt, err := newEncryptor(*shared.encryption)
+	if err != nil {
+		return err
+	}
+	f.crypt = crypt
+	if crypt.aes256 {
+		// AES-256 is part of PDF 2.0 and of Adobe's extension level 8 to
+		// PDF 1.7.
+		f.catalog["Extensions"] = pdfDict{
+			"ADBE": pdfDict{"BaseVersion": pdfName("1.7"), "ExtensionLevel": 8},
+		}
+	}
+	return nil
+}
+
+// writeFile creates path and writes the PDF produced by write into it.
+func writeFile(path string, write func(io.Writer) (int64, error)) (err error) {
+	file, err := os.Create(path) //nolint:gosec // the caller chooses the output path
+	if err != nil {
+		return fmt.Errorf("pdf: failed to create file: %w", err)
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("pdf: failed to close file: %w", closeErr)
+		}
+	}()
+	buffered := bufio.NewWriter(file)
+	if _, err = write(buffered); err != nil {
+		return err
+	}
+	return buffered.Flush()
+}
@@ -0,0 +1,99 @@
+package pdf
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+
+	"github.com/gogpu/gg/recording"
+)
+
+// PlaybackAll plays each recording onto a new page with the recording's
+// dimensions, drawing up to workers pages at the same time; with workers
+// below one, as many as GOMAXPROCS. The pages are added in the order of
+// recs however the drawing interleaves, and share fonts, images, color
+// spaces, and patterns as pages drawn with Playback do. Pages of a
+// streaming document are written as they finish.
+//
+// PlaybackAll stops drawing at the first error, or when ctx is done, and
+// returns that error, failing the document as PlaybackContext does. Tagged
+// documents cannot be drawn in parallel, since structure elements take the
+// content drawn while they are open.
+//
+// The Document must not be used otherwise until PlaybackAll returns.
+func (d *Document) PlaybackAll(ctx context.Context, recs []*recording.Recording, workers int) error {
+	return d.PlaybackAllProgress(ctx, recs, workers, nil)
+}
+
+// PlaybackAllProgress is PlaybackAll calling progress, if non-nil, after
+// each page, one call at a time.
+func (d *Document) PlaybackAllProgress(ctx context.Context, recs []*recording.Recording, workers int, progress func(Progress)) error {
+	if d.shared.tags.enabled {
+		return errors.New("pdf: tagged documents cannot be drawn in parallel; use Playback")
+	}
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	type job struct {
+		rec  *recording.Recording
+		page *pageBackend
+	}
+	var (
+		idle = make(chan struct{}) // a worker is ready for a job
+		jobs = make(chan job)
+		stop = make(chan struct{})
+		wg   sync.WaitGroup
+		mu   sync.Mutex // serializes progress calls
+		done int
+	)
+	for range min(workers, len(recs)) {
+		wg.Go(func() {
+			for {
+				select {
+				case idle <- struct{}{}:
+				case <-stop:
+					return
+				}
+				j, ok := <-jobs
+				if !ok {
+					return
+				}
+				if err := playContext(ctx, j.rec, j.page); err != nil {
+					cancel(err)
+					continue
+				}
+				if progress != nil {
+					mu.Lock()
+					done++
+					progress(Progress{Pages: done, Total: len(recs), BytesWritten: d.bytesWritten()})
+					mu.Unlock()
+				}
+			}
+		})
+	}
+
+	// Pages are created here, one at a time, so that they are numbered in
+	// order. Each is created once a worker is ready to draw it, so that no
+	// empty page is added after drawing stops.
+	for _, rec := range recs {
+		select {
+		case <-ctx.Done():
+		case <-idle:
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- job{rec: rec, page: d.playbackPage(rec)}
+	}
+	close(stop)
+	close(jobs)
+	wg.Wait()
+	if err := context.Cause(ctx); err != nil {
+		return d.abort(err)
+	}
+	return nil
+}
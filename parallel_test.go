@@ -0,0 +1,180 @@
+package pdf
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/gogpu/gg/recording"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+// parallelDocument returns a document set up for statements and the pages
+// to draw on it: they share a logo, a pattern, a font, and CMYK
+// conversions, and come in several sizes.
+func parallelDocument(t *testing.T, doc *Document) []*recording.Recording {
+	t.Helper()
+
+	if err := doc.RegisterFont(goregular.TTF); err != nil {
+		t.Fatalf("RegisterFont failed: %v", err)
+	}
+	if err := doc.SetColorManagement(ColorManagement{Model: ColorModelCMYK, ICCBased: true}); err != nil {
+		t.Fatalf("SetColorManagement failed: %v", err)
+	}
+	hatch, err := doc.RegisterPattern(TilingPattern{Content: stripes()})
+	if err != nil {
+		t.Fatalf("RegisterPattern failed: %v", err)
+	}
+	logo := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	logo.Set(1, 1, color.NRGBA{R: 200, A: 255})
+
+	var recs []*recording.Recording
+	for i := range 24 {
+		switch i % 3 {
+		case 0:
+			recs = append(recs, vectorRecording())
+		case 1:
+			recs = append(recs, chartRecording())
+		}
+		rec := recording.NewRecorder(300+i, 200)
+		rec.DrawImageScaled(logo, 10, 10, 40, 40)
+		rec.SetFillStyle(hatch)
+		rec.DrawRectangle(60, 10, 100, 40)
+		rec.Fill()
+		rec.SetFillRGB(float64(i)/24, 0.5, 0.2)
+		rec.SetFontFamily("Go")
+		rec.SetFontSize(12)
+		rec.DrawString(fmt.Sprintf("Statement %d", i), 10, 100)
+		recs = append(recs, rec.FinishRecording())
+	}
+	return recs
+}
+
+// pageContents returns the decoded content stream of every page of data.
+func pageContents(t *testing.T, data []byte) [][]byte {
+	t.Helper()
+
+	src := openSource(t, data)
+	contents := make([][]byte, src.PageCount())
+	for i, page := range src.pages {
+		content, err := src.contentData(page.dict["Contents"])
+		if err != nil {
+			t.Fatalf("page %d content: %v", i, err)
+		}
+		contents[i] = content
+	}
+	return contents
+}
+
+func TestPlaybackAll(t *testing.T) {
+	var want bytes.Buffer
+	doc := NewDocument()
+	for _, rec := range parallelDocument(t, doc) {
+		if err := doc.Playback(rec); err != nil {
+			t.Fatalf("Playback failed: %v", err)
+		}
+	}
+	if _, err := doc.WriteTo(&want); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	wantContents := pageContents(t, want.Bytes())
+
+	for _, workers := range []int{0, 1, 5} {
+		t.Run(fmt.Sprintf("%d workers", workers), func(t *testing.T) {
+			var buf bytes.Buffer
+			doc := NewDocument()
+			if err := doc.PlaybackAll(context.Background(), parallelDocument(t, doc), workers); err != nil {
+				t.Fatalf("PlaybackAll failed: %v", err)
+			}
+			if _, err := doc.WriteTo(&buf); err != nil {
+				t.Fatalf("WriteTo failed: %v", err)
+			}
+			got := pageContents(t, buf.Bytes())
+			if len(got) != len(wantContents) {
+				t.Fatalf("document has %d pages, want %d", len(got), len(wantContents))
+			}
+			for i := range got {
+				if !bytes.Equal(got[i], wantContents[i]) {
+					t.Errorf("page %d draws\n%s\nwant\n%s", i, got[i], wantContents[i])
+				}
+			}
+
+			// The logo is encoded once for all pages.
+			src := openSource(t, buf.Bytes())
+			logos := make(map[pdfRef]bool)
+			for _, page := range src.pages {
+				res, _ := src.r.resolveDict(page.resources)
+				xobjects, _ := src.r.resolveDict(res["XObject"])
+				for _, ref := range xobjects {
+					obj, _ := src.r.resolve(ref)
+					if s, ok := obj.(*pdfStream); ok && s.Dict["Width"] == 4 {
+						logos[ref.(pdfRef)] = true
+					}
+				}
+			}
+			if len(logos) != 1 {
+				t.Errorf("logo is written %d times, want once", len(logos))
+			}
+		})
+	}
+}
+
+func TestPlaybackAllStreaming(t *testing.T) {
+	var want bytes.Buffer
+	doc := NewDocument()
+	if err := doc.PlaybackAll(context.Background(), parallelDocument(t, doc), 4); err != nil {
+		t.Fatalf("PlaybackAll failed: %v", err)
+	}
+	if _, err := doc.WriteTo(&want); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	stream := NewStreamingDocument(&buf)
+	if err := stream.PlaybackAll(context.Background(), parallelDocument(t, stream), 4); err != nil {
+		t.Fatalf("PlaybackAll failed: %v", err)
+	}
+	if err := stream.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	got, wantContents := pageContents(t, buf.Bytes()), pageContents(t, want.Bytes())
+	if len(got) != len(wantContents) {
+		t.Fatalf("streamed document has %d pages, want %d", len(got), len(wantContents))
+	}
+	for i := range got {
+		if !bytes.Equal(got[i], wantContents[i]) {
+			t.Errorf("streamed page %d differs", i)
+		}
+	}
+}
+
+func TestPlaybackAllErrors(t *testing.T) {
+	doc := NewDocument()
+	if err := doc.BeginStructure(StructureDocument, StructureAttributes{}); err != nil {
+		t.Fatalf("BeginStructure failed: %v", err)
+	}
+	if err := doc.PlaybackAll(context.Background(), []*recording.Recording{vectorRecording()}, 2); err == nil {
+		t.Error("PlaybackAll succeeded on a tagged document")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	doc = NewDocument()
+	if err := doc.PlaybackAll(ctx, []*recording.Recording{vectorRecording(), vectorRecording()}, 2); !errors.Is(err, context.Canceled) {
+		t.Errorf("PlaybackAll error = %v, want %v", err, context.Canceled)
+	}
+
+	// Text without an embedded font fails PDF/A.
+	doc = NewDocument()
+	if err := doc.SetConformance(ConformancePDFA2B); err != nil {
+		t.Fatalf("SetConformance failed: %v", err)
+	}
+	recs := []*recording.Recording{vectorRecording(), chartRecording(), vectorRecording()}
+	if err := doc.PlaybackAll(context.Background(), recs, 2); !errors.Is(err, errNoEmbeddedFont) {
+		t.Errorf("PlaybackAll error = %v, want %v", err, errNoEmbeddedFont)
+	}
+}
@@ -0,0 +1,238 @@
+package pdf
+
+import (
+	"errors"
+	"fmt"
+	"image"
+
+	"github.com/gogpu/gg/recording"
+)
+
+// TilingType selects how a tiling pattern's cells may be adjusted to the
+// device pixel grid.
+type TilingType int
+
+const (
+	// TilingConstantSpacing keeps the pattern cells evenly spaced, possibly
+	// distorting the cell content by up to a device pixel.
+	TilingConstantSpacing TilingType = iota
+
+	// TilingNoDistortion keeps the cell content undistorted, possibly
+	// varying the spacing between cells by up to a device pixel.
+	TilingNoDistortion
+
+	// TilingFaster keeps the spacing constant and lets viewers distort the
+	// cells further in exchange for faster rendering.
+	TilingFaster
+)
+
+// TilingPattern describes a pattern that repeats a cell of content, such as
+// hatching, a checkerboard, or a texture. The cell is drawn either from an
+// image or from a recording.
+type TilingPattern struct {
+	// Image is drawn to fill the cell.
+	Image image.Image
+
+	// Content is played into the cell. Its Begin and End do not start a new
+	// page.
+	Content *recording.Recording
+
+	// Cell is the content of one tile in pattern space. It defaults to the
+	// size of the image or recording at the origin.
+	Cell recording.Rect
+
+	// XStep and YStep are the distances between tiles in pattern space.
+	// They default to the size of the cell; larger steps leave gaps.
+	XStep, YStep float64
+
+	// Matrix maps pattern space into the user space of the content painted
+	// with the pattern. The zero matrix is the identity.
+	Matrix recording.Matrix
+
+	// Tiling adjusts the cells to the device pixel grid.
+	Tiling TilingType
+}
+
+// tilingPattern is a registered pattern: its cell content once captured,
+// and the pattern objects written for each pattern matrix.
+type tilingPattern struct {
+	TilingPattern
+	cell     *contentStream
+	building bool
+	streams  map[recording.Matrix]*pdfStream
+}
+
+// registerPattern validates p, fills in its defaults, and returns the brush
+// that paints it.
+func (s *sharedResources) registerPattern(p TilingPattern) (*recording.PatternBrush, error) {
+	p, err := p.normalize()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	brush := recording.NewPatternBrush(recording.ImageRef(len(s.patterns)))
+	s.addPattern(brush, p)
+	return brush, nil
+}
+
+// addPattern registers p for brush. The caller holds s.mu.
+func (s *sharedResources) addPattern(brush *recording.PatternBrush, p TilingPattern) *tilingPattern {
+	if s.patterns == nil {
+		s.patterns = make(map[*recording.PatternBrush]*tilingPattern)
+	}
+	t := &tilingPattern{
+		TilingPattern: p,
+		streams:       make(map[recording.Matrix]*pdfStream),
+	}
+	s.patterns[brush] = t
+	return t
+}
+
+// tilingPattern returns the pattern registered with s that brush paints,
+// or nil.
+func (s *sharedResources) tilingPattern(brush *recording.PatternBrush) *tilingPattern {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.patterns[brush]
+}
+
+// normalize validates p and returns it with its defaults filled in.
+func (p TilingPattern) normalize() (TilingPattern, error) {
+	var size recording.Rect
+	switch {
+	case p.Image != nil && p.Content != nil:
+		return p, errors.New("pdf: tiling pattern has both an image and a recording")
+	case p.Image != nil:
+		b := p.Image.Bounds()
+		size = recording.NewRect(0, 0, float64(b.Dx()), float64(b.Dy()))
+	case p.Content != nil:
+		size = recording.NewRect(0, 0, float64(p.Content.Width()), float64(p.Content.Height()))
+	default:
+		return p, errors.New("pdf: tiling pattern has neither an image nor a recording")
+	}
+	if p.Cell == (recording.Rect{}) {
+		p.Cell = size
+	}
+	if p.Cell.Width() <= 0 || p.Cell.Height() <= 0 {
+		return p, fmt.Errorf("pdf: tiling pattern cell %v is empty", p.Cell)
+	}
+	if p.XStep == 0 {
+		p.XStep = p.Cell.Width()
+	}
+	if p.YStep == 0 {
+		p.YStep = p.Cell.Height()
+	}
+	if p.XStep < 0 || p.YStep < 0 {
+		return p, fmt.Errorf("pdf: tiling pattern step %vx%v is negative", p.XStep, p.YStep)
+	}
+	if p.Matrix == (recording.Matrix{}) {
+		p.Matrix = recording.Identity()
+	}
+	if p.Tiling < TilingConstantSpacing || p.Tiling > TilingFaster {
+		return p, fmt.Errorf("pdf: unknown tiling type %d", p.Tiling)
+	}
+	return p, nil
+}
+
+// RegisterPattern registers a tiling pattern and returns the brush that
+// paints it in fills, strokes, and text:
+//
+//	hatch, err := b.RegisterPattern(pdf.TilingPattern{Content: stripes})
+//	if err != nil {
+//		return err
+//	}
+//	b.FillPath(region, hatch, recording.FillRuleNonZero)
+//
+// The brush is identified by its pointer, so it can be recorded with
+// Recorder.SetFillStyle and played back; its Repeat and Transform fields
+// are not used. Patterns are written as colored tiling patterns.
+func (b *Backend) RegisterPattern(p TilingPattern) (*recording.PatternBrush, error) {
+	return b.shared.registerPattern(p)
+}
+
+// pattern returns the resource name of the tiling pattern that brush
+// paints, or false if brush is not a registered pattern. The pattern matrix
+// is relative to the default space of the content stream, so it includes
+// the current transform and, on the page, the Y-flip.
+func (b *Backend) pattern(brush recording.Brush) (pdfName, bool) {
+	pb, ok := brush.(*recording.PatternBrush)
+	if !ok {
+		return "", false
+	}
+	p := b.shared.tilingPattern(pb)
+	if p == nil {
+		return "", false
+	}
+	defer b.shared.lockCells(b)()
+	cell := b.patternCell(p)
+	if cell == nil {
+		return "", false
+	}
+
+	base := recording.Identity()
+	if len(b.groups) == 0 {
+		base = recording.Matrix{A: 1, E: -1, F: b.height}
+	}
+	m := base.Multiply(b.currentTransform).Multiply(p.Matrix)
+	stream, ok := p.streams[m]
+	if !ok {
+		stream = flateStream(pdfDict{
+			"Type":        pdfName("Pattern"),
+			"PatternType": 1,
+			"PaintType":   1,
+			"TilingType":  int(p.Tiling) + 1,
+			"BBox":        rectArray(p.Cell.MinX, p.Cell.MinY, p.Cell.MaxX, p.Cell.MaxY),
+			"XStep":       p.XStep,
+			"YStep":       p.YStep,
+			"Matrix":      pdfArray{m.A, m.D, m.B, m.E, m.C, m.F},
+			"Resources":   cell.res.dict(),
+		}, cell.buf.Bytes())
+		p.streams[m] = stream
+	}
+
+	c := b.content
+	c.transparency = c.transparency || cell.transparency
+	c.deviceRGB = c.deviceRGB || cell.deviceRGB
+	c.deviceCMYK = c.deviceCMYK || cell.deviceCMYK
+	return c.res.add("Pattern", "P", stream, func() pdfObject { return stream }), true
+}
+
+// lockCells takes the lock for capturing pattern cells, unless b holds it
+// already because a cell it captures paints another pattern, and returns
+// the function that releases it.
+func (s *sharedResources) lockCells(b *Backend) func() {
+	if s.cellOwner.Load() == b {
+		return func() {}
+	}
+	s.cells.Lock()
+	s.cellOwner.Store(b)
+	return func() {
+		s.cellOwner.Store(nil)
+		s.cells.Unlock()
+	}
+}
+
+// patternCell returns the content of the pattern's cell, drawing it the
+// first time the pattern is used.
+func (b *Backend) patternCell(p *tilingPattern) *contentStream {
+	if p.cell != nil {
+		return p.cell
+	}
+	if p.building {
+		b.fail(errors.New("pdf: tiling pattern is painted with itself"))
+		return nil
+	}
+	p.building = true
+	p.cell = b.captureContent(func() {
+		if p.Image != nil {
+			bounds := p.Image.Bounds()
+			src := recording.NewRect(float64(bounds.Min.X), float64(bounds.Min.Y), float64(bounds.Dx()), float64(bounds.Dy()))
+			b.DrawImage(p.Image, src, p.Cell, recording.DefaultImageOptions())
+		} else {
+			b.playInto(p.Content)
+		}
+	})
+	p.building = false
+	return p.cell
+}
@@ -0,0 +1,197 @@
+package pdf
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/gogpu/gg"
+	"github.com/gogpu/gg/recording"
+)
+
+// tilingPatternStream resolves the pattern name on a page and returns it with
+// its decoded cell content.
+func tilingPatternStream(t *testing.T, res pdfDict, r *pdfReader, name pdfName) (*pdfStream, string) {
+	t.Helper()
+
+	patterns, _ := res["Pattern"].(pdfDict)
+	obj, _ := r.resolve(patterns[name])
+	pattern, ok := obj.(*pdfStream)
+	if !ok || pattern.Dict["PatternType"] != 1 || pattern.Dict["PaintType"] != 1 {
+		t.Fatalf("/%s = %v, want a colored tiling pattern", name, obj)
+	}
+	data, err := decodeStream(pattern)
+	if err != nil {
+		t.Fatalf("failed to decode /%s: %v", name, err)
+	}
+	return pattern, string(data)
+}
+
+// stripes records a red bar in a 10x10 cell.
+func stripes() *recording.Recording {
+	rec := recording.NewRecorder(10, 10)
+	rec.SetFillRGB(1, 0, 0)
+	rec.DrawRectangle(0, 0, 5, 10)
+	rec.Fill()
+	return rec.FinishRecording()
+}
+
+func TestPatternImageFill(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(0, 0, color.Black)
+	var brush *recording.PatternBrush
+	write := backendWriter(t, 100, 50, func(b *Backend) {
+		var err error
+		brush, err = b.RegisterPattern(TilingPattern{Image: img, XStep: 4})
+		if err != nil {
+			t.Fatalf("RegisterPattern failed: %v", err)
+		}
+		b.FillRect(recording.NewRect(0, 0, 100, 50), brush)
+		b.FillRect(recording.NewRect(0, 0, 10, 10), brush)
+	})
+
+	content, res, r := pageContent(t, write, 0)
+	if strings.Count(content, "/Pattern cs\n/P1 scn\n") != 2 || strings.Contains(content, " rg\n") {
+		t.Errorf("fills do not share the pattern:\n%s", content)
+	}
+	if strings.Contains(content, " Do\n") {
+		t.Errorf("pattern cell is drawn on the page:\n%s", content)
+	}
+	pattern, data := tilingPatternStream(t, res, r, "P1")
+	if !strings.Contains(data, "/Im1 Do\n") {
+		t.Errorf("cell content = %q, want the image", data)
+	}
+	d := pattern.Dict
+	if d["TilingType"] != 1 || d["XStep"] != 4.0 || d["YStep"] != 2.0 {
+		t.Errorf("pattern = %v, want constant spacing with steps 4 and 2", d)
+	}
+	if bbox, _ := d["BBox"].(pdfArray); len(bbox) != 4 || bbox[2] != 2.0 || bbox[3] != 2.0 {
+		t.Errorf("/BBox = %v, want the image size", d["BBox"])
+	}
+	if m, _ := d["Matrix"].(pdfArray); len(m) != 6 || m[3] != -1.0 || m[5] != 50.0 {
+		t.Errorf("/Matrix = %v, want the page Y-flip", d["Matrix"])
+	}
+}
+
+func TestPatternRecordingStrokeAndText(t *testing.T) {
+	doc := NewDocument()
+	brush, err := doc.RegisterPattern(TilingPattern{
+		Content: stripes(),
+		Matrix:  recording.Scale(2, 2),
+		Tiling:  TilingNoDistortion,
+	})
+	if err != nil {
+		t.Fatalf("RegisterPattern failed: %v", err)
+	}
+	line := gg.NewPath()
+	line.MoveTo(0, 0)
+	line.LineTo(50, 50)
+	for range 2 {
+		page := doc.NewPage(100, 100)
+		page.SetTransform(recording.Translate(10, 0))
+		page.StrokePath(line, brush, recording.Stroke{Width: 4})
+		page.DrawText("Hi", 10, 20, nil, brush)
+	}
+
+	for i := range 2 {
+		content, res, r := pageContent(t, documentWriter(doc), i)
+		if !strings.Contains(content, "/Pattern CS\n/P1 SCN\n") || !strings.Contains(content, "/Pattern cs\n/P1 scn\n") {
+			t.Errorf("page %d does not stroke and fill text with the pattern:\n%s", i, content)
+		}
+		pattern, data := tilingPatternStream(t, res, r, "P1")
+		if !strings.Contains(data, "1 0 0 rg\n") {
+			t.Errorf("cell content = %q, want the recorded bar", data)
+		}
+		want := pdfArray{2.0, 0.0, 0.0, -2.0, 10.0, 100.0}
+		if m, _ := pattern.Dict["Matrix"].(pdfArray); len(m) != 6 || m[0] != want[0] || m[3] != want[3] || m[4] != want[4] || m[5] != want[5] {
+			t.Errorf("/Matrix = %v, want %v", pattern.Dict["Matrix"], want)
+		}
+		if pattern.Dict["TilingType"] != 2 {
+			t.Errorf("/TilingType = %v, want 2", pattern.Dict["TilingType"])
+		}
+	}
+}
+
+func TestPatternPlayback(t *testing.T) {
+	doc := NewDocument()
+	brush, err := doc.RegisterPattern(TilingPattern{Content: stripes()})
+	if err != nil {
+		t.Fatalf("RegisterPattern failed: %v", err)
+	}
+	rec := recording.NewRecorder(100, 100)
+	rec.SetFillStyle(brush)
+	rec.DrawCircle(50, 50, 40)
+	rec.Fill()
+	if err := doc.Playback(rec.FinishRecording()); err != nil {
+		t.Fatalf("Playback failed: %v", err)
+	}
+
+	content, _, _ := pageContent(t, documentWriter(doc), 0)
+	if !strings.Contains(content, "/P1 scn\n") {
+		t.Errorf("recorded pattern brush is not written as a pattern:\n%s", content)
+	}
+}
+
+func TestPatternInGroup(t *testing.T) {
+	write := backendWriter(t, 100, 100, func(b *Backend) {
+		brush, err := b.RegisterPattern(TilingPattern{Content: stripes()})
+		if err != nil {
+			t.Fatalf("RegisterPattern failed: %v", err)
+		}
+		b.BeginGroup(TransparencyGroup{Opacity: 0.5})
+		b.FillRect(recording.NewRect(0, 0, 50, 50), brush)
+		b.EndGroup()
+	})
+
+	_, res, r := pageContent(t, write, 0)
+	form, _ := formXObject(t, res, r, "Fm1")
+	inner, _ := form.Dict["Resources"].(pdfDict)
+	pattern, _ := tilingPatternStream(t, inner, r, "P1")
+	// In a form the pattern maps to the form's space, which is already
+	// flipped.
+	if m, _ := pattern.Dict["Matrix"].(pdfArray); len(m) != 6 || m[3] != 1.0 || m[5] != 0.0 {
+		t.Errorf("/Matrix = %v, want the identity", pattern.Dict["Matrix"])
+	}
+}
+
+func TestPatternPaintedWithItself(t *testing.T) {
+	backend := NewBackend()
+	if err := backend.Begin(10, 10); err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	brush, err := backend.RegisterPattern(TilingPattern{Content: stripes()})
+	if err != nil {
+		t.Fatalf("RegisterPattern failed: %v", err)
+	}
+	rec := recording.NewRecorder(10, 10)
+	rec.SetFillStyle(brush)
+	rec.DrawRectangle(0, 0, 10, 10)
+	rec.Fill()
+	backend.shared.patterns[brush].Content = rec.FinishRecording()
+
+	backend.FillRect(recording.NewRect(0, 0, 10, 10), brush)
+	if err := backend.End(); err == nil {
+		t.Error("End succeeded with a pattern painted with itself")
+	}
+}
+
+func TestPatternInvalid(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	for _, tt := range []struct {
+		name    string
+		pattern TilingPattern
+	}{
+		{"no content", TilingPattern{}},
+		{"image and recording", TilingPattern{Image: img, Content: stripes()}},
+		{"empty cell", TilingPattern{Image: img, Cell: recording.NewRect(0, 0, 0, 5)}},
+		{"negative step", TilingPattern{Image: img, XStep: -1}},
+		{"unknown tiling", TilingPattern{Image: img, Tiling: TilingType(7)}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewDocument().RegisterPattern(tt.pattern); err == nil {
+				t.Error("RegisterPattern succeeded")
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package pdf
+
+import (
+	"context"
+	"image"
+
+	"github.com/gogpu/gg"
+	"github.com/gogpu/gg/recording"
+	"github.com/gogpu/gg/text"
+)
+
+// Progress reports how far PlaybackContext or PlaybackAllProgress has come.
+type Progress struct {
+	// Pages is the number of pages drawn so far by the call, out of Total.
+	Pages, Total int
+
+	// BytesWritten is the size of the output of a streaming document so
+	// far. It stays zero for a document written at once by WriteTo.
+	BytesWritten int64
+}
+
+// PlaybackContext plays each recording onto a new page with the
+// recording's dimensions, in order, like Playback. It checks ctx between
+// drawing operations and calls progress, if non-nil, after each page.
+//
+// When ctx is done, the page being drawn is abandoned and PlaybackContext
+// returns ctx.Err(). On that or any other error the document fails: pages
+// can no longer be added, and WriteTo, SaveToFile, and Close return the
+// error. A streaming document stops writing, so its output is incomplete.
+func (d *Document) PlaybackContext(ctx context.Context, recs []*recording.Recording, progress func(Progress)) error {
+	for i, rec := range recs {
+		if err := ctx.Err(); err != nil {
+			return d.abort(err)
+		}
+		if err := playContext(ctx, rec, d.playbackPage(rec)); err != nil {
+			return d.abort(err)
+		}
+		if progress != nil {
+			progress(Progress{Pages: i + 1, Total: len(recs), BytesWritten: d.bytesWritten()})
+		}
+	}
+	return nil
+}
+
+// abort fails the document with err, unless it has failed already, and
+// returns the error it failed with.
+func (d *Document) abort(err error) error {
+	if d.err == nil {
+		d.err = err
+		if d.stream != nil {
+			d.stream.mu.Lock()
+			d.stream.fail(err)
+			d.stream.mu.Unlock()
+		}
+	}
+	return d.err
+}
+
+// bytesWritten returns the size of the output of a streaming document so
+// far, and zero for other documents.
+func (d *Document) bytesWritten() int64 {
+	if d.stream == nil {
+		return 0
+	}
+	d.stream.mu.Lock()
+	defer d.stream.mu.Unlock()
+	return d.stream.ow.offset
+}
+
+// playContext plays rec onto page, skipping the remaining drawing
+// operations once ctx is done.
+func playContext(ctx context.Context, rec *recording.Recording, page *pageBackend) error {
+	return rec.Playback(contextPage{page: page, ctx: ctx})
+}
+
+// contextPage is a document page that ignores drawing operations once ctx
+// is done. End then fails the page with the context's error, so that a
+// streaming document does not write it. It has only the methods of
+// recording.Backend, each of which checks ctx.
+type contextPage struct {
+	page *pageBackend
+	ctx  context.Context
+}
+
+func (p contextPage) done() bool { return p.ctx.Err() != nil }
+
+func (p contextPage) Begin(width, height int) error {
+	if err := p.ctx.Err(); err != nil {
+		return err
+	}
+	return p.page.Begin(width, height)
+}
+
+func (p contextPage) End() error {
+	if err := p.ctx.Err(); err != nil && !p.page.ended {
+		p.page.fail(err)
+	}
+	return p.page.End()
+}
+
+func (p contextPage) Save() {
+	if !p.done() {
+		p.page.Save()
+	}
+}
+
+func (p contextPage) Restore() {
+	if !p.done() {
+		p.page.Restore()
+	}
+}
+
+func (p contextPage) SetTransform(m recording.Matrix) {
+	if !p.done() {
+		p.page.SetTransform(m)
+	}
+}
+
+func (p contextPage) SetClip(path *gg.Path, rule recording.FillRule) {
+	if !p.done() {
+		p.page.SetClip(path, rule)
+	}
+}
+
+func (p contextPage) ClearClip() {
+	if !p.done() {
+		p.page.ClearClip()
+	}
+}
+
+func (p contextPage) FillPath(path *gg.Path, brush recording.Brush, rule recording.FillRule) {
+	if !p.done() {
+		p.page.FillPath(path, brush, rule)
+	}
+}
+
+func (p contextPage) StrokePath(path *gg.Path, brush recording.Brush, stroke recording.Stroke) {
+	if !p.done() {
+		p.page.StrokePath(path, brush, stroke)
+	}
+}
+
+func (p contextPage) FillRect(rect recording.Rect, brush recording.Brush) {
+	if !p.done() {
+		p.page.FillRect(rect, brush)
+	}
+}
+
+func (p contextPage) DrawImage(img image.Image, src, dst recording.Rect, opts recording.ImageOptions) {
+	if !p.done() {
+		p.page.DrawImage(img, src, dst, opts)
+	}
+}
+
+func (p contextPage) DrawText(s string, x, y float64, face text.Face, brush recording.Brush) {
+	if !p.done() {
+		p.page.DrawText(s, x, y, face, brush)
+	}
+}
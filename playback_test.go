@@ -0,0 +1,207 @@
+package pdf
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gogpu/gg/recording"
+)
+
+// countdownContext is a context that is canceled once its Err has been
+// called n times, while n is positive.
+type countdownContext struct {
+	context.Context
+	n int
+}
+
+func (c *countdownContext) Err() error {
+	if c.n > 0 {
+		c.n--
+		if c.n == 0 {
+			c.Context = canceledContext()
+		}
+	}
+	return c.Context.Err()
+}
+
+func canceledContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
+
+func TestPlaybackContext(t *testing.T) {
+	recs := []*recording.Recording{vectorRecording(), chartRecording(), vectorRecording()}
+
+	var want bytes.Buffer
+	doc := NewDocument()
+	for _, rec := range recs {
+		if err := doc.Playback(rec); err != nil {
+			t.Fatalf("Playback failed: %v", err)
+		}
+	}
+	if _, err := doc.WriteTo(&want); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	var reports []Progress
+	doc = NewDocument()
+	err := doc.PlaybackContext(context.Background(), recs, func(p Progress) {
+		reports = append(reports, p)
+	})
+	if err != nil {
+		t.Fatalf("PlaybackContext failed: %v", err)
+	}
+	if _, err := doc.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	got, wantContents := pageContents(t, buf.Bytes()), pageContents(t, want.Bytes())
+	if len(got) != len(wantContents) {
+		t.Fatalf("document has %d pages, want %d", len(got), len(wantContents))
+	}
+	for i := range got {
+		if !bytes.Equal(got[i], wantContents[i]) {
+			t.Errorf("page %d draws\n%s\nwant\n%s", i, got[i], wantContents[i])
+		}
+	}
+	for i, p := range reports {
+		if want := (Progress{Pages: i + 1, Total: len(recs)}); p != want {
+			t.Errorf("progress report %d = %+v, want %+v", i, p, want)
+		}
+	}
+	if len(reports) != len(recs) {
+		t.Errorf("progress reported %d times, want %d", len(reports), len(recs))
+	}
+
+	// A streaming document reports the bytes written so far.
+	buf.Reset()
+	stream := NewStreamingDocument(&buf)
+	var written []int64
+	err = stream.PlaybackContext(context.Background(), recs, func(p Progress) {
+		written = append(written, p.BytesWritten)
+		if p.BytesWritten != int64(buf.Len()) {
+			t.Errorf("page %d: %d bytes reported, %d written", p.Pages, p.BytesWritten, buf.Len())
+		}
+	})
+	if err != nil {
+		t.Fatalf("PlaybackContext failed: %v", err)
+	}
+	for i := 1; i < len(written); i++ {
+		if written[i] <= written[i-1] {
+			t.Errorf("bytes written do not grow: %v", written)
+		}
+	}
+	if err := stream.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
+
+func TestPlaybackContextCanceled(t *testing.T) {
+	recs := []*recording.Recording{vectorRecording(), chartRecording(), vectorRecording()}
+
+	// Canceled between pages.
+	doc := NewDocument()
+	if err := doc.PlaybackContext(canceledContext(), recs, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("PlaybackContext error = %v, want %v", err, context.Canceled)
+	}
+	if doc.PageCount() != 0 {
+		t.Errorf("canceled document has %d pages, want none", doc.PageCount())
+	}
+
+	// Canceled while the second page is drawn: that page is not written,
+	// and the document stays failed.
+	var buf bytes.Buffer
+	doc = NewStreamingDocument(&buf)
+	ctx := &countdownContext{Context: context.Background()}
+	var firstPage int
+	err := doc.PlaybackContext(ctx, recs, func(p Progress) {
+		firstPage = int(p.BytesWritten)
+		ctx.n = 4
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("PlaybackContext error = %v, want %v", err, context.Canceled)
+	}
+	if buf.Len() != firstPage {
+		t.Errorf("%d bytes written, want the %d of the first page", buf.Len(), firstPage)
+	}
+	if err := doc.Playback(vectorRecording()); !errors.Is(err, context.Canceled) {
+		t.Errorf("Playback on a failed document = %v, want %v", err, context.Canceled)
+	}
+	if err := doc.PlaybackContext(context.Background(), recs, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("PlaybackContext on a failed document = %v, want %v", err, context.Canceled)
+	}
+	if err := doc.Close(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Close error = %v, want %v", err, context.Canceled)
+	}
+	if buf.Len() != firstPage {
+		t.Errorf("Close of a failed document wrote %d bytes", buf.Len()-firstPage)
+	}
+
+	doc = NewDocument()
+	ctx = &countdownContext{Context: context.Background(), n: 5}
+	if err := doc.PlaybackContext(ctx, recs, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("PlaybackContext error = %v, want %v", err, context.Canceled)
+	}
+	if _, err := doc.WriteTo(&bytes.Buffer{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("WriteTo error = %v, want %v", err, context.Canceled)
+	}
+	if err := doc.NewPage(100, 100).End(); !errors.Is(err, context.Canceled) {
+		t.Errorf("End of a page added after failing = %v, want %v", err, context.Canceled)
+	}
+
+	// Playback reaches no page method that draws without checking ctx.
+	var page recording.Backend = contextPage{page: NewDocument().NewPage(100, 100).(*pageBackend), ctx: canceledContext()}
+	if _, ok := page.(interface{ BeginGroup(TransparencyGroup) }); ok {
+		t.Error("page played back with a context has methods that do not check it")
+	}
+}
+
+func TestPlaybackAllProgress(t *testing.T) {
+	doc := NewDocument()
+	recs := parallelDocument(t, doc)
+	pages := 0
+	err := doc.PlaybackAllProgress(context.Background(), recs, 4, func(p Progress) {
+		pages++
+		if p.Pages != pages || p.Total != len(recs) {
+			t.Errorf("progress = %+v, want %d of %d pages", p, pages, len(recs))
+		}
+	})
+	if err != nil {
+		t.Fatalf("PlaybackAllProgress failed: %v", err)
+	}
+	if pages != len(recs) {
+		t.Errorf("progress reported %d times, want %d", pages, len(recs))
+	}
+
+	// Canceling from the progress callback stops the remaining pages and
+	// fails the document.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	doc = NewDocument()
+	recs = parallelDocument(t, doc)
+	err = doc.PlaybackAllProgress(ctx, recs, 2, func(Progress) { cancel() })
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("PlaybackAllProgress error = %v, want %v", err, context.Canceled)
+	}
+	if doc.PageCount() == len(recs) {
+		t.Error("PlaybackAllProgress drew every page after being canceled")
+	}
+
+	// With one worker, no page is added after the one that canceled.
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	doc = NewDocument()
+	recs = parallelDocument(t, doc)
+	if err := doc.PlaybackAllProgress(ctx, recs, 1, func(Progress) { cancel() }); !errors.Is(err, context.Canceled) {
+		t.Fatalf("PlaybackAllProgress error = %v, want %v", err, context.Canceled)
+	}
+	if n := doc.PageCount(); n != 1 {
+		t.Errorf("document has %d pages after canceling on the first, want 1", n)
+	}
+	if _, err := doc.WriteTo(&bytes.Buffer{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("WriteTo error = %v, want %v", err, context.Canceled)
+	}
+}
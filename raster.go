@@ -0,0 +1,389 @@
+package pdf
+
+import (
+	"image"
+	"math"
+
+	"github.com/gogpu/gg"
+	"github.com/gogpu/gg/recording"
+	"github.com/gogpu/gg/scene"
+	"github.com/gogpu/gg/text"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+)
+
+// rasterScale is the resolution of rasterized content in pixels per point,
+// 150 dpi.
+const rasterScale = 150.0 / 72
+
+// maxRasterPixels limits the area drawn as pixels at once, 4096x4096 pixels
+// or about 64 MB.
+const maxRasterPixels = 1 << 24
+
+// rasterizer paints backend calls into pixels for content PDF cannot
+// express as vectors. Its target covers only the pixels the content can
+// change.
+//
+// On the page, the target is a transparent layer painted over the page
+// content. The page is opaque, so every Porter-Duff operator other than
+// Plus amounts to such a layer, which depends only on the content drawn.
+// In transparency groups, masks, and captured content, the target starts
+// transparent and holds what was drawn in them so far.
+type rasterizer struct {
+	target *image.RGBA
+	ctx    *gg.Context
+
+	// paper is set when the target lies over the page.
+	paper bool
+
+	transform recording.Matrix
+	clip      *image.Alpha // nil when nothing is clipped
+	mask      *image.Alpha // nil when nothing is masked
+	mode      scene.BlendMode
+	stack     []rasterState
+
+	// groups holds the targets interrupted by open transparency groups and
+	// masks, which are drawn on transparent layers of their own.
+	groups []*image.RGBA
+
+	// dirty is the area changed since it was last reset.
+	dirty image.Rectangle
+}
+
+// rasterState is the part of the graphics state Save and Restore affect.
+type rasterState struct {
+	transform recording.Matrix
+	clip      *image.Alpha
+	mask      *image.Alpha
+	mode      scene.BlendMode
+}
+
+// newRasterizer returns a rasterizer for the pixels in bounds. paper is
+// set when it draws over the page.
+func newRasterizer(bounds image.Rectangle, paper bool) *rasterizer {
+	return &rasterizer{
+		target:    image.NewRGBA(bounds),
+		ctx:       gg.NewContext(bounds.Dx(), bounds.Dy()),
+		paper:     paper,
+		transform: recording.Identity(),
+	}
+}
+
+// device returns the matrix from user space to pixels.
+func (r *rasterizer) device() recording.Matrix {
+	return recording.Scale(rasterScale, rasterScale).Multiply(r.transform)
+}
+
+// deviceRect returns the pixels covering rect in the space given by m.
+func deviceRect(m recording.Matrix, rect recording.Rect) image.Rectangle {
+	box := transformRect(recording.Scale(rasterScale, rasterScale).Multiply(m), rect)
+	return image.Rect(
+		int(math.Floor(box.MinX)), int(math.Floor(box.MinY)),
+		int(math.Ceil(box.MaxX)), int(math.Ceil(box.MaxY)),
+	)
+}
+
+// transformRect returns the bounding box of rect transformed by m.
+func transformRect(m recording.Matrix, rect recording.Rect) recording.Rect {
+	box := recording.Rect{MinX: math.Inf(1), MinY: math.Inf(1), MaxX: math.Inf(-1), MaxY: math.Inf(-1)}
+	for _, p := range [4][2]float64{
+		{rect.MinX, rect.MinY}, {rect.MaxX, rect.MinY},
+		{rect.MinX, rect.MaxY}, {rect.MaxX, rect.MaxY},
+	} {
+		x, y := m.TransformPoint(p[0], p[1])
+		box.MinX, box.MinY = min(box.MinX, x), min(box.MinY, y)
+		box.MaxX, box.MaxY = max(box.MaxX, x), max(box.MaxY, y)
+	}
+	return box
+}
+
+// pathBounds returns a rectangle containing path, including its control
+// points.
+func pathBounds(path *gg.Path) recording.Rect {
+	box := recording.Rect{MinX: math.Inf(1), MinY: math.Inf(1), MaxX: math.Inf(-1), MaxY: math.Inf(-1)}
+	add := func(p gg.Point) {
+		box.MinX, box.MinY = min(box.MinX, p.X), min(box.MinY, p.Y)
+		box.MaxX, box.MaxY = max(box.MaxX, p.X), max(box.MaxY, p.Y)
+	}
+	for _, elem := range path.Elements() {
+		switch e := elem.(type) {
+		case gg.MoveTo:
+			add(e.Point)
+		case gg.LineTo:
+			add(e.Point)
+		case gg.QuadTo:
+			add(e.Control)
+			add(e.Point)
+		case gg.CubicTo:
+			add(e.Control1)
+			add(e.Control2)
+			add(e.Point)
+		}
+	}
+	if box.MinX > box.MaxX {
+		return recording.Rect{}
+	}
+	return box
+}
+
+func (r *rasterizer) save() {
+	r.stack = append(r.stack, rasterState{transform: r.transform, clip: r.clip, mask: r.mask, mode: r.mode})
+}
+
+func (r *rasterizer) restore() {
+	if len(r.stack) == 0 {
+		return
+	}
+	state := r.stack[len(r.stack)-1]
+	r.stack = r.stack[:len(r.stack)-1]
+	r.transform, r.clip, r.mask, r.mode = state.transform, state.clip, state.mask, state.mode
+}
+
+// beginGroup starts drawing on a transparent layer.
+func (r *rasterizer) beginGroup() {
+	r.groups = append(r.groups, r.target)
+	r.target = image.NewRGBA(r.target.Bounds())
+}
+
+// endGroup composites the group's layer onto the target it interrupted.
+func (r *rasterizer) endGroup(opacity float64, mode scene.BlendMode) {
+	if len(r.groups) == 0 {
+		return
+	}
+	layer := r.target
+	r.target = r.groups[len(r.groups)-1]
+	r.groups = r.groups[:len(r.groups)-1]
+	saved := r.mode
+	r.mode = mode
+	r.paint(layer, opacity)
+	r.mode = saved
+}
+
+// beginMask starts drawing mask content on a transparent layer. The mask
+// content itself is not masked.
+func (r *rasterizer) beginMask() {
+	r.beginGroup()
+	r.mask = nil
+}
+
+// endMask turns the mask layer into the mask of the current state.
+func (r *rasterizer) endMask(mode MaskMode) {
+	if len(r.groups) == 0 {
+		return
+	}
+	layer := r.target
+	r.target = r.groups[len(r.groups)-1]
+	r.groups = r.groups[:len(r.groups)-1]
+	mask := image.NewAlpha(layer.Bounds())
+	for i := range mask.Pix {
+		p := layer.Pix[4*i : 4*i+4 : 4*i+4]
+		a := p[3]
+		if mode == MaskLuminosity {
+			// Premultiplied components are the content drawn on black.
+			c := [3]float64{float64(p[0]), float64(p[1]), float64(p[2])}
+			a = uint8(math.Round(clamp01(lum(c)/0xFF) * 0xFF))
+		}
+		mask.Pix[i] = a
+	}
+	r.mask = mask
+}
+
+func (r *rasterizer) setTransform(m recording.Matrix) {
+	r.transform = m
+}
+
+// setClip intersects the clip with the coverage of path.
+func (r *rasterizer) setClip(path *gg.Path, rule recording.FillRule) {
+	coverage := r.shape(path, func() {
+		r.ctx.SetFillBrush(gg.Solid(gg.White))
+		r.ctx.SetFillRule(fillRule(rule))
+		_ = r.ctx.Fill()
+	})
+	mask := image.NewAlpha(r.target.Bounds())
+	for i := range mask.Pix {
+		a := coverage.Pix[4*i+3]
+		if r.clip != nil {
+			a = uint8(uint16(a) * uint16(r.clip.Pix[i]) / 0xFF)
+		}
+		mask.Pix[i] = a
+	}
+	r.clip = mask
+}
+
+func (r *rasterizer) fillPath(path *gg.Path, brush recording.Brush, rule recording.FillRule) {
+	col := solidColor(brush)
+	r.paint(r.shape(path, func() {
+		r.ctx.SetFillBrush(gg.Solid(col))
+		r.ctx.SetFillRule(fillRule(rule))
+		_ = r.ctx.Fill()
+	}), 1)
+}
+
+func (r *rasterizer) strokePath(path *gg.Path, brush recording.Brush, stroke recording.Stroke) {
+	col := solidColor(brush)
+	r.paint(r.shape(path, func() {
+		r.ctx.SetStrokeBrush(gg.Solid(col))
+		r.ctx.SetLineWidth(stroke.Width)
+		r.ctx.SetLineCap(gg.LineCap(stroke.Cap))
+		r.ctx.SetLineJoin(gg.LineJoin(stroke.Join))
+		r.ctx.SetMiterLimit(stroke.MiterLimit)
+		if len(stroke.DashPattern) > 0 {
+			r.ctx.SetDash(stroke.DashPattern...)
+			r.ctx.SetDashOffset(stroke.DashOffset)
+		} else {
+			r.ctx.ClearDash()
+		}
+		_ = r.ctx.Stroke()
+	}), 1)
+}
+
+func (r *rasterizer) fillRect(rect recording.Rect, brush recording.Brush) {
+	path := gg.NewPath()
+	path.Rectangle(rect.MinX, rect.MinY, rect.Width(), rect.Height())
+	r.fillPath(path, brush, recording.FillRuleNonZero)
+}
+
+// shape paints path in device space with paint and returns the pixels of
+// the target's bounds.
+func (r *rasterizer) shape(path *gg.Path, paint func()) *image.RGBA {
+	origin := r.target.Rect.Min
+	m := recording.Translate(float64(-origin.X), float64(-origin.Y)).Multiply(r.device())
+	r.ctx.Clear()
+	r.ctx.SetTransform(gg.Matrix{A: m.A, B: m.B, C: m.C, D: m.D, E: m.E, F: m.F})
+	r.ctx.ClearPath()
+	for _, elem := range path.Elements() {
+		switch e := elem.(type) {
+		case gg.MoveTo:
+			r.ctx.MoveTo(e.Point.X, e.Point.Y)
+		case gg.LineTo:
+			r.ctx.LineTo(e.Point.X, e.Point.Y)
+		case gg.QuadTo:
+			r.ctx.QuadraticTo(e.Control.X, e.Control.Y, e.Point.X, e.Point.Y)
+		case gg.CubicTo:
+			r.ctx.CubicTo(e.Control1.X, e.Control1.Y, e.Control2.X, e.Control2.Y, e.Point.X, e.Point.Y)
+		case gg.Close:
+			r.ctx.ClosePath()
+		}
+	}
+	paint()
+	img := r.ctx.ResizeTarget().ToImage()
+	img.Rect = r.target.Rect
+	return img
+}
+
+func (r *rasterizer) drawImage(img image.Image, src, dst recording.Rect, opts recording.ImageOptions) {
+	if src.Width() <= 0 || src.Height() <= 0 {
+		return
+	}
+	if p, ok := img.(*ProfiledImage); ok {
+		img = p.Image
+	}
+	m := r.device().
+		Multiply(recording.Translate(dst.MinX, dst.MinY)).
+		Multiply(recording.Scale(dst.Width()/src.Width(), dst.Height()/src.Height())).
+		Multiply(recording.Translate(-src.MinX, -src.MinY))
+	layer := image.NewRGBA(r.target.Bounds())
+	sr := image.Rect(
+		int(math.Floor(src.MinX)), int(math.Floor(src.MinY)),
+		int(math.Ceil(src.MaxX)), int(math.Ceil(src.MaxY)),
+	).Intersect(img.Bounds())
+	draw.BiLinear.Transform(layer, f64.Aff3{m.A, m.B, m.C, m.D, m.E, m.F}, img, sr, draw.Over, nil)
+	r.paint(layer, opts.Alpha)
+}
+
+// drawText draws s with the face scaled to device pixels. Rotation and
+// skew are not applied to the glyphs.
+func (r *rasterizer) drawText(s string, x, y float64, face text.Face, brush recording.Brush) {
+	if face == nil || face.Source() == nil {
+		return
+	}
+	m := r.device()
+	scale := math.Sqrt(math.Abs(m.A*m.E - m.B*m.D))
+	px, py := m.TransformPoint(x, y)
+	layer := image.NewRGBA(r.target.Bounds())
+	text.Draw(layer, s, face.Source().Face(face.Size()*scale), px, py, solidColor(brush).Color())
+	r.paint(layer, 1)
+}
+
+// paint composites the premultiplied pixels of layer, scaled by opacity,
+// onto the target in the current blend mode, within the clip and mask.
+// Over the page, it paints the layer the mode amounts to instead.
+func (r *rasterizer) paint(layer *image.RGBA, opacity float64) {
+	opacity = clamp01(opacity)
+	_, unbounded := porterDuff[r.mode]
+	paper := r.paper && len(r.groups) == 0
+	width := r.target.Rect.Dx()
+	dirty := image.Rectangle{Min: image.Pt(math.MaxInt, math.MaxInt)}
+	for i := 0; i < len(r.target.Pix); i += 4 {
+		sp := layer.Pix[i : i+4 : i+4]
+		if sp[3] == 0 && !unbounded {
+			continue
+		}
+		coverage := 1.0
+		if r.clip != nil {
+			coverage = float64(r.clip.Pix[i/4]) / 0xFF
+		}
+		if r.mask != nil {
+			coverage *= float64(r.mask.Pix[i/4]) / 0xFF
+		}
+		if coverage == 0 {
+			continue
+		}
+		dp := r.target.Pix[i : i+4 : i+4]
+		var s, d [4]float64
+		for j := range 4 {
+			s[j] = float64(sp[j]) / 0xFF * opacity
+			d[j] = float64(dp[j]) / 0xFF
+		}
+		var out [4]float64
+		if paper {
+			// The layer's color is the result over black, and its alpha
+			// the part of the page that no longer shows through.
+			black := onPaper(composite(r.mode, s, [4]float64{0, 0, 0, 1}))
+			white := onPaper(composite(r.mode, s, [4]float64{1, 1, 1, 1}))
+			over := black
+			over[3] = 1 - (white[0] - black[0])
+			for j := range 4 {
+				out[j] = over[j]*coverage + d[j]*(1-over[3]*coverage)
+			}
+		} else {
+			out = composite(r.mode, s, d)
+			for j := range 4 {
+				// Keep the backdrop where the clip or mask only
+				// partially covers the pixel.
+				out[j] = out[j]*coverage + d[j]*(1-coverage)
+			}
+		}
+		changed := false
+		for j := range 4 {
+			v := uint8(math.Round(clamp01(out[j]) * 0xFF))
+			changed = changed || v != dp[j]
+			dp[j] = v
+		}
+		if changed {
+			x, y := r.target.Rect.Min.X+i/4%width, r.target.Rect.Min.Y+i/4/width
+			dirty.Min.X, dirty.Min.Y = min(dirty.Min.X, x), min(dirty.Min.Y, y)
+			dirty.Max.X, dirty.Max.Y = max(dirty.Max.X, x+1), max(dirty.Max.Y, y+1)
+		}
+	}
+	if !dirty.Empty() {
+		r.dirty = r.dirty.Union(dirty)
+	}
+}
+
+// onPaper returns a premultiplied pixel as it shows on white paper.
+func onPaper(p [4]float64) [4]float64 {
+	for j := range 3 {
+		p[j] += 1 - p[3]
+	}
+	p[3] = 1
+	return p
+}
+
+// fillRule converts a recording fill rule for gg.
+func fillRule(rule recording.FillRule) gg.FillRule {
+	if rule == recording.FillRuleEvenOdd {
+		return gg.FillRuleEvenOdd
+	}
+	return gg.FillRuleNonZero
+}
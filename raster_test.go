@@ -0,0 +1,101 @@
+package pdf
+
+import (
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/gogpu/gg"
+	"github.com/gogpu/gg/recording"
+	"github.com/gogpu/gg/scene"
+)
+
+// rasterPixel returns the target pixel at a point given in page units.
+func rasterPixel(r *rasterizer, x, y float64) color.RGBA {
+	return r.target.RGBAAt(int(x*rasterScale), int(y*rasterScale))
+}
+
+// layerRasterizer returns a rasterizer drawing on a transparent layer of
+// width by height points.
+func layerRasterizer(width, height float64) *rasterizer {
+	return newRasterizer(deviceRect(recording.Identity(), recording.NewRect(0, 0, width, height)), false)
+}
+
+func TestRasterizerTransformAndClip(t *testing.T) {
+	r := layerRasterizer(100, 100)
+	r.save()
+	r.setTransform(recording.Translate(10, 10))
+	clip := gg.NewPath()
+	clip.Rectangle(0, 0, 20, 20)
+	r.setClip(clip, recording.FillRuleNonZero)
+	r.fillRect(recording.NewRect(10, 10, 40, 40), recording.NewSolidBrush(gg.RGB(1, 0, 0)))
+	r.restore()
+	r.fillRect(recording.NewRect(60, 60, 10, 10), recording.NewSolidBrush(gg.RGB(0, 0, 1)))
+
+	red, blue, clear := color.RGBA{0xFF, 0, 0, 0xFF}, color.RGBA{0, 0, 0xFF, 0xFF}, color.RGBA{}
+	for _, tt := range []struct {
+		x, y float64
+		want color.RGBA
+	}{
+		{25, 25, red},   // inside the clip and the translated rectangle
+		{15, 15, clear}, // inside the clip, outside the rectangle
+		{35, 35, clear}, // inside the rectangle, outside the clip
+		{65, 65, blue},  // drawn after Restore, unclipped
+	} {
+		if got := rasterPixel(r, tt.x, tt.y); got != tt.want {
+			t.Errorf("pixel at (%v, %v) = %v, want %v", tt.x, tt.y, got, tt.want)
+		}
+	}
+}
+
+func TestRasterizerTracksChangedArea(t *testing.T) {
+	r := layerRasterizer(100, 100)
+	r.mode = scene.BlendDestination
+	r.fillRect(recording.NewRect(0, 0, 100, 100), recording.NewSolidBrush(gg.White))
+	if !r.dirty.Empty() {
+		t.Errorf("painting in the destination mode changed %v", r.dirty)
+	}
+	r.mode = scene.BlendNormal
+	r.fillRect(recording.NewRect(10, 20, 30, 40), recording.NewSolidBrush(gg.Black))
+	want := recording.NewRect(10, 20, 30, 40)
+	got := r.dirty
+	if math.Abs(float64(got.Min.X)/rasterScale-want.MinX) > 1 || math.Abs(float64(got.Max.Y)/rasterScale-want.MaxY) > 1 {
+		t.Errorf("changed area = %v pixels, want about %v points", got, want)
+	}
+}
+
+func TestRasterizerPaintsLayerOverPage(t *testing.T) {
+	r := newRasterizer(deviceRect(recording.Identity(), recording.NewRect(0, 0, 100, 100)), true)
+	r.mode = scene.BlendDestinationOut
+	r.fillRect(recording.NewRect(0, 0, 50, 100), recording.NewSolidBrush(gg.RGBA2(0, 0, 0, 0.5)))
+
+	// Half the page is cut through to the paper: a half-transparent white
+	// layer, whatever the page shows below it.
+	got := rasterPixel(r, 25, 50)
+	if got.R != got.A || got.G != got.A || got.B != got.A || got.A < 0x7E || got.A > 0x81 {
+		t.Errorf("pixel cut through = %v, want white at half opacity", got)
+	}
+	if got := rasterPixel(r, 75, 50); got != (color.RGBA{}) {
+		t.Errorf("pixel outside the rectangle = %v, want transparent", got)
+	}
+}
+
+func TestRasterizerBounds(t *testing.T) {
+	bounds := deviceRect(recording.Identity(), recording.NewRect(40, 40, 20, 20))
+	r := newRasterizer(bounds, false)
+	r.fillRect(recording.NewRect(0, 0, 50, 50), recording.NewSolidBrush(gg.RGB(1, 0, 0)))
+
+	if r.target.Bounds() != bounds {
+		t.Errorf("target covers %v, want %v", r.target.Bounds(), bounds)
+	}
+	red := color.RGBA{0xFF, 0, 0, 0xFF}
+	if got := rasterPixel(r, 45, 45); got != red {
+		t.Errorf("pixel inside the rectangle = %v, want %v", got, red)
+	}
+	if got := rasterPixel(r, 55, 55); got != (color.RGBA{}) {
+		t.Errorf("pixel outside the rectangle = %v, want transparent", got)
+	}
+	if r.dirty.Max.X > bounds.Max.X || r.dirty.Min.X < bounds.Min.X {
+		t.Errorf("changed area %v is outside the target %v", r.dirty, bounds)
+	}
+}
@@ -0,0 +1,1068 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+)
+
+// pdfReader provides random access to the objects of a serialized PDF file.
+// It is used to import pages from existing files.
+type pdfReader struct {
+	data    []byte
+	offsets map[int]int64
+	trailer pdfDict
+	cache   map[int]pdfObject
+
+	// Objects stored in object streams, and the decoded object streams.
+	compressed map[int]compressedEntry
+	objStms    map[int][]byte
+}
+
+// compressedEntry locates an object stored in an object stream.
+type compressedEntry struct {
+	stream, index int
+}
+
+// errMalformedPDF is wrapped by every parse error.
+var errMalformedPDF = errors.New("pdf: malformed file")
+
+// newPDFReader parses the cross-reference table and trailer of data.
+func newPDFReader(data []byte) (*pdfReader, error) {
+	r := &pdfReader{
+		data:       data,
+		offsets:    make(map[int]int64),
+		cache:      make(map[int]pdfObject),
+		compressed: make(map[int]compressedEntry),
+		objStms:    make(map[int][]byte),
+	}
+	if err := r.readXref(); err != nil {
+		return nil, err
+	}
+	if r.trailer == nil {
+		return nil, fmt.Errorf("%w: missing trailer", errMalformedPDF)
+	}
+	return r, nil
+}
+
+// readXref follows the startxref pointer and every /Prev section.
+func (r *pdfReader) readXref() error {
+	idx := bytes.LastIndex(r.data, []byte("startxref"))
+	if idx < 0 {
+		return fmt.Errorf("%w: missing startxref", errMalformedPDF)
+	}
+	lx := newLexer(r.data, idx+len("startxref"))
+	tok, err := lx.next()
+	if err != nil {
+		return err
+	}
+	offset, err := strconv.ParseInt(tok.text, 10, 64)
+	if err != nil || tok.kind != tokNumber {
+		return fmt.Errorf("%w: invalid startxref", errMalformedPDF)
+	}
+
+	seen := make(map[int64]bool)
+	for offset > 0 && !seen[offset] {
+		seen[offset] = true
+		trailer, err := r.readXrefSection(offset)
+		if err != nil {
+			return err
+		}
+		if r.trailer == nil {
+			r.trailer = trailer
+		}
+		// Hybrid files list the objects in object streams in a separate
+		// cross-reference stream.
+		if stm, ok := trailer["XRefStm"].(int); ok && !seen[int64(stm)] {
+			seen[int64(stm)] = true
+			if _, err := r.readXrefStream(int64(stm)); err != nil {
+				return err
+			}
+		}
+		prev, ok := trailer["Prev"].(int)
+		if !ok {
+			break
+		}
+		offset = int64(prev)
+	}
+	return nil
+}
+
+// readXrefSection reads one cross-reference section, classic or stream, and
+// returns its trailer dictionary. Entries already known from a newer section
+// win.
+func (r *pdfReader) readXrefSection(offset int64) (pdfDict, error) {
+	if offset < 0 || offset >= int64(len(r.data)) {
+		return nil, fmt.Errorf("%w: xref offset %d out of range", errMalformedPDF, offset)
+	}
+	lx := newLexer(r.data, int(offset))
+	tok, err := lx.next()
+	if err != nil {
+		return nil, err
+	}
+	if tok.kind == tokNumber {
+		return r.readXrefStream(offset)
+	}
+	if tok.kind != tokKeyword || tok.text != "xref" {
+		return nil, fmt.Errorf("%w: expected xref at offset %d", errMalformedPDF, offset)
+	}
+
+	for {
+		tok, err = lx.next()
+		if err != nil {
+			return nil, err
+		}
+		if tok.kind == tokKeyword && tok.text == "trailer" {
+			break
+		}
+		first, err1 := strconv.Atoi(tok.text)
+		countTok, err2 := lx.next()
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("%w: invalid xref subsection", errMalformedPDF)
+		}
+		count, err := strconv.Atoi(countTok.text)
+		if err != nil || first < 0 || count < 0 {
+			return nil, fmt.Errorf("%w: invalid xref subsection", errMalformedPDF)
+		}
+		// Each entry takes 20 bytes.
+		if count > (len(r.data)-lx.pos)/20 {
+			return nil, fmt.Errorf("%w: xref subsection of %d entries exceeds the file", errMalformedPDF, count)
+		}
+		for i := 0; i < count; i++ {
+			offTok, err1 := lx.next()
+			genTok, err2 := lx.next()
+			typeTok, err3 := lx.next()
+			if err1 != nil || err2 != nil || err3 != nil ||
+				offTok.kind == tokEOF || genTok.kind == tokEOF || typeTok.kind == tokEOF {
+				return nil, fmt.Errorf("%w: truncated xref", errMalformedPDF)
+			}
+			num := first + i
+			if r.known(num) || typeTok.text != "n" {
+				continue
+			}
+			off, err := strconv.ParseInt(offTok.text, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid xref entry", errMalformedPDF)
+			}
+			r.offsets[num] = off
+		}
+	}
+
+	p := &objectParser{lx: lx}
+	obj, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	trailer, ok := obj.(pdfDict)
+	if !ok {
+		return nil, fmt.Errorf("%w: trailer is not a dictionary", errMalformedPDF)
+	}
+	return trailer, nil
+}
+
+// readXrefStream reads a cross-reference stream, the compressed form of a
+// cross-reference section introduced in PDF 1.5, and returns its
+// dictionary, which also serves as the trailer.
+func (r *pdfReader) readXrefStream(offset int64) (pdfDict, error) {
+	if offset < 0 || offset >= int64(len(r.data)) {
+		return nil, fmt.Errorf("%w: xref offset %d out of range", errMalformedPDF, offset)
+	}
+	lx := newLexer(r.data, int(offset))
+	if !objectHeader(lx) {
+		return nil, fmt.Errorf("%w: expected xref stream at offset %d", errMalformedPDF, offset)
+	}
+	p := &objectParser{lx: lx}
+	obj, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	dict, _ := obj.(pdfDict)
+	stream, err := p.streamAfter(dict)
+	if err != nil {
+		return nil, err
+	}
+	if stream == nil || dict["Type"] != pdfName("XRef") {
+		return nil, fmt.Errorf("%w: expected xref stream at offset %d", errMalformedPDF, offset)
+	}
+	data, err := decodeStream(stream)
+	if err != nil {
+		return nil, fmt.Errorf("xref stream: %w", err)
+	}
+
+	var w [3]int
+	widths, _ := dict["W"].(pdfArray)
+	for i := range w {
+		if i < len(widths) {
+			w[i], _ = widths[i].(int)
+		}
+		if w[i] < 0 || w[i] > 8 {
+			return nil, fmt.Errorf("%w: invalid xref stream /W", errMalformedPDF)
+		}
+	}
+	index, _ := dict["Index"].(pdfArray)
+	if index == nil {
+		size, _ := dict["Size"].(int)
+		index = pdfArray{0, size}
+	}
+	field := func(b []byte, def int) int {
+		if len(b) == 0 {
+			return def
+		}
+		n := 0
+		for _, c := range b {
+			n = n<<8 | int(c)
+		}
+		return n
+	}
+	entry := w[0] + w[1] + w[2]
+	pos := 0
+	for i := 0; i+1 < len(index); i += 2 {
+		first, _ := index[i].(int)
+		count, _ := index[i+1].(int)
+		for j := 0; j < count; j++ {
+			if entry == 0 || pos+entry > len(data) {
+				return nil, fmt.Errorf("%w: truncated xref stream", errMalformedPDF)
+			}
+			row := data[pos : pos+entry]
+			pos += entry
+			num := first + j
+			if r.known(num) {
+				continue
+			}
+			f2, f3 := field(row[w[0]:w[0]+w[1]], 0), field(row[w[0]+w[1]:], 0)
+			switch field(row[:w[0]], 1) {
+			case 1:
+				r.offsets[num] = int64(f2)
+			case 2:
+				r.compressed[num] = compressedEntry{stream: f2, index: f3}
+			}
+		}
+	}
+	return dict, nil
+}
+
+// known reports whether a newer cross-reference section has located num.
+func (r *pdfReader) known(num int) bool {
+	if _, ok := r.offsets[num]; ok {
+		return true
+	}
+	_, ok := r.compressed[num]
+	return ok
+}
+
+// objectHeader consumes the "num gen obj" header of an indirect object and
+// reports whether it was well formed.
+func objectHeader(lx *lexer) bool {
+	for _, want := range []tokenKind{tokNumber, tokNumber} {
+		if tok, err := lx.next(); err != nil || tok.kind != want {
+			return false
+		}
+	}
+	tok, err := lx.next()
+	return err == nil && tok.text == "obj"
+}
+
+// object returns the object with the given number. Missing objects resolve to
+// null, as the PDF specification requires.
+func (r *pdfReader) object(num int) (pdfObject, error) {
+	if obj, ok := r.cache[num]; ok {
+		return obj, nil
+	}
+	if entry, ok := r.compressed[num]; ok {
+		obj, err := r.compressedObject(num, entry)
+		if err != nil {
+			return nil, fmt.Errorf("object %d: %w", num, err)
+		}
+		r.cache[num] = obj
+		return obj, nil
+	}
+	offset, ok := r.offsets[num]
+	if !ok {
+		return nil, nil
+	}
+	if offset < 0 || offset >= int64(len(r.data)) {
+		return nil, fmt.Errorf("%w: object %d offset out of range", errMalformedPDF, num)
+	}
+
+	lx := newLexer(r.data, int(offset))
+	if !objectHeader(lx) {
+		return nil, fmt.Errorf("%w: invalid header for object %d", errMalformedPDF, num)
+	}
+
+	p := &objectParser{lx: lx, reader: r}
+	obj, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("object %d: %w", num, err)
+	}
+	if dict, ok := obj.(pdfDict); ok {
+		if stream, err := p.streamAfter(dict); err != nil {
+			return nil, fmt.Errorf("object %d: %w", num, err)
+		} else if stream != nil {
+			obj = stream
+		}
+	}
+	r.cache[num] = obj
+	return obj, nil
+}
+
+// compressedObject parses object num from the object stream that holds it.
+func (r *pdfReader) compressedObject(num int, entry compressedEntry) (pdfObject, error) {
+	obj, err := r.object(entry.stream)
+	if err != nil {
+		return nil, err
+	}
+	stream, ok := obj.(*pdfStream)
+	if !ok || stream.Dict["Type"] != pdfName("ObjStm") {
+		return nil, fmt.Errorf("%w: object %d is not an object stream", errMalformedPDF, entry.stream)
+	}
+	data, ok := r.objStms[entry.stream]
+	if !ok {
+		if data, err = decodeStream(stream); err != nil {
+			return nil, err
+		}
+		r.objStms[entry.stream] = data
+	}
+
+	// The stream starts with pairs of object numbers and offsets relative to
+	// /First.
+	first, _ := stream.Dict["First"].(int)
+	lx := newLexer(data, 0)
+	offset := -1
+	for i := 0; i <= entry.index; i++ {
+		numTok, err1 := lx.next()
+		offTok, err2 := lx.next()
+		if err1 != nil || err2 != nil || numTok.kind != tokNumber || offTok.kind != tokNumber {
+			return nil, fmt.Errorf("%w: invalid object stream header", errMalformedPDF)
+		}
+		if i == entry.index {
+			n, _ := strconv.Atoi(numTok.text)
+			offset, _ = strconv.Atoi(offTok.text)
+			if n != num {
+				return nil, fmt.Errorf("%w: object stream holds object %d, not %d", errMalformedPDF, n, num)
+			}
+		}
+	}
+	if offset < 0 || first+offset >= len(data) {
+		return nil, fmt.Errorf("%w: object offset out of range", errMalformedPDF)
+	}
+	p := &objectParser{lx: newLexer(data, first+offset), reader: r}
+	return p.parse()
+}
+
+// resolve follows references until it reaches a direct object.
+func (r *pdfReader) resolve(obj pdfObject) (pdfObject, error) {
+	for depth := 0; depth < 32; depth++ {
+		ref, ok := obj.(pdfRef)
+		if !ok {
+			return obj, nil
+		}
+		next, err := r.object(ref.Num)
+		if err != nil {
+			return nil, err
+		}
+		obj = next
+	}
+	return nil, fmt.Errorf("%w: reference chain too deep", errMalformedPDF)
+}
+
+// resolveDict resolves obj and returns it as a dictionary. Streams yield
+// their dictionary. A nil result means obj is not a dictionary.
+func (r *pdfReader) resolveDict(obj pdfObject) (pdfDict, error) {
+	obj, err := r.resolve(obj)
+	if err != nil {
+		return nil, err
+	}
+	switch v := obj.(type) {
+	case pdfDict:
+		return v, nil
+	case *pdfStream:
+		return v.Dict, nil
+	}
+	return nil, nil
+}
+
+// tokenKind classifies lexer tokens.
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokName
+	tokString
+	tokKeyword
+	tokArrayOpen
+	tokArrayClose
+	tokDictOpen
+	tokDictClose
+)
+
+// token is a single lexical element of a PDF file or content stream.
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// lexer splits PDF syntax into tokens.
+type lexer struct {
+	data []byte
+	pos  int
+}
+
+func newLexer(data []byte, pos int) *lexer {
+	return &lexer{data: data, pos: pos}
+}
+
+// isWhitespace reports whether c is PDF white space.
+func isWhitespace(c byte) bool {
+	switch c {
+	case 0, '\t', '\n', '\f', '\r', ' ':
+		return true
+	}
+	return false
+}
+
+// isDelimiter reports whether c is a PDF delimiter character.
+func isDelimiter(c byte) bool {
+	switch c {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return false
+}
+
+// skipSpace skips white space and comments.
+func (lx *lexer) skipSpace() {
+	for lx.pos < len(lx.data) {
+		c := lx.data[lx.pos]
+		if isWhitespace(c) {
+			lx.pos++
+			continue
+		}
+		if c == '%' {
+			for lx.pos < len(lx.data) && lx.data[lx.pos] != '\n' && lx.data[lx.pos] != '\r' {
+				lx.pos++
+			}
+			continue
+		}
+		return
+	}
+}
+
+// next returns the next token.
+func (lx *lexer) next() (token, error) {
+	lx.skipSpace()
+	if lx.pos >= len(lx.data) {
+		return token{kind: tokEOF}, nil
+	}
+
+	c := lx.data[lx.pos]
+	switch {
+	case c == '[':
+		lx.pos++
+		return token{kind: tokArrayOpen, text: "["}, nil
+	case c == ']':
+		lx.pos++
+		return token{kind: tokArrayClose, text: "]"}, nil
+	case c == '<' && lx.peekAt(1) == '<':
+		lx.pos += 2
+		return token{kind: tokDictOpen, text: "<<"}, nil
+	case c == '>' && lx.peekAt(1) == '>':
+		lx.pos += 2
+		return token{kind: tokDictClose, text: ">>"}, nil
+	case c == '<':
+		return lx.hexString()
+	case c == '(':
+		return lx.literalString()
+	case c == '/':
+		return lx.name(), nil
+	case c == '{' || c == '}':
+		lx.pos++
+		return token{kind: tokKeyword, text: string(c)}, nil
+	case c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9'):
+		return lx.number(), nil
+	case isDelimiter(c):
+		return token{}, fmt.Errorf("%w: unexpected %q at offset %d", errMalformedPDF, c, lx.pos)
+	}
+
+	start := lx.pos
+	for lx.pos < len(lx.data) && !isWhitespace(lx.data[lx.pos]) && !isDelimiter(lx.data[lx.pos]) {
+		lx.pos++
+	}
+	return token{kind: tokKeyword, text: string(lx.data[start:lx.pos])}, nil
+}
+
+func (lx *lexer) peekAt(n int) byte {
+	if lx.pos+n < len(lx.data) {
+		return lx.data[lx.pos+n]
+	}
+	return 0
+}
+
+func (lx *lexer) number() token {
+	start := lx.pos
+	lx.pos++
+	for lx.pos < len(lx.data) {
+		c := lx.data[lx.pos]
+		if (c < '0' || c > '9') && c != '.' {
+			break
+		}
+		lx.pos++
+	}
+	return token{kind: tokNumber, text: string(lx.data[start:lx.pos])}
+}
+
+func (lx *lexer) name() token {
+	lx.pos++ // skip '/'
+	var buf []byte
+	for lx.pos < len(lx.data) {
+		c := lx.data[lx.pos]
+		if isWhitespace(c) || isDelimiter(c) {
+			break
+		}
+		if c == '#' && lx.pos+2 < len(lx.data) {
+			if v, err := strconv.ParseUint(string(lx.data[lx.pos+1:lx.pos+3]), 16, 8); err == nil {
+				buf = append(buf, byte(v))
+				lx.pos += 3
+				continue
+			}
+		}
+		buf = append(buf, c)
+		lx.pos++
+	}
+	return token{kind: tokName, text: string(buf)}
+}
+
+func (lx *lexer) hexString() (token, error) {
+	lx.pos++ // skip '<'
+	var digits []byte
+	for lx.pos < len(lx.data) && lx.data[lx.pos] != '>' {
+		if c := lx.data[lx.pos]; !isWhitespace(c) {
+			digits = append(digits, c)
+		}
+		lx.pos++
+	}
+	if lx.pos >= len(lx.data) {
+		return token{}, fmt.Errorf("%w: unterminated hex string", errMalformedPDF)
+	}
+	lx.pos++ // skip '>'
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	out := make([]byte, len(digits)/2)
+	for i := range out {
+		v, err := strconv.ParseUint(string(digits[2*i:2*i+2]), 16, 8)
+		if err != nil {
+			return token{}, fmt.Errorf("%w: invalid hex string", errMalformedPDF)
+		}
+		out[i] = byte(v)
+	}
+	return token{kind: tokString, text: string(out)}, nil
+}
+
+func (lx *lexer) literalString() (token, error) {
+	lx.pos++ // skip '('
+	var out []byte
+	depth := 1
+	for lx.pos < len(lx.data) {
+		c := lx.data[lx.pos]
+		lx.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return token{kind: tokString, text: string(out)}, nil
+			}
+		case '\\':
+			out = lx.escape(out)
+			continue
+		}
+		out = append(out, c)
+	}
+	return token{}, fmt.Errorf("%w: unterminated string", errMalformedPDF)
+}
+
+// escape decodes the escape sequence following a backslash.
+func (lx *lexer) escape(out []byte) []byte {
+	if lx.pos >= len(lx.data) {
+		return out
+	}
+	c := lx.data[lx.pos]
+	lx.pos++
+	switch c {
+	case 'n':
+		return append(out, '\n')
+	case 'r':
+		return append(out, '\r')
+	case 't':
+		return append(out, '\t')
+	case 'b':
+		return append(out, '\b')
+	case 'f':
+		return append(out, '\f')
+	case '\r':
+		if lx.pos < len(lx.data) && lx.data[lx.pos] == '\n' {
+			lx.pos++
+		}
+		return out
+	case '\n':
+		return out
+	}
+	if c >= '0' && c <= '7' {
+		v := int(c - '0')
+		for i := 0; i < 2 && lx.pos < len(lx.data); i++ {
+			d := lx.data[lx.pos]
+			if d < '0' || d > '7' {
+				break
+			}
+			v = v*8 + int(d-'0')
+			lx.pos++
+		}
+		return append(out, byte(v))
+	}
+	return append(out, c)
+}
+
+// objectParser builds objects from lexer tokens.
+type objectParser struct {
+	lx     *lexer
+	reader *pdfReader
+	peeked []token
+}
+
+func (p *objectParser) nextToken() (token, error) {
+	if n := len(p.peeked); n > 0 {
+		tok := p.peeked[n-1]
+		p.peeked = p.peeked[:n-1]
+		return tok, nil
+	}
+	return p.lx.next()
+}
+
+func (p *objectParser) unread(tok token) {
+	p.peeked = append(p.peeked, tok)
+}
+
+// parse reads one complete object.
+func (p *objectParser) parse() (pdfObject, error) {
+	tok, err := p.nextToken()
+	if err != nil {
+		return nil, err
+	}
+	return p.parseFrom(tok)
+}
+
+func (p *objectParser) parseFrom(tok token) (pdfObject, error) {
+	switch tok.kind {
+	case tokEOF:
+		return nil, fmt.Errorf("%w: unexpected end of data", errMalformedPDF)
+	case tokName:
+		return pdfName(tok.text), nil
+	case tokString:
+		return pdfString(tok.text), nil
+	case tokArrayOpen:
+		return p.parseArray()
+	case tokDictOpen:
+		return p.parseDict()
+	case tokNumber:
+		return p.parseNumberOrRef(tok)
+	case tokKeyword:
+		switch tok.text {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: unexpected token %q", errMalformedPDF, tok.text)
+}
+
+func (p *objectParser) parseArray() (pdfObject, error) {
+	arr := pdfArray{}
+	for {
+		tok, err := p.nextToken()
+		if err != nil {
+			return nil, err
+		}
+		if tok.kind == tokArrayClose {
+			return arr, nil
+		}
+		obj, err := p.parseFrom(tok)
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, obj)
+	}
+}
+
+func (p *objectParser) parseDict() (pdfObject, error) {
+	dict := pdfDict{}
+	for {
+		tok, err := p.nextToken()
+		if err != nil {
+			return nil, err
+		}
+		if tok.kind == tokDictClose {
+			return dict, nil
+		}
+		if tok.kind != tokName {
+			return nil, fmt.Errorf("%w: dictionary key %q is not a name", errMalformedPDF, tok.text)
+		}
+		val, err := p.parse()
+		if err != nil {
+			return nil, err
+		}
+		if val != nil {
+			dict[pdfName(tok.text)] = val
+		}
+	}
+}
+
+// parseNumberOrRef parses a number, looking ahead for the "N G R" form of
+// an indirect reference.
+func (p *objectParser) parseNumberOrRef(tok token) (pdfObject, error) {
+	num := parseNumber(tok.text)
+	n, isInt := num.(int)
+	if !isInt || n < 0 {
+		return num, nil
+	}
+
+	second, err := p.nextToken()
+	if err != nil {
+		return nil, err
+	}
+	if second.kind != tokNumber {
+		p.unread(second)
+		return num, nil
+	}
+	third, err := p.nextToken()
+	if err != nil {
+		return nil, err
+	}
+	if third.kind == tokKeyword && third.text == "R" {
+		gen, err := strconv.Atoi(second.text)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid generation %q", errMalformedPDF, second.text)
+		}
+		return pdfRef{Num: n, Gen: gen}, nil
+	}
+	p.unread(third)
+	p.unread(second)
+	return num, nil
+}
+
+// parseNumber converts a number token to int or float64. Malformed reals
+// such as "--1" or "1.2.3" read as zero, as they do in most viewers.
+func parseNumber(text string) pdfObject {
+	if n, err := strconv.Atoi(text); err == nil {
+		return n
+	}
+	if f, err := strconv.ParseFloat(text, 64); err == nil {
+		return f
+	}
+	return 0.0
+}
+
+// atStreamKeyword consumes the next token and reports whether it is the
+// "stream" keyword.
+func (p *objectParser) atStreamKeyword() bool {
+	tok, err := p.lx.next()
+	return err == nil && tok.kind == tokKeyword && tok.text == "stream"
+}
+
+// streamAfter reads the stream data following dict, if the "stream" keyword
+// comes next. It returns nil when the object is a plain dictionary.
+func (p *objectParser) streamAfter(dict pdfDict) (*pdfStream, error) {
+	if len(p.peeked) > 0 {
+		return nil, nil
+	}
+	save := p.lx.pos
+	if !p.atStreamKeyword() {
+		p.lx.pos = save
+		return nil, nil
+	}
+
+	data := p.lx.data
+	pos := p.lx.pos
+	if pos < len(data) && data[pos] == '\r' {
+		pos++
+	}
+	if pos < len(data) && data[pos] == '\n' {
+		pos++
+	}
+
+	length := -1
+	if p.reader != nil {
+		if v, err := p.reader.resolve(dict["Length"]); err == nil {
+			if n, ok := v.(int); ok {
+				length = n
+			}
+		}
+	} else if n, ok := dict["Length"].(int); ok {
+		length = n
+	}
+	end := pos + length
+	if length < 0 || end > len(data) || !bytes.HasPrefix(bytes.TrimLeft(data[end:], "\r\n "), []byte("endstream")) {
+		// The declared length is missing or wrong; fall back to scanning.
+		idx := bytes.Index(data[pos:], []byte("endstream"))
+		if idx < 0 {
+			return nil, fmt.Errorf("%w: unterminated stream", errMalformedPDF)
+		}
+		end = pos + idx
+		for end > pos && (data[end-1] == '\n' || data[end-1] == '\r') {
+			end--
+		}
+	}
+	p.lx.pos = end
+	return &pdfStream{Dict: dict, Data: data[pos:end]}, nil
+}
+
+// objectImporter copies objects out of a pdfReader into writer objects,
+// turning references into shared *pdfIndirect values. Each source object is
+// copied once, so cycles such as /Parent links are preserved.
+type objectImporter struct {
+	r    *pdfReader
+	refs map[int]*pdfIndirect
+
+	// dedup, when set, maps the hash of streams that reference no other
+	// objects, such as font files and images, to the object imported first,
+	// so that copies imported from different files are written once.
+	dedup map[[32]byte]*pdfIndirect
+}
+
+func newObjectImporter(r *pdfReader) *objectImporter {
+	return &objectImporter{r: r, refs: make(map[int]*pdfIndirect)}
+}
+
+// indirect returns the imported indirect object for ref.
+func (im *objectImporter) indirect(ref pdfRef) (*pdfIndirect, error) {
+	if ind, ok := im.refs[ref.Num]; ok {
+		return ind, nil
+	}
+	ind := newIndirect(nil)
+	im.refs[ref.Num] = ind
+
+	obj, err := im.r.object(ref.Num)
+	if err != nil {
+		return nil, err
+	}
+	value, err := im.value(obj)
+	if err != nil {
+		return nil, err
+	}
+	ind.Value = value
+	if stream, ok := value.(*pdfStream); ok && im.dedup != nil && !hasReferences(stream.Dict) {
+		key := streamHash(stream)
+		if existing, ok := im.dedup[key]; ok {
+			im.refs[ref.Num] = existing
+			return existing, nil
+		}
+		im.dedup[key] = ind
+	}
+	return ind, nil
+}
+
+// hasReferences reports whether obj refers to an indirect object.
+func hasReferences(obj pdfObject) bool {
+	switch v := obj.(type) {
+	case *pdfIndirect, *pdfStream, pdfRef:
+		return true
+	case pdfArray:
+		return slices.ContainsFunc(v, hasReferences)
+	case pdfDict:
+		for _, item := range v {
+			if hasReferences(item) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// streamHash identifies a stream by its serialized dictionary and data.
+func streamHash(stream *pdfStream) [32]byte {
+	h := sha256.New()
+	h.Write(newObjectWriter(io.Discard).appendValue(nil, stream.Dict))
+	h.Write(stream.Data)
+	var sum [32]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+// value deep-copies a direct object, importing everything it references.
+func (im *objectImporter) value(obj pdfObject) (pdfObject, error) {
+	switch v := obj.(type) {
+	case pdfRef:
+		return im.indirect(v)
+	case pdfArray:
+		out := make(pdfArray, len(v))
+		for i, item := range v {
+			copied, err := im.value(item)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = copied
+		}
+		return out, nil
+	case pdfDict:
+		out := make(pdfDict, len(v))
+		for k, item := range v {
+			copied, err := im.value(item)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = copied
+		}
+		return out, nil
+	case *pdfStream:
+		dict, err := im.value(v.Dict)
+		if err != nil {
+			return nil, err
+		}
+		return &pdfStream{Dict: dict.(pdfDict), Data: v.Data}, nil
+	}
+	return obj, nil
+}
+
+// indirectDict returns the value of ind as a dictionary, or nil.
+func indirectDict(ind *pdfIndirect) pdfDict {
+	if ind == nil {
+		return nil
+	}
+	switch v := ind.Value.(type) {
+	case pdfDict:
+		return v
+	case *pdfStream:
+		return v.Dict
+	}
+	return nil
+}
+
+// decodeStream returns the decoded data of a stream. Only FlateDecode is
+// supported, with or without a PNG predictor, which covers everything this
+// package writes and the cross-reference and object streams of other
+// writers.
+func decodeStream(s *pdfStream) ([]byte, error) {
+	var filters, params pdfArray
+	switch f := s.Dict["Filter"].(type) {
+	case nil:
+		return s.Data, nil
+	case pdfName:
+		filters = pdfArray{f}
+	case pdfArray:
+		filters = f
+	}
+	switch p := s.Dict["DecodeParms"].(type) {
+	case pdfDict:
+		params = pdfArray{p}
+	case pdfArray:
+		params = p
+	}
+
+	data := s.Data
+	for i, f := range filters {
+		if f != pdfName("FlateDecode") {
+			return nil, fmt.Errorf("pdf: unsupported stream filter %v", f)
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("pdf: invalid Flate stream: %w", err)
+		}
+		decoded, err := io.ReadAll(zr)
+		_ = zr.Close()
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("pdf: invalid Flate stream: %w", err)
+		}
+		data = decoded
+		if i < len(params) {
+			parms, _ := params[i].(pdfDict)
+			if data, err = unpredict(data, parms); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return data, nil
+}
+
+// unpredict reverses the PNG predictor named in the decode parameters of a
+// Flate stream.
+func unpredict(data []byte, parms pdfDict) ([]byte, error) {
+	param := func(key pdfName, def int) int {
+		if v, ok := parms[key].(int); ok {
+			return v
+		}
+		return def
+	}
+	predictor := param("Predictor", 1)
+	if predictor == 1 {
+		return data, nil
+	}
+	if predictor < 10 {
+		return nil, fmt.Errorf("pdf: unsupported predictor %d", predictor)
+	}
+	bits := param("Colors", 1) * param("BitsPerComponent", 8)
+	bpp := max(1, bits/8)
+	rowLen := (bits*param("Columns", 1) + 7) / 8
+	if rowLen <= 0 {
+		return nil, fmt.Errorf("%w: invalid predictor columns", errMalformedPDF)
+	}
+
+	out := make([]byte, 0, len(data)/(rowLen+1)*rowLen)
+	prev := make([]byte, rowLen)
+	for len(data) > rowLen {
+		filter := data[0]
+		row := append([]byte(nil), data[1:rowLen+1]...)
+		data = data[rowLen+1:]
+		for i := range row {
+			var left, upLeft int
+			if i >= bpp {
+				left, upLeft = int(row[i-bpp]), int(prev[i-bpp])
+			}
+			up := int(prev[i])
+			switch filter {
+			case 0:
+			case 1:
+				row[i] += byte(left)
+			case 2:
+				row[i] += byte(up)
+			case 3:
+				row[i] += byte((left + up) / 2)
+			case 4:
+				row[i] += byte(paeth(left, up, upLeft))
+			default:
+				return nil, fmt.Errorf("%w: invalid PNG filter type %d", errMalformedPDF, filter)
+			}
+		}
+		out = append(out, row...)
+		prev = row
+	}
+	return out, nil
+}
+
+// paeth returns whichever of a, b, and c is closest to a + b - c.
+func paeth(a, b, c int) int {
+	p := a + b - c
+	pa, pb, pc := abs(p-a), abs(p-b), abs(p-c)
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	}
+	return c
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
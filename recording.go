@@ -0,0 +1,996 @@
+package pdf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"time"
+
+	"github.com/gogpu/gg"
+	"github.com/gogpu/gg/recording"
+)
+
+// pieceInfoKey is the page-piece dictionary that holds the source
+// recording of a page.
+const pieceInfoKey pdfName = "GGRecording"
+
+// recordingMagic starts the serialized form of a recording, followed by
+// its format version.
+const (
+	recordingMagic   = "GGREC"
+	recordingVersion = 1
+)
+
+// errMalformedRecording is reported for embedded recordings that cannot be
+// decoded.
+var errMalformedRecording = errors.New("pdf: malformed embedded recording")
+
+// EmbedRecording stores rec in the output as the source of the backend's
+// page, so that SourcePDF.Recording can extract it again for editing. The
+// recording is kept in a private page-piece dictionary that viewers
+// ignore; nil removes it. Call it after playing rec back, since it is not
+// reset by Begin. Tiling patterns the recording paints are stored with it,
+// and writing fails if a pattern's content paints the pattern itself.
+func (b *Backend) EmbedRecording(rec *recording.Recording) {
+	b.source = rec
+}
+
+// Recording returns the recording embedded in page i, indexed from zero,
+// of a file written with EmbedRecording or SetEmbedRecordings. It returns
+// nil if the page has none. Pattern brushes in the recording paint no
+// tiling pattern; use Document.ImportRecording to play it into a document.
+func (s *SourcePDF) Recording(i int) (*recording.Recording, error) {
+	rec, _, err := s.recording(i)
+	return rec, err
+}
+
+// ImportRecording returns the recording embedded in page i of src, as
+// SourcePDF.Recording does, with its tiling patterns registered with the
+// document, so that playing it back reproduces the page and a report can
+// be modified and exported again:
+//
+//	src, _ := pdf.OpenPDFFile("report.pdf")
+//	doc := pdf.NewDocument()
+//	rec, _ := doc.ImportRecording(src, 0)
+//	doc.SetEmbedRecordings(true)
+//	_ = doc.Playback(rec)
+func (d *Document) ImportRecording(src *SourcePDF, i int) (*recording.Recording, error) {
+	return src.importRecording(i, d.shared)
+}
+
+// ImportRecording returns the recording embedded in page i of src with its
+// tiling patterns registered for the backend's document. See
+// Document.ImportRecording.
+func (b *Backend) ImportRecording(src *SourcePDF, i int) (*recording.Recording, error) {
+	return src.importRecording(i, b.shared)
+}
+
+func (s *SourcePDF) importRecording(i int, shared *sharedResources) (*recording.Recording, error) {
+	rec, patterns, err := s.recording(i)
+	if err != nil || rec == nil {
+		return nil, err
+	}
+	shared.mu.Lock()
+	defer shared.mu.Unlock()
+	for brush, p := range patterns {
+		shared.addPattern(brush, p)
+	}
+	return rec, nil
+}
+
+// recording decodes the recording embedded in page i and the tiling
+// patterns its brushes paint.
+func (s *SourcePDF) recording(i int) (*recording.Recording, map[*recording.PatternBrush]TilingPattern, error) {
+	if i < 0 || i >= len(s.pages) {
+		return nil, nil, fmt.Errorf("pdf: page %d out of range [0, %d)", i, len(s.pages))
+	}
+	pieces, err := s.r.resolveDict(s.pages[i].dict["PieceInfo"])
+	if err != nil {
+		return nil, nil, err
+	}
+	piece, err := s.r.resolveDict(pieces[pieceInfoKey])
+	if err != nil || piece == nil {
+		return nil, nil, err
+	}
+	obj, err := s.r.resolve(piece["Private"])
+	if err != nil {
+		return nil, nil, err
+	}
+	stream, ok := obj.(*pdfStream)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: page %d has no recording stream", errMalformedRecording, i+1)
+	}
+	data, err := decodeStream(stream)
+	if err != nil {
+		return nil, nil, err
+	}
+	return decodeRecording(data)
+}
+
+// embedRecording adds rec, with the tiling patterns registered with shared
+// that it paints, to the page-piece dictionary of page.
+func embedRecording(page *pdfIndirect, rec *recording.Recording, shared *sharedResources, modified time.Time) error {
+	data, err := encodeRecording(rec, shared)
+	if err != nil {
+		return err
+	}
+	date := pdfString(formatPDFDate(modified))
+	dict := indirectDict(page)
+	pieces, _ := dict["PieceInfo"].(pdfDict)
+	if pieces == nil {
+		pieces = pdfDict{}
+	}
+	pieces[pieceInfoKey] = pdfDict{
+		"LastModified": date,
+		"Private":      flateStream(nil, data),
+	}
+	dict["PieceInfo"] = pieces
+	dict["LastModified"] = date
+	return nil
+}
+
+// Tags of the serialized path elements, brushes, and images.
+const (
+	elementMoveTo byte = iota
+	elementLineTo
+	elementQuadTo
+	elementCubicTo
+	elementClose
+)
+
+const (
+	brushSolid byte = iota
+	brushLinear
+	brushRadial
+	brushSweep
+	brushPattern
+)
+
+const (
+	imageNil byte = iota
+	imageRGBA
+	imageNRGBA
+	imageGray
+	imageAlpha
+	imageRGBA64
+	imageNRGBA64
+	imageGray16
+	imageCMYK
+	imageProfiled
+)
+
+// Kinds of pattern brush: one the backend does not know, written as is,
+// or a registered tiling pattern, written with its definition.
+const (
+	patternPlain byte = iota
+	patternImage
+	patternContent
+)
+
+// maxRecordingDepth limits the nesting of tiling patterns drawn from
+// recordings in an embedded recording.
+const maxRecordingDepth = 32
+
+// recordingEncoder serializes a recording: its size, its resource pool,
+// and its commands, with resources referenced by their pool index.
+type recordingEncoder struct {
+	buf    []byte
+	shared *sharedResources
+	err    error
+
+	// open are the pattern brushes whose content is being written.
+	open map[*recording.PatternBrush]bool
+}
+
+// encodeRecording returns the serialized form of rec. Font faces in the
+// resource pool are left out; no command refers to them. Tiling patterns
+// registered with shared are written with the brushes that paint them.
+func encodeRecording(rec *recording.Recording, shared *sharedResources) ([]byte, error) {
+	e := &recordingEncoder{
+		buf:    append([]byte(recordingMagic), recordingVersion),
+		shared: shared,
+		open:   make(map[*recording.PatternBrush]bool),
+	}
+	e.recording(rec)
+	if e.err != nil {
+		return nil, e.err
+	}
+	return e.buf, nil
+}
+
+func (e *recordingEncoder) recording(rec *recording.Recording) {
+	e.uvarint(uint64(rec.Width()))
+	e.uvarint(uint64(rec.Height()))
+
+	pool := rec.Resources()
+	e.uvarint(uint64(pool.PathCount()))
+	for i := 0; i < pool.PathCount(); i++ {
+		e.path(pool.GetPath(recording.PathRef(i)))
+	}
+	e.uvarint(uint64(pool.BrushCount()))
+	for i := 0; i < pool.BrushCount(); i++ {
+		e.brush(pool.GetBrush(recording.BrushRef(i)))
+	}
+	e.uvarint(uint64(pool.ImageCount()))
+	for i := 0; i < pool.ImageCount(); i++ {
+		e.image(pool.GetImage(recording.ImageRef(i)))
+	}
+
+	e.uvarint(uint64(len(rec.Commands())))
+	for _, cmd := range rec.Commands() {
+		e.command(cmd)
+	}
+}
+
+func (e *recordingEncoder) uvarint(v uint64) {
+	e.buf = binary.AppendUvarint(e.buf, v)
+}
+
+func (e *recordingEncoder) float(v ...float64) {
+	for _, f := range v {
+		e.buf = binary.LittleEndian.AppendUint64(e.buf, math.Float64bits(f))
+	}
+}
+
+func (e *recordingEncoder) text(s string) {
+	e.uvarint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *recordingEncoder) rect(r recording.Rect) {
+	e.float(r.MinX, r.MinY, r.MaxX, r.MaxY)
+}
+
+func (e *recordingEncoder) color(c gg.RGBA) {
+	e.float(c.R, c.G, c.B, c.A)
+}
+
+// floats writes a slice that may be nil, keeping nil and empty apart.
+func (e *recordingEncoder) floats(v []float64) {
+	if v == nil {
+		e.uvarint(0)
+		return
+	}
+	e.uvarint(uint64(len(v)) + 1)
+	e.float(v...)
+}
+
+func (e *recordingEncoder) path(p *gg.Path) {
+	if p == nil {
+		e.uvarint(0)
+		return
+	}
+	elements := p.Elements()
+	e.uvarint(uint64(len(elements)) + 1)
+	for _, el := range elements {
+		switch el := el.(type) {
+		case gg.MoveTo:
+			e.buf = append(e.buf, elementMoveTo)
+			e.float(el.Point.X, el.Point.Y)
+		case gg.LineTo:
+			e.buf = append(e.buf, elementLineTo)
+			e.float(el.Point.X, el.Point.Y)
+		case gg.QuadTo:
+			e.buf = append(e.buf, elementQuadTo)
+			e.float(el.Control.X, el.Control.Y, el.Point.X, el.Point.Y)
+		case gg.CubicTo:
+			e.buf = append(e.buf, elementCubicTo)
+			e.float(el.Control1.X, el.Control1.Y, el.Control2.X, el.Control2.Y, el.Point.X, el.Point.Y)
+		case gg.Close:
+			e.buf = append(e.buf, elementClose)
+		}
+	}
+}
+
+// brush writes a brush with its kind and whether it is held by pointer,
+// which backends distinguish.
+func (e *recordingEncoder) brush(b recording.Brush) {
+	tag := func(kind byte, pointer bool) {
+		if pointer {
+			kind |= 0x80
+		}
+		e.buf = append(e.buf, kind)
+	}
+	switch b := b.(type) {
+	case recording.SolidBrush:
+		tag(brushSolid, false)
+		e.color(b.Color)
+	case *recording.SolidBrush:
+		tag(brushSolid, true)
+		e.color(b.Color)
+	case recording.LinearGradientBrush:
+		tag(brushLinear, false)
+		e.linear(&b)
+	case *recording.LinearGradientBrush:
+		tag(brushLinear, true)
+		e.linear(b)
+	case recording.RadialGradientBrush:
+		tag(brushRadial, false)
+		e.radial(&b)
+	case *recording.RadialGradientBrush:
+		tag(brushRadial, true)
+		e.radial(b)
+	case recording.SweepGradientBrush:
+		tag(brushSweep, false)
+		e.sweep(&b)
+	case *recording.SweepGradientBrush:
+		tag(brushSweep, true)
+		e.sweep(b)
+	case recording.PatternBrush:
+		tag(brushPattern, false)
+		e.pattern(&b, nil)
+	case *recording.PatternBrush:
+		tag(brushPattern, true)
+		e.pattern(b, e.shared.tilingPattern(b))
+	default:
+		// Nil, or a brush type added to gg later: kept as black.
+		tag(brushSolid, false)
+		e.color(gg.Black)
+	}
+}
+
+func (e *recordingEncoder) linear(b *recording.LinearGradientBrush) {
+	e.float(b.Start.X, b.Start.Y, b.End.X, b.End.Y)
+	e.stops(b.Stops, b.Extend)
+}
+
+func (e *recordingEncoder) radial(b *recording.RadialGradientBrush) {
+	e.float(b.Center.X, b.Center.Y, b.Focus.X, b.Focus.Y, b.StartRadius, b.EndRadius)
+	e.stops(b.Stops, b.Extend)
+}
+
+func (e *recordingEncoder) sweep(b *recording.SweepGradientBrush) {
+	e.float(b.Center.X, b.Center.Y, b.StartAngle, b.EndAngle)
+	e.stops(b.Stops, b.Extend)
+}
+
+func (e *recordingEncoder) stops(stops []recording.GradientStop, extend recording.ExtendMode) {
+	e.uvarint(uint64(len(stops)))
+	for _, s := range stops {
+		e.float(s.Offset)
+		e.color(s.Color)
+	}
+	e.uvarint(uint64(extend))
+}
+
+// pattern writes a pattern brush and, if it paints the tiling pattern p,
+// the pattern's definition.
+func (e *recordingEncoder) pattern(b *recording.PatternBrush, p *tilingPattern) {
+	e.uvarint(uint64(b.Image))
+	e.uvarint(uint64(b.Repeat))
+	m := b.Transform
+	e.float(m.A, m.B, m.C, m.D, m.E, m.F)
+	switch {
+	case p == nil:
+		e.buf = append(e.buf, patternPlain)
+		return
+	case p.Image != nil:
+		e.buf = append(e.buf, patternImage)
+	default:
+		e.buf = append(e.buf, patternContent)
+	}
+	e.rect(p.Cell)
+	e.float(p.XStep, p.YStep)
+	e.float(p.Matrix.A, p.Matrix.B, p.Matrix.C, p.Matrix.D, p.Matrix.E, p.Matrix.F)
+	e.uvarint(uint64(p.Tiling))
+	if p.Image != nil {
+		e.image(p.Image)
+		return
+	}
+	if e.open[b] || len(e.open) >= maxRecordingDepth {
+		if e.err == nil {
+			e.err = errors.New("pdf: cannot embed a recording whose tiling patterns paint themselves")
+		}
+		return
+	}
+	e.open[b] = true
+	e.recording(p.Content)
+	delete(e.open, b)
+}
+
+// image writes the pixels of img in its own color model for the common
+// image types, and the profile of a ProfiledImage with its image; other
+// types are converted to 16-bit NRGBA, which keeps their colors exactly.
+func (e *recordingEncoder) image(img image.Image) {
+	if img == nil {
+		e.buf = append(e.buf, imageNil)
+		return
+	}
+	if p, ok := img.(*ProfiledImage); ok {
+		e.buf = append(e.buf, imageProfiled)
+		e.text(string(p.Profile))
+		e.image(p.Image)
+		return
+	}
+	var (
+		kind   byte
+		pix    []byte
+		stride int
+		size   int // bytes per pixel
+	)
+	switch m := img.(type) {
+	case *image.RGBA:
+		kind, pix, stride, size = imageRGBA, m.Pix, m.Stride, 4
+	case *image.NRGBA:
+		kind, pix, stride, size = imageNRGBA, m.Pix, m.Stride, 4
+	case *image.Gray:
+		kind, pix, stride, size = imageGray, m.Pix, m.Stride, 1
+	case *image.Alpha:
+		kind, pix, stride, size = imageAlpha, m.Pix, m.Stride, 1
+	case *image.RGBA64:
+		kind, pix, stride, size = imageRGBA64, m.Pix, m.Stride, 8
+	case *image.NRGBA64:
+		kind, pix, stride, size = imageNRGBA64, m.Pix, m.Stride, 8
+	case *image.Gray16:
+		kind, pix, stride, size = imageGray16, m.Pix, m.Stride, 2
+	case *image.CMYK:
+		kind, pix, stride, size = imageCMYK, m.Pix, m.Stride, 4
+	default:
+		converted := image.NewNRGBA64(img.Bounds())
+		draw.Draw(converted, converted.Rect, img, img.Bounds().Min, draw.Src)
+		kind, pix, stride, size = imageNRGBA64, converted.Pix, converted.Stride, 8
+	}
+	r := img.Bounds()
+	e.buf = append(e.buf, kind)
+	e.buf = binary.AppendVarint(e.buf, int64(r.Min.X))
+	e.buf = binary.AppendVarint(e.buf, int64(r.Min.Y))
+	e.uvarint(uint64(r.Dx()))
+	e.uvarint(uint64(r.Dy()))
+	row := r.Dx() * size
+	for y := 0; y < r.Dy(); y++ {
+		e.buf = append(e.buf, pix[y*stride:y*stride+row]...)
+	}
+}
+
+func (e *recordingEncoder) stroke(s recording.Stroke) {
+	e.float(s.Width)
+	e.buf = append(e.buf, byte(s.Cap), byte(s.Join))
+	e.float(s.MiterLimit)
+	e.floats(s.DashPattern)
+	e.float(s.DashOffset)
+}
+
+func (e *recordingEncoder) command(cmd recording.Command) {
+	e.buf = append(e.buf, byte(cmd.Type()))
+	switch c := cmd.(type) {
+	case recording.SetTransformCommand:
+		m := c.Matrix
+		e.float(m.A, m.B, m.C, m.D, m.E, m.F)
+	case recording.SetClipCommand:
+		e.uvarint(uint64(c.Path))
+		e.buf = append(e.buf, byte(c.Rule))
+	case recording.FillPathCommand:
+		e.uvarint(uint64(c.Path))
+		e.uvarint(uint64(c.Brush))
+		e.buf = append(e.buf, byte(c.Rule))
+	case recording.StrokePathCommand:
+		e.uvarint(uint64(c.Path))
+		e.uvarint(uint64(c.Brush))
+		e.stroke(c.Stroke)
+	case recording.FillRectCommand:
+		e.rect(c.Rect)
+		e.uvarint(uint64(c.Brush))
+	case recording.StrokeRectCommand:
+		e.rect(c.Rect)
+		e.uvarint(uint64(c.Brush))
+		e.stroke(c.Stroke)
+	case recording.DrawImageCommand:
+		e.uvarint(uint64(c.Image))
+		e.rect(c.SrcRect)
+		e.rect(c.DstRect)
+		e.buf = append(e.buf, byte(c.Options.Interpolation))
+		e.float(c.Options.Alpha)
+	case recording.DrawTextCommand:
+		e.text(c.Text)
+		e.float(c.X, c.Y, c.FontSize)
+		e.text(c.FontFamily)
+		e.uvarint(uint64(c.Brush))
+	case recording.SetFillStyleCommand:
+		e.uvarint(uint64(c.Brush))
+	case recording.SetStrokeStyleCommand:
+		e.uvarint(uint64(c.Brush))
+	case recording.SetLineWidthCommand:
+		e.float(c.Width)
+	case recording.SetLineCapCommand:
+		e.buf = append(e.buf, byte(c.Cap))
+	case recording.SetLineJoinCommand:
+		e.buf = append(e.buf, byte(c.Join))
+	case recording.SetMiterLimitCommand:
+		e.float(c.Limit)
+	case recording.SetDashCommand:
+		e.floats(c.Pattern)
+		e.float(c.Offset)
+	case recording.SetFillRuleCommand:
+		e.buf = append(e.buf, byte(c.Rule))
+	}
+}
+
+// recordingDecoder reads a serialized recording. The first error is kept
+// and ends decoding; later reads return zero values.
+type recordingDecoder struct {
+	data []byte
+	err  error
+
+	// Sizes of the resource pool, to check references against.
+	paths, brushes, images uint64
+
+	// depth is the nesting of the recording being read.
+	depth int
+
+	// patterns are the tiling patterns read with pattern brushes.
+	patterns map[*recording.PatternBrush]TilingPattern
+}
+
+// decodeRecording rebuilds a recording from its serialized form, with the
+// tiling patterns its pattern brushes paint.
+func decodeRecording(data []byte) (*recording.Recording, map[*recording.PatternBrush]TilingPattern, error) {
+	if len(data) < len(recordingMagic)+1 || string(data[:len(recordingMagic)]) != recordingMagic {
+		return nil, nil, fmt.Errorf("%w: missing header", errMalformedRecording)
+	}
+	if v := data[len(recordingMagic)]; v != recordingVersion {
+		return nil, nil, fmt.Errorf("pdf: unsupported embedded recording version %d", v)
+	}
+	d := &recordingDecoder{
+		data:     data[len(recordingMagic)+1:],
+		patterns: make(map[*recording.PatternBrush]TilingPattern),
+	}
+	rec := d.recording()
+	if d.err == nil && len(d.data) != 0 {
+		d.fail("%d trailing bytes", len(d.data))
+	}
+	if d.err != nil {
+		return nil, nil, d.err
+	}
+	return rec, d.patterns, nil
+}
+
+// recording reads a recording, at the top level or as the content of a
+// tiling pattern.
+func (d *recordingDecoder) recording() *recording.Recording {
+	if d.depth++; d.depth > maxRecordingDepth {
+		d.fail("tiling patterns nested deeper than %d", maxRecordingDepth)
+		return nil
+	}
+	defer func(paths, brushes, images uint64) {
+		d.paths, d.brushes, d.images = paths, brushes, images
+		d.depth--
+	}(d.paths, d.brushes, d.images)
+
+	width, height := d.dimension(), d.dimension()
+
+	var (
+		paths   []*gg.Path
+		brushes []recording.Brush
+		images  []image.Image
+	)
+	d.paths = d.count()
+	for i := uint64(0); i < d.paths && d.err == nil; i++ {
+		paths = append(paths, d.path())
+	}
+	d.brushes = d.count()
+	for i := uint64(0); i < d.brushes && d.err == nil; i++ {
+		brushes = append(brushes, d.brush())
+	}
+	d.images = d.count()
+	for i := uint64(0); i < d.images && d.err == nil; i++ {
+		images = append(images, d.image())
+	}
+	n := d.count()
+	commands := make([]recording.Command, 0, min(n, uint64(len(d.data))))
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		commands = append(commands, d.command())
+	}
+	if d.err != nil {
+		return nil
+	}
+	rec, err := newRecording(width, height, commands, paths, brushes, images)
+	if err != nil {
+		d.err = err
+	}
+	return rec
+}
+
+// newRecording assembles a recording from its commands and the resources
+// they reference, in pool order.
+func newRecording(width, height int, commands []recording.Command, paths []*gg.Path, brushes []recording.Brush, images []image.Image) (*recording.Recording, error) {
+	// Only a recorder creates recordings, and its drawing methods transform
+	// their geometry, so they cannot record these commands as they are.
+	// The recorder records one placeholder per command, and the commands
+	// of the finished recording are then set in place. The result is
+	// checked, so that a recorder that copies its commands is reported
+	// instead of producing a recording of placeholders.
+	rec := recording.NewRecorder(width, height)
+	for range commands {
+		rec.ResetClip()
+	}
+	out := rec.FinishRecording()
+	copy(out.Commands(), commands)
+	got := out.Commands()
+	if len(got) != len(commands) {
+		return nil, errors.New("pdf: cannot create a recording of the commands")
+	}
+	for i, cmd := range commands {
+		if got[i].Type() != cmd.Type() {
+			return nil, errors.New("pdf: cannot create a recording of the commands")
+		}
+	}
+
+	pool := out.Resources()
+	for _, p := range paths {
+		pool.AddPath(p)
+	}
+	for _, b := range brushes {
+		pool.AddBrush(b)
+	}
+	for _, img := range images {
+		pool.AddImage(img)
+	}
+	return out, nil
+}
+
+func (d *recordingDecoder) fail(format string, args ...any) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: "+format, append([]any{errMalformedRecording}, args...)...)
+	}
+	d.data = nil
+}
+
+func (d *recordingDecoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.fail("bad integer")
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *recordingDecoder) varint() int64 {
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.fail("bad integer")
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+// count reads a number of items that each take at least one byte, so that
+// corrupt input cannot request huge allocations.
+func (d *recordingDecoder) count() uint64 {
+	v := d.uvarint()
+	if v > uint64(len(d.data)) {
+		d.fail("count %d exceeds the data", v)
+		return 0
+	}
+	return v
+}
+
+// dimension reads a width or height.
+func (d *recordingDecoder) dimension() int {
+	v := d.uvarint()
+	if v > math.MaxInt32 {
+		d.fail("dimension %d out of range", v)
+		return 0
+	}
+	return int(v)
+}
+
+func (d *recordingDecoder) readByte() byte {
+	if len(d.data) < 1 {
+		d.fail("unexpected end")
+		return 0
+	}
+	b := d.data[0]
+	d.data = d.data[1:]
+	return b
+}
+
+func (d *recordingDecoder) take(n uint64) []byte {
+	if n > uint64(len(d.data)) {
+		d.fail("unexpected end")
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *recordingDecoder) float() float64 {
+	b := d.take(8)
+	if b == nil {
+		return 0
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b))
+}
+
+func (d *recordingDecoder) point() gg.Point {
+	return gg.Point{X: d.float(), Y: d.float()}
+}
+
+func (d *recordingDecoder) rect() recording.Rect {
+	return recording.Rect{MinX: d.float(), MinY: d.float(), MaxX: d.float(), MaxY: d.float()}
+}
+
+func (d *recordingDecoder) color() gg.RGBA {
+	return gg.RGBA{R: d.float(), G: d.float(), B: d.float(), A: d.float()}
+}
+
+func (d *recordingDecoder) floats() []float64 {
+	n := d.uvarint()
+	if n == 0 {
+		return nil
+	}
+	if n-1 > uint64(len(d.data))/8 {
+		d.fail("unexpected end")
+		return nil
+	}
+	v := make([]float64, n-1)
+	for i := range v {
+		v[i] = d.float()
+	}
+	return v
+}
+
+func (d *recordingDecoder) text() string {
+	return string(d.take(d.uvarint()))
+}
+
+func (d *recordingDecoder) path() *gg.Path {
+	n := d.uvarint()
+	if n == 0 {
+		return nil
+	}
+	p := gg.NewPath()
+	for i := uint64(1); i < n && d.err == nil; i++ {
+		switch tag := d.readByte(); tag {
+		case elementMoveTo:
+			pt := d.point()
+			p.MoveTo(pt.X, pt.Y)
+		case elementLineTo:
+			pt := d.point()
+			p.LineTo(pt.X, pt.Y)
+		case elementQuadTo:
+			c, pt := d.point(), d.point()
+			p.QuadraticTo(c.X, c.Y, pt.X, pt.Y)
+		case elementCubicTo:
+			c1, c2, pt := d.point(), d.point(), d.point()
+			p.CubicTo(c1.X, c1.Y, c2.X, c2.Y, pt.X, pt.Y)
+		case elementClose:
+			p.Close()
+		default:
+			d.fail("unknown path element %d", tag)
+		}
+	}
+	return p
+}
+
+func (d *recordingDecoder) stops() ([]recording.GradientStop, recording.ExtendMode) {
+	n := d.count()
+	var stops []recording.GradientStop
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		stops = append(stops, recording.GradientStop{Offset: d.float(), Color: d.color()})
+	}
+	return stops, recording.ExtendMode(d.uvarint())
+}
+
+func (d *recordingDecoder) brush() recording.Brush {
+	tag := d.readByte()
+	pointer := tag&0x80 != 0
+	switch tag &^ 0x80 {
+	case brushSolid:
+		b := recording.SolidBrush{Color: d.color()}
+		if pointer {
+			return &b
+		}
+		return b
+	case brushLinear:
+		b := &recording.LinearGradientBrush{Start: d.point(), End: d.point()}
+		b.Stops, b.Extend = d.stops()
+		if !pointer {
+			return *b
+		}
+		return b
+	case brushRadial:
+		b := &recording.RadialGradientBrush{Center: d.point(), Focus: d.point(), StartRadius: d.float(), EndRadius: d.float()}
+		b.Stops, b.Extend = d.stops()
+		if !pointer {
+			return *b
+		}
+		return b
+	case brushSweep:
+		b := &recording.SweepGradientBrush{Center: d.point(), StartAngle: d.float(), EndAngle: d.float()}
+		b.Stops, b.Extend = d.stops()
+		if !pointer {
+			return *b
+		}
+		return b
+	case brushPattern:
+		b := &recording.PatternBrush{Image: recording.ImageRef(d.uvarint()), Repeat: recording.RepeatMode(d.uvarint())}
+		b.Transform = gg.Matrix{A: d.float(), B: d.float(), C: d.float(), D: d.float(), E: d.float(), F: d.float()}
+		if kind := d.readByte(); kind != patternPlain {
+			if !pointer {
+				d.fail("tiling pattern brush not held by pointer")
+				return nil
+			}
+			d.tilingPattern(b, kind)
+		}
+		if !pointer {
+			return *b
+		}
+		return b
+	default:
+		d.fail("unknown brush %d", tag)
+		return nil
+	}
+}
+
+// tilingPattern reads the definition of the tiling pattern brush paints.
+func (d *recordingDecoder) tilingPattern(brush *recording.PatternBrush, kind byte) {
+	p := TilingPattern{Cell: d.rect(), XStep: d.float(), YStep: d.float()}
+	p.Matrix = recording.Matrix{A: d.float(), B: d.float(), C: d.float(), D: d.float(), E: d.float(), F: d.float()}
+	p.Tiling = TilingType(d.uvarint())
+	switch kind {
+	case patternImage:
+		p.Image = d.image()
+	case patternContent:
+		p.Content = d.recording()
+	default:
+		d.fail("unknown pattern brush %d", kind)
+	}
+	if d.err != nil {
+		return
+	}
+	p, err := p.normalize()
+	if err != nil {
+		d.fail("%v", err)
+		return
+	}
+	d.patterns[brush] = p
+}
+
+func (d *recordingDecoder) image() image.Image {
+	kind := d.readByte()
+	if kind == imageNil || d.err != nil {
+		return nil
+	}
+	if kind == imageProfiled {
+		profile := []byte(d.text())
+		return &ProfiledImage{Image: d.image(), Profile: profile}
+	}
+	x, y := int(d.varint()), int(d.varint())
+	w, h := d.dimension(), d.dimension()
+	if d.err != nil {
+		return nil
+	}
+	if x > math.MaxInt-w || y > math.MaxInt-h {
+		d.fail("image origin %d,%d out of range", x, y)
+		return nil
+	}
+	r := image.Rect(x, y, x+w, y+h)
+	var (
+		img image.Image
+		pix []byte
+	)
+	size := map[byte]int{
+		imageRGBA: 4, imageNRGBA: 4, imageGray: 1, imageAlpha: 1,
+		imageRGBA64: 8, imageNRGBA64: 8, imageGray16: 2, imageCMYK: 4,
+	}[kind]
+	if size == 0 {
+		d.fail("unknown image type %d", kind)
+		return nil
+	}
+	if w != 0 && h > len(d.data)/(w*size) {
+		d.fail("unexpected end")
+		return nil
+	}
+	switch kind {
+	case imageRGBA:
+		m := image.NewRGBA(r)
+		img, pix = m, m.Pix
+	case imageNRGBA:
+		m := image.NewNRGBA(r)
+		img, pix = m, m.Pix
+	case imageGray:
+		m := image.NewGray(r)
+		img, pix = m, m.Pix
+	case imageAlpha:
+		m := image.NewAlpha(r)
+		img, pix = m, m.Pix
+	case imageRGBA64:
+		m := image.NewRGBA64(r)
+		img, pix = m, m.Pix
+	case imageNRGBA64:
+		m := image.NewNRGBA64(r)
+		img, pix = m, m.Pix
+	case imageGray16:
+		m := image.NewGray16(r)
+		img, pix = m, m.Pix
+	case imageCMYK:
+		m := image.NewCMYK(r)
+		img, pix = m, m.Pix
+	}
+	copy(pix, d.take(uint64(w*h*size)))
+	return img
+}
+
+// ref reads a resource reference and checks it against the pool size.
+func (d *recordingDecoder) ref(size uint64, kind string) uint32 {
+	v := d.uvarint()
+	if v >= size {
+		d.fail("%s reference %d out of range", kind, v)
+		return 0
+	}
+	return uint32(v)
+}
+
+func (d *recordingDecoder) pathRef() recording.PathRef {
+	return recording.PathRef(d.ref(d.paths, "path"))
+}
+
+func (d *recordingDecoder) brushRef() recording.BrushRef {
+	return recording.BrushRef(d.ref(d.brushes, "brush"))
+}
+
+func (d *recordingDecoder) stroke() recording.Stroke {
+	s := recording.Stroke{Width: d.float(), Cap: recording.LineCap(d.readByte()), Join: recording.LineJoin(d.readByte())}
+	s.MiterLimit = d.float()
+	s.DashPattern = d.floats()
+	s.DashOffset = d.float()
+	return s
+}
+
+func (d *recordingDecoder) command() recording.Command {
+	switch t := recording.CommandType(d.readByte()); t {
+	case recording.CmdSave:
+		return recording.SaveCommand{}
+	case recording.CmdRestore:
+		return recording.RestoreCommand{}
+	case recording.CmdSetTransform:
+		return recording.SetTransformCommand{Matrix: recording.Matrix{
+			A: d.float(), B: d.float(), C: d.float(), D: d.float(), E: d.float(), F: d.float(),
+		}}
+	case recording.CmdSetClip:
+		return recording.SetClipCommand{Path: d.pathRef(), Rule: recording.FillRule(d.readByte())}
+	case recording.CmdClearClip:
+		return recording.ClearClipCommand{}
+	case recording.CmdFillPath:
+		return recording.FillPathCommand{Path: d.pathRef(), Brush: d.brushRef(), Rule: recording.FillRule(d.readByte())}
+	case recording.CmdStrokePath:
+		return recording.StrokePathCommand{Path: d.pathRef(), Brush: d.brushRef(), Stroke: d.stroke()}
+	case recording.CmdFillRect:
+		return recording.FillRectCommand{Rect: d.rect(), Brush: d.brushRef()}
+	case recording.CmdStrokeRect:
+		return recording.StrokeRectCommand{Rect: d.rect(), Brush: d.brushRef(), Stroke: d.stroke()}
+	case recording.CmdDrawImage:
+		c := recording.DrawImageCommand{Image: recording.ImageRef(d.ref(d.images, "image")), SrcRect: d.rect(), DstRect: d.rect()}
+		c.Options = recording.ImageOptions{Interpolation: recording.InterpolationMode(d.readByte()), Alpha: d.float()}
+		return c
+	case recording.CmdDrawText:
+		return recording.DrawTextCommand{
+			Text: d.text(), X: d.float(), Y: d.float(), FontSize: d.float(), FontFamily: d.text(), Brush: d.brushRef(),
+		}
+	case recording.CmdSetFillStyle:
+		return recording.SetFillStyleCommand{Brush: d.brushRef()}
+	case recording.CmdSetStrokeStyle:
+		return recording.SetStrokeStyleCommand{Brush: d.brushRef()}
+	case recording.CmdSetLineWidth:
+		return recording.SetLineWidthCommand{Width: d.float()}
+	case recording.CmdSetLineCap:
+		return recording.SetLineCapCommand{Cap: recording.LineCap(d.readByte())}
+	case recording.CmdSetLineJoin:
+		return recording.SetLineJoinCommand{Join: recording.LineJoin(d.readByte())}
+	case recording.CmdSetMiterLimit:
+		return recording.SetMiterLimitCommand{Limit: d.float()}
+	case recording.CmdSetDash:
+		return recording.SetDashCommand{Pattern: d.floats(), Offset: d.float()}
+	case recording.CmdSetFillRule:
+		return recording.SetFillRuleCommand{Rule: recording.FillRule(d.readByte())}
+	default:
+		d.fail("unknown command %d", t)
+		return nil
+	}
+}
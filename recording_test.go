@@ -0,0 +1,351 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gogpu/gg"
+	"github.com/gogpu/gg/recording"
+)
+
+// chartRecording returns a recording that uses every kind of command and
+// resource that can be embedded.
+func chartRecording() *recording.Recording {
+	rec := recording.NewRecorder(200, 100)
+	rec.SetFillRGB(1, 1, 1)
+	rec.Clear()
+
+	rec.Save()
+	rec.Translate(20, 10)
+	rec.DrawRectangle(0, 0, 160, 80)
+	rec.Clip()
+	grad := recording.NewLinearGradientBrush(0, 0, 160, 0).
+		AddColorStop(0, gg.Blue).
+		AddColorStop(1, gg.RGBA{R: 0, G: 0.5, B: 1, A: 0.5})
+	rec.SetFillStyle(grad)
+	rec.DrawRoundedRectangle(10, 10, 40, 60, 5)
+	rec.Fill()
+	rec.SetFillRuleGG(gg.FillRuleEvenOdd)
+	rec.SetFillStyle(recording.NewRadialGradientBrush(90, 40, 0, 20).SetFocus(85, 35))
+	rec.DrawCircle(90, 40, 20)
+	rec.Fill()
+	rec.Restore()
+
+	rec.SetStrokeRGBA(1, 0, 0, 0.8)
+	rec.SetLineWidth(2)
+	rec.SetLineCap(recording.LineCapRound)
+	rec.SetLineJoin(recording.LineJoinBevel)
+	rec.SetMiterLimit(8)
+	rec.SetDash(4, 2)
+	rec.SetDashOffset(1)
+	rec.MoveTo(20, 90)
+	rec.QuadraticTo(60, 60, 100, 90)
+	rec.Stroke()
+	rec.ClearDash()
+	rec.StrokeRectangle(5, 5, 190, 90)
+	rec.ResetClip()
+
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(0, 0, color.NRGBA{R: 255, A: 128})
+	img.Set(1, 1, color.NRGBA{B: 255, A: 255})
+	rec.DrawImageScaled(img, 150, 10, 20, 20)
+	rec.DrawImage(image.NewGray16(image.Rect(1, 1, 3, 2)), 150, 40)
+
+	rec.SetFontFamily("Go")
+	rec.SetFontSize(9)
+	rec.SetFillRGB(0, 0, 0)
+	rec.DrawString("Q3: 135", 120, 80)
+	return rec.FinishRecording()
+}
+
+// recordingsEqual reports whether a and b have the same size, commands,
+// and resources.
+func recordingsEqual(t *testing.T, a, b *recording.Recording) {
+	t.Helper()
+
+	if a.Width() != b.Width() || a.Height() != b.Height() {
+		t.Fatalf("size %dx%d, want %dx%d", b.Width(), b.Height(), a.Width(), a.Height())
+	}
+	if !reflect.DeepEqual(a.Commands(), b.Commands()) {
+		t.Errorf("commands = %#v\nwant %#v", b.Commands(), a.Commands())
+	}
+	pa, pb := a.Resources(), b.Resources()
+	if pa.PathCount() != pb.PathCount() || pa.BrushCount() != pb.BrushCount() || pa.ImageCount() != pb.ImageCount() {
+		t.Fatalf("resource counts differ: %d/%d/%d paths/brushes/images, want %d/%d/%d",
+			pb.PathCount(), pb.BrushCount(), pb.ImageCount(), pa.PathCount(), pa.BrushCount(), pa.ImageCount())
+	}
+	for i := 0; i < pa.PathCount(); i++ {
+		ref := recording.PathRef(i)
+		if !reflect.DeepEqual(pa.GetPath(ref).Elements(), pb.GetPath(ref).Elements()) {
+			t.Errorf("path %d = %v, want %v", i, pb.GetPath(ref).Elements(), pa.GetPath(ref).Elements())
+		}
+	}
+	for i := 0; i < pa.BrushCount(); i++ {
+		ref := recording.BrushRef(i)
+		if !reflect.DeepEqual(pa.GetBrush(ref), pb.GetBrush(ref)) {
+			t.Errorf("brush %d = %#v, want %#v", i, pb.GetBrush(ref), pa.GetBrush(ref))
+		}
+	}
+	for i := 0; i < pa.ImageCount(); i++ {
+		ref := recording.ImageRef(i)
+		if !reflect.DeepEqual(pa.GetImage(ref), pb.GetImage(ref)) {
+			t.Errorf("image %d = %#v, want %#v", i, pb.GetImage(ref), pa.GetImage(ref))
+		}
+	}
+}
+
+func TestRecordingRoundTrip(t *testing.T) {
+	rec := chartRecording()
+	doc := NewDocument()
+	doc.SetEmbedRecordings(true)
+	if err := doc.Playback(rec); err != nil {
+		t.Fatalf("Playback failed: %v", err)
+	}
+	doc.NewPage(100, 100)
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	src, err := OpenPDF(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("OpenPDF failed: %v", err)
+	}
+	if page := src.pages[0].dict; page["LastModified"] == nil {
+		t.Errorf("page with a page-piece dictionary has no /LastModified: %v", page)
+	}
+	got, err := src.Recording(0)
+	if err != nil || got == nil {
+		t.Fatalf("Recording(0) = %v, %v", got, err)
+	}
+	recordingsEqual(t, rec, got)
+	if other, err := src.Recording(1); other != nil || err != nil {
+		t.Errorf("Recording(1) = %v, %v, want none for a page drawn directly", other, err)
+	}
+	if _, err := src.Recording(2); err == nil {
+		t.Error("Recording(2) succeeded for a page out of range")
+	}
+
+	// The extracted recording is exported again unchanged.
+	again := NewDocument()
+	again.SetEmbedRecordings(true)
+	if err := again.Playback(got); err != nil {
+		t.Fatalf("Playback of the extracted recording failed: %v", err)
+	}
+	if !bytes.Equal(mustEncode(t, again.pages[0].source), mustEncode(t, rec)) {
+		t.Error("re-exported recording differs from the original")
+	}
+	if !bytes.Equal(again.pages[0].content.buf.Bytes(), doc.pages[0].content.buf.Bytes()) {
+		t.Error("extracted recording draws a different page")
+	}
+}
+
+func TestRecordingBackend(t *testing.T) {
+	rec := chartRecording()
+	b := NewBackend()
+	if err := rec.Playback(b); err != nil {
+		t.Fatalf("Playback failed: %v", err)
+	}
+	b.EmbedRecording(rec)
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	src, err := OpenPDF(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("OpenPDF failed: %v", err)
+	}
+	got, err := src.Recording(0)
+	if err != nil || got == nil {
+		t.Fatalf("Recording(0) = %v, %v", got, err)
+	}
+	recordingsEqual(t, rec, got)
+}
+
+func TestRecordingMalformed(t *testing.T) {
+	data := mustEncode(t, chartRecording())
+	for n := 0; n < len(data); n++ {
+		if _, _, err := decodeRecording(data[:n]); err == nil {
+			t.Fatalf("decoding %d of %d bytes succeeded", n, len(data))
+		}
+	}
+	if _, _, err := decodeRecording(append(data, 0)); err == nil {
+		t.Error("decoding with a trailing byte succeeded")
+	}
+
+	// A reference past the end of the resource pool.
+	rec := recording.NewRecorder(10, 10)
+	rec.FillRectangle(0, 0, 5, 5)
+	bad := mustEncode(t, rec.FinishRecording())
+	bad[len(bad)-1] = 7
+	if _, _, err := decodeRecording(bad); err == nil {
+		t.Error("decoding a brush reference out of range succeeded")
+	}
+
+	// An image whose bounds overflow.
+	for _, origin := range [][2]int64{{math.MaxInt64 - 5, 0}, {0, math.MaxInt64}} {
+		e := &recordingEncoder{buf: append([]byte(recordingMagic), recordingVersion)}
+		e.uvarint(10)
+		e.uvarint(10)
+		e.uvarint(0)
+		e.uvarint(0)
+		e.uvarint(1)
+		e.buf = append(e.buf, imageRGBA)
+		e.buf = binary.AppendVarint(e.buf, origin[0])
+		e.buf = binary.AppendVarint(e.buf, origin[1])
+		e.uvarint(10)
+		e.uvarint(1)
+		e.buf = append(e.buf, make([]byte, 40)...)
+		e.uvarint(0)
+		if _, _, err := decodeRecording(e.buf); !errors.Is(err, errMalformedRecording) {
+			t.Errorf("decoding an image at %v = %v, want %v", origin, err, errMalformedRecording)
+		}
+	}
+}
+
+// mustEncode returns the serialized form of rec without tiling patterns.
+func mustEncode(t *testing.T, rec *recording.Recording) []byte {
+	t.Helper()
+	data, err := encodeRecording(rec, newSharedResources())
+	if err != nil {
+		t.Fatalf("encodeRecording failed: %v", err)
+	}
+	return data
+}
+
+// reopenRecording writes doc and imports the recording embedded in its
+// first page into into.
+func reopenRecording(t *testing.T, doc, into *Document) *recording.Recording {
+	t.Helper()
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	src, err := OpenPDF(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("OpenPDF failed: %v", err)
+	}
+	rec, err := into.ImportRecording(src, 0)
+	if err != nil || rec == nil {
+		t.Fatalf("ImportRecording(0) = %v, %v", rec, err)
+	}
+	return rec
+}
+
+func TestRecordingTilingPattern(t *testing.T) {
+	doc := NewDocument()
+	doc.SetEmbedRecordings(true)
+	dots := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	dots.Set(0, 0, color.NRGBA{R: 255, A: 255})
+	inner, err := doc.RegisterPattern(TilingPattern{Image: dots, XStep: 4})
+	if err != nil {
+		t.Fatalf("RegisterPattern failed: %v", err)
+	}
+	cell := recording.NewRecorder(8, 8)
+	cell.SetFillStyle(inner)
+	cell.DrawRectangle(0, 0, 8, 4)
+	cell.Fill()
+	outer, err := doc.RegisterPattern(TilingPattern{
+		Content: cell.FinishRecording(),
+		YStep:   10,
+		Matrix:  recording.Rotate(0.5),
+		Tiling:  TilingNoDistortion,
+	})
+	if err != nil {
+		t.Fatalf("RegisterPattern failed: %v", err)
+	}
+	rec := recording.NewRecorder(100, 100)
+	rec.SetFillStyle(outer)
+	rec.DrawRectangle(10, 10, 80, 80)
+	rec.Fill()
+	if err := doc.Playback(rec.FinishRecording()); err != nil {
+		t.Fatalf("Playback failed: %v", err)
+	}
+
+	// The imported recording paints the same patterns in a new document,
+	// where they were never registered.
+	again := NewDocument()
+	if err := again.Playback(reopenRecording(t, doc, again)); err != nil {
+		t.Fatalf("Playback of the extracted recording failed: %v", err)
+	}
+	if got, want := again.pages[0].content.buf.String(), doc.pages[0].content.buf.String(); got != want {
+		t.Errorf("extracted recording draws\n%s\nwant\n%s", got, want)
+	}
+	if !strings.Contains(again.pages[0].content.buf.String(), "/Pattern cs") {
+		t.Error("extracted recording does not fill with the pattern")
+	}
+	painted := 0
+	for _, p := range again.shared.patterns {
+		if p.cell != nil {
+			painted++
+		}
+	}
+	if painted != 2 {
+		t.Errorf("extracted recording paints %d patterns, want 2", painted)
+	}
+
+	// A recording imported into one document paints no pattern in another.
+	other := NewDocument()
+	if err := other.Playback(reopenRecording(t, doc, NewDocument())); err != nil {
+		t.Fatalf("Playback of the extracted recording failed: %v", err)
+	}
+	if strings.Contains(other.pages[0].content.buf.String(), "/Pattern cs") {
+		t.Error("recording imported into another document fills with the pattern")
+	}
+}
+
+func TestRecordingSelfPaintingPattern(t *testing.T) {
+	b := NewBackend()
+	cell := recording.NewRecorder(4, 4)
+	cell.DrawRectangle(0, 0, 2, 2)
+	cell.Fill()
+	brush, err := b.RegisterPattern(TilingPattern{Content: cell.FinishRecording()})
+	if err != nil {
+		t.Fatalf("RegisterPattern failed: %v", err)
+	}
+	// Make the cell paint its own pattern, which cannot be serialized.
+	p := b.shared.patterns[brush]
+	loop := recording.NewRecorder(4, 4)
+	loop.SetFillStyle(brush)
+	loop.DrawRectangle(0, 0, 2, 2)
+	loop.Fill()
+	p.Content = loop.FinishRecording()
+	if _, err := encodeRecording(p.Content, b.shared); err == nil {
+		t.Error("encoding a pattern that paints itself succeeded")
+	}
+}
+
+func TestRecordingImageColors(t *testing.T) {
+	profile := testICCProfile("GRAY", "XYZ ", nil)
+	gray := image.NewGray(image.Rect(0, 0, 2, 1))
+	gray.Pix[1] = 200
+	cmyk := image.NewCMYK(image.Rect(0, 0, 1, 1))
+	cmyk.Pix = []byte{10, 20, 30, 40}
+
+	rec := recording.NewRecorder(20, 10)
+	rec.DrawImage(&ProfiledImage{Image: gray, Profile: profile}, 0, 0)
+	rec.DrawImage(cmyk, 10, 0)
+	data := mustEncode(t, rec.FinishRecording())
+	got, _, err := decodeRecording(data)
+	if err != nil {
+		t.Fatalf("decodeRecording failed: %v", err)
+	}
+	pool := got.Resources()
+	if pool.ImageCount() != 2 {
+		t.Fatalf("decoded %d images, want 2", pool.ImageCount())
+	}
+	p, ok := pool.GetImage(0).(*ProfiledImage)
+	if !ok || !bytes.Equal(p.Profile, profile) || !reflect.DeepEqual(p.Image, gray) {
+		t.Errorf("decoded %#v, want the profiled gray image", pool.GetImage(0))
+	}
+	if c, ok := pool.GetImage(1).(*image.CMYK); !ok || !reflect.DeepEqual(c, cmyk) {
+		t.Errorf("decoded %T, want the CMYK image", pool.GetImage(1))
+	}
+}
@@ -1,7 +1,7 @@
 // Package pdf provides a PDF export backend for gg's recording system.
 //
 // This package registers a "pdf" backend that can be used to export
-// recorded drawing operations to PDF format using the gxpdf library.
+// recorded drawing operations to PDF format.
 //
 // # Usage
 //
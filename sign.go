@@ -0,0 +1,416 @@
+package pdf
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gogpu/gg/recording"
+)
+
+// defaultSignatureSize is the space reserved for a signature: enough for
+// a certificate chain of a few certificates and a timestamp token.
+const defaultSignatureSize = 16 << 10
+
+// Signature describes the digital signature applied to the output. The
+// file is signed as a whole when it is written, with a detached CMS
+// signature (adbe.pkcs7.detached) over everything but the signature
+// itself.
+type Signature struct {
+	// Signer signs with the private key of the first certificate. RSA and
+	// ECDSA keys are supported; hardware tokens can be used through their
+	// crypto.Signer.
+	Signer crypto.Signer
+
+	// Certificates are the signing certificate followed by its chain,
+	// which viewers use to validate the signature.
+	Certificates []*x509.Certificate
+
+	// Field is the name of the signature field; empty uses "Signature1".
+	Field string
+
+	// Name, Reason, Location, and ContactInfo are shown by viewers in the
+	// signature details.
+	Name, Reason, Location, ContactInfo string
+
+	// Time is the signing time; the zero value uses the time of writing.
+	Time time.Time
+
+	// Rect places a visible signature on its page, in gg coordinates. An
+	// empty Rect makes the signature invisible.
+	Rect recording.Rect
+
+	// Appearance is drawn scaled to fill Rect. Without it, a visible
+	// signature is an empty box that viewers decorate themselves.
+	Appearance *recording.Recording
+
+	// Timestamp, if set, returns a DER-encoded RFC 3161 TimeStampToken
+	// for the signature value, typically from a timestamping authority.
+	// It is added as an unsigned attribute, proving that the signature
+	// existed at the time stamped.
+	Timestamp func(signature []byte) ([]byte, error)
+
+	// Size is the number of bytes reserved for the signature; zero
+	// reserves 16 KiB. Writing fails if the signature does not fit.
+	Size int
+}
+
+// signatureField is a signature together with the index of its page.
+type signatureField struct {
+	Signature
+	page int
+}
+
+// validate checks that the signer matches the certificate.
+func (s Signature) validate() error {
+	if s.Signer == nil || len(s.Certificates) == 0 {
+		return errors.New("pdf: signature needs a signer and its certificate")
+	}
+	pub, ok := s.Signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(s.Certificates[0].PublicKey) {
+		return errors.New("pdf: signer does not match the signing certificate")
+	}
+	switch s.Signer.Public().(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+	default:
+		return fmt.Errorf("pdf: unsupported signing key %T", s.Signer.Public())
+	}
+	if strings.Contains(s.Field, ".") {
+		return fmt.Errorf("pdf: signature field name %q contains a period", s.Field)
+	}
+	if s.Size < 0 {
+		return fmt.Errorf("pdf: invalid signature size %d", s.Size)
+	}
+	return nil
+}
+
+// SetSignature signs the output of WriteTo and SaveToFile, with the
+// signature field on the backend's page. Every write is signed anew, so the
+// signature covers whatever is drawn after SetSignature is called:
+//
+//	_ = b.SetSignature(pdf.Signature{
+//		Signer:       key,
+//		Certificates: []*x509.Certificate{cert, intermediate},
+//		Reason:       "Approved",
+//	})
+func (b *Backend) SetSignature(s Signature) error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+	b.shared.signature = &signatureField{Signature: s}
+	return nil
+}
+
+// pdfPlaceholder is a value written as fixed-width text and patched once
+// the file is complete. The writer records the file offset of the text.
+type pdfPlaceholder struct {
+	text   string
+	offset int64
+}
+
+// signer completes the signature of a written file.
+type signer struct {
+	sig       Signature
+	time      time.Time
+	byteRange *pdfPlaceholder
+	contents  *pdfPlaceholder
+}
+
+// writeSignature adds the signature field and its dictionary to out. The
+// dictionary holds placeholders for the signed byte range and the
+// signature, which the returned signer fills in.
+func writeSignature(out *outputFile, pages []*Backend, sf *signatureField) (*signer, error) {
+	if sf.page >= len(pages) {
+		return nil, fmt.Errorf("pdf: signature page %d out of range [0, %d)", sf.page, len(pages))
+	}
+	b := pages[sf.page]
+	visible := !sf.Rect.IsEmpty()
+	if visible && !rectContains(recording.NewRect(0, 0, b.width, b.height), sf.Rect) {
+		return nil, fmt.Errorf("pdf: signature rectangle %v is outside the page", sf.Rect)
+	}
+
+	size := sf.Size
+	if size == 0 {
+		size = defaultSignatureSize
+	}
+	s := &signer{
+		sig:       sf.Signature,
+		time:      sf.Time,
+		byteRange: &pdfPlaceholder{text: "[0 0 0 0" + strings.Repeat(" ", 30) + "]"},
+		contents:  &pdfPlaceholder{text: "<" + strings.Repeat("0", 2*size) + ">"},
+	}
+	if s.time.IsZero() {
+		s.time = time.Now()
+	}
+
+	dict := pdfDict{
+		"Type":      pdfName("Sig"),
+		"Filter":    pdfName("Adobe.PPKLite"),
+		"SubFilter": pdfName("adbe.pkcs7.detached"),
+		"ByteRange": s.byteRange,
+		"Contents":  s.contents,
+		"M":         pdfString(formatPDFDate(s.time)),
+	}
+	for key, value := range map[pdfName]string{
+		"Name":        sf.Name,
+		"Reason":      sf.Reason,
+		"Location":    sf.Location,
+		"ContactInfo": sf.ContactInfo,
+	} {
+		if value != "" {
+			dict[key] = textString(value)
+		}
+	}
+
+	rect := rectArray(0, 0, 0, 0)
+	appearance := pdfDict{
+		"Type":      pdfName("XObject"),
+		"Subtype":   pdfName("Form"),
+		"BBox":      rectArray(0, 0, 0, 0),
+		"Resources": pdfDict{},
+	}
+	var content []byte
+	if visible {
+		r := sf.Rect
+		rect = pageRect(r, b.height)
+		appearance["BBox"] = rectArray(0, 0, r.Width(), r.Height())
+	}
+	if visible && sf.Appearance != nil {
+		// The recording is drawn in gg coordinates; viewers scale its
+		// flipped bounding box to fill the rectangle.
+		rec := sf.Appearance
+		w, h := float64(rec.Width()), float64(rec.Height())
+		captured := b.captureContent(func() { b.playInto(rec) })
+		if b.err != nil {
+			return nil, b.err
+		}
+		appearance["BBox"] = rectArray(0, 0, w, h)
+		appearance["Matrix"] = pdfArray{1.0, 0.0, 0.0, -1.0, 0.0, h}
+		appearance["Resources"] = captured.res.dict()
+		content = captured.buf.Bytes()
+	}
+
+	name := sf.Field
+	if name == "" {
+		name = "Signature1"
+	}
+	form, _ := out.catalog["AcroForm"].(pdfDict)
+	if form == nil {
+		form = pdfDict{}
+		out.catalog["AcroForm"] = form
+	}
+	fields, _ := form["Fields"].(pdfArray)
+	for _, f := range fields {
+		if indirectDict(f.(*pdfIndirect))["T"] == textString(name) {
+			return nil, fmt.Errorf("pdf: signature field name %q is used by a form field", name)
+		}
+	}
+
+	page := out.pages[sf.page]
+	field := newIndirect(pdfDict{
+		"Type":    pdfName("Annot"),
+		"Subtype": pdfName("Widget"),
+		"FT":      pdfName("Sig"),
+		"T":       textString(name),
+		"V":       newIndirect(dict),
+		"F":       4, // print
+		"Rect":    rect,
+		"P":       page,
+		"AP":      pdfDict{"N": flateStream(appearance, content)},
+	})
+	addAnnotation(page, field)
+
+	// SignaturesExist and AppendOnly: viewers must save changes as
+	// incremental updates, which keep the signature valid.
+	form["Fields"] = append(fields, field)
+	form["SigFlags"] = 3
+	return s, nil
+}
+
+// sign fills in the byte range of data, the written file, and signs it.
+func (s *signer) sign(data []byte) error {
+	start := s.contents.offset
+	end := start + int64(len(s.contents.text))
+	byteRange := fmt.Sprintf("[0 %d %d %d", start, end, int64(len(data))-end)
+	copy(data[s.byteRange.offset:], byteRange)
+
+	h := sha256.New()
+	h.Write(data[:start])
+	h.Write(data[end:])
+	der, err := s.cms(h.Sum(nil))
+	if err != nil {
+		return err
+	}
+	if 2*len(der) > len(s.contents.text)-2 {
+		return fmt.Errorf("pdf: signature needs %d bytes, but only %d are reserved", len(der), len(s.contents.text)/2-1)
+	}
+	hex.Encode(data[start+1:], der)
+	return nil
+}
+
+// Object identifiers of the CMS structures in a signature.
+var (
+	oidData             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidTimeStampToken   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 14}
+	oidSHA256           = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRSAEncryption    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidECDSAWithSHA256  = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	contextSpecificZero = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true}
+)
+
+// contentInfo is the CMS ContentInfo of RFC 5652 wrapping signed data.
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+// signedData is the CMS SignedData of a detached signature.
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo encapContentInfo
+	Certificates     asn1.RawValue `asn1:"optional"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+// encapContentInfo is the type of the signed content, which is detached.
+type encapContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+}
+
+// signerInfo is the signature of one signer.
+type signerInfo struct {
+	Version            int
+	SID                issuerAndSerial
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional"`
+}
+
+// issuerAndSerial identifies the signing certificate.
+type issuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+// attribute is a signed or unsigned attribute of a signer.
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// cms returns the DER-encoded CMS signature of the file with the given
+// SHA-256 digest.
+func (s *signer) cms(digest []byte) ([]byte, error) {
+	cert := s.sig.Certificates[0]
+	signingTime, err := asn1.Marshal(s.time.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("pdf: invalid signing time: %w", err)
+	}
+	digestValue, _ := asn1.Marshal(digest)
+	contentType, _ := asn1.Marshal(oidData)
+	signedAttrs, err := attributeSet([]attribute{
+		{Type: oidContentType, Values: []asn1.RawValue{{FullBytes: contentType}}},
+		{Type: oidSigningTime, Values: []asn1.RawValue{{FullBytes: signingTime}}},
+		{Type: oidMessageDigest, Values: []asn1.RawValue{{FullBytes: digestValue}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The signature covers the attributes encoded as a SET, although they
+	// are stored with an implicit [0] tag.
+	set, _ := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: signedAttrs})
+	sum := sha256.Sum256(set)
+	value, err := s.sig.Signer.Sign(rand.Reader, sum[:], crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("pdf: failed to sign: %w", err)
+	}
+
+	info := signerInfo{
+		Version:         1,
+		SID:             issuerAndSerial{Issuer: asn1.RawValue{FullBytes: cert.RawIssuer}, Serial: cert.SerialNumber},
+		DigestAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
+		SignedAttrs:     withBytes(contextSpecificZero, signedAttrs),
+		Signature:       value,
+	}
+	if _, ok := s.sig.Signer.Public().(*rsa.PublicKey); ok {
+		info.SignatureAlgorithm = pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}
+	} else {
+		info.SignatureAlgorithm = pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256}
+	}
+
+	if s.sig.Timestamp != nil {
+		token, err := s.sig.Timestamp(value)
+		if err != nil {
+			return nil, fmt.Errorf("pdf: failed to timestamp the signature: %w", err)
+		}
+		var raw asn1.RawValue
+		if rest, err := asn1.Unmarshal(token, &raw); err != nil || len(rest) > 0 {
+			return nil, errors.New("pdf: timestamp token is not a single DER value")
+		}
+		unsigned, err := attributeSet([]attribute{
+			{Type: oidTimeStampToken, Values: []asn1.RawValue{{FullBytes: token}}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		info.UnsignedAttrs = withBytes(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true}, unsigned)
+	}
+
+	var certs []byte
+	for _, c := range s.sig.Certificates {
+		certs = append(certs, c.Raw...)
+	}
+	sd, err := asn1.Marshal(signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: oidSHA256}},
+		EncapContentInfo: encapContentInfo{ContentType: oidData},
+		Certificates:     withBytes(contextSpecificZero, certs),
+		SignerInfos:      []signerInfo{info},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pdf: failed to encode signature: %w", err)
+	}
+	return asn1.Marshal(contentInfo{ContentType: oidSignedData, Content: withBytes(contextSpecificZero, sd)})
+}
+
+// attributeSet returns the DER encoding of the contents of a SET OF
+// attributes, which DER requires to be sorted by their encoding.
+func attributeSet(attrs []attribute) ([]byte, error) {
+	encoded := make([][]byte, len(attrs))
+	for i, a := range attrs {
+		der, err := asn1.Marshal(a)
+		if err != nil {
+			return nil, fmt.Errorf("pdf: failed to encode signature attribute: %w", err)
+		}
+		encoded[i] = der
+	}
+	slices.SortFunc(encoded, bytes.Compare)
+	return bytes.Join(encoded, nil), nil
+}
+
+// withBytes returns the tag of v holding the given contents.
+func withBytes(v asn1.RawValue, contents []byte) asn1.RawValue {
+	v.Bytes = contents
+	return v
+}
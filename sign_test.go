@@ -0,0 +1,277 @@
+package pdf
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gogpu/gg"
+	"github.com/gogpu/gg/recording"
+)
+
+// testCertificate returns a self-signed certificate for key.
+func testCertificate(t *testing.T, key crypto.Signer) *x509.Certificate {
+	t.Helper()
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "Contracts"},
+		NotBefore:    time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2036, 1, 1, 0, 0, 0, 0, time.UTC),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	return cert
+}
+
+// verifySignature checks the signature of a written file and returns its
+// signature dictionary and signer info.
+func verifySignature(t *testing.T, data []byte) (pdfDict, signerInfo) {
+	t.Helper()
+
+	r, catalog := readOutput(t, data)
+	form, _ := r.resolveDict(catalog["AcroForm"])
+	fields, _ := form["Fields"].(pdfArray)
+	if len(fields) != 1 || form["SigFlags"] != 3 {
+		t.Fatalf("/AcroForm = %v, want one signature field", form)
+	}
+	field, _ := r.resolveDict(fields[0])
+	sig, _ := r.resolveDict(field["V"])
+
+	br, _ := sig["ByteRange"].(pdfArray)
+	var ranges [4]int
+	for i := range ranges {
+		ranges[i], _ = br[i].(int)
+	}
+	if ranges[0] != 0 || data[ranges[1]] != '<' || data[ranges[2]-1] != '>' || ranges[2]+ranges[3] != len(data) {
+		t.Fatalf("/ByteRange %v does not cover the file except /Contents", br)
+	}
+	signed := append(append([]byte(nil), data[:ranges[1]]...), data[ranges[2]:]...)
+
+	contents, _ := sig["Contents"].(pdfString)
+	var ci contentInfo
+	if _, err := asn1.Unmarshal([]byte(contents), &ci); err != nil || !ci.ContentType.Equal(oidSignedData) {
+		t.Fatalf("/Contents is not CMS signed data: %v", err)
+	}
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil || len(sd.SignerInfos) != 1 {
+		t.Fatalf("failed to parse signed data: %v", err)
+	}
+	cert, err := x509.ParseCertificate(sd.Certificates.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse signing certificate: %v", err)
+	}
+	info := sd.SignerInfos[0]
+	if info.SID.Serial.Cmp(cert.SerialNumber) != 0 {
+		t.Errorf("signer serial %v, want %v", info.SID.Serial, cert.SerialNumber)
+	}
+
+	rest := info.SignedAttrs.Bytes
+	var digest []byte
+	for len(rest) > 0 {
+		var a attribute
+		if rest, err = asn1.Unmarshal(rest, &a); err != nil {
+			t.Fatalf("failed to parse signed attribute: %v", err)
+		}
+		if a.Type.Equal(oidMessageDigest) {
+			_, _ = asn1.Unmarshal(a.Values[0].FullBytes, &digest)
+		}
+	}
+	if sum := sha256.Sum256(signed); !bytes.Equal(digest, sum[:]) {
+		t.Fatal("message digest does not match the signed byte range")
+	}
+
+	set, _ := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: info.SignedAttrs.Bytes})
+	sum := sha256.Sum256(set)
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		err = rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], info.Signature)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, sum[:], info.Signature) {
+			err = errors.New("ECDSA verification failed")
+		}
+	}
+	if err != nil {
+		t.Fatalf("signature does not verify: %v", err)
+	}
+	return sig, info
+}
+
+func TestSignature(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		key     crypto.Signer
+		encrypt bool
+	}{
+		{"RSA", rsaKey, false},
+		{"ECDSA", ecKey, false},
+		{"ECDSA encrypted", ecKey, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := NewDocument()
+			page := doc.NewPage(200, 100)
+			page.FillRect(recording.NewRect(10, 10, 20, 20), recording.NewSolidBrush(gg.Blue))
+			if tt.encrypt {
+				if err := doc.SetEncryption(Encryption{}); err != nil {
+					t.Fatalf("SetEncryption failed: %v", err)
+				}
+			}
+			if err := doc.SetSignature(0, Signature{
+				Signer:       tt.key,
+				Certificates: []*x509.Certificate{testCertificate(t, tt.key)},
+				Name:         "Contracts",
+				Reason:       "Approved",
+				Time:         time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC),
+			}); err != nil {
+				t.Fatalf("SetSignature failed: %v", err)
+			}
+			var buf bytes.Buffer
+			if _, err := doc.WriteTo(&buf); err != nil {
+				t.Fatalf("WriteTo failed: %v", err)
+			}
+
+			sig, info := verifySignature(t, buf.Bytes())
+			if sig["SubFilter"] != pdfName("adbe.pkcs7.detached") {
+				t.Errorf("/SubFilter = %v, want adbe.pkcs7.detached", sig["SubFilter"])
+			}
+			if !tt.encrypt && (sig["Reason"] != pdfString("Approved") || sig["M"] != pdfString("D:20261018093000Z")) {
+				t.Errorf("signature dictionary = %v, want the reason and signing time", sig)
+			}
+			if len(info.UnsignedAttrs.Bytes) != 0 {
+				t.Error("signature without a timestamp hook has unsigned attributes")
+			}
+		})
+	}
+}
+
+func TestSignatureAppearance(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rec := recording.NewRecorder(120, 40)
+	rec.SetFillRGB(0, 0, 0)
+	rec.DrawRectangle(0, 0, 120, 40)
+	rec.Fill()
+
+	var stamped []byte
+	b := NewBackend()
+	if err := b.SetSignature(Signature{
+		Signer:       key,
+		Certificates: []*x509.Certificate{testCertificate(t, key)},
+		Field:        "Approval",
+		Rect:         recording.NewRect(10, 20, 60, 20),
+		Appearance:   rec.FinishRecording(),
+		Timestamp: func(signature []byte) ([]byte, error) {
+			stamped = signature
+			return asn1.Marshal(contentInfo{ContentType: oidSignedData, Content: withBytes(contextSpecificZero, []byte{0x30, 0})})
+		},
+	}); err != nil {
+		t.Fatalf("SetSignature failed: %v", err)
+	}
+	if err := b.Begin(100, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.End(); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	_, info := verifySignature(t, buf.Bytes())
+	if !bytes.Equal(stamped, info.Signature) {
+		t.Error("timestamp hook did not receive the signature value")
+	}
+	var a attribute
+	if _, err := asn1.Unmarshal(info.UnsignedAttrs.Bytes, &a); err != nil || !a.Type.Equal(oidTimeStampToken) {
+		t.Errorf("unsigned attributes do not hold the timestamp token: %v", err)
+	}
+
+	r, catalog := readOutput(t, buf.Bytes())
+	pagesRoot, _ := r.resolveDict(catalog["Pages"])
+	kids, _ := pagesRoot["Kids"].(pdfArray)
+	page, _ := r.resolveDict(kids[0])
+	annots, _ := page["Annots"].(pdfArray)
+	if len(annots) != 1 {
+		t.Fatalf("page /Annots = %v, want the signature widget", page["Annots"])
+	}
+	widget, _ := r.resolveDict(annots[0])
+	rect, _ := widget["Rect"].(pdfArray)
+	if widget["T"] != pdfString("Approval") || formatArray(rect) != "[10.00 60.00 70.00 80.00]" {
+		t.Errorf("widget = %v, want the Approval field at [10 60 70 80]", widget)
+	}
+	ap, _ := widget["AP"].(pdfDict)
+	obj, _ := r.resolve(ap["N"])
+	form, _ := obj.(*pdfStream)
+	data, err := decodeStream(form)
+	if err != nil {
+		t.Fatalf("failed to decode appearance: %v", err)
+	}
+	matrix, _ := form.Dict["Matrix"].(pdfArray)
+	if !strings.Contains(string(data), "120 40 l\n") || formatArray(matrix) != "[1.00 0.00 0.00 -1.00 0.00 40.00]" {
+		t.Errorf("appearance %v does not draw the flipped recording:\n%s", form.Dict, data)
+	}
+}
+
+func TestSignatureErrors(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	cert := testCertificate(t, key)
+
+	doc := NewDocument()
+	if err := doc.SetSignature(0, Signature{Signer: key, Certificates: []*x509.Certificate{cert}}); err == nil {
+		t.Error("SetSignature succeeded without pages")
+	}
+	doc.NewPage(100, 100)
+	for _, sig := range []Signature{
+		{Signer: key},
+		{Signer: other, Certificates: []*x509.Certificate{cert}},
+		{Signer: key, Certificates: []*x509.Certificate{cert}, Field: "a.b"},
+	} {
+		if err := doc.SetSignature(0, sig); err == nil {
+			t.Errorf("SetSignature(%+v) succeeded", sig)
+		}
+	}
+
+	for _, sig := range []Signature{
+		{Signer: key, Certificates: []*x509.Certificate{cert}, Size: 16},
+		{Signer: key, Certificates: []*x509.Certificate{cert}, Rect: recording.NewRect(90, 90, 20, 20)},
+		{Signer: key, Certificates: []*x509.Certificate{cert}, Timestamp: func([]byte) ([]byte, error) {
+			return []byte("not DER"), nil
+		}},
+	} {
+		if err := doc.SetSignature(0, sig); err != nil {
+			t.Fatalf("SetSignature failed: %v", err)
+		}
+		if _, err := doc.WriteTo(&bytes.Buffer{}); err == nil {
+			t.Errorf("WriteTo succeeded with signature %+v", sig)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package pdf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// errStreaming is returned by the methods that write a streaming document
+// at once.
+var errStreaming = errors.New("pdf: a streaming document is written while it is drawn; call Close to complete it")
+
+// errStreamSettings reports a change to the settings that apply to the
+// whole file after a streaming document has started writing it.
+var errStreamSettings = errors.New("pdf: conformance, encryption, and color settings of a streaming document must be set before its first page ends")
+
+// errStreamPageBoxes reports a change to the page boxes of pages that a
+// streaming document has already written.
+var errStreamPageBoxes = errors.New("pdf: the page boxes and bleed of a streaming document must be set before the pages they apply to end")
+
+// streamWriter writes the file of a streaming Document while its pages are
+// drawn. The objects of each page are written when the page ends; the page
+// dictionaries, which later parts of the file add entries to, and the
+// objects that are only complete once all pages are known, such as fonts,
+// are written by close.
+type streamWriter struct {
+	// mu serializes the writes of pages that end in parallel.
+	mu  sync.Mutex
+	ow  *objectWriter
+	out *outputFile
+
+	// started is set once the header is written. The conformance level and
+	// encryption in effect then apply to the whole file.
+	started     bool
+	conformance Conformance
+	encryption  *Encryption
+
+	closed bool
+	err    error
+}
+
+// NewStreamingDocument creates a multi-page PDF document that is written to
+// w while it is drawn, for documents too large to hold in memory. The
+// content stream, images, and other objects of a page are written as soon
+// as its backend's End is called, by recording playback or by Finish, and
+// their memory is released. Close completes the file.
+//
+// SetConformance, SetEncryption, SetOutputIntent, SetColorManagement,
+// RegisterSpotColor, and SetBleed apply to the whole file and must be
+// called before the first page ends; SetPageBoxes must be called before
+// its page ends. A streaming document cannot be signed, since the
+// signature covers bytes already passed on, and its WriteTo and SaveToFile
+// fail.
+func NewStreamingDocument(w io.Writer) *Document {
+	d := NewDocument()
+	ow := newObjectWriter(w)
+	ow.discard = true
+	d.stream = &streamWriter{
+		ow:  ow,
+		out: newOutputFile(),
+	}
+	return d
+}
+
+// Close ends the pages not ended yet and completes the file of a streaming
+// document: the page tree, the catalog, fonts and other objects shared by
+// the pages, the cross-reference table, and the trailer. It does not close
+// the underlying writer. Close returns the first error met while writing
+// the document; calling it again returns the same error.
+func (d *Document) Close() error {
+	s := d.stream
+	if s == nil {
+		return errors.New("pdf: document is not streaming; use WriteTo or SaveToFile")
+	}
+	if s.closed {
+		return s.err
+	}
+	if err := d.Finish(); err != nil {
+		s.fail(fmt.Errorf("pdf: failed to finish document: %w", err))
+	}
+	s.closed = true
+	if s.err != nil {
+		return s.err
+	}
+
+	pages := make([]*Backend, len(d.pages))
+	for i, pb := range d.pages {
+		pages[i] = pb.Backend
+	}
+	s.fail(s.close(pages, d.shared, d.meta))
+	return s.err
+}
+
+// checkStreamStarted fails once a streaming document has started writing,
+// for settings that apply to the whole file.
+func (d *Document) checkStreamStarted() error {
+	if d.stream != nil && d.stream.started {
+		return errStreamSettings
+	}
+	return nil
+}
+
+// addPage reserves the dictionary of a new page, in document order.
+func (s *streamWriter) addPage(width, height float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.out.addPage(width, height)
+}
+
+// start writes the file header and fixes the settings that apply to the
+// whole file.
+func (s *streamWriter) start(shared *sharedResources) error {
+	s.started = true
+	s.conformance = shared.conformance
+	s.encryption = shared.encryption
+	if shared.conformance.isPDFX() {
+		// PDF/X-4 is based on PDF 1.6.
+		s.ow.version = "1.6"
+	}
+	if err := s.out.encrypt(shared); err != nil {
+		return err
+	}
+	s.ow.crypt = s.out.crypt
+	s.ow.writeHeader()
+	return s.ow.err
+}
+
+// writePage writes the objects of the page b drew and releases its
+// content. The page dictionary itself is kept until close.
+func (s *streamWriter) writePage(b *pageBackend) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	if !s.started {
+		if err := s.start(b.shared); err != nil {
+			return s.fail(err)
+		}
+	}
+
+	page := s.out.pages[b.index]
+	if err := setPage(page, b.index, b.Backend, b.meta); err != nil {
+		return s.fail(err)
+	}
+	// Fonts are completed with the glyphs of all pages.
+	for _, font := range b.shared.fonts {
+		s.ow.hold(font.obj)
+	}
+	s.ow.appendValue(nil, page.Value)
+	s.ow.flush()
+
+	// Annotation appearances are captured by close into content streams of
+	// their own, so the page's content is no longer needed.
+	b.content.buf = bytes.Buffer{}
+	b.content.res = newResourceSet()
+	b.source = nil
+	return s.fail(s.ow.err)
+}
+
+// close writes what remains of the file once all pages are written.
+func (s *streamWriter) close(pages []*Backend, shared *sharedResources, meta *metadata) error {
+	if !s.started {
+		if err := s.start(shared); err != nil {
+			return err
+		}
+	}
+	if shared.conformance != s.conformance || shared.encryption != s.encryption {
+		return errStreamSettings
+	}
+	if shared.signature != nil {
+		return errors.New("pdf: a streaming document cannot be signed")
+	}
+
+	s.out.linkPages()
+	info, _, err := finishOutput(s.out, pages, shared, meta)
+	if err != nil {
+		return err
+	}
+	s.ow.release()
+	_, err = s.ow.writeTrailer(s.out.root, newIndirect(info))
+	return err
+}
+
+// fail records the first error of the stream and returns it.
+func (s *streamWriter) fail(err error) error {
+	if s.err == nil {
+		s.err = err
+	}
+	return s.err
+}
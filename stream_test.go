@@ -0,0 +1,311 @@
+package pdf
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/gogpu/gg"
+	"github.com/gogpu/gg/recording"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+// drawStatement draws the same three pages on doc, with text in the
+// registered font on the second.
+func drawStatement(t *testing.T, doc *Document) {
+	t.Helper()
+
+	doc.SetTitle("Statements")
+	if err := doc.RegisterFont(goregular.TTF); err != nil {
+		t.Fatalf("RegisterFont failed: %v", err)
+	}
+	if err := doc.Playback(vectorRecording()); err != nil {
+		t.Fatalf("Playback failed: %v", err)
+	}
+	page := doc.NewPage(200, 100)
+	page.DrawText("Balance: 42", 10, 50, goRegularFace(t, 12), recording.NewSolidBrush(gg.Black))
+	if err := page.End(); err != nil {
+		t.Fatalf("End failed: %v", err)
+	}
+	if err := doc.Playback(chartRecording()); err != nil {
+		t.Fatalf("Playback failed: %v", err)
+	}
+}
+
+func TestStreamingDocument(t *testing.T) {
+	var want bytes.Buffer
+	doc := NewDocument()
+	drawStatement(t, doc)
+	if _, err := doc.WriteTo(&want); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	stream := NewStreamingDocument(&buf)
+	if err := stream.Playback(vectorRecording()); err != nil {
+		t.Fatalf("Playback failed: %v", err)
+	}
+	first := stream.pages[0]
+	if buf.Len() == 0 || first.content.buf.Len() != 0 || first.source != nil {
+		t.Errorf("first page is not written and released on End: %d bytes written", buf.Len())
+	}
+
+	buf.Reset()
+	stream = NewStreamingDocument(&buf)
+	drawStatement(t, stream)
+	if err := stream.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if !bytes.HasSuffix(buf.Bytes(), []byte("%%EOF\n")) {
+		t.Error("streamed file does not end with the end-of-file marker")
+	}
+
+	got, ref := openSource(t, buf.Bytes()), openSource(t, want.Bytes())
+	if got.PageCount() != ref.PageCount() {
+		t.Fatalf("streamed file has %d pages, want %d", got.PageCount(), ref.PageCount())
+	}
+	for i := range ref.pages {
+		have, err := got.contentData(got.pages[i].dict["Contents"])
+		if err != nil {
+			t.Fatalf("page %d content: %v", i, err)
+		}
+		wantContent, _ := ref.contentData(ref.pages[i].dict["Contents"])
+		if !bytes.Equal(have, wantContent) {
+			t.Errorf("page %d draws\n%s\nwant\n%s", i, have, wantContent)
+		}
+	}
+
+	// The font is written once all pages are known, with the glyphs of the
+	// second page.
+	resources, _ := got.r.resolveDict(got.pages[1].resources)
+	fonts, _ := got.r.resolveDict(resources["Font"])
+	for _, font := range fonts {
+		dict, err := got.r.resolveDict(font)
+		if err != nil || dict["Subtype"] != pdfName("Type0") {
+			t.Fatalf("streamed font = %v, %v, want a Type0 font", dict, err)
+		}
+		descendants, _ := dict["DescendantFonts"].(pdfArray)
+		cid, _ := got.r.resolveDict(descendants[0])
+		if widths, _ := cid["W"].(pdfArray); len(widths) == 0 {
+			t.Error("streamed font has no widths for the glyphs in use")
+		}
+	}
+	info, _ := got.r.resolveDict(got.r.trailer["Info"])
+	if title, _ := got.textString(info["Title"]); title != "Statements" {
+		t.Errorf("title = %q, want Statements", title)
+	}
+}
+
+func TestStreamingDocumentAnnotationAppearance(t *testing.T) {
+	stamp := recording.NewRecorder(60, 20)
+	stamp.SetFillRGB(0, 0.5, 0)
+	stamp.DrawRoundedRectangle(0, 0, 60, 20, 4)
+	stamp.Fill()
+	appearance := stamp.FinishRecording()
+
+	var want bytes.Buffer
+	doc := NewDocument()
+	page := doc.NewPage(200, 100).(*pageBackend)
+	annotate(t, page, Annotation{Kind: AnnotationStamp, Rect: recording.NewRect(20, 20, 60, 20), Appearance: appearance})
+	if _, err := doc.WriteTo(&want); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	// The appearance is drawn once the page has been written and released.
+	var buf bytes.Buffer
+	stream := NewStreamingDocument(&buf)
+	page = stream.NewPage(200, 100).(*pageBackend)
+	annotate(t, page, Annotation{Kind: AnnotationStamp, Rect: recording.NewRect(20, 20, 60, 20), Appearance: appearance})
+	if err := page.End(); err != nil {
+		t.Fatalf("End failed: %v", err)
+	}
+	if err := stream.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	appearances := func(data []byte) []byte {
+		src := openSource(t, data)
+		annots, _ := src.pages[0].dict["Annots"].(pdfArray)
+		if len(annots) != 1 {
+			t.Fatalf("page has %d annotations, want 1", len(annots))
+		}
+		annot, _ := src.r.resolveDict(annots[0])
+		ap, _ := src.r.resolveDict(annot["AP"])
+		content, err := src.contentData(ap["N"])
+		if err != nil {
+			t.Fatalf("appearance stream: %v", err)
+		}
+		return content
+	}
+	if got, want := appearances(buf.Bytes()), appearances(want.Bytes()); !bytes.Equal(got, want) {
+		t.Errorf("streamed appearance draws\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestStreamingDocumentReleasesImages(t *testing.T) {
+	stream := NewStreamingDocument(&bytes.Buffer{})
+	draw := func(fill uint8) *image.NRGBA {
+		img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+		for i := range img.Pix {
+			img.Pix[i] = fill
+		}
+		page := stream.NewPage(100, 100)
+		src, dst := recording.NewRect(0, 0, 64, 64), recording.NewRect(0, 0, 64, 64)
+		page.DrawImage(img, src, dst, recording.DefaultImageOptions())
+		if err := page.End(); err != nil {
+			t.Fatalf("End failed: %v", err)
+		}
+		return img
+	}
+	released := make(chan struct{})
+	runtime.AddCleanup(draw(1), func(done chan struct{}) { close(done) }, released)
+	draw(1) // the same pixels, written once
+	draw(2)
+
+	// The first image is not kept for reuse once its page is written.
+	deadline := time.After(time.Second)
+wait:
+	for {
+		runtime.GC()
+		select {
+		case <-released:
+			break wait
+		case <-deadline:
+			t.Error("drawn image is still referenced after its page was written")
+			break wait
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if n := len(stream.shared.images); n != 2 {
+		t.Errorf("document has %d images, want 2", n)
+	}
+	if err := stream.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
+
+// failingWriter accepts n bytes and fails after that.
+type failingWriter struct{ n int }
+
+var errWriteFailed = errors.New("disk full")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		n := w.n
+		w.n = 0
+		return n, errWriteFailed
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestStreamingDocumentErrors(t *testing.T) {
+	doc := NewStreamingDocument(&bytes.Buffer{})
+	if err := doc.SetSignature(0, Signature{}); err == nil {
+		t.Error("SetSignature succeeded on a streaming document")
+	}
+	if err := doc.Playback(vectorRecording()); err != nil {
+		t.Fatalf("Playback failed: %v", err)
+	}
+	if err := doc.SetEncryption(Encryption{UserPassword: "late"}); err == nil {
+		t.Error("SetEncryption succeeded after the first page was written")
+	}
+	if err := doc.SetConformance(ConformancePDFA2B); err == nil {
+		t.Error("SetConformance succeeded after the first page was written")
+	}
+	if err := doc.SetOutputIntent(testCMYKProfile(), "FOGRA39"); !errors.Is(err, errStreamSettings) {
+		t.Errorf("SetOutputIntent after the first page was written = %v, want %v", err, errStreamSettings)
+	}
+	if err := doc.SetColorManagement(ColorManagement{Model: ColorModelCMYK}); !errors.Is(err, errStreamSettings) {
+		t.Errorf("SetColorManagement after the first page was written = %v, want %v", err, errStreamSettings)
+	}
+	if err := doc.RegisterSpotColor("Spot", gg.Red, [4]float64{0, 1, 1, 0}); !errors.Is(err, errStreamSettings) {
+		t.Errorf("RegisterSpotColor after the first page was written = %v, want %v", err, errStreamSettings)
+	}
+	if err := doc.SetBleed(9); !errors.Is(err, errStreamPageBoxes) {
+		t.Errorf("SetBleed after the first page was written = %v, want %v", err, errStreamPageBoxes)
+	}
+	box := recording.NewRect(10, 10, 50, 50)
+	if err := doc.SetPageBoxes(0, box, box); !errors.Is(err, errStreamPageBoxes) {
+		t.Errorf("SetPageBoxes of a written page = %v, want %v", err, errStreamPageBoxes)
+	}
+	open := doc.NewPage(100, 100)
+	if err := doc.SetPageBoxes(1, box, box); err != nil {
+		t.Errorf("SetPageBoxes of a page not yet written failed: %v", err)
+	}
+	if err := open.End(); err != nil {
+		t.Fatalf("End failed: %v", err)
+	}
+	if _, err := doc.WriteTo(&bytes.Buffer{}); !errors.Is(err, errStreaming) {
+		t.Errorf("WriteTo error = %v, want %v", err, errStreaming)
+	}
+	if err := doc.SaveToFile(t.TempDir() + "/out.pdf"); !errors.Is(err, errStreaming) {
+		t.Errorf("SaveToFile error = %v, want %v", err, errStreaming)
+	}
+	if err := doc.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := doc.Playback(vectorRecording()); err == nil {
+		t.Error("Playback succeeded on a closed document")
+	}
+	if err := NewDocument().Close(); err == nil {
+		t.Error("Close succeeded on a document that is not streaming")
+	}
+
+	// Settings changed through a page backend are caught by Close.
+	doc = NewStreamingDocument(&bytes.Buffer{})
+	page := doc.NewPage(100, 100).(*pageBackend)
+	if err := page.End(); err != nil {
+		t.Fatalf("End failed: %v", err)
+	}
+	if err := page.SetEncryption(Encryption{UserPassword: "late"}); err != nil {
+		t.Fatalf("SetEncryption failed: %v", err)
+	}
+	if err := doc.Close(); !errors.Is(err, errStreamSettings) {
+		t.Errorf("Close error = %v, want %v", err, errStreamSettings)
+	}
+
+	// A write error fails the page and the document.
+	doc = NewStreamingDocument(&failingWriter{n: 100})
+	if err := doc.Playback(vectorRecording()); !errors.Is(err, errWriteFailed) {
+		t.Errorf("Playback error = %v, want %v", err, errWriteFailed)
+	}
+	if err := doc.Playback(vectorRecording()); !errors.Is(err, errWriteFailed) {
+		t.Errorf("Playback after a write error = %v, want %v", err, errWriteFailed)
+	}
+	err := doc.Close()
+	if !errors.Is(err, errWriteFailed) {
+		t.Errorf("Close error = %v, want the write error", err)
+	}
+	if again := doc.Close(); again != err {
+		t.Errorf("second Close = %v, want %v", again, err)
+	}
+}
+
+func TestStreamingDocumentEncrypted(t *testing.T) {
+	var buf bytes.Buffer
+	doc := NewStreamingDocument(&buf)
+	if err := doc.SetEncryption(Encryption{UserPassword: "reader"}); err != nil {
+		t.Fatalf("SetEncryption failed: %v", err)
+	}
+	page := doc.NewPage(100, 100)
+	page.FillRect(recording.NewRect(10, 10, 20, 20), recording.NewSolidBrush(gg.Red))
+	if err := doc.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	d, ok := openEncrypted(t, buf.Bytes(), "reader")
+	if !ok {
+		t.Fatal("user password did not authenticate")
+	}
+	catalog, _ := d.r.resolveDict(d.r.trailer["Root"])
+	pagesRoot, _ := d.r.resolveDict(catalog["Pages"])
+	kids, _ := pagesRoot["Kids"].(pdfArray)
+	pageDict, _ := d.r.resolveDict(kids[0])
+	if content := d.stream(t, pageDict["Contents"]); !bytes.Contains(content, []byte("10 10 20 20 re\nf\n")) {
+		t.Errorf("decrypted content = %q, want the rectangle", content)
+	}
+}
@@ -0,0 +1,372 @@
+package pdf
+
+import (
+	"fmt"
+)
+
+// StructureType is the type of a structure element in a tagged PDF. The
+// types are the standard structure types of ISO 32000-1, which assistive
+// technology understands without a role map.
+type StructureType string
+
+// Standard structure types.
+const (
+	StructureDocument StructureType = "Document"
+	StructurePart     StructureType = "Part"
+	StructureSect     StructureType = "Sect"
+	StructureDiv      StructureType = "Div"
+	StructureH1       StructureType = "H1"
+	StructureH2       StructureType = "H2"
+	StructureH3       StructureType = "H3"
+	StructureH4       StructureType = "H4"
+	StructureH5       StructureType = "H5"
+	StructureH6       StructureType = "H6"
+	StructureP        StructureType = "P"
+	StructureL        StructureType = "L"
+	StructureLI       StructureType = "LI"
+	StructureSpan     StructureType = "Span"
+	StructureFigure   StructureType = "Figure"
+	StructureCaption  StructureType = "Caption"
+	StructureTable    StructureType = "Table"
+	StructureTR       StructureType = "TR"
+	StructureTH       StructureType = "TH"
+	StructureTD       StructureType = "TD"
+
+	// StructureArtifact marks content that is not part of the document's
+	// logical content, such as page decoration, backgrounds, and running
+	// headers. It is not added to the structure tree and cannot contain
+	// structure elements.
+	StructureArtifact StructureType = "Artifact"
+)
+
+// validStructureTypes are the structure types BeginStructure accepts.
+var validStructureTypes = map[StructureType]bool{
+	StructureDocument: true, StructurePart: true, StructureSect: true, StructureDiv: true,
+	StructureH1: true, StructureH2: true, StructureH3: true,
+	StructureH4: true, StructureH5: true, StructureH6: true,
+	StructureP: true, StructureL: true, StructureLI: true, StructureSpan: true,
+	StructureFigure: true, StructureCaption: true,
+	StructureTable: true, StructureTR: true, StructureTH: true, StructureTD: true,
+	StructureArtifact: true,
+}
+
+// StructureAttributes are the optional entries of a structure element.
+type StructureAttributes struct {
+	// Alt is the alternate description read in place of the element's
+	// content. Figures need it to be accessible.
+	Alt string
+
+	// ActualText is the exact text the content represents, for content
+	// such as text drawn as paths.
+	ActualText string
+
+	// Lang is the BCP 47 language of the element when it differs from the
+	// document language, e.g. "fr-CA".
+	Lang string
+}
+
+// structElem is a structure element. Its kids are nested elements and the
+// marked-content sequences drawn while it was the innermost open element,
+// in drawing order.
+type structElem struct {
+	kind  StructureType
+	attrs StructureAttributes
+	kids  []any // *structElem or markedContentRef
+}
+
+// markedContentRef identifies a marked-content sequence on a page.
+type markedContentRef struct {
+	page *contentStream
+	mcid int
+}
+
+// structTree is the logical structure of a file as it is being drawn.
+type structTree struct {
+	// enabled is set by the first BeginStructure; from then on the file is
+	// tagged and content drawn outside any element becomes an artifact.
+	enabled bool
+
+	roots []*structElem
+	open  []*structElem
+
+	// artifact stands in for content drawn outside any element.
+	artifact *structElem
+}
+
+// begin opens a structure element nested in the innermost open element.
+func (t *structTree) begin(kind StructureType, attrs StructureAttributes) error {
+	if !validStructureTypes[kind] {
+		return fmt.Errorf("pdf: unknown structure type %q", kind)
+	}
+	if parent := t.current(); parent != nil && parent.kind == StructureArtifact {
+		return fmt.Errorf("pdf: cannot open %s inside an artifact", kind)
+	}
+	elem := &structElem{kind: kind, attrs: attrs}
+	t.attach(elem)
+	t.open = append(t.open, elem)
+	t.enabled = true
+	return nil
+}
+
+// attach adds elem to the innermost open element, or to the roots.
+// Artifacts are not part of the tree.
+func (t *structTree) attach(elem *structElem) {
+	switch {
+	case elem.kind == StructureArtifact:
+	case len(t.open) == 0:
+		t.roots = append(t.roots, elem)
+	default:
+		parent := t.open[len(t.open)-1]
+		parent.kids = append(parent.kids, elem)
+	}
+}
+
+// end closes the innermost open element.
+func (t *structTree) end() error {
+	if len(t.open) == 0 {
+		return fmt.Errorf("pdf: EndStructure without a matching BeginStructure")
+	}
+	t.open = t.open[:len(t.open)-1]
+	return nil
+}
+
+// current returns the innermost open element, or nil.
+func (t *structTree) current() *structElem {
+	if len(t.open) == 0 {
+		return nil
+	}
+	return t.open[len(t.open)-1]
+}
+
+// target returns the element content drawn now belongs to: the innermost
+// open element, the artifact stand-in once the file is tagged, or nil.
+func (t *structTree) target() *structElem {
+	if elem := t.current(); elem != nil {
+		return elem
+	}
+	if !t.enabled {
+		return nil
+	}
+	if t.artifact == nil {
+		t.artifact = &structElem{kind: StructureArtifact}
+	}
+	return t.artifact
+}
+
+// reset discards the content of a previous file while keeping the elements
+// that are still open, so that they apply to the next one.
+func (t *structTree) reset() {
+	open := t.open
+	t.roots, t.open = nil, nil
+	for _, elem := range open {
+		elem.kids = nil
+		t.attach(elem)
+		t.open = append(t.open, elem)
+	}
+}
+
+// mark opens the marked-content sequence for content drawn in elem, unless
+// it is already open. Consecutive drawing operations in the same element
+// share one sequence.
+func (c *contentStream) mark(elem *structElem) {
+	if elem == nil {
+		c.untagged = true
+	}
+	if elem == c.marked {
+		return
+	}
+	c.endMarked()
+	switch {
+	case elem == nil:
+		return
+	case elem.kind == StructureArtifact:
+		c.op("BMC", pdfName("Artifact"))
+	default:
+		mcid := len(c.parents)
+		c.parents = append(c.parents, elem)
+		elem.kids = append(elem.kids, markedContentRef{page: c, mcid: mcid})
+		c.op("BDC", pdfName(elem.kind), fmt.Sprintf("<< /MCID %d >>", mcid))
+	}
+	c.marked = elem
+}
+
+// span opens a marked-content span carrying text under key, /Alt or
+// /ActualText, for the content drawn inside it.
+func (c *contentStream) span(key pdfName, text string) {
+	props := appendName([]byte("<< "), key)
+	props = append(props, ' ')
+	props = appendLiteralString(props, string(textString(text)))
+	props = append(props, " >>"...)
+	c.op("BDC", pdfName("Span"), string(props))
+}
+
+// endMarked closes the open marked-content sequence, if any. Sequences are
+// closed before q and Q so that they nest properly with graphics states.
+func (c *contentStream) endMarked() {
+	if c.marked != nil {
+		c.op("EMC")
+		c.marked = nil
+	}
+}
+
+// writeStructTree adds the structure tree and the mark information to the
+// catalog and links the pages and their annotations to the tree through the
+// parent tree.
+func writeStructTree(out *outputFile, pages []*Backend, tree *structTree) {
+	pageObjs := make(map[*contentStream]*pdfIndirect, len(pages))
+	for i, b := range pages {
+		pageObjs[b.content] = out.pages[i]
+	}
+
+	root := newIndirect(nil)
+	objs := make(map[*structElem]*pdfIndirect)
+	var build func(elem *structElem, parent *pdfIndirect) *pdfIndirect
+	build = func(elem *structElem, parent *pdfIndirect) *pdfIndirect {
+		obj := newIndirect(nil)
+		objs[elem] = obj
+		dict := pdfDict{
+			"Type": pdfName("StructElem"),
+			"S":    pdfName(elem.kind),
+			"P":    parent,
+		}
+		var page *pdfIndirect
+		for _, kid := range elem.kids {
+			if ref, ok := kid.(markedContentRef); ok {
+				page = pageObjs[ref.page]
+				dict["Pg"] = page
+				break
+			}
+		}
+		kids := pdfArray{}
+		for _, kid := range elem.kids {
+			switch k := kid.(type) {
+			case *structElem:
+				kids = append(kids, build(k, obj))
+			case markedContentRef:
+				if pageObjs[k.page] == page {
+					kids = append(kids, k.mcid)
+				} else {
+					kids = append(kids, pdfDict{
+						"Type": pdfName("MCR"),
+						"Pg":   pageObjs[k.page],
+						"MCID": k.mcid,
+					})
+				}
+			}
+		}
+		dict["K"] = kids
+		if elem.attrs.Alt != "" {
+			dict["Alt"] = textString(elem.attrs.Alt)
+		}
+		if elem.attrs.ActualText != "" {
+			dict["ActualText"] = textString(elem.attrs.ActualText)
+		}
+		if elem.attrs.Lang != "" {
+			dict["Lang"] = textString(elem.attrs.Lang)
+		}
+		obj.Value = dict
+		return obj
+	}
+
+	kids := pdfArray{}
+	for _, elem := range tree.roots {
+		kids = append(kids, build(elem, root))
+	}
+
+	// The parent tree maps each page's marked-content identifiers back to
+	// the elements that own them.
+	nums := pdfArray{}
+	key := 0
+	for i, b := range pages {
+		if len(b.content.parents) == 0 {
+			continue
+		}
+		parents := pdfArray{}
+		for _, elem := range b.content.parents {
+			parents = append(parents, objs[elem])
+		}
+		page := indirectDict(out.pages[i])
+		page["StructParents"] = key
+		page["Tabs"] = pdfName("S")
+		nums = append(nums, key, newIndirect(parents))
+		key++
+	}
+
+	// Each annotation is the content of an element of its own: Form for
+	// widgets, Annot for the others. Pop-ups belong to their parent.
+	parent := root
+	if len(tree.roots) == 1 && tree.roots[0].kind == StructureDocument {
+		parent = objs[tree.roots[0]]
+	}
+	var annotElems pdfArray
+	for _, page := range out.pages {
+		annots, _ := indirectDict(page)["Annots"].(pdfArray)
+		for _, a := range annots {
+			annot, ok := a.(*pdfIndirect)
+			if !ok {
+				continue
+			}
+			dict := indirectDict(annot)
+			kind := pdfName("Annot")
+			switch dict["Subtype"] {
+			case pdfName("Popup"):
+				continue
+			case pdfName("Widget"):
+				kind = "Form"
+			}
+			elem := pdfDict{
+				"Type": pdfName("StructElem"),
+				"S":    kind,
+				"P":    parent,
+				"Pg":   page,
+				"K":    pdfDict{"Type": pdfName("OBJR"), "Obj": annot},
+			}
+			if alt, ok := dict["Contents"]; ok {
+				elem["Alt"] = alt
+			} else if alt, ok := dict["TU"]; ok {
+				elem["Alt"] = alt
+			}
+			obj := newIndirect(elem)
+			annotElems = append(annotElems, obj)
+			dict["StructParent"] = key
+			indirectDict(page)["Tabs"] = pdfName("S")
+			nums = append(nums, key, obj)
+			key++
+		}
+	}
+	if parent == root {
+		kids = append(kids, annotElems...)
+	} else {
+		doc := indirectDict(parent)
+		doc["K"] = append(doc["K"].(pdfArray), annotElems...)
+	}
+
+	root.Value = pdfDict{
+		"Type":              pdfName("StructTreeRoot"),
+		"K":                 kids,
+		"ParentTree":        newIndirect(pdfDict{"Nums": nums}),
+		"ParentTreeNextKey": key,
+	}
+	out.catalog["StructTreeRoot"] = root
+	out.catalog["MarkInfo"] = pdfDict{"Marked": true}
+}
+
+// checkFigureAlt reports an error if a figure in elems, or nested in them,
+// has no alternate text.
+func checkFigureAlt(elems []*structElem) error {
+	for _, elem := range elems {
+		if elem.kind == StructureFigure && elem.attrs.Alt == "" && elem.attrs.ActualText == "" {
+			return fmt.Errorf("pdf: PDF/UA requires alternate text for every Figure")
+		}
+		var nested []*structElem
+		for _, kid := range elem.kids {
+			if k, ok := kid.(*structElem); ok {
+				nested = append(nested, k)
+			}
+		}
+		if err := checkFigureAlt(nested); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,432 @@
+package pdf
+
+import (
+	"bytes"
+	"image"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gogpu/gg"
+	"github.com/gogpu/gg/recording"
+)
+
+// documentWriter returns a function writing doc.
+func documentWriter(doc *Document) func(*bytes.Buffer) error {
+	return func(buf *bytes.Buffer) error {
+		_, err := doc.WriteTo(buf)
+		return err
+	}
+}
+
+// mustStructure fails the test if a structure call returns an error.
+func mustStructure(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("structure call failed: %v", err)
+	}
+}
+
+// newTaggedDocument draws a heading, a figure, and a page number artifact.
+func newTaggedDocument(t *testing.T) *Document {
+	t.Helper()
+
+	doc := newPDFADocument(t, ConformanceNone)
+	doc.SetTitle("Annual report")
+	doc.SetLanguage("en-US")
+	face := goRegularFace(t, 18)
+	black := recording.NewSolidBrush(gg.Black)
+
+	page := doc.NewPage(200, 200)
+	mustStructure(t, doc.BeginStructure(StructureDocument, StructureAttributes{}))
+	mustStructure(t, doc.BeginStructure(StructureH1, StructureAttributes{}))
+	page.DrawText("Annual", 10, 30, face, black)
+	page.DrawText("report", 80, 30, face, black)
+	mustStructure(t, doc.EndStructure())
+
+	mustStructure(t, doc.BeginStructure(StructureFigure, StructureAttributes{Alt: "Revenue chart"}))
+	page.Save()
+	page.FillRect(recording.NewRect(10, 50, 100, 100), black)
+	page.Restore()
+	mustStructure(t, doc.EndStructure())
+
+	mustStructure(t, doc.BeginStructure(StructureArtifact, StructureAttributes{}))
+	page.DrawText("1", 100, 190, face, black)
+	mustStructure(t, doc.EndStructure())
+	mustStructure(t, doc.EndStructure())
+	return doc
+}
+
+func TestTaggedContentIsMarked(t *testing.T) {
+	doc := newTaggedDocument(t)
+
+	content, _, _ := pageContent(t, documentWriter(doc), 0)
+	for _, want := range []string{
+		// Consecutive operations in one element share a sequence.
+		"/H1 << /MCID 0 >> BDC\nq\n",
+		"ET\nQ\nq\n",
+		// Sequences close before q and Q.
+		"EMC\nq\n/Figure << /MCID 1 >> BDC\n",
+		"EMC\nQ\n",
+		"/Artifact BMC\nq\n",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("content missing %q:\n%s", want, content)
+		}
+	}
+	if strings.Count(content, "BDC\n")+strings.Count(content, "BMC\n") != strings.Count(content, "EMC\n") {
+		t.Errorf("content has unbalanced marked content:\n%s", content)
+	}
+}
+
+func TestTaggedStructureTree(t *testing.T) {
+	doc := newTaggedDocument(t)
+
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	r, catalog := readOutput(t, buf.Bytes())
+
+	markInfo, _ := catalog["MarkInfo"].(pdfDict)
+	if markInfo["Marked"] != true {
+		t.Errorf("/MarkInfo = %v, want /Marked true", catalog["MarkInfo"])
+	}
+	if catalog["Lang"] != pdfString("en-US") {
+		t.Errorf("/Lang = %v, want (en-US)", catalog["Lang"])
+	}
+
+	root, err := r.resolveDict(catalog["StructTreeRoot"])
+	if err != nil || root["Type"] != pdfName("StructTreeRoot") {
+		t.Fatalf("/StructTreeRoot = %v (%v)", root, err)
+	}
+	kids, _ := root["K"].(pdfArray)
+	if len(kids) != 1 {
+		t.Fatalf("structure tree has %d roots, want the Document element", len(kids))
+	}
+	document, _ := r.resolveDict(kids[0])
+	if document["S"] != pdfName("Document") {
+		t.Fatalf("root element = %v, want /S /Document", document)
+	}
+	children, _ := document["K"].(pdfArray)
+	if len(children) != 2 {
+		t.Fatalf("Document has %d kids, want H1 and Figure without the artifact", len(children))
+	}
+	heading, _ := r.resolveDict(children[0])
+	figure, _ := r.resolveDict(children[1])
+	if heading["S"] != pdfName("H1") || !reflect.DeepEqual(heading["K"], pdfArray{0}) {
+		t.Errorf("heading = %v, want /S /H1 /K [0]", heading)
+	}
+	if figure["S"] != pdfName("Figure") || figure["Alt"] != pdfString("Revenue chart") || !reflect.DeepEqual(figure["K"], pdfArray{1}) {
+		t.Errorf("figure = %v, want /S /Figure /Alt (Revenue chart) /K [1]", figure)
+	}
+
+	pagesRoot, _ := r.resolveDict(catalog["Pages"])
+	pageKids, _ := pagesRoot["Kids"].(pdfArray)
+	page, _ := r.resolveDict(pageKids[0])
+	if page["StructParents"] != 0 || page["Tabs"] != pdfName("S") {
+		t.Errorf("page /StructParents = %v /Tabs = %v, want 0 and /S", page["StructParents"], page["Tabs"])
+	}
+	if heading["Pg"] != pageKids[0] {
+		t.Errorf("heading /Pg = %v, want the page %v", heading["Pg"], pageKids[0])
+	}
+
+	parentTree, _ := r.resolveDict(root["ParentTree"])
+	nums, _ := parentTree["Nums"].(pdfArray)
+	if len(nums) != 2 || nums[0] != 0 {
+		t.Fatalf("parent tree /Nums = %v, want one entry for page 0", nums)
+	}
+	obj, _ := r.resolve(nums[1])
+	parents, _ := obj.(pdfArray)
+	if len(parents) != 2 || parents[0] != children[0] || parents[1] != children[1] {
+		t.Errorf("parent tree entry = %v, want the H1 and Figure for MCIDs 0 and 1", parents)
+	}
+	if root["ParentTreeNextKey"] != 1 {
+		t.Errorf("/ParentTreeNextKey = %v, want 1", root["ParentTreeNextKey"])
+	}
+}
+
+func TestTaggedElementSpansPages(t *testing.T) {
+	doc := NewDocument()
+	black := recording.NewSolidBrush(gg.Black)
+	mustStructure(t, doc.BeginStructure(StructureTable, StructureAttributes{}))
+	first := doc.NewPage(100, 100)
+	first.FillRect(recording.NewRect(0, 0, 10, 10), black)
+	second := doc.NewPage(100, 100)
+	second.FillRect(recording.NewRect(0, 0, 10, 10), black)
+	mustStructure(t, doc.EndStructure())
+
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	r, catalog := readOutput(t, buf.Bytes())
+	root, _ := r.resolveDict(catalog["StructTreeRoot"])
+	kids, _ := root["K"].(pdfArray)
+	table, _ := r.resolveDict(kids[0])
+	tableKids, _ := table["K"].(pdfArray)
+	if len(tableKids) != 2 || tableKids[0] != 0 {
+		t.Fatalf("table /K = %v, want an MCID and a marked-content reference", tableKids)
+	}
+	mcr, _ := tableKids[1].(pdfDict)
+	if mcr["Type"] != pdfName("MCR") || mcr["MCID"] != 0 || mcr["Pg"] == table["Pg"] {
+		t.Errorf("second kid = %v, want /MCR for MCID 0 on the second page", mcr)
+	}
+	if root["ParentTreeNextKey"] != 2 {
+		t.Errorf("/ParentTreeNextKey = %v, want an entry per page", root["ParentTreeNextKey"])
+	}
+}
+
+func TestTaggedContentOutsideElementsIsArtifact(t *testing.T) {
+	write := backendWriter(t, 100, 100, func(b *Backend) {
+		mustStructure(t, b.BeginStructure(StructureP, StructureAttributes{}))
+		b.FillRect(recording.NewRect(0, 0, 10, 10), recording.NewSolidBrush(gg.Black))
+		mustStructure(t, b.EndStructure())
+		b.FillRect(recording.NewRect(20, 0, 10, 10), recording.NewSolidBrush(gg.Black))
+	})
+
+	content, _, _ := pageContent(t, write, 0)
+	if !strings.Contains(content, "EMC\n/Artifact BMC\nq\n") {
+		t.Errorf("content after the last element is not an artifact:\n%s", content)
+	}
+}
+
+func TestStructureValidation(t *testing.T) {
+	doc := NewDocument()
+	if err := doc.EndStructure(); err == nil {
+		t.Error("EndStructure without BeginStructure succeeded")
+	}
+	if err := doc.BeginStructure("Chart", StructureAttributes{}); err == nil {
+		t.Error("BeginStructure accepted an unknown structure type")
+	}
+	mustStructure(t, doc.BeginStructure(StructureArtifact, StructureAttributes{}))
+	if err := doc.BeginStructure(StructureP, StructureAttributes{}); err == nil {
+		t.Error("BeginStructure accepted an element inside an artifact")
+	}
+}
+
+func TestPDFUAIdentification(t *testing.T) {
+	doc := newTaggedDocument(t)
+	doc.SetPDFUA(true)
+
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	_, catalog := readOutput(t, buf.Bytes())
+	prefs, _ := catalog["ViewerPreferences"].(pdfDict)
+	if prefs["DisplayDocTitle"] != true {
+		t.Errorf("/ViewerPreferences = %v, want /DisplayDocTitle true", catalog["ViewerPreferences"])
+	}
+
+	packet, _ := documentXMP(t, doc)
+	for _, want := range []string{
+		"<pdfuaid:part>1</pdfuaid:part>",
+		"<dc:language>",
+		"<rdf:li>en-US</rdf:li>",
+	} {
+		if !strings.Contains(packet, want) {
+			t.Errorf("XMP missing %q", want)
+		}
+	}
+	if strings.Contains(packet, "pdfaExtension") {
+		t.Error("XMP describes pdfuaid in an extension schema without PDF/A")
+	}
+}
+
+func TestPDFUAWithPDFADescribesSchema(t *testing.T) {
+	doc := newTaggedDocument(t)
+	if err := doc.SetConformance(ConformancePDFA2B); err != nil {
+		t.Fatalf("SetConformance failed: %v", err)
+	}
+	doc.SetPDFUA(true)
+
+	packet, _ := documentXMP(t, doc)
+	for _, want := range []string{
+		"<pdfaSchema:namespaceURI>" + xmpNamespacePDFUA + "</pdfaSchema:namespaceURI>",
+		"<pdfaProperty:valueType>Integer</pdfaProperty:valueType>",
+	} {
+		if !strings.Contains(packet, want) {
+			t.Errorf("XMP missing %q", want)
+		}
+	}
+}
+
+func TestPDFUARequirements(t *testing.T) {
+	black := recording.NewSolidBrush(gg.Black)
+	img := image.NewGray(image.Rect(0, 0, 1, 1))
+	for _, tt := range []struct {
+		name string
+		draw func(*Document)
+		want string
+	}{
+		{"missing language", func(d *Document) {
+			d.SetLanguage("")
+			d.NewPage(100, 100)
+		}, "language"},
+		{"figure without alt", func(d *Document) {
+			page := d.NewPage(100, 100)
+			_ = d.BeginStructure(StructureFigure, StructureAttributes{})
+			page.DrawImage(img, recording.NewRect(0, 0, 1, 1), recording.NewRect(0, 0, 10, 10), recording.DefaultImageOptions())
+			_ = d.EndStructure()
+		}, "alternate text"},
+		{"untagged content", func(d *Document) {
+			d.NewPage(100, 100).FillRect(recording.NewRect(0, 0, 10, 10), black)
+		}, "before the first structure element"},
+		{"standard font", func(d *Document) {
+			page := d.NewPage(100, 100)
+			_ = d.BeginStructure(StructureP, StructureAttributes{})
+			page.DrawText("Hello", 10, 10, nil, black)
+			_ = d.EndStructure()
+		}, "embedded font"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := NewDocument()
+			doc.SetTitle("Report")
+			doc.SetLanguage("en")
+			doc.SetPDFUA(true)
+			tt.draw(doc)
+			if !doc.shared.tags.enabled {
+				_ = doc.BeginStructure(StructureDocument, StructureAttributes{})
+			}
+
+			_, err := doc.WriteTo(&bytes.Buffer{})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("WriteTo error = %v, want one mentioning %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAltTextSpansSaveLevel(t *testing.T) {
+	write := backendWriter(t, 100, 100, func(b *Backend) {
+		path := gg.NewPath()
+		path.Rectangle(10, 10, 20, 20)
+		b.Save()
+		b.SetAltText("Revenue by quarter, Q1 to Q4")
+		b.FillPath(path, recording.NewSolidBrush(gg.Black), recording.FillRuleNonZero)
+		b.StrokePath(path, recording.NewSolidBrush(gg.Black), recording.DefaultStroke())
+		b.Restore()
+		b.FillRect(recording.NewRect(50, 50, 10, 10), recording.NewSolidBrush(gg.Black))
+	})
+
+	content, _, _ := pageContent(t, write, 0)
+	const span = "q\n/Span << /Alt (Revenue by quarter, Q1 to Q4) >> BDC\nq\n"
+	if !strings.Contains(content, span) || strings.Count(content, "BDC\n") != 1 {
+		t.Fatalf("content does not wrap the group in one alt text span:\n%s", content)
+	}
+	if !strings.Contains(content, "S\nQ\nEMC\nQ\nq\n") {
+		t.Errorf("span does not end at Restore:\n%s", content)
+	}
+}
+
+func TestActualTextOnDocumentPage(t *testing.T) {
+	doc := NewDocument()
+	page := doc.NewPage(100, 100)
+	labeled, ok := page.(interface{ SetActualText(string) })
+	if !ok {
+		t.Fatal("document page does not support SetActualText")
+	}
+	img := image.NewGray(image.Rect(0, 0, 2, 2))
+	labeled.SetActualText("€5")
+	page.DrawImage(img, recording.NewRect(0, 0, 2, 2), recording.NewRect(0, 0, 20, 20), recording.DefaultImageOptions())
+	labeled.SetActualText("")
+
+	content, _, _ := pageContent(t, documentWriter(doc), 0)
+	if !strings.Contains(content, "/Span << /ActualText (\xFE\xFF\x20\xAC\x005) >> BDC\nq\n") ||
+		!strings.Contains(content, "Do\nQ\nEMC\n") {
+		t.Errorf("image is not wrapped in an actual text span:\n%q", content)
+	}
+}
+
+func TestAltTextNestsWithStructureAndSaves(t *testing.T) {
+	write := backendWriter(t, 100, 100, func(b *Backend) {
+		mustStructure(t, b.BeginStructure(StructureP, StructureAttributes{}))
+		b.FillRect(recording.NewRect(0, 0, 10, 10), recording.NewSolidBrush(gg.Black))
+		b.SetAltText("Logo")
+		b.FillRect(recording.NewRect(0, 0, 10, 10), recording.NewSolidBrush(gg.Black))
+		b.Save()
+		b.Save()
+	})
+
+	content, _, _ := pageContent(t, write, 0)
+	if !strings.Contains(content, "EMC\n/Span << /Alt (Logo) >> BDC\n/P << /MCID 1 >> BDC\nq\n") {
+		t.Errorf("structure content does not nest inside the span:\n%s", content)
+	}
+	if !strings.HasSuffix(content, "Q\nEMC\nq\nq\nQ\nQ\nEMC\n") {
+		t.Errorf("End does not close the span after the graphics states opened in it:\n%s", content)
+	}
+}
+
+func TestPDFUATagsAnnotations(t *testing.T) {
+	doc := newTaggedDocument(t)
+	doc.SetPDFUA(true)
+	page := doc.pages[0]
+	if err := page.AddField(Field{
+		Kind: FieldText, Name: "name", Rect: recording.NewRect(10, 160, 80, 20),
+		Face: goRegularFace(t, 10), Tooltip: "Full name",
+	}); err != nil {
+		t.Fatalf("AddField failed: %v", err)
+	}
+	if err := page.AddAnnotation(Annotation{
+		Kind: AnnotationText, Rect: recording.NewRect(150, 10, 20, 20), Contents: "Check the figures",
+	}); err != nil {
+		t.Fatalf("AddAnnotation failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	r, catalog := readOutput(t, buf.Bytes())
+	root, _ := r.resolveDict(catalog["StructTreeRoot"])
+	parentTree, _ := r.resolveDict(root["ParentTree"])
+	nums, _ := parentTree["Nums"].(pdfArray)
+	kids, _ := root["K"].(pdfArray)
+	document, _ := r.resolveDict(kids[0])
+	children, _ := document["K"].(pdfArray)
+
+	pagesRoot, _ := r.resolveDict(catalog["Pages"])
+	pageKids, _ := pagesRoot["Kids"].(pdfArray)
+	pageDict, _ := r.resolveDict(pageKids[0])
+	annots, _ := pageDict["Annots"].(pdfArray)
+	if len(annots) != 3 {
+		t.Fatalf("page has %d annotations, want the widget, the note, and its pop-up", len(annots))
+	}
+	want := map[pdfName]pdfString{"Widget": "Full name", "Text": "Check the figures"}
+	for _, ref := range annots {
+		annot, _ := r.resolveDict(ref)
+		subtype, _ := annot["Subtype"].(pdfName)
+		key, tagged := annot["StructParent"].(int)
+		if subtype == "Popup" {
+			if tagged {
+				t.Errorf("pop-up has /StructParent %d", key)
+			}
+			continue
+		}
+		if !tagged {
+			t.Errorf("%s annotation has no /StructParent", subtype)
+			continue
+		}
+		var elem pdfDict
+		for i := 0; i+1 < len(nums); i += 2 {
+			if nums[i] == key {
+				elem, _ = r.resolveDict(nums[i+1])
+			}
+		}
+		kind := pdfName("Annot")
+		if subtype == "Widget" {
+			kind = "Form"
+		}
+		objr, _ := elem["K"].(pdfDict)
+		if elem["S"] != kind || objr["Type"] != pdfName("OBJR") || objr["Obj"] != ref || elem["Alt"] != want[subtype] {
+			t.Errorf("%s annotation element = %v, want /S /%s with an OBJR and /Alt %q", subtype, elem, kind, want[subtype])
+		}
+	}
+	if len(children) != 4 || children[2] != nums[3] || children[3] != nums[5] {
+		t.Errorf("Document kids = %v, want H1, Figure, and the annotation elements", children)
+	}
+	if root["ParentTreeNextKey"] != 3 {
+		t.Errorf("/ParentTreeNextKey = %v, want 3", root["ParentTreeNextKey"])
+	}
+}
@@ -0,0 +1,277 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/md5" //nolint:gosec // MD5 is what the PDF specification uses for file identifiers
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"weak"
+)
+
+// objectWriter serializes a graph of PDF objects into a complete file.
+// Objects reachable from the trailer are numbered in the order the writer
+// reaches them; *pdfIndirect values and streams become indirect objects.
+// The numbers are kept by weak pointer, so that an object written is not
+// held by the writer, only its number and offset.
+type objectWriter struct {
+	w       io.Writer
+	version string
+	offset  int64
+	offsets []int64 // offsets[n] is the file offset of object n
+	nums    map[weak.Pointer[pdfIndirect]]int
+	queue   []*pdfIndirect
+	streams map[weak.Pointer[pdfStream]]int
+	digest  hash.Hash
+	crypt   *encryptor // nil for an unencrypted file
+	err     error
+
+	// held objects are numbered when referenced but written only after
+	// release; pending are the held objects referenced so far. discard
+	// drops the data of streams once written, for streaming output.
+	held    map[*pdfIndirect]bool
+	pending []*pdfIndirect
+	discard bool
+}
+
+// newObjectWriter creates a writer that emits a PDF file to w.
+func newObjectWriter(w io.Writer) *objectWriter {
+	return &objectWriter{
+		w:       w,
+		version: "1.7",
+		offsets: []int64{0},
+		nums:    make(map[weak.Pointer[pdfIndirect]]int),
+		streams: make(map[weak.Pointer[pdfStream]]int),
+		digest:  md5.New(), //nolint:gosec // see import comment
+	}
+}
+
+// write appends raw bytes to the output, tracking the offset.
+func (ow *objectWriter) write(p []byte) {
+	if ow.err != nil {
+		return
+	}
+	n, err := ow.w.Write(p)
+	ow.digest.Write(p[:n])
+	ow.offset += int64(n)
+	ow.err = err
+}
+
+// ref returns the object number of ind, assigning one and queuing the object
+// for output the first time it is referenced.
+func (ow *objectWriter) ref(ind *pdfIndirect) int {
+	key := weak.Make(ind)
+	num, ok := ow.nums[key]
+	if !ok {
+		num = len(ow.offsets)
+		ow.nums[key] = num
+		ow.offsets = append(ow.offsets, -1)
+		ow.queue = append(ow.queue, ind)
+	}
+	return num
+}
+
+// appendValue serializes a direct object, queuing any indirect objects it
+// references.
+func (ow *objectWriter) appendValue(buf []byte, v pdfObject) []byte {
+	switch v := v.(type) {
+	case nil:
+		return append(buf, "null"...)
+	case bool:
+		return strconv.AppendBool(buf, v)
+	case int:
+		return strconv.AppendInt(buf, int64(v), 10)
+	case int64:
+		return strconv.AppendInt(buf, v, 10)
+	case float64:
+		return append(buf, formatReal(v)...)
+	case pdfName:
+		return appendName(buf, v)
+	case pdfString:
+		return appendLiteralString(buf, string(v))
+	case pdfHexString:
+		return appendHexString(buf, string(v))
+	case pdfArray:
+		buf = append(buf, '[')
+		for i, item := range v {
+			if i > 0 {
+				buf = append(buf, ' ')
+			}
+			buf = ow.appendValue(buf, item)
+		}
+		return append(buf, ']')
+	case pdfDict:
+		buf = append(buf, "<<"...)
+		for _, k := range v.sortedKeys() {
+			buf = append(buf, ' ')
+			buf = appendName(buf, k)
+			buf = append(buf, ' ')
+			buf = ow.appendValue(buf, v[k])
+		}
+		return append(buf, " >>"...)
+	case *pdfIndirect:
+		return fmt.Appendf(buf, "%d 0 R", ow.ref(v))
+	case *pdfStream:
+		key := weak.Make(v)
+		num, ok := ow.streams[key]
+		if !ok {
+			num = ow.ref(newIndirect(v))
+			ow.streams[key] = num
+		}
+		return fmt.Appendf(buf, "%d 0 R", num)
+	case *pdfPlaceholder:
+		// buf holds the object being written from its start, so the text
+		// lands at this offset.
+		v.offset = ow.offset + int64(len(buf))
+		return append(buf, v.text...)
+	case pdfRef:
+		// Unresolved references from parsed files must be imported first.
+		ow.err = fmt.Errorf("pdf: unresolved reference %d %d R", v.Num, v.Gen)
+		return append(buf, "null"...)
+	default:
+		ow.err = fmt.Errorf("pdf: cannot serialize %T", v)
+		return append(buf, "null"...)
+	}
+}
+
+// writeIndirect writes one queued indirect object.
+func (ow *objectWriter) writeIndirect(ind *pdfIndirect) {
+	num := ow.nums[weak.Make(ind)]
+	ow.offsets[num] = ow.offset
+	buf := fmt.Appendf(nil, "%d 0 obj\n", num)
+
+	value := ind.Value
+	if ow.crypt != nil && ind != ow.crypt.dict {
+		value = ow.crypt.object(num, value)
+	}
+	if stream, ok := value.(*pdfStream); ok {
+		dict := stream.Dict.clone()
+		dict["Length"] = len(stream.Data)
+		buf = ow.appendValue(buf, dict)
+		buf = append(buf, "\nstream\n"...)
+		ow.write(buf)
+		ow.write(stream.Data)
+		ow.write([]byte("\nendstream\nendobj\n"))
+		if original, ok := ind.Value.(*pdfStream); ok && ow.discard {
+			original.Data = nil
+		}
+		return
+	}
+
+	buf = ow.appendValue(buf, value)
+	buf = append(buf, "\nendobj\n"...)
+	ow.write(buf)
+}
+
+// flush writes every queued object, including objects queued while writing,
+// except held ones.
+func (ow *objectWriter) flush() {
+	for len(ow.queue) > 0 && ow.err == nil {
+		ind := ow.queue[0]
+		ow.queue = ow.queue[1:]
+		if ow.held[ind] {
+			ow.pending = append(ow.pending, ind)
+			continue
+		}
+		ow.writeIndirect(ind)
+	}
+}
+
+// hold keeps ind from being written until release, for objects that are
+// referenced before their value is complete.
+func (ow *objectWriter) hold(ind *pdfIndirect) {
+	if ow.held == nil {
+		ow.held = make(map[*pdfIndirect]bool)
+	}
+	ow.held[ind] = true
+}
+
+// release queues the held objects referenced so far and stops holding any.
+func (ow *objectWriter) release() {
+	ow.held = nil
+	ow.queue = append(ow.queue, ow.pending...)
+	ow.pending = nil
+}
+
+// writeFile writes a complete PDF file whose trailer references root and,
+// if non-nil, info. It returns the number of bytes written.
+func (ow *objectWriter) writeFile(root, info *pdfIndirect) (int64, error) {
+	ow.writeHeader()
+	return ow.writeTrailer(root, info)
+}
+
+// writeHeader writes the file header with the PDF version.
+func (ow *objectWriter) writeHeader() {
+	ow.write([]byte("%PDF-" + ow.version + "\n%\xE2\xE3\xCF\xD3\n"))
+}
+
+// writeTrailer writes root and info, every object they reach that has not
+// been written yet, the cross-reference table, and the trailer. It returns
+// the number of bytes written in total.
+func (ow *objectWriter) writeTrailer(root, info *pdfIndirect) (int64, error) {
+	// Number the catalog first so it is object 1 in a file written at
+	// once, then everything it reaches.
+	ow.ref(root)
+	if info != nil {
+		ow.ref(info)
+	}
+	ow.flush()
+	if ow.err != nil {
+		return ow.offset, ow.err
+	}
+
+	id := ow.fileID()
+	trailer := pdfDict{
+		"Size": len(ow.offsets),
+		"Root": root,
+	}
+	if info != nil {
+		trailer["Info"] = info
+	}
+	if ow.crypt != nil {
+		// The key is bound to the identifier, so it cannot depend on the
+		// bytes written.
+		id = ow.crypt.id
+		trailer["Encrypt"] = ow.crypt.dict
+		ow.ref(ow.crypt.dict)
+		ow.flush()
+	}
+	trailer["ID"] = pdfArray{id, id}
+	ow.writeXref(trailer)
+	return ow.offset, ow.err
+}
+
+// writeXref writes the cross-reference table and the trailer.
+func (ow *objectWriter) writeXref(trailer pdfDict) {
+	start := ow.offset
+	buf := fmt.Appendf(nil, "xref\n0 %d\n0000000000 65535 f \n", len(ow.offsets))
+	for _, off := range ow.offsets[1:] {
+		buf = fmt.Appendf(buf, "%010d 00000 n \n", off)
+	}
+	buf = append(buf, "trailer\n"...)
+	buf = ow.appendValue(buf, trailer)
+	buf = fmt.Appendf(buf, "\nstartxref\n%d\n%%%%EOF\n", start)
+	ow.write(buf)
+}
+
+// fileID derives the trailer /ID from the bytes written so far, so identical
+// documents get identical identifiers.
+func (ow *objectWriter) fileID() pdfHexString {
+	return pdfHexString(ow.digest.Sum(nil))
+}
+
+// flateStream returns a stream holding data compressed with FlateDecode.
+func flateStream(dict pdfDict, data []byte) *pdfStream {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	_, _ = zw.Write(data)
+	_ = zw.Close()
+
+	if dict == nil {
+		dict = pdfDict{}
+	}
+	dict["Filter"] = pdfName("FlateDecode")
+	return &pdfStream{Dict: dict, Data: buf.Bytes()}
+}
@@ -0,0 +1,118 @@
+package pdf
+
+import (
+	"bytes"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestFormatReal(t *testing.T) {
+	tests := []struct {
+		in   float64
+		want string
+	}{
+		{0, "0.00"},
+		{123, "123.00"},
+		{-0.0, "0.00"},
+		{0.5, "0.50"},
+		{1.0 / 3.0, "0.333333"},
+		{-2.125, "-2.125"},
+	}
+	for _, tt := range tests {
+		if got := formatReal(tt.in); got != tt.want {
+			t.Errorf("formatReal(%v) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestObjectWriterRoundTrip(t *testing.T) {
+	shared := newIndirect(pdfDict{"Type": pdfName("Shared")})
+	stream := flateStream(pdfDict{"Type": pdfName("Test")}, []byte("payload"))
+	root := newIndirect(pdfDict{
+		"Type":   pdfName("Catalog"),
+		"Name":   pdfName("With Space#"),
+		"Text":   pdfString("a (nested) \\ string\n"),
+		"Hex":    pdfHexString("\x00\xFF"),
+		"Array":  pdfArray{1, 2.5, true, nil, shared},
+		"Again":  shared,
+		"Stream": stream,
+	})
+
+	var buf bytes.Buffer
+	if _, err := newObjectWriter(&buf).writeFile(root, nil); err != nil {
+		t.Fatalf("writeFile failed: %v", err)
+	}
+
+	r, catalog := readOutput(t, buf.Bytes())
+	if catalog["Name"] != pdfName("With Space#") {
+		t.Errorf("/Name = %v, want the escaped name to round-trip", catalog["Name"])
+	}
+	if catalog["Text"] != pdfString("a (nested) \\ string\n") {
+		t.Errorf("/Text = %q, want the escaped string to round-trip", catalog["Text"])
+	}
+	if catalog["Hex"] != pdfString("\x00\xFF") {
+		t.Errorf("/Hex = %q, want the hex string to round-trip", catalog["Hex"])
+	}
+
+	arr := catalog["Array"].(pdfArray)
+	if arr[0] != 1 || arr[1] != 2.5 || arr[2] != true || arr[3] != nil {
+		t.Errorf("/Array = %v, want [1 2.5 true null ...]", arr)
+	}
+	if arr[4] != catalog["Again"] {
+		t.Errorf("shared object written twice: %v and %v", arr[4], catalog["Again"])
+	}
+
+	obj, err := r.resolve(catalog["Stream"])
+	if err != nil {
+		t.Fatalf("failed to resolve stream: %v", err)
+	}
+	data, err := decodeStream(obj.(*pdfStream))
+	if err != nil {
+		t.Fatalf("failed to decode stream: %v", err)
+	}
+	if string(data) != "payload" {
+		t.Errorf("stream data = %q, want %q", data, "payload")
+	}
+}
+
+func TestObjectWriterReleasesObjects(t *testing.T) {
+	ow := newObjectWriter(&bytes.Buffer{})
+	kept := newIndirect(pdfDict{"Type": pdfName("Kept")})
+	released := make(chan struct{})
+	func() {
+		page := newIndirect(pdfDict{"Kept": kept, "Contents": flateStream(nil, []byte("0 0 m"))})
+		runtime.AddCleanup(page, func(done chan struct{}) { close(done) }, released)
+		ow.appendValue(nil, page)
+		ow.flush()
+	}()
+	if ow.err != nil {
+		t.Fatalf("flush failed: %v", ow.err)
+	}
+
+	// The writer keeps the numbers of the objects it wrote, not the objects.
+	deadline := time.After(time.Second)
+wait:
+	for {
+		runtime.GC()
+		select {
+		case <-released:
+			break wait
+		case <-deadline:
+			t.Error("written object is still referenced by the writer")
+			break wait
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if num := ow.ref(kept); num != 3 || len(ow.queue) != 0 {
+		t.Errorf("object written before is numbered %d with %d queued, want 3 and none", num, len(ow.queue))
+	}
+}
+
+func TestPDFReaderRejectsGarbage(t *testing.T) {
+	for _, data := range []string{"", "not a pdf", "%PDF-1.7\nstartxref\n999\n%%EOF"} {
+		if _, err := newPDFReader([]byte(data)); err == nil {
+			t.Errorf("newPDFReader(%q) succeeded, want an error", data)
+		}
+	}
+}
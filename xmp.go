@@ -0,0 +1,398 @@
+package pdf
+
+import (
+	"bytes"
+	"crypto/md5" //nolint:gosec // used for stable identifiers only
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Namespace URIs of the XMP schemas written for every document.
+const (
+	xmpNamespaceRDF = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	xmpNamespaceDC  = "http://purl.org/dc/elements/1.1/"
+	xmpNamespaceXMP = "http://ns.adobe.com/xap/1.0/"
+	xmpNamespacePDF = "http://ns.adobe.com/pdf/1.3/"
+)
+
+// Namespace URIs of the PDF/A extension schema container, which describes
+// custom namespaces to PDF/A validators.
+const (
+	xmpNamespacePDFAExtension = "http://www.aiim.org/pdfa/ns/extension/"
+	xmpNamespacePDFASchema    = "http://www.aiim.org/pdfa/ns/schema#"
+	xmpNamespacePDFAProperty  = "http://www.aiim.org/pdfa/ns/property#"
+)
+
+// defaultProducer is written as the producer unless SetProducer overrides it.
+const defaultProducer = "gogpu/gg-pdf"
+
+// xmpPrefixPattern matches valid XML namespace prefixes and local names.
+var xmpPrefixPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// reservedXMPPrefixes are the prefixes used by the packet itself.
+var reservedXMPPrefixes = map[string]bool{
+	"x": true, "rdf": true, "xml": true, "xmlns": true,
+	"dc": true, "xmp": true, "pdf": true,
+	"pdfaid": true, "pdfxid": true, "pdfuaid": true, "pdfx": true,
+	"pdfaExtension": true, "pdfaSchema": true, "pdfaProperty": true,
+	"xmpMM": true,
+}
+
+// xmpNamespace is a namespace declared in the XMP packet.
+type xmpNamespace struct {
+	prefix string
+	uri    string
+}
+
+// xmpProperty is a simple text property in a custom namespace.
+type xmpProperty struct {
+	uri   string
+	name  string
+	value string
+}
+
+// metadata holds the document information that is written both to the Info
+// dictionary and to the XMP packet, so the two never disagree.
+type metadata struct {
+	title    string
+	author   string
+	subject  string
+	keywords string
+	creator  string
+	producer string
+	created  time.Time
+	modified time.Time
+
+	// trapped is the Info /Trapped value, written only when set.
+	trapped string
+
+	// lang is the document language, written as the catalog /Lang and the
+	// XMP dc:language.
+	lang string
+
+	namespaces []xmpNamespace
+	properties []xmpProperty
+
+	// extensionSchemas describes the custom namespaces in a PDF/A extension
+	// schema, which PDF/A requires for every schema it does not predefine.
+	extensionSchemas bool
+}
+
+func newMetadata() *metadata {
+	now := time.Now().Truncate(time.Second)
+	return &metadata{
+		producer: defaultProducer,
+		created:  now,
+		modified: now,
+	}
+}
+
+// namespaceByURI returns the custom namespace registered for uri.
+func (m *metadata) namespaceByURI(uri string) (xmpNamespace, bool) {
+	for _, ns := range m.namespaces {
+		if ns.uri == uri {
+			return ns, true
+		}
+	}
+	return xmpNamespace{}, false
+}
+
+// registerNamespace declares a custom namespace.
+func (m *metadata) registerNamespace(prefix, uri string) error {
+	if !xmpPrefixPattern.MatchString(prefix) || strings.HasPrefix(strings.ToLower(prefix), "xml") {
+		return fmt.Errorf("pdf: invalid XMP namespace prefix %q", prefix)
+	}
+	if reservedXMPPrefixes[prefix] {
+		return fmt.Errorf("pdf: XMP namespace prefix %q is reserved", prefix)
+	}
+	if uri == "" {
+		return fmt.Errorf("pdf: XMP namespace %q has an empty URI", prefix)
+	}
+	switch uri {
+	case xmpNamespaceRDF, xmpNamespaceDC, xmpNamespaceXMP, xmpNamespacePDF,
+		xmpNamespacePDFAID, xmpNamespacePDFXID, xmpNamespaceXMPMM, xmpNamespacePDFUA:
+		return fmt.Errorf("pdf: XMP namespace %q is built in", uri)
+	}
+	for _, ns := range m.namespaces {
+		if ns.prefix == prefix && ns.uri == uri {
+			return nil
+		}
+		if ns.prefix == prefix {
+			return fmt.Errorf("pdf: XMP namespace prefix %q is already bound to %q", prefix, ns.uri)
+		}
+		if ns.uri == uri {
+			return fmt.Errorf("pdf: XMP namespace %q is already registered as %q", uri, ns.prefix)
+		}
+	}
+	m.namespaces = append(m.namespaces, xmpNamespace{prefix: prefix, uri: uri})
+	return nil
+}
+
+// setProperty sets a simple property in a registered custom namespace,
+// replacing any previous value.
+func (m *metadata) setProperty(uri, name, value string) error {
+	if _, ok := m.namespaceByURI(uri); !ok {
+		return fmt.Errorf("pdf: XMP namespace %q is not registered", uri)
+	}
+	if !xmpPrefixPattern.MatchString(name) {
+		return fmt.Errorf("pdf: invalid XMP property name %q", name)
+	}
+	for i, p := range m.properties {
+		if p.uri == uri && p.name == name {
+			m.properties[i].value = value
+			return nil
+		}
+	}
+	m.properties = append(m.properties, xmpProperty{uri: uri, name: name, value: value})
+	return nil
+}
+
+// infoDict builds the document information dictionary.
+func (m *metadata) infoDict() pdfDict {
+	info := pdfDict{
+		"Producer":     textString(m.producer),
+		"CreationDate": pdfString(formatPDFDate(m.created)),
+		"ModDate":      pdfString(formatPDFDate(m.modified)),
+	}
+	if m.trapped != "" {
+		info["Trapped"] = pdfName(m.trapped)
+	}
+	for key, value := range map[pdfName]string{
+		"Title":    m.title,
+		"Author":   m.author,
+		"Subject":  m.subject,
+		"Keywords": m.keywords,
+		"Creator":  m.creator,
+	} {
+		if value != "" {
+			info[key] = textString(value)
+		}
+	}
+	return info
+}
+
+// documentID returns a stable identifier for the document, derived from its
+// title and creation date, in the uuid: form XMP uses.
+func (m *metadata) documentID() string {
+	sum := md5.Sum([]byte(m.title + "\x00" + formatXMPDate(m.created))) //nolint:gosec // an identifier, not a security hash
+	return fmt.Sprintf("uuid:%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+// xmpBuilder accumulates the properties of one rdf:Description.
+type xmpBuilder struct {
+	body bytes.Buffer
+}
+
+func (b *xmpBuilder) simple(qname, value string) {
+	fmt.Fprintf(&b.body, "   <%s>%s</%s>\n", qname, xmlEscape(value), qname)
+}
+
+func (b *xmpBuilder) container(qname, kind string, items []string, lang bool) {
+	fmt.Fprintf(&b.body, "   <%s>\n    <rdf:%s>\n", qname, kind)
+	for _, item := range items {
+		if lang {
+			fmt.Fprintf(&b.body, "     <rdf:li xml:lang=\"x-default\">%s</rdf:li>\n", xmlEscape(item))
+		} else {
+			fmt.Fprintf(&b.body, "     <rdf:li>%s</rdf:li>\n", xmlEscape(item))
+		}
+	}
+	fmt.Fprintf(&b.body, "    </rdf:%s>\n   </%s>\n", kind, qname)
+}
+
+// xmpPacket serializes the metadata as an XMP packet. Extra namespaces and
+// properties, such as conformance identification, are appended after the
+// document properties.
+func (m *metadata) xmpPacket(extra ...xmpNamespacedProperties) []byte {
+	var b xmpBuilder
+	b.simple("dc:format", "application/pdf")
+	if m.title != "" {
+		b.container("dc:title", "Alt", []string{m.title}, true)
+	}
+	if m.author != "" {
+		b.container("dc:creator", "Seq", []string{m.author}, false)
+	}
+	if m.subject != "" {
+		b.container("dc:description", "Alt", []string{m.subject}, true)
+	}
+	if keywords := splitKeywords(m.keywords); len(keywords) > 0 {
+		b.container("dc:subject", "Bag", keywords, false)
+	}
+	if m.lang != "" {
+		b.container("dc:language", "Bag", []string{m.lang}, false)
+	}
+
+	b.simple("xmp:CreateDate", formatXMPDate(m.created))
+	b.simple("xmp:ModifyDate", formatXMPDate(m.modified))
+	b.simple("xmp:MetadataDate", formatXMPDate(m.modified))
+	if m.creator != "" {
+		b.simple("xmp:CreatorTool", m.creator)
+	}
+
+	b.simple("pdf:Producer", m.producer)
+	if m.keywords != "" {
+		b.simple("pdf:Keywords", m.keywords)
+	}
+	if m.trapped != "" {
+		b.simple("pdf:Trapped", m.trapped)
+	}
+
+	namespaces := []xmpNamespace{
+		{prefix: "dc", uri: xmpNamespaceDC},
+		{prefix: "xmp", uri: xmpNamespaceXMP},
+		{prefix: "pdf", uri: xmpNamespacePDF},
+	}
+	var schemas []xmpSchema
+	for _, group := range extra {
+		namespaces = append(namespaces, group.namespace)
+		for _, p := range group.properties {
+			b.simple(group.namespace.prefix+":"+p[0], p[1])
+		}
+		if group.schema != nil {
+			schemas = append(schemas, *group.schema)
+		}
+	}
+	for _, ns := range m.namespaces {
+		namespaces = append(namespaces, ns)
+		schema := xmpSchema{namespace: ns, description: ns.prefix}
+		for _, p := range m.properties {
+			if p.uri == ns.uri {
+				b.simple(ns.prefix+":"+p.name, p.value)
+				schema.properties = append(schema.properties, xmpPropertySchema{
+					name: p.name, valueType: "Text", category: "external", description: p.name,
+				})
+			}
+		}
+		schemas = append(schemas, schema)
+	}
+	if m.extensionSchemas && len(schemas) > 0 {
+		namespaces = append(namespaces,
+			xmpNamespace{prefix: "pdfaExtension", uri: xmpNamespacePDFAExtension},
+			xmpNamespace{prefix: "pdfaSchema", uri: xmpNamespacePDFASchema},
+			xmpNamespace{prefix: "pdfaProperty", uri: xmpNamespacePDFAProperty},
+		)
+		writeExtensionSchemas(&b, schemas)
+	}
+
+	var out bytes.Buffer
+	out.WriteString("<?xpacket begin=\"\xEF\xBB\xBF\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	out.WriteString("<x:xmpmeta xmlns:x=\"adobe:ns:meta/\">\n")
+	out.WriteString(" <rdf:RDF xmlns:rdf=\"" + xmpNamespaceRDF + "\">\n")
+	out.WriteString("  <rdf:Description rdf:about=\"\"")
+	for _, ns := range namespaces {
+		fmt.Fprintf(&out, "\n    xmlns:%s=\"%s\"", ns.prefix, xmlEscape(ns.uri))
+	}
+	out.WriteString(">\n")
+	out.Write(b.body.Bytes())
+	out.WriteString("  </rdf:Description>\n </rdf:RDF>\n</x:xmpmeta>\n")
+
+	// Leave room for in-place edits by other tools, as XMP recommends.
+	for i := 0; i < 20; i++ {
+		out.WriteString(strings.Repeat(" ", 99) + "\n")
+	}
+	out.WriteString("<?xpacket end=\"w\"?>")
+	return out.Bytes()
+}
+
+// xmpSchema describes a namespace that PDF/A does not predefine, for its
+// extension schema container.
+type xmpSchema struct {
+	namespace   xmpNamespace
+	description string
+	properties  []xmpPropertySchema
+}
+
+// xmpPropertySchema describes one property of an extension schema.
+type xmpPropertySchema struct {
+	name        string
+	valueType   string
+	category    string
+	description string
+}
+
+// writeExtensionSchemas writes the PDF/A extension schemas describing the
+// given namespaces and their properties.
+func writeExtensionSchemas(b *xmpBuilder, schemas []xmpSchema) {
+	b.body.WriteString("   <pdfaExtension:schemas>\n    <rdf:Bag>\n")
+	for _, schema := range schemas {
+		ns := schema.namespace
+		b.body.WriteString("     <rdf:li rdf:parseType=\"Resource\">\n")
+		fmt.Fprintf(&b.body, "      <pdfaSchema:schema>%s</pdfaSchema:schema>\n", xmlEscape(schema.description))
+		fmt.Fprintf(&b.body, "      <pdfaSchema:namespaceURI>%s</pdfaSchema:namespaceURI>\n", xmlEscape(ns.uri))
+		fmt.Fprintf(&b.body, "      <pdfaSchema:prefix>%s</pdfaSchema:prefix>\n", ns.prefix)
+		b.body.WriteString("      <pdfaSchema:property>\n       <rdf:Seq>\n")
+		for _, p := range schema.properties {
+			b.body.WriteString("        <rdf:li rdf:parseType=\"Resource\">\n")
+			fmt.Fprintf(&b.body, "         <pdfaProperty:name>%s</pdfaProperty:name>\n", p.name)
+			fmt.Fprintf(&b.body, "         <pdfaProperty:valueType>%s</pdfaProperty:valueType>\n", p.valueType)
+			fmt.Fprintf(&b.body, "         <pdfaProperty:category>%s</pdfaProperty:category>\n", p.category)
+			fmt.Fprintf(&b.body, "         <pdfaProperty:description>%s</pdfaProperty:description>\n", xmlEscape(p.description))
+			b.body.WriteString("        </rdf:li>\n")
+		}
+		b.body.WriteString("       </rdf:Seq>\n      </pdfaSchema:property>\n     </rdf:li>\n")
+	}
+	b.body.WriteString("    </rdf:Bag>\n   </pdfaExtension:schemas>\n")
+}
+
+// xmpNamespacedProperties is a group of simple properties in one namespace,
+// given as name/value pairs. A schema is given for namespaces that PDF/A
+// does not predefine.
+type xmpNamespacedProperties struct {
+	namespace  xmpNamespace
+	properties [][2]string
+	schema     *xmpSchema
+}
+
+// metadataStream returns the /Metadata stream for the catalog. The packet is
+// left uncompressed so that tools can find it without decoding the file.
+func (m *metadata) metadataStream(extra ...xmpNamespacedProperties) *pdfStream {
+	return &pdfStream{
+		Dict: pdfDict{
+			"Type":    pdfName("Metadata"),
+			"Subtype": pdfName("XML"),
+		},
+		Data: m.xmpPacket(extra...),
+	}
+}
+
+// splitKeywords splits a keyword string on commas and semicolons.
+func splitKeywords(keywords string) []string {
+	fields := strings.FieldsFunc(keywords, func(r rune) bool {
+		return r == ',' || r == ';'
+	})
+	out := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if f = strings.TrimSpace(f); f != "" {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// xmlEscape escapes text for use in XML character data and attributes.
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	_ = xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// formatPDFDate formats t as a PDF date string, e.g. D:20260203150405+01'00'.
+func formatPDFDate(t time.Time) string {
+	_, offset := t.Zone()
+	if offset == 0 {
+		return t.Format("D:20060102150405") + "Z"
+	}
+	sign := '+'
+	if offset < 0 {
+		sign = '-'
+		offset = -offset
+	}
+	return fmt.Sprintf("%s%c%02d'%02d'", t.Format("D:20060102150405"), sign, offset/3600, offset%3600/60)
+}
+
+// formatXMPDate formats t as an XMP (ISO 8601) date.
+func formatXMPDate(t time.Time) string {
+	return t.Format(time.RFC3339)
+}
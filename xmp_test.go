@@ -0,0 +1,207 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readOutput parses a written PDF and returns its reader and catalog.
+func readOutput(t *testing.T, data []byte) (*pdfReader, pdfDict) {
+	t.Helper()
+
+	r, err := newPDFReader(data)
+	if err != nil {
+		t.Fatalf("failed to parse output: %v", err)
+	}
+	catalog, err := r.resolveDict(r.trailer["Root"])
+	if err != nil || catalog == nil {
+		t.Fatalf("failed to resolve catalog: %v", err)
+	}
+	return r, catalog
+}
+
+// documentXMP writes doc and returns the XMP packet and Info dictionary.
+func documentXMP(t *testing.T, doc *Document) (string, pdfDict) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	r, catalog := readOutput(t, buf.Bytes())
+
+	obj, err := r.resolve(catalog["Metadata"])
+	if err != nil {
+		t.Fatalf("failed to resolve /Metadata: %v", err)
+	}
+	stream, ok := obj.(*pdfStream)
+	if !ok {
+		t.Fatalf("/Metadata is %T, want a stream", obj)
+	}
+	if stream.Dict["Type"] != pdfName("Metadata") || stream.Dict["Subtype"] != pdfName("XML") {
+		t.Errorf("/Metadata dictionary = %v, want /Type /Metadata /Subtype /XML", stream.Dict)
+	}
+
+	info, err := r.resolveDict(r.trailer["Info"])
+	if err != nil || info == nil {
+		t.Fatalf("failed to resolve /Info: %v", err)
+	}
+	return string(stream.Data), info
+}
+
+func TestDocumentXMPMatchesInfo(t *testing.T) {
+	created := time.Date(2026, 2, 3, 15, 4, 5, 0, time.FixedZone("CET", 3600))
+
+	doc := NewDocument()
+	doc.SetTitle("Quarterly <Report>")
+	doc.SetAuthor("Finance & Ops")
+	doc.SetSubject("Revenue")
+	doc.SetKeywords("revenue, q1; charts")
+	doc.SetCreator("report-generator")
+	doc.SetCreationDate(created)
+	_ = doc.NewPage(200, 100)
+
+	packet, info := documentXMP(t, doc)
+
+	for _, want := range []string{
+		`<rdf:li xml:lang="x-default">Quarterly &lt;Report&gt;</rdf:li>`,
+		`<rdf:li>Finance &amp; Ops</rdf:li>`,
+		`<rdf:li xml:lang="x-default">Revenue</rdf:li>`,
+		`<rdf:li>revenue</rdf:li>`,
+		`<rdf:li>q1</rdf:li>`,
+		`<rdf:li>charts</rdf:li>`,
+		`<pdf:Keywords>revenue, q1; charts</pdf:Keywords>`,
+		`<xmp:CreatorTool>report-generator</xmp:CreatorTool>`,
+		`<xmp:CreateDate>2026-02-03T15:04:05+01:00</xmp:CreateDate>`,
+		`<xmp:ModifyDate>2026-02-03T15:04:05+01:00</xmp:ModifyDate>`,
+		`<pdf:Producer>` + defaultProducer + `</pdf:Producer>`,
+	} {
+		if !strings.Contains(packet, want) {
+			t.Errorf("XMP packet missing %q:\n%s", want, packet)
+		}
+	}
+
+	wantInfo := map[pdfName]pdfString{
+		"Title":        "Quarterly <Report>",
+		"Author":       "Finance & Ops",
+		"Subject":      "Revenue",
+		"Keywords":     "revenue, q1; charts",
+		"Creator":      "report-generator",
+		"Producer":     defaultProducer,
+		"CreationDate": "D:20260203150405+01'00'",
+		"ModDate":      "D:20260203150405+01'00'",
+	}
+	for key, want := range wantInfo {
+		if got := info[key]; got != want {
+			t.Errorf("Info /%s = %v, want %q", key, got, want)
+		}
+	}
+}
+
+func TestDocumentXMPIsWellFormed(t *testing.T) {
+	doc := NewDocument()
+	doc.SetTitle("Title")
+	_ = doc.NewPage(200, 100)
+	if err := doc.RegisterXMPNamespace("acme", "http://example.com/acme/1.0/"); err != nil {
+		t.Fatalf("RegisterXMPNamespace failed: %v", err)
+	}
+	if err := doc.SetXMPProperty("http://example.com/acme/1.0/", "JobID", "A&B-42"); err != nil {
+		t.Fatalf("SetXMPProperty failed: %v", err)
+	}
+
+	packet, _ := documentXMP(t, doc)
+	dec := xml.NewDecoder(strings.NewReader(packet))
+	found := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("XMP packet is not well-formed XML: %v\n%s", err, packet)
+		}
+		if start, ok := tok.(xml.StartElement); ok &&
+			start.Name.Space == "http://example.com/acme/1.0/" && start.Name.Local == "JobID" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("custom property not found in the acme namespace:\n%s", packet)
+	}
+}
+
+func TestDocumentXMPPropertyReplacesValue(t *testing.T) {
+	const uri = "http://example.com/acme/1.0/"
+
+	doc := NewDocument()
+	_ = doc.NewPage(200, 100)
+	if err := doc.RegisterXMPNamespace("acme", uri); err != nil {
+		t.Fatalf("RegisterXMPNamespace failed: %v", err)
+	}
+	// Registering the same binding twice is harmless.
+	if err := doc.RegisterXMPNamespace("acme", uri); err != nil {
+		t.Fatalf("repeated RegisterXMPNamespace failed: %v", err)
+	}
+	_ = doc.SetXMPProperty(uri, "Status", "draft")
+	_ = doc.SetXMPProperty(uri, "Status", "final")
+
+	packet, _ := documentXMP(t, doc)
+	if strings.Contains(packet, "draft") || strings.Count(packet, "<acme:Status>final</acme:Status>") != 1 {
+		t.Errorf("XMP packet does not hold exactly the replaced value:\n%s", packet)
+	}
+}
+
+func TestDocumentXMPNamespaceValidation(t *testing.T) {
+	doc := NewDocument()
+	tests := []struct {
+		name        string
+		prefix, uri string
+	}{
+		{name: "reserved prefix", prefix: "dc", uri: "http://example.com/dc/"},
+		{name: "built-in uri", prefix: "mine", uri: xmpNamespaceXMP},
+		{name: "invalid prefix", prefix: "1abc", uri: "http://example.com/x/"},
+		{name: "xml prefix", prefix: "xmlfoo", uri: "http://example.com/x/"},
+		{name: "empty uri", prefix: "empty", uri: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := doc.RegisterXMPNamespace(tt.prefix, tt.uri); err == nil {
+				t.Fatalf("RegisterXMPNamespace(%q, %q) succeeded, want an error", tt.prefix, tt.uri)
+			}
+		})
+	}
+
+	if err := doc.RegisterXMPNamespace("acme", "http://example.com/acme/"); err != nil {
+		t.Fatalf("RegisterXMPNamespace failed: %v", err)
+	}
+	if err := doc.RegisterXMPNamespace("acme", "http://example.com/other/"); err == nil {
+		t.Error("rebinding a prefix succeeded, want an error")
+	}
+	if err := doc.SetXMPProperty("http://example.com/unknown/", "Name", "v"); err == nil {
+		t.Error("SetXMPProperty on an unregistered namespace succeeded, want an error")
+	}
+	if err := doc.SetXMPProperty("http://example.com/acme/", "bad name", "v"); err == nil {
+		t.Error("SetXMPProperty with an invalid name succeeded, want an error")
+	}
+}
+
+func TestDocumentInfoEncodesNonASCIIAsUTF16(t *testing.T) {
+	doc := NewDocument()
+	doc.SetTitle("Отчёт")
+	_ = doc.NewPage(200, 100)
+
+	packet, info := documentXMP(t, doc)
+	if got := info["Title"]; got != textString("Отчёт") {
+		t.Errorf("Info /Title = %q, want UTF-16BE text string", got)
+	}
+	if !strings.HasPrefix(string(info["Title"].(pdfString)), "\xFE\xFF") {
+		t.Error("Info /Title is missing the UTF-16BE byte order mark")
+	}
+	if !strings.Contains(packet, "Отчёт") {
+		t.Error("XMP packet does not contain the UTF-8 title")
+	}
+}